@@ -1,53 +1,171 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"path/filepath"
+	"sync"
 	"time"
 )
 
 func Add(file F, path string, index int) error {
+	_, err := addFile(file, path, index)
+	return err
+}
+
+// addFile is Add, returning the slot the file was written to. A file
+// skipped as unchanged with IfChanged returns the slot it already has.
+func addFile(file F, path string, index int) (slot int, err error) {
+	defer func() { err = checkDevice(err) }()
+
 	s, err := os.Stat(path)
 	if err != nil {
-		return fmt.Errorf("failed to stat file: %w", err)
+		return -1, fmt.Errorf("failed to stat file: %w", err)
 	}
 
 	name := s.Name()
 	if len(name) > MAX_FILE_NAME_SIZE {
-		return fmt.Errorf("filename too long: %d (max %d)", len(name), MAX_FILE_NAME_SIZE)
+		return -1, fmt.Errorf("filename too long: %d (max %d)", len(name), MAX_FILE_NAME_SIZE)
 	}
 
 	meta, err := ReadMeta(file)
 	if err != nil {
-		return fmt.Errorf("failed to read metadata: %w", err)
+		return -1, fmt.Errorf("failed to read metadata: %w", err)
 	}
 
-	nextFileIndex := 0
-	foundIndex := false
+	nextFileIndex, foundIndex, err := pickSlot(meta, index)
+	if err != nil {
+		return -1, err
+	}
 
-	if index != OUT_OF_BOUNDS_INDEX {
-		if index < 0 || index >= len(meta.Files) {
-			return fmt.Errorf("index out of range: %d (valid range: 0-%d)", index, len(meta.Files)-1)
-		}
-		nextFileIndex = index
-		foundIndex = true
-	} else {
-		for i, v := range meta.Files {
-			if v.Name == "" {
+	src, err := os.Open(path)
+	if err != nil {
+		return -1, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer src.Close()
+
+	before, err := src.Stat()
+	if err != nil {
+		return -1, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	fb, err := readLimited(src, maxSourceSize())
+	if err != nil {
+		return -1, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	if ConfirmSource {
+		after, err := src.Stat()
+		if err != nil {
+			return -1, fmt.Errorf("failed to stat file: %w", err)
+		}
+		if err := checkSourceUnchanged(before, after, len(fb)); err != nil {
+			return -1, err
+		}
+	}
+
+	// With IfChanged the source path and content checksum are recorded, so
+	// a later add of the same path can be skipped or reuse its slot.
+	var origin string
+	var checksum []byte
+	if IfChanged {
+		origin, err = filepath.Abs(path)
+		if err != nil {
+			return -1, fmt.Errorf("failed to resolve path: %w", err)
+		}
+		sum := sha256.Sum256(fb)
+		checksum = sum[:]
+
+		if i, ok := findOrigin(meta, origin); ok {
+			if bytes.Equal(meta.Files[i].Checksum, checksum) {
+				if OutputIndex {
+					fmt.Println(i)
+				} else {
+					Printf("%s %s\n", C(ColorDim, "unchanged"), C(ColorWhite, fmt.Sprintf("[%d] %s", i, name)))
+				}
+				return i, nil
+			}
+			if index == OUT_OF_BOUNDS_INDEX {
 				nextFileIndex = i
 				foundIndex = true
-				break
 			}
 		}
 	}
 
 	if !foundIndex {
-		return fmt.Errorf("no more file slots available (max %d files)", TOTAL_FILES)
+		return -1, fmt.Errorf("no more file slots available (max %d files)", slotCount(meta))
+	}
+	if err := checkReserve(meta, newSlots(meta, nextFileIndex)); err != nil {
+		return -1, err
 	}
 
-	fb, err := os.ReadFile(path)
+	password, err := GetEncKey()
 	if err != nil {
-		return fmt.Errorf("failed to read file: %w", err)
+		return -1, fmt.Errorf("failed to get encryption key: %w", err)
+	}
+
+	entry := File{
+		Name:     name,
+		Origin:   origin,
+		Checksum: checksum,
+	}
+	finalSize, err := storeFile(file, meta, nextFileIndex, entry, fb, password)
+	if err != nil {
+		return -1, err
+	}
+
+	if ShredSource {
+		if err := verifySlotContent(file, meta, password, nextFileIndex, fb); err != nil {
+			return -1, fmt.Errorf("source not shredded, read-back verification failed: %w", err)
+		}
+		src.Close()
+		if err := ShredFile(path); err != nil {
+			return -1, fmt.Errorf("file added but failed to shred source: %w", err)
+		}
+	}
+
+	printAdded(nextFileIndex, name, finalSize, len(fb), ShredSource)
+
+	return nextFileIndex, nil
+}
+
+// AddReader stores everything read from r as a file called name. It is the
+// streaming counterpart of Add for sources that are not files on disk, such
+// as archive entries or stdin; r is never buffered beyond the slot size.
+func AddReader(file F, r io.Reader, name string, index int) (err error) {
+	defer func() { err = checkDevice(err) }()
+
+	if name == "" {
+		return fmt.Errorf("filename cannot be empty")
+	}
+	if len(name) > MAX_FILE_NAME_SIZE {
+		return fmt.Errorf("filename too long: %d (max %d)", len(name), MAX_FILE_NAME_SIZE)
+	}
+
+	meta, err := ReadMeta(file)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	nextFileIndex, foundIndex, err := pickSlot(meta, index)
+	if err != nil {
+		return err
+	}
+	if !foundIndex {
+		return fmt.Errorf("no more file slots available (max %d files)", slotCount(meta))
+	}
+	if err := checkReserve(meta, newSlots(meta, nextFileIndex)); err != nil {
+		return err
+	}
+
+	fb, err := readLimited(r, maxSourceSize())
+	if err != nil {
+		return fmt.Errorf("failed to read input: %w", err)
 	}
 
 	password, err := GetEncKey()
@@ -55,63 +173,589 @@ func Add(file F, path string, index int) error {
 		return fmt.Errorf("failed to get encryption key: %w", err)
 	}
 
-	encrypted, err := EncryptGCM(fb, password, meta.Salt)
+	finalSize, err := storeFile(file, meta, nextFileIndex, File{Name: name}, fb, password)
 	if err != nil {
-		return fmt.Errorf("failed to encrypt file: %w", err)
+		return err
+	}
+
+	printAdded(nextFileIndex, name, finalSize, len(fb), false)
+
+	return nil
+}
+
+// pickSlot returns the slot to write to: index itself if it was given, or
+// the first free slot. found is false when the volume is full.
+func pickSlot(meta *Meta, index int) (slot int, found bool, err error) {
+	if index != OUT_OF_BOUNDS_INDEX {
+		if index < 0 || index >= slotCount(meta) {
+			return 0, false, fmt.Errorf("index out of range: %d (valid range: 0-%d)", index, slotCount(meta)-1)
+		}
+		return index, true, nil
+	}
+
+	for i, v := range meta.Files[:slotCount(meta)] {
+		if v.Name == "" {
+			return i, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+// newSlots is 1 if writing to index takes a free slot and 0 if it
+// overwrites a file.
+func newSlots(meta *Meta, index int) int {
+	if meta.Files[index].Name == "" {
+		return 1
+	}
+	return 0
+}
+
+// checkReserve fails if taking added more free slots would leave fewer
+// than MinFree, unless Force is set.
+func checkReserve(meta *Meta, added int) error {
+	if MinFree == 0 || Force || added == 0 {
+		return nil
+	}
+
+	free := slotCount(meta) - CountUsedSlots(meta)
+	if free-added < MinFree {
+		return fmt.Errorf("only %d free slots left and %d are reserved by --min-free, use --force to add anyway", free, MinFree)
+	}
+
+	return nil
+}
+
+// storeFile encrypts fb into slot index and writes the updated metadata
+// with entry recorded for it. Size, Created and MIME are filled in here,
+// and Checksum when Checksums is set. With NameHash the name is replaced
+// by its hash here. With VerifyAfterAdd the block is read back and
+// checked before the metadata is written.
+// It returns the size of the ciphertext.
+func storeFile(file F, meta *Meta, index int, entry File, fb []byte, password string) (int, error) {
+	payload, compressed, err := slotPayload(fb)
+	if err != nil {
+		return 0, err
+	}
+	entry.Compressed = compressed
+
+	encrypted, err := EncryptGCM(payload, password, meta.Salt, contextAAD())
+	if err != nil {
+		return 0, fmt.Errorf("failed to encrypt file: %w", err)
 	}
 
 	if len(encrypted) >= MAX_FILE_SIZE {
-		return fmt.Errorf("file too large after encryption: %d bytes (max %d)", len(encrypted), MAX_FILE_SIZE)
+		return 0, fmt.Errorf("file too large after encryption: %d bytes (max %d)", len(encrypted), MAX_FILE_SIZE)
 	}
 
 	finalSize := len(encrypted)
 
 	missing := MAX_FILE_SIZE - len(encrypted)
 	encrypted = append(encrypted, make([]byte, missing)...)
+	if err := padBlock(meta.Noise, encrypted, finalSize); err != nil {
+		return 0, err
+	}
 
 	if len(encrypted) != MAX_FILE_SIZE {
-		return fmt.Errorf("internal error: padding calculation failed: %d != %d", len(encrypted), MAX_FILE_SIZE)
+		return 0, fmt.Errorf("internal error: padding calculation failed: %d != %d", len(encrypted), MAX_FILE_SIZE)
 	}
 
-	seekPos := int64(META_FILE_SIZE) + (int64(nextFileIndex) * int64(MAX_FILE_SIZE))
-	_, err = file.Seek(seekPos, 0)
-	if err != nil {
-		return fmt.Errorf("failed to seek to file position: %w", err)
+	overwriting := meta.Files[index].Name != ""
+
+	// Check the new block and keep a copy of the old one before touching
+	// the slot, so a failed write can put the original back. A changed
+	// file found with IfChanged always keeps it, the caller never asked
+	// for that slot to be overwritten.
+	var previous []byte
+	if PreserveOnError && overwriting {
+		if err := checkEncryptedBlock(encrypted[:finalSize], password, meta.Salt, contextAAD(), payload); err != nil {
+			return 0, err
+		}
+	}
+	if (PreserveOnError || VerifyAfterAdd || IfChanged) && overwriting {
+		previous, err = ReadBlock(file, index)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read existing file: %w", err)
+		}
 	}
 
-	n, err := file.Write(encrypted)
+	meta.Wear.Adds++
+	meta.Wear.BytesWritten += MAX_FILE_SIZE
+
+	if err := completeEntry(&entry, fb, finalSize, password, meta.Salt); err != nil {
+		return 0, err
+	}
+	replaced := meta.Files[index]
+	meta.Files[index] = entry
+
+	// Refuse before touching the slot if the updated metadata won't fit,
+	// otherwise the block would be written but never referenced.
+	if err := checkMetaFits(meta); err != nil {
+		return 0, err
+	}
+
+	seekPos := int64(META_FILE_SIZE) + (int64(index) * int64(MAX_FILE_SIZE))
+	_, err = file.Seek(seekPos, 0)
 	if err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
+		return 0, fmt.Errorf("failed to seek to file position: %w", err)
 	}
 
-	if n != len(encrypted) {
-		return fmt.Errorf("short write: wrote %d bytes, expected %d", n, len(encrypted))
+	if err := writeFull(file, encrypted); err != nil {
+		if previous != nil {
+			if rerr := WriteBlock(file, previous, entry.Name, index); rerr != nil {
+				return 0, fmt.Errorf("failed to write file: %w (restoring previous file also failed: %v)", err, rerr)
+			}
+			return 0, fmt.Errorf("failed to write file, previous file restored: %w", err)
+		}
+		return 0, fmt.Errorf("failed to write file: %w", err)
 	}
 
 	if err := file.Sync(); err != nil {
-		return fmt.Errorf("failed to sync file data: %w", err)
+		return 0, fmt.Errorf("failed to sync file data: %w", err)
 	}
+	LogDebug("wrote %d bytes to slot %d", len(encrypted), index)
 
-	meta.Files[nextFileIndex] = File{
-		Name:    name,
-		Size:    finalSize,
-		Created: time.Now().Unix(),
+	// The metadata isn't written yet, so putting the slot back the way it
+	// was is enough to undo a block that didn't survive the write.
+	if VerifyAfterAdd {
+		if err := verifySlotContent(file, meta, password, index, fb); err != nil {
+			meta.Files[index] = replaced
+			if rerr := restoreSlot(file, meta, index, previous, replaced.Name); rerr != nil {
+				return 0, fmt.Errorf("verification after write failed: %w (rolling back also failed: %v)", err, rerr)
+			}
+			return 0, fmt.Errorf("verification after write failed, slot %d rolled back: %w", index, err)
+		}
 	}
 
 	if err := WriteMeta(file, meta); err != nil {
-		return fmt.Errorf("failed to update metadata: %w", err)
+		return 0, fmt.Errorf("failed to update metadata: %w", err)
+	}
+
+	return finalSize, nil
+}
+
+// restoreSlot puts back the block previous was read from, or clears the
+// slot when there was none.
+func restoreSlot(file F, meta *Meta, index int, previous []byte, name string) error {
+	if previous != nil {
+		return WriteBlock(file, previous, name, index)
+	}
+	return clearSlot(file, meta, index)
+}
+
+// completeEntry fills in Size, Created and MIME for a file with content fb
+// stored as size bytes of ciphertext, Checksum when Checksums is set and
+// Bound when a Context is. With NameHash the name is replaced by its hash.
+func completeEntry(entry *File, fb []byte, size int, password string, salt []byte) error {
+	if NameHash {
+		sealed, err := EncryptGCM([]byte(entry.Name), password, salt, nil)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt file name: %w", err)
+		}
+		entry.SealedName = sealed
+		entry.Name = hashName(salt, entry.Name)
+	}
+	if Checksums && entry.Checksum == nil {
+		entry.Checksum = ComputeChecksum(fb)
+	}
+	entry.Bound = Context != ""
+	entry.Size = size
+	entry.Created = time.Now().Unix()
+	entry.MIME = http.DetectContentType(fb)
+
+	return nil
+}
+
+func printAdded(index int, name string, finalSize int, size int, shredded bool) {
+	// The bare index is printed even with Silent, it is the point of the
+	// flag.
+	if OutputIndex {
+		fmt.Println(index)
+		return
 	}
 
 	Println("")
 	PrintHeader("FILE ADDED")
 	PrintSeparator(60)
-	Printf(" %-20s %s\n", C(ColorBold+ColorLightBlue, "Index:"), C(ColorWhite, fmt.Sprintf("%d", nextFileIndex)))
+	Printf(" %-20s %s\n", C(ColorBold+ColorLightBlue, "Index:"), C(ColorWhite, fmt.Sprintf("%d", index)))
 	Printf(" %-20s %s\n", C(ColorBold+ColorLightBlue, "Name:"), C(ColorWhite, name))
 	Printf(" %-20s %s\n", C(ColorBold+ColorLightBlue, "Size (encrypted):"), C(ColorWhite, fmt.Sprintf("%d bytes", finalSize)))
-	Printf(" %-20s %s\n", C(ColorBold+ColorLightBlue, "Size (original):"), C(ColorWhite, fmt.Sprintf("%d bytes", len(fb))))
-	Printf(" %-20s %s\n", C(ColorBold+ColorLightBlue, "Location:"), C(ColorWhite, fmt.Sprintf("offset %d", META_FILE_SIZE+(nextFileIndex*MAX_FILE_SIZE))))
+	Printf(" %-20s %s\n", C(ColorBold+ColorLightBlue, "Size (original):"), C(ColorWhite, fmt.Sprintf("%d bytes", size)))
+	Printf(" %-20s %s\n", C(ColorBold+ColorLightBlue, "Location:"), C(ColorWhite, fmt.Sprintf("offset %d", META_FILE_SIZE+(index*MAX_FILE_SIZE))))
+	if shredded {
+		Printf(" %-20s %s\n", C(ColorBold+ColorLightBlue, "Source:"), C(ColorWhite, "shredded"))
+	}
 	PrintSeparator(60)
 	Println("")
+}
+
+// findOrigin returns the slot that was added from origin.
+func findOrigin(meta *Meta, origin string) (int, bool) {
+	for i, v := range meta.Files {
+		if v.Name != "" && v.Origin == origin {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// AddDir adds every regular file directly inside dir. With IfChanged set,
+// files already stored from the same path with the same content are
+// skipped, which makes repeated runs incremental.
+func AddDir(file F, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+
+		if err := Add(file, filepath.Join(dir, e.Name()), OUT_OF_BOUNDS_INDEX); err != nil {
+			return fmt.Errorf("failed to add %s: %w", e.Name(), err)
+		}
+	}
 
 	return nil
 }
+
+// pendingAdd is a file AddDirParallel has read and assigned a slot to.
+// A changed file found with IfChanged goes back to the slot it had, whose
+// block is kept in previous while it is rewritten.
+type pendingAdd struct {
+	index     int
+	entry     File
+	content   []byte
+	overwrite bool
+	previous  []byte
+}
+
+// AddDirParallel adds the same files as AddDir, but derives the key once,
+// encrypts and writes the blocks with up to workers goroutines and writes
+// the metadata a single time at the end. Every file that was written is
+// recorded even if others failed; the errors are returned joined.
+func AddDirParallel(file F, dir string, workers int) (err error) {
+	defer func() { err = checkDevice(err) }()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	meta, err := ReadMeta(file)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	password, err := GetEncKey()
+	if err != nil {
+		return fmt.Errorf("failed to get encryption key: %w", err)
+	}
+
+	pending, err := planDirAdd(meta, dir, entries, password)
+	if err != nil {
+		return err
+	}
+
+	key, err := DeriveKey(password, meta.Salt)
+	if err != nil {
+		return fmt.Errorf("key derivation failed: %w", err)
+	}
+	defer zeroBytes(key)
+
+	errs := make([]error, len(pending))
+	next := make(chan int)
+
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				errs[i] = writePendingAdd(file, key, meta.Noise, &pending[i])
+			}
+		}()
+	}
+	for i := range pending {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync file data: %w", err)
+	}
+
+	added := 0
+	for i, p := range pending {
+		if errs[i] != nil {
+			errs[i] = fmt.Errorf("failed to add %s: %w", p.entry.Name, errs[i])
+			continue
+		}
+
+		meta.Files[p.index] = p.entry
+		meta.Wear.Adds++
+		meta.Wear.BytesWritten += MAX_FILE_SIZE
+		added++
+
+		Printf(" %-7s  %s  %s\n",
+			C(ColorBrightBlue, fmt.Sprintf("[%d]", p.index)),
+			C(ColorWhite, p.entry.Name),
+			C(ColorDim, fmt.Sprintf("%d bytes", len(p.content))))
+	}
+
+	if added > 0 {
+		if err := WriteMeta(file, meta); err != nil {
+			return fmt.Errorf("failed to update metadata: %w", restorePendingAdds(file, pending, errs, err))
+		}
+	}
+
+	PrintSuccess(fmt.Sprintf("Added %s", C(ColorBold+ColorWhite, fmt.Sprintf("%d files", added))))
+
+	return errors.Join(errs...)
+}
+
+// planDirAdd reads every regular file in dir and assigns it a slot,
+// following the same IfChanged rules as Add. The entries are completed
+// with the ciphertext size GCM will produce, so the metadata is known to
+// fit before any block is written.
+func planDirAdd(meta *Meta, dir string, entries []os.DirEntry, password string) ([]pendingAdd, error) {
+	var pending []pendingAdd
+	taken := make(map[int]bool)
+
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+
+		name := e.Name()
+		if len(name) > MAX_FILE_NAME_SIZE {
+			return nil, fmt.Errorf("failed to add %s: filename too long: %d (max %d)", name, len(name), MAX_FILE_NAME_SIZE)
+		}
+
+		path := filepath.Join(dir, name)
+		fb, err := readSource(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add %s: %w", name, err)
+		}
+
+		p := pendingAdd{index: -1, entry: File{Name: name}, content: fb}
+
+		if IfChanged {
+			origin, err := filepath.Abs(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve path: %w", err)
+			}
+			sum := sha256.Sum256(fb)
+			p.entry.Origin = origin
+			p.entry.Checksum = sum[:]
+
+			if i, ok := findOrigin(meta, origin); ok {
+				if bytes.Equal(meta.Files[i].Checksum, p.entry.Checksum) {
+					Printf("%s %s\n", C(ColorDim, "unchanged"), C(ColorWhite, fmt.Sprintf("[%d] %s", i, name)))
+					continue
+				}
+				p.index = i
+				p.overwrite = true
+			}
+		}
+
+		if p.index < 0 {
+			for i, v := range meta.Files[:slotCount(meta)] {
+				if v.Name == "" && !taken[i] {
+					p.index = i
+					break
+				}
+			}
+		}
+		if p.index < 0 {
+			return nil, fmt.Errorf("no more file slots available (max %d files)", slotCount(meta))
+		}
+		taken[p.index] = true
+
+		p.content, p.entry.Compressed, err = slotPayload(fb)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add %s: %w", name, err)
+		}
+
+		if err := completeEntry(&p.entry, fb, len(p.content)+NonceSize+TagSize, password, meta.Salt); err != nil {
+			return nil, err
+		}
+		pending = append(pending, p)
+	}
+
+	added := 0
+	for _, p := range pending {
+		added += newSlots(meta, p.index)
+	}
+	if err := checkReserve(meta, added); err != nil {
+		return nil, err
+	}
+
+	planned := *meta
+	for _, p := range pending {
+		planned.Files[p.index] = p.entry
+	}
+	if err := checkMetaFits(&planned); err != nil {
+		return nil, err
+	}
+
+	return pending, nil
+}
+
+// writePendingAdd encrypts one planned file and writes it to its slot,
+// padded with noise when noise is set. When the file overwrites a slot the
+// metadata still points at, the old block is read into p.previous first
+// and written back if the write or its verification fails, so the old
+// entry keeps a block that matches it.
+func writePendingAdd(file F, key []byte, noise bool, p *pendingAdd) error {
+	encrypted, err := encryptWithKey(p.content, key, contextAAD())
+	if err != nil {
+		return fmt.Errorf("failed to encrypt file: %w", err)
+	}
+
+	if len(encrypted) != p.entry.Size {
+		return fmt.Errorf("internal error: ciphertext size %d, expected %d", len(encrypted), p.entry.Size)
+	}
+
+	block := make([]byte, MAX_FILE_SIZE)
+	copy(block, encrypted)
+	if err := padBlock(noise, block, len(encrypted)); err != nil {
+		return err
+	}
+
+	seekPos := int64(META_FILE_SIZE) + (int64(p.index) * int64(MAX_FILE_SIZE))
+	if p.overwrite {
+		p.previous = make([]byte, MAX_FILE_SIZE)
+		if n, err := file.ReadAt(p.previous, seekPos); n != MAX_FILE_SIZE {
+			p.previous = nil
+			return fmt.Errorf("failed to read existing file: %w", err)
+		}
+	}
+
+	err = writePendingBlock(file, key, p, block, seekPos)
+	if err != nil && p.previous != nil {
+		if _, rerr := file.WriteAt(p.previous, seekPos); rerr != nil {
+			return fmt.Errorf("%w (restoring previous file also failed: %v)", err, rerr)
+		}
+		return fmt.Errorf("%w, previous file restored", err)
+	}
+
+	return err
+}
+
+// writePendingBlock writes block at seekPos and, with VerifyAfterAdd,
+// reads the ciphertext back and checks it against p.
+func writePendingBlock(file F, key []byte, p *pendingAdd, block []byte, seekPos int64) error {
+	n, err := file.WriteAt(block, seekPos)
+	if err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if n != MAX_FILE_SIZE {
+		return fmt.Errorf("short write: wrote %d bytes, expected %d", n, MAX_FILE_SIZE)
+	}
+
+	if VerifyAfterAdd {
+		written := make([]byte, p.entry.Size)
+		if _, err := file.ReadAt(written, seekPos); err != nil {
+			return fmt.Errorf("verification after write failed: %w", err)
+		}
+		decrypted, err := decryptWithKey(written, key, contextAAD())
+		if err != nil {
+			return fmt.Errorf("verification after write failed: %w", err)
+		}
+		if !bytes.Equal(decrypted, p.content) {
+			return fmt.Errorf("verification after write failed: content mismatch at index %d", p.index)
+		}
+	}
+
+	return nil
+}
+
+// restorePendingAdds puts back the old blocks of the files that were
+// rewritten in place once the metadata that would have pointed at the new
+// ones failed to write. It returns err, with any restore failures added.
+func restorePendingAdds(file F, pending []pendingAdd, errs []error, err error) error {
+	for i, p := range pending {
+		if errs[i] != nil || p.previous == nil {
+			continue
+		}
+		seekPos := int64(META_FILE_SIZE) + (int64(p.index) * int64(MAX_FILE_SIZE))
+		if _, rerr := file.WriteAt(p.previous, seekPos); rerr != nil {
+			err = fmt.Errorf("%w (restoring previous file at index %d also failed: %v)", err, p.index, rerr)
+		}
+	}
+
+	return err
+}
+
+// readSource reads a source file, failing if it can't fit in a slot.
+func readSource(path string) ([]byte, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer src.Close()
+
+	fb, err := readLimited(src, maxSourceSize())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	return fb, nil
+}
+
+// checkSourceUnchanged compares the source file's size and mtime from
+// before and after it was read, to catch a file that was being written to
+// while Add read it.
+func checkSourceUnchanged(before, after os.FileInfo, read int) error {
+	if before.Size() != after.Size() || !before.ModTime().Equal(after.ModTime()) {
+		return fmt.Errorf("source changed while reading: size %d -> %d, mtime %s -> %s",
+			before.Size(), after.Size(),
+			before.ModTime().Format(time.RFC3339Nano), after.ModTime().Format(time.RFC3339Nano))
+	}
+
+	if int64(read) != after.Size() {
+		return fmt.Errorf("source changed while reading: read %d bytes, file is %d bytes", read, after.Size())
+	}
+
+	return nil
+}
+
+// checkEncryptedBlock decrypts a freshly encrypted block and compares it
+// with the plaintext it was made from.
+func checkEncryptedBlock(encrypted []byte, password string, salt []byte, additionalData []byte, plaintext []byte) error {
+	decrypted, err := DecryptGCM(encrypted, password, salt, additionalData)
+	if err != nil {
+		return fmt.Errorf("encrypted block failed validation: %w", err)
+	}
+
+	if !bytes.Equal(decrypted, plaintext) {
+		return fmt.Errorf("encrypted block failed validation: content mismatch")
+	}
+
+	return nil
+}
+
+// MaxPlaintextSize is the largest input that still fits in a slot once the
+// GCM nonce and tag have been added.
+func MaxPlaintextSize() int64 {
+	return MAX_FILE_SIZE - 1 - NonceSize - TagSize
+}
+
+// readLimited reads r to the end but never buffers more than limit+1 bytes,
+// so an oversized stream fails early instead of exhausting memory.
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	b, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(b)) > limit {
+		return nil, fmt.Errorf("input too large: exceeds %d bytes", limit)
+	}
+
+	return b, nil
+}
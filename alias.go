@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ResolveIndex parses an index argument. An argument starting with @ is
+// looked up in the volume's aliases, so "@config" can stand in for the
+// slot it was set to.
+func ResolveIndex(file F, arg string) (int, error) {
+	name, ok := strings.CutPrefix(arg, "@")
+	if !ok {
+		return strconv.Atoi(arg)
+	}
+
+	meta, err := ReadMeta(file)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	index, ok := meta.Aliases[name]
+	if !ok {
+		return 0, fmt.Errorf("no alias named %q", name)
+	}
+
+	return index, nil
+}
+
+// SetAlias points name at the used slot index, replacing any previous
+// alias with that name.
+func SetAlias(file F, name string, index int) error {
+	if name == "" || strings.ContainsAny(name, "@ \t") {
+		return fmt.Errorf("invalid alias name: %q", name)
+	}
+	if index < 0 || index >= TOTAL_FILES {
+		return fmt.Errorf("index out of range: %d (valid range: 0-%d)", index, TOTAL_FILES-1)
+	}
+
+	meta, err := ReadMeta(file)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	if meta.Files[index].Name == "" {
+		return fmt.Errorf("no file exists at index %d", index)
+	}
+
+	if meta.Aliases == nil {
+		meta.Aliases = make(map[string]int)
+	}
+	meta.Aliases[name] = index
+
+	if err := checkMetaFits(meta); err != nil {
+		return err
+	}
+	if err := WriteMeta(file, meta); err != nil {
+		return fmt.Errorf("failed to update metadata: %w", err)
+	}
+
+	PrintSuccess(fmt.Sprintf("Alias @%s now points to index %d", name, index))
+
+	return nil
+}
+
+// RemoveAlias deletes the alias called name.
+func RemoveAlias(file F, name string) error {
+	meta, err := ReadMeta(file)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	if _, ok := meta.Aliases[name]; !ok {
+		return fmt.Errorf("no alias named %q", name)
+	}
+	delete(meta.Aliases, name)
+
+	if err := WriteMeta(file, meta); err != nil {
+		return fmt.Errorf("failed to update metadata: %w", err)
+	}
+
+	PrintSuccess(fmt.Sprintf("Alias @%s removed", name))
+
+	return nil
+}
+
+// ListAliases prints every alias with the file it points to.
+func ListAliases(file F) error {
+	meta, err := ReadMeta(file)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	names := make([]string, 0, len(meta.Aliases))
+	for name := range meta.Aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	PrintHeader("ALIASES")
+	PrintSeparator(70)
+	for _, name := range names {
+		index := meta.Aliases[name]
+		Printf(" %-20s  %-7s  %s\n",
+			C(ColorWhite, "@"+name),
+			C(ColorBrightBlue, fmt.Sprintf("[%d]", index)),
+			C(ColorDim, meta.Files[index].Name))
+	}
+	PrintSeparator(70)
+
+	return nil
+}
+
+// dropAliases removes the aliases pointing at index, for when its file is
+// deleted.
+func dropAliases(meta *Meta, index int) {
+	for name, i := range meta.Aliases {
+		if i == index {
+			delete(meta.Aliases, name)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAliases(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	Silent = true
+	defer func() { Silent = false }()
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	Add(file, CreateTempSourceFileWithName(t, []byte("key: value"), "config.yaml"), 5)
+	Add(file, CreateTempSourceFileWithName(t, []byte("other"), "other.txt"), 6)
+
+	if err := SetAlias(file, "myconfig", 5); err != nil {
+		t.Fatalf("SetAlias failed: %v", err)
+	}
+	if err := SetAlias(file, "empty", 7); err == nil {
+		t.Error("Expected SetAlias to refuse an empty slot")
+	}
+	if err := SetAlias(file, "bad@name", 5); err == nil {
+		t.Error("Expected SetAlias to refuse a name containing @")
+	}
+
+	// Aliases survive reads and are resolved from the metadata.
+	index, err := ResolveIndex(file, "@myconfig")
+	if err != nil {
+		t.Fatalf("ResolveIndex failed: %v", err)
+	}
+	if index != 5 {
+		t.Errorf("Expected @myconfig to resolve to 5, got %d", index)
+	}
+	if index, err := ResolveIndex(file, "6"); err != nil || index != 6 {
+		t.Errorf("Expected plain index 6, got %d: %v", index, err)
+	}
+	if _, err := ResolveIndex(file, "@missing"); err == nil {
+		t.Error("Expected an unknown alias to fail")
+	}
+
+	outPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := Get(file, index, outPath); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if data, _ := os.ReadFile(outPath); string(data) != "key: value" {
+		t.Errorf("Expected config content, got %q", data)
+	}
+
+	// Pointing an existing alias elsewhere replaces it.
+	if err := SetAlias(file, "myconfig", 6); err != nil {
+		t.Fatalf("SetAlias failed: %v", err)
+	}
+	if index, _ := ResolveIndex(file, "@myconfig"); index != 6 {
+		t.Errorf("Expected @myconfig to move to 6, got %d", index)
+	}
+
+	if err := RemoveAlias(file, "myconfig"); err != nil {
+		t.Fatalf("RemoveAlias failed: %v", err)
+	}
+	if _, err := ResolveIndex(file, "@myconfig"); err == nil {
+		t.Error("Expected removed alias not to resolve")
+	}
+	if err := RemoveAlias(file, "myconfig"); err == nil {
+		t.Error("Expected removing a missing alias to fail")
+	}
+
+	// Deleting a file drops its aliases.
+	SetAlias(file, "cfg", 5)
+	Del(file, "5")
+	meta := VerifyMetadataIntegrity(t, file)
+	if _, ok := meta.Aliases["cfg"]; ok {
+		t.Error("Expected delete to remove the alias of the deleted file")
+	}
+}
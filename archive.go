@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// A volume archive holds a volume as it is stored, still encrypted, but
+// only the parts in use:
+//
+//	magic "HDNFSARC", version byte
+//	uint32 length, metadata block without its padding
+//	per used slot: uint32 index, uint32 size, ciphertext
+//	uint32 archiveEnd
+//
+// Integers are big endian. Names and everything else about the files stay
+// inside the encrypted metadata.
+const (
+	ARCHIVE_MAGIC   = "HDNFSARC"
+	ARCHIVE_VERSION = 1
+
+	archiveEnd = ^uint32(0)
+)
+
+// Export writes file to w as a volume archive. Unlike ExportTar nothing is
+// decrypted beyond the metadata; free slots and padding are left out, so
+// the archive is a fraction of the volume size.
+//
+// Nothing else is written to stdout, so w can be os.Stdout.
+func Export(file F, w io.Writer) error {
+	meta, err := ReadMeta(file)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	metaBlock := make([]byte, META_FILE_SIZE)
+	if _, err := file.ReadAt(metaBlock, 0); err != nil {
+		return fmt.Errorf("failed to read metadata: %w", err)
+	}
+	_, encrypted, err := parseMetaBlock(metaBlock)
+	if err != nil {
+		return err
+	}
+	metaLen := HEADER_SIZE + len(encrypted) + CHECKSUM_SIZE
+
+	header := append([]byte(ARCHIVE_MAGIC), ARCHIVE_VERSION)
+	header = binary.BigEndian.AppendUint32(header, uint32(metaLen))
+	if _, err := w.Write(header); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if _, err := w.Write(metaBlock[:metaLen]); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+
+	for i, v := range meta.Files {
+		if v.Name == "" {
+			continue
+		}
+
+		block, err := ReadBlock(file, i)
+		if err != nil {
+			return fmt.Errorf("failed to read slot %d: %w", i, err)
+		}
+
+		record := binary.BigEndian.AppendUint32(nil, uint32(i))
+		record = binary.BigEndian.AppendUint32(record, uint32(v.Size))
+		record = append(record, block[:v.Size]...)
+		if _, err := w.Write(record); err != nil {
+			return fmt.Errorf("failed to write archive: %w", err)
+		}
+	}
+
+	if _, err := w.Write(binary.BigEndian.AppendUint32(nil, archiveEnd)); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+
+	return nil
+}
+
+// Import rebuilds the volume in r onto file. The metadata must decrypt
+// with the current password and every used slot must be in the archive
+// with its recorded size. Blocks are written as they are read and the
+// metadata last, so a failed import leaves no metadata pointing at
+// missing blocks on a fresh destination.
+//
+// A regular file is truncated first and ends up sparse. On a device, free
+// slots are not touched, so import onto an erased one.
+func Import(file F, r io.Reader) error {
+	header := make([]byte, len(ARCHIVE_MAGIC)+1+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return fmt.Errorf("failed to read archive header: %w", err)
+	}
+	if string(header[:len(ARCHIVE_MAGIC)]) != ARCHIVE_MAGIC {
+		return errors.New("not a volume archive")
+	}
+	if version := header[len(ARCHIVE_MAGIC)]; version != ARCHIVE_VERSION {
+		return fmt.Errorf("unsupported archive version: %d", version)
+	}
+
+	metaLen := binary.BigEndian.Uint32(header[len(ARCHIVE_MAGIC)+1:])
+	if metaLen < HEADER_SIZE+CHECKSUM_SIZE || metaLen > META_FILE_SIZE {
+		return fmt.Errorf("invalid metadata length in archive: %d", metaLen)
+	}
+	metaBlock := make([]byte, META_FILE_SIZE)
+	if _, err := io.ReadFull(r, metaBlock[:metaLen]); err != nil {
+		return fmt.Errorf("failed to read archive metadata: %w", err)
+	}
+	meta, err := decodeMetaBlock(metaBlock)
+	if err != nil {
+		return fmt.Errorf("failed to read archive metadata: %w", err)
+	}
+
+	s, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat destination: %w", err)
+	}
+	if s.Mode().IsRegular() {
+		if err := file.Truncate(0); err != nil {
+			return fmt.Errorf("failed to truncate file: %w", err)
+		}
+	}
+
+	seen := make([]bool, TOTAL_FILES)
+	imported := 0
+	record := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, record[:4]); err != nil {
+			return fmt.Errorf("archive is truncated: %w", err)
+		}
+		index := binary.BigEndian.Uint32(record[:4])
+		if index == archiveEnd {
+			break
+		}
+		if _, err := io.ReadFull(r, record[4:]); err != nil {
+			return fmt.Errorf("archive is truncated: %w", err)
+		}
+		size := int(binary.BigEndian.Uint32(record[4:]))
+
+		if index >= TOTAL_FILES || meta.Files[index].Name == "" {
+			return fmt.Errorf("archive holds slot %d, which its metadata doesn't use", index)
+		}
+		if size != meta.Files[index].Size {
+			return fmt.Errorf("archive slot %d is %d bytes, its metadata says %d", index, size, meta.Files[index].Size)
+		}
+
+		block := make([]byte, MAX_FILE_SIZE)
+		if _, err := io.ReadFull(r, block[:size]); err != nil {
+			return fmt.Errorf("archive is truncated in slot %d: %w", index, err)
+		}
+		if err := padBlock(meta.Noise, block, size); err != nil {
+			return err
+		}
+		if err := WriteBlock(file, block, meta.Files[index].Name, int(index)); err != nil {
+			return fmt.Errorf("failed to write slot %d: %w", index, err)
+		}
+
+		seen[index] = true
+		imported++
+	}
+
+	for i, v := range meta.Files {
+		if v.Name != "" && !seen[i] {
+			return fmt.Errorf("archive is missing slot %d", i)
+		}
+	}
+
+	if _, err := file.Seek(0, 0); err != nil {
+		return fmt.Errorf("failed to seek to metadata position: %w", err)
+	}
+	forgetMeta(file)
+	if err := writeFull(file, metaBlock); err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync metadata: %w", err)
+	}
+
+	PrintSuccess(fmt.Sprintf("Imported %s", C(ColorBold+ColorWhite, fmt.Sprintf("%d files", imported))))
+
+	return nil
+}
+
+// openArchivePath opens path for reading, or stdin for "-".
+func openArchivePath(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return os.Stdin, nil
+	}
+	return os.Open(path)
+}
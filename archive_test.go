@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExportImportVolume(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	src := NewMockFile(0)
+	InitMeta(src, "file")
+
+	files := make(map[int][]byte)
+	for i := range 6 {
+		index := i * 137
+		content := GenerateRandomBytes(100 + i*8000)
+		sourcePath := CreateTempSourceFileWithName(t, content, fmt.Sprintf("file_%d.bin", i))
+		if err := Add(src, sourcePath, index); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+		files[index] = content
+	}
+
+	var archive bytes.Buffer
+	if err := Export(src, &archive); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if archive.Len() >= META_FILE_SIZE+len(files)*MAX_FILE_SIZE {
+		t.Errorf("Archive is %d bytes, expected less than the used slots take on the volume", archive.Len())
+	}
+	if bytes.Contains(archive.Bytes(), []byte("file_3.bin")) {
+		t.Error("Archive contains a file name in plaintext")
+	}
+	raw := bytes.Clone(archive.Bytes())
+
+	dst := NewMockFile(0)
+	captureOutput(func() {
+		if err := Import(dst, &archive); err != nil {
+			t.Fatalf("Import failed: %v", err)
+		}
+	})
+
+	srcMeta := VerifyMetadataIntegrity(t, src)
+	dstMeta := VerifyMetadataIntegrity(t, dst)
+	if !reflect.DeepEqual(srcMeta.Files, dstMeta.Files) {
+		t.Error("Imported metadata differs from the source")
+	}
+
+	for index, content := range files {
+		var buf bytes.Buffer
+		if err := GetToWriter(dst, index, &buf); err != nil {
+			t.Fatalf("Get of slot %d failed: %v", index, err)
+		}
+		if !bytes.Equal(buf.Bytes(), content) {
+			t.Errorf("Content mismatch in slot %d", index)
+		}
+	}
+
+	if err := Import(NewMockFile(0), bytes.NewReader(raw[:len(raw)-10])); err == nil {
+		t.Error("Expected a truncated archive to be refused")
+	}
+
+	SetPasswordForTesting("some-other-password")
+	if err := Import(NewMockFile(0), bytes.NewReader(raw)); err == nil {
+		t.Error("Expected import under the wrong password to fail")
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+)
+
+// metaBackupOffset is the position of backup slot n. The ring starts right
+// after the last data slot, so the device must have room for it, see
+// checkBackupRoom.
+func metaBackupOffset(n int) int64 {
+	return int64(META_FILE_SIZE) + int64(TOTAL_FILES)*int64(MAX_FILE_SIZE) + int64(n)*int64(META_FILE_SIZE)
+}
+
+// checkBackupRoom fails if a device is too small to hold n backup slots
+// after the data slots. File-backed volumes grow on demand and always fit.
+func checkBackupRoom(file F, n int) error {
+	s, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat device: %w", err)
+	}
+	if s.Mode().IsRegular() {
+		return nil
+	}
+
+	size, err := DeviceSize(file)
+	if err != nil {
+		return err
+	}
+	if need := metaBackupOffset(n); size < need {
+		return fmt.Errorf("device is %d bytes, %d metadata backups need %d", size, n, need)
+	}
+
+	return nil
+}
+
+// backupMeta copies the metadata block currently on disk into backup slot
+// n before WriteMeta replaces it. A device that was never initialized has
+// nothing worth keeping and is skipped.
+func backupMeta(file F, n int) error {
+	block := make([]byte, META_FILE_SIZE)
+	if read, _ := file.ReadAt(block, 0); read != META_FILE_SIZE {
+		return nil
+	}
+	if _, _, err := parseMetaBlock(block); err != nil {
+		return nil
+	}
+
+	if _, err := file.Seek(metaBackupOffset(n), 0); err != nil {
+		return fmt.Errorf("failed to seek to metadata backup: %w", err)
+	}
+
+	if err := writeFull(file, block); err != nil {
+		return fmt.Errorf("failed to write metadata backup: %w", err)
+	}
+
+	return nil
+}
+
+// Rollback replaces the metadata with the newest backup older than the
+// current version. The wear counters are kept, and the replaced metadata
+// goes onto the ring like any other write, so a second rollback undoes the
+// first.
+//
+// Files whose slot was reused after the backup was taken now point at the
+// newer data and fail to read.
+func Rollback(file F) error {
+	current, err := ReadMeta(file)
+
+	backups := KeepMetaBackups
+	if current != nil && current.Backups > 0 {
+		backups = current.Backups
+	}
+	if backups == 0 {
+		if err != nil {
+			return fmt.Errorf("failed to read metadata: %w", err)
+		}
+		return fmt.Errorf("volume keeps no metadata backups, enable them with --keep-metadata-backup")
+	}
+
+	var restored *Meta
+	block := make([]byte, META_FILE_SIZE)
+	for n := range backups {
+		if read, _ := file.ReadAt(block, metaBackupOffset(n)); read != META_FILE_SIZE {
+			continue
+		}
+
+		m, err := decodeMetaBlock(block)
+		if err != nil {
+			continue
+		}
+		if current != nil && m.Wear.MetaWrites >= current.Wear.MetaWrites {
+			continue
+		}
+		if restored == nil || m.Wear.MetaWrites > restored.Wear.MetaWrites {
+			restored = m
+		}
+	}
+
+	if restored == nil {
+		return fmt.Errorf("no metadata backup to roll back to")
+	}
+
+	version := restored.Wear.MetaWrites
+	if current != nil {
+		restored.Wear = current.Wear
+	}
+	restored.Backups = backups
+
+	if err := WriteMeta(file, restored); err != nil {
+		return fmt.Errorf("failed to write restored metadata: %w", err)
+	}
+
+	count := 0
+	for _, f := range restored.Files {
+		if f.Name != "" {
+			count++
+		}
+	}
+
+	PrintSuccess(fmt.Sprintf("Rolled back to metadata version %s (%s)",
+		C(ColorBold+ColorWhite, fmt.Sprintf("%d", version)),
+		C(ColorBold+ColorWhite, fmt.Sprintf("%d files", count))))
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRollbackAfterDelete(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	Silent = true
+	defer func() { Silent = false }()
+
+	KeepMetaBackups = 3
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+	KeepMetaBackups = 0
+
+	content := []byte("precious data")
+	if err := Add(file, CreateTempSourceFileWithName(t, content, "precious.txt"), 0); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := Add(file, CreateTempSourceFileWithName(t, []byte("other"), "other.txt"), 1); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	// The setting is remembered, so delete keeps the block without the flag.
+	if _, err := Del(file, "0"); err != nil {
+		t.Fatalf("Del failed: %v", err)
+	}
+	meta := VerifyMetadataIntegrity(t, file)
+	if meta.Files[0].Name != "" {
+		t.Fatal("Expected slot 0 to be empty after delete")
+	}
+	deletedWrites := meta.Wear.MetaWrites
+
+	if err := Rollback(file); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+
+	meta = VerifyMetadataIntegrity(t, file)
+	if meta.Files[0].Name != "precious.txt" || meta.Files[1].Name != "other.txt" {
+		t.Fatalf("Expected both files after rollback, got %q and %q", meta.Files[0].Name, meta.Files[1].Name)
+	}
+	if meta.Wear.MetaWrites != deletedWrites+1 {
+		t.Errorf("Expected wear counters to carry on from %d, got %d", deletedWrites, meta.Wear.MetaWrites)
+	}
+
+	outPath := filepath.Join(t.TempDir(), "out.txt")
+	if err := Get(file, 0, outPath); err != nil {
+		t.Fatalf("Get failed after rollback: %v", err)
+	}
+	if data, _ := os.ReadFile(outPath); string(data) != string(content) {
+		t.Errorf("Expected %q after rollback, got %q", content, data)
+	}
+
+	// A second rollback undoes the first.
+	if err := Rollback(file); err != nil {
+		t.Fatalf("Second rollback failed: %v", err)
+	}
+	meta = VerifyMetadataIntegrity(t, file)
+	if meta.Files[0].Name != "" || meta.Files[1].Name != "other.txt" {
+		t.Errorf("Expected the delete to be back after a second rollback, got %q and %q", meta.Files[0].Name, meta.Files[1].Name)
+	}
+}
+
+func TestRollbackWithoutBackups(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	if err := Rollback(file); err == nil {
+		t.Error("Expected Rollback to fail on a volume without backups")
+	}
+
+	if err := Add(file, CreateTempSourceFile(t, []byte("data")), 0); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	captureOutput(func() { Del(file, "0") })
+
+	block, err := ReadBlock(file, 0)
+	if err != nil {
+		t.Fatalf("ReadBlock failed: %v", err)
+	}
+	for _, b := range block {
+		if b != 0 {
+			t.Fatal("Expected delete to zero the block when no backups are kept")
+		}
+	}
+}
+
+func TestMetaBackupsNeedRoomOnDevice(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	layout := int64(META_FILE_SIZE) + int64(TOTAL_FILES)*int64(MAX_FILE_SIZE)
+	capacity := layout
+
+	old := blockDeviceSize
+	blockDeviceSize = func(F) (int64, error) { return capacity, nil }
+	defer func() { blockDeviceSize = old }()
+
+	device := &blockDeviceFile{MockFile: NewMockFile(0)}
+	InitMeta(device.MockFile, "file")
+
+	KeepMetaBackups = 3
+	defer func() { KeepMetaBackups = 0 }()
+
+	meta := VerifyMetadataIntegrity(t, device)
+	if err := WriteMeta(device, meta); err == nil {
+		t.Fatal("Expected backups to be refused on a device sized for the data slots only")
+	}
+
+	capacity = metaBackupOffset(3)
+	meta = VerifyMetadataIntegrity(t, device)
+	if err := WriteMeta(device, meta); err != nil {
+		t.Fatalf("WriteMeta with room for the backups failed: %v", err)
+	}
+	if c := checkGeometry(device, meta); c.Status != DOCTOR_PASS {
+		t.Errorf("Expected geometry to pass with room for the ring, got %s: %s", c.Status, c.Detail)
+	}
+
+	capacity = layout
+	if c := checkGeometry(device, meta); c.Status != DOCTOR_FAIL {
+		t.Errorf("Expected geometry to fail without room for the ring, got %s: %s", c.Status, c.Detail)
+	}
+}
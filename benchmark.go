@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// BenchResult holds the timing of one benchmarked operation.
+type BenchResult struct {
+	Op      string
+	Count   int
+	Bytes   int64
+	Elapsed time.Duration
+}
+
+func (r BenchResult) MBPerSec() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / (1 << 20) / r.Elapsed.Seconds()
+}
+
+func (r BenchResult) OpsPerSec() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Count) / r.Elapsed.Seconds()
+}
+
+// Benchmark times count adds and gets of full size files in free slots on
+// the volume, followed by a sync of the volume to a temporary file. The
+// slots are written back with their old contents and the wear counters are
+// put back before returning, so the only trace left is one metadata write.
+//
+// With Silent set the results are printed as tab separated lines of
+// op, count, bytes, seconds, MB/s and ops/s.
+func Benchmark(file F, count int) ([]BenchResult, error) {
+	if count < 1 {
+		return nil, fmt.Errorf("invalid count: %d", count)
+	}
+
+	results, err := runBenchmark(file, count)
+	if err != nil {
+		return nil, err
+	}
+
+	if Silent {
+		for _, r := range results {
+			fmt.Printf("%s\t%d\t%d\t%.6f\t%.2f\t%.2f\n",
+				r.Op, r.Count, r.Bytes, r.Elapsed.Seconds(), r.MBPerSec(), r.OpsPerSec())
+		}
+		return results, nil
+	}
+
+	PrintHeader("BENCHMARK")
+	PrintSeparator(70)
+	Printf(" %-8s  %8s  %12s  %12s  %12s\n",
+		C(ColorBold+ColorLightBlue, "OP"),
+		C(ColorBold+ColorLightBlue, "COUNT"),
+		C(ColorBold+ColorLightBlue, "TIME"),
+		C(ColorBold+ColorLightBlue, "MB/S"),
+		C(ColorBold+ColorLightBlue, "OPS/S"))
+	for _, r := range results {
+		Printf(" %-8s  %8s  %12s  %12s  %12s\n",
+			C(ColorWhite, r.Op),
+			C(ColorWhite, fmt.Sprintf("%d", r.Count)),
+			C(ColorWhite, r.Elapsed.Round(time.Millisecond).String()),
+			C(ColorWhite, fmt.Sprintf("%.2f", r.MBPerSec())),
+			C(ColorWhite, fmt.Sprintf("%.2f", r.OpsPerSec())))
+	}
+	PrintSeparator(70)
+
+	return results, nil
+}
+
+func runBenchmark(file F, count int) (results []BenchResult, err error) {
+	meta, err := ReadMeta(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	var slots []int
+	for i, v := range meta.Files {
+		if v.Name == "" && len(slots) < count {
+			slots = append(slots, i)
+		}
+	}
+	if len(slots) < count {
+		return nil, fmt.Errorf("not enough free slots: need %d, have %d", count, len(slots))
+	}
+
+	// A slot past the end of a volume file reads as zeros, it is kept as
+	// nil and zeroed again afterwards.
+	saved := make([][]byte, len(slots))
+	for n, i := range slots {
+		saved[n], err = ReadBlock(file, i)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to save slot %d: %w", i, err)
+		}
+	}
+	wear := meta.Wear
+
+	tmpDir, err := os.MkdirTemp("", "hdnfs-bench-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	payload := make([]byte, MaxPlaintextSize())
+	if _, err := rand.Read(payload); err != nil {
+		return nil, fmt.Errorf("failed to generate payload: %w", err)
+	}
+	srcPath := filepath.Join(tmpDir, "bench.bin")
+	if err := os.WriteFile(srcPath, payload, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write payload: %w", err)
+	}
+
+	// The individual operations print their own progress, which would
+	// only add noise to the timings.
+	silent := Silent
+	Silent = true
+	defer func() { Silent = silent }()
+
+	defer func() {
+		if restoreErr := restoreBenchSlots(file, slots, saved, wear); restoreErr != nil && err == nil {
+			results, err = nil, restoreErr
+		}
+	}()
+
+	start := time.Now()
+	for _, i := range slots {
+		if err := Add(file, srcPath, i); err != nil {
+			return nil, fmt.Errorf("add failed at index %d: %w", i, err)
+		}
+	}
+	results = append(results, BenchResult{
+		Op:      "add",
+		Count:   count,
+		Bytes:   int64(count) * int64(len(payload)),
+		Elapsed: time.Since(start),
+	})
+
+	outPath := filepath.Join(tmpDir, "out.bin")
+	start = time.Now()
+	for _, i := range slots {
+		if err := Get(file, i, outPath); err != nil {
+			return nil, fmt.Errorf("get failed at index %d: %w", i, err)
+		}
+	}
+	results = append(results, BenchResult{
+		Op:      "get",
+		Count:   count,
+		Bytes:   int64(count) * int64(len(payload)),
+		Elapsed: time.Since(start),
+	})
+
+	dst, err := os.Create(filepath.Join(tmpDir, "sync.hdnfs"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sync target: %w", err)
+	}
+	defer dst.Close()
+
+	meta, err = ReadMeta(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata: %w", err)
+	}
+	synced := CountNonEmptyFiles(meta)
+
+	start = time.Now()
+	if err := Sync(file, dst); err != nil {
+		return nil, fmt.Errorf("sync failed: %w", err)
+	}
+	results = append(results, BenchResult{
+		Op:      "sync",
+		Count:   synced,
+		Bytes:   int64(META_FILE_SIZE) + int64(synced)*int64(MAX_FILE_SIZE),
+		Elapsed: time.Since(start),
+	})
+
+	return results, nil
+}
+
+// restoreBenchSlots writes the saved blocks back to slots, frees them and
+// puts the wear counters back to wear. MetaWrites is left alone, it is the
+// metadata generation and has to keep growing for the backup ring.
+func restoreBenchSlots(file F, slots []int, saved [][]byte, wear WearStats) error {
+	meta, err := ReadMeta(file)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	for n, i := range slots {
+		if saved[n] == nil {
+			err = zeroSlot(file, i)
+		} else {
+			err = WriteBlock(file, saved[n], "", i)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore slot %d: %w", i, err)
+		}
+		meta.Files[i] = File{}
+	}
+
+	wear.MetaWrites = meta.Wear.MetaWrites
+	meta.Wear = wear
+	if err := WriteMeta(file, meta); err != nil {
+		return fmt.Errorf("failed to update metadata: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestBenchmarkCommand(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	mock := NewMockFile(0)
+	if err := InitMeta(mock, "file"); err != nil {
+		t.Fatalf("InitMeta failed: %v", err)
+	}
+
+	sourcePath := CreateTempSourceFileWithName(t, []byte("keep me"), "keep.txt")
+	if err := Add(mock, sourcePath, 0); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	// Free slots may hold noise, which has to survive the benchmark.
+	noise := bytes.Repeat([]byte{0xA5}, MAX_FILE_SIZE)
+	if err := WriteBlock(mock, noise, "", 1); err != nil {
+		t.Fatalf("WriteBlock failed: %v", err)
+	}
+	before, err := ReadMeta(mock)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+	wear := before.Wear
+
+	results, err := Benchmark(mock, 2)
+	if err != nil {
+		t.Fatalf("Benchmark failed: %v", err)
+	}
+
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %d", len(results))
+	}
+	for i, op := range []string{"add", "get", "sync"} {
+		r := results[i]
+		if r.Op != op {
+			t.Errorf("Result %d: expected op %q, got %q", i, op, r.Op)
+		}
+		if r.Elapsed <= 0 || r.Bytes <= 0 || r.Count <= 0 {
+			t.Errorf("%s: expected non-zero results, got %+v", op, r)
+		}
+		if r.MBPerSec() <= 0 || r.OpsPerSec() <= 0 {
+			t.Errorf("%s: expected positive throughput, got %.2f MB/s %.2f ops/s", op, r.MBPerSec(), r.OpsPerSec())
+		}
+	}
+
+	meta, err := ReadMeta(mock)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+	if CountUsedSlots(meta) != 1 || meta.Files[0].Name != "keep.txt" {
+		t.Errorf("Benchmark did not clean up: %d used slots", CountUsedSlots(meta))
+	}
+	want := wear
+	want.MetaWrites = meta.Wear.MetaWrites
+	want.BytesWritten += META_FILE_SIZE
+	if meta.Wear != want || meta.Wear.MetaWrites <= wear.MetaWrites {
+		t.Errorf("Benchmark did not restore wear: before %+v, after %+v", wear, meta.Wear)
+	}
+	block, err := ReadBlock(mock, 1)
+	if err != nil {
+		t.Fatalf("ReadBlock failed: %v", err)
+	}
+	if !bytes.Equal(block, noise) {
+		t.Error("Benchmark did not restore the slot contents")
+	}
+	if Silent {
+		t.Error("Benchmark did not restore Silent")
+	}
+}
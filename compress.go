@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+)
+
+// maxSourceSize is the largest input add accepts. With Compress, whether
+// it fits is only known after compressing, so the cap is
+// MAX_COMPRESSED_SOURCE, which also bounds what a stored file may expand to
+// on the way out.
+func maxSourceSize() int64 {
+	if Compress {
+		return MAX_COMPRESSED_SOURCE
+	}
+	return MaxPlaintextSize()
+}
+
+// slotPayload returns what is encrypted into a slot for content fb and
+// whether it was compressed: fb gzipped when Compress is set and that makes
+// it smaller, fb itself otherwise. Content that still can't fit in a slot
+// is refused.
+func slotPayload(fb []byte) ([]byte, bool, error) {
+	if !Compress {
+		return fb, false, nil
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(fb); err != nil {
+		return nil, false, fmt.Errorf("failed to compress file: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, false, fmt.Errorf("failed to compress file: %w", err)
+	}
+
+	// Already compressed data only grows, so it is stored as is.
+	if buf.Len() >= len(fb) {
+		if int64(len(fb)) > MaxPlaintextSize() {
+			return nil, false, fmt.Errorf("file too large: %d bytes, does not compress (max %d)", len(fb), MaxPlaintextSize())
+		}
+		return fb, false, nil
+	}
+
+	if int64(buf.Len()) > MaxPlaintextSize() {
+		return nil, false, fmt.Errorf("file too large: %d bytes compressed (max %d)", buf.Len(), MaxPlaintextSize())
+	}
+
+	return buf.Bytes(), true, nil
+}
+
+// looksCompressed reports whether a payload found without its metadata
+// entry was gzipped by slotPayload. A file that was itself gzip data when
+// added looks the same, so this is only a guess for when the entry is lost.
+func looksCompressed(payload []byte) bool {
+	return len(payload) >= 2 && payload[0] == 0x1f && payload[1] == 0x8b
+}
+
+// openPayload reverses slotPayload for the decrypted content of f.
+func openPayload(f File, decrypted []byte) ([]byte, error) {
+	if !f.Compressed {
+		return decrypted, nil
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(decrypted))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress: %w", err)
+	}
+	defer zr.Close()
+
+	content, err := readLimited(zr, MAX_COMPRESSED_SOURCE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress: %w", err)
+	}
+
+	return content, nil
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"os"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -39,7 +40,7 @@ func TestMetadataConsistencyBasic(t *testing.T) {
 		t.Errorf("File name mismatch: %s", meta2.Files[0].Name)
 	}
 
-	if err := Del(file, 0); err != nil {
+	if _, err := Del(file, "0"); err != nil {
 		t.Fatalf("Del failed: %v", err)
 	}
 
@@ -87,7 +88,7 @@ func TestMetadataConsistencyMultipleOperations(t *testing.T) {
 				t.Fatalf("Add failed at operation %d: %v", i, err)
 			}
 		case "del":
-			if err := Del(file, op.index); err != nil {
+			if _, err := Del(file, strconv.Itoa(op.index)); err != nil {
 				t.Fatalf("Del failed at operation %d: %v", i, err)
 			}
 		}
@@ -292,7 +293,7 @@ func TestFileConsistencyAcrossSync(t *testing.T) {
 		if err != nil {
 			t.Fatalf("GetEncKey failed: %v", err)
 		}
-		decrypted, err := DecryptGCM(buff, password, meta.Salt)
+		decrypted, err := DecryptGCM(buff, password, meta.Salt, nil)
 		if err != nil {
 			t.Fatalf("DecryptGCM failed for file %d: %v", i, err)
 		}
@@ -357,7 +358,7 @@ func TestFileConsistencyWithOverwrite(t *testing.T) {
 	if err != nil {
 		t.Fatalf("GetEncKey failed: %v", err)
 	}
-	decrypted, err := DecryptGCM(buff, password, meta.Salt)
+	decrypted, err := DecryptGCM(buff, password, meta.Salt, nil)
 	if err != nil {
 		t.Fatalf("DecryptGCM failed: %v", err)
 	}
@@ -390,7 +391,7 @@ func TestFileConsistencyAfterDelete(t *testing.T) {
 		t.Fatalf("Add failed: %v", err)
 	}
 
-	if err := Del(file, 3); err != nil {
+	if _, err := Del(file, "3"); err != nil {
 		t.Fatalf("Del failed: %v", err)
 	}
 
@@ -492,7 +493,7 @@ func TestMetadataConsistencyUnderLoad(t *testing.T) {
 
 		for i := 0; i < 5; i++ {
 			index := (iteration*10 + i*2) % 100
-			if err := Del(file, index); err != nil {
+			if _, err := Del(file, strconv.Itoa(index)); err != nil {
 				t.Fatalf("Del failed at iteration %d, index %d: %v", iteration, index, err)
 			}
 		}
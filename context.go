@@ -0,0 +1,26 @@
+package main
+
+import (
+	"errors"
+)
+
+// contextAAD is the GCM additional data for blocks written now: the
+// Context, or nil without one.
+func contextAAD() []byte {
+	if Context == "" {
+		return nil
+	}
+	return []byte(Context)
+}
+
+// fileAAD returns the additional data the block of f was sealed with.
+// Files added without a context open without one, whatever Context is.
+func fileAAD(f File) ([]byte, error) {
+	if !f.Bound {
+		return nil, nil
+	}
+	if Context == "" {
+		return nil, errors.New("file is bound to a context, pass it with --context")
+	}
+	return []byte(Context), nil
+}
@@ -22,6 +22,7 @@ const (
 	SaltSize = 32
 
 	NonceSize = 12
+	TagSize   = 16
 )
 
 func DeriveKey(password string, salt []byte) ([]byte, error) {
@@ -36,9 +37,13 @@ func DeriveKey(password string, salt []byte) ([]byte, error) {
 	return key, nil
 }
 
+// randSource supplies the salts and GCM nonces. It is only ever replaced by
+// tests that need reproducible ciphertext.
+var randSource io.Reader = rand.Reader
+
 func GenerateSalt() ([]byte, error) {
 	salt := make([]byte, SaltSize)
-	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+	if _, err := io.ReadFull(randSource, salt); err != nil {
 		return nil, fmt.Errorf("failed to generate salt: %w", err)
 	}
 
@@ -66,7 +71,10 @@ func GetEncKey() (string, error) {
 	return password, nil
 }
 
-func EncryptGCM(plaintext []byte, password string, salt []byte) ([]byte, error) {
+// EncryptGCM seals plaintext with a key derived from password and salt.
+// additionalData is authenticated but not stored, and must be given again
+// to DecryptGCM; nil for none.
+func EncryptGCM(plaintext []byte, password string, salt []byte, additionalData []byte) ([]byte, error) {
 
 	key, err := DeriveKey(password, salt)
 	if err != nil {
@@ -74,6 +82,12 @@ func EncryptGCM(plaintext []byte, password string, salt []byte) ([]byte, error)
 	}
 	defer zeroBytes(key)
 
+	return encryptWithKey(plaintext, key, additionalData)
+}
+
+// encryptWithKey seals plaintext with an already derived key, the
+// counterpart of decryptWithKey for callers encrypting many blocks.
+func encryptWithKey(plaintext []byte, key []byte, additionalData []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create cipher: %w", err)
@@ -85,7 +99,7 @@ func EncryptGCM(plaintext []byte, password string, salt []byte) ([]byte, error)
 	}
 
 	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+	if _, err := io.ReadFull(randSource, nonce); err != nil {
 		return nil, fmt.Errorf("failed to generate nonce: %w", err)
 	}
 
@@ -100,12 +114,12 @@ func EncryptGCM(plaintext []byte, password string, salt []byte) ([]byte, error)
 		return nil, errors.New("generated invalid all-zero nonce")
 	}
 
-	ciphertext := gcm.Seal(nonce, nonce, plaintext, nil)
+	ciphertext := gcm.Seal(nonce, nonce, plaintext, additionalData)
 
 	return ciphertext, nil
 }
 
-func DecryptGCM(ciphertext []byte, password string, salt []byte) ([]byte, error) {
+func DecryptGCM(ciphertext []byte, password string, salt []byte, additionalData []byte) ([]byte, error) {
 
 	key, err := DeriveKey(password, salt)
 	if err != nil {
@@ -113,6 +127,12 @@ func DecryptGCM(ciphertext []byte, password string, salt []byte) ([]byte, error)
 	}
 	defer zeroBytes(key)
 
+	return decryptWithKey(ciphertext, key, additionalData)
+}
+
+// decryptWithKey opens ciphertext with an already derived key. It lets
+// callers that try many candidate blocks pay for key derivation once.
+func decryptWithKey(ciphertext []byte, key []byte, additionalData []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create cipher: %w", err)
@@ -131,7 +151,7 @@ func DecryptGCM(ciphertext []byte, password string, salt []byte) ([]byte, error)
 	nonce := ciphertext[:nonceSize]
 	ciphertextData := ciphertext[nonceSize:]
 
-	plaintext, err := gcm.Open(nil, nonce, ciphertextData, nil)
+	plaintext, err := gcm.Open(nil, nonce, ciphertextData, additionalData)
 	if err != nil {
 
 		return nil, fmt.Errorf("decryption failed (wrong password or data corrupted): %w", err)
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/rand"
 	"fmt"
+	"io"
 	"testing"
 	"time"
 )
@@ -164,7 +165,7 @@ func TestEncryptDecryptGCM(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 
-			encrypted, err := EncryptGCM(tt.data, password, salt)
+			encrypted, err := EncryptGCM(tt.data, password, salt, nil)
 			if err != nil {
 				t.Fatalf("Encryption failed: %v", err)
 			}
@@ -173,7 +174,7 @@ func TestEncryptDecryptGCM(t *testing.T) {
 				t.Fatalf("Encrypted data too short: %d bytes", len(encrypted))
 			}
 
-			decrypted, err := DecryptGCM(encrypted, password, salt)
+			decrypted, err := DecryptGCM(encrypted, password, salt, nil)
 			if err != nil {
 				t.Fatalf("Decryption failed: %v", err)
 			}
@@ -208,12 +209,12 @@ func TestEncryptionRandomness(t *testing.T) {
 
 	data := []byte("Same data encrypted twice")
 
-	encrypted1, err := EncryptGCM(data, password, salt)
+	encrypted1, err := EncryptGCM(data, password, salt, nil)
 	if err != nil {
 		t.Fatalf("First encryption failed: %v", err)
 	}
 
-	encrypted2, err := EncryptGCM(data, password, salt)
+	encrypted2, err := EncryptGCM(data, password, salt, nil)
 	if err != nil {
 		t.Fatalf("Second encryption failed: %v", err)
 	}
@@ -226,12 +227,12 @@ func TestEncryptionRandomness(t *testing.T) {
 		t.Error("Encrypting same data twice should produce different ciphertexts")
 	}
 
-	decrypted1, err := DecryptGCM(encrypted1, password, salt)
+	decrypted1, err := DecryptGCM(encrypted1, password, salt, nil)
 	if err != nil {
 		t.Fatalf("First decryption failed: %v", err)
 	}
 
-	decrypted2, err := DecryptGCM(encrypted2, password, salt)
+	decrypted2, err := DecryptGCM(encrypted2, password, salt, nil)
 	if err != nil {
 		t.Fatalf("Second decryption failed: %v", err)
 	}
@@ -244,6 +245,123 @@ func TestEncryptionRandomness(t *testing.T) {
 	}
 }
 
+// repeatReader returns its bytes over and over, so every salt or nonce
+// drawn from it is the same.
+type repeatReader struct {
+	b []byte
+}
+
+func (r repeatReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = r.b[i%len(r.b)]
+	}
+	return len(p), nil
+}
+
+// setRandSource makes GenerateSalt and EncryptGCM draw their salts and
+// nonces from r until the test ends. It only exists in test builds.
+func setRandSource(t testing.TB, r io.Reader) {
+	t.Helper()
+
+	previous := randSource
+	randSource = r
+	t.Cleanup(func() { randSource = previous })
+}
+
+func TestEncryptGCMFixedNonce(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	password, err := GetEncKey()
+	if err != nil {
+		t.Fatalf("Failed to get encryption key: %v", err)
+	}
+
+	salt, err := GenerateSalt()
+	if err != nil {
+		t.Fatalf("Failed to generate salt: %v", err)
+	}
+
+	nonce := []byte("fixed-nonce!")
+	setRandSource(t, repeatReader{nonce})
+
+	data := []byte("Same data encrypted twice")
+
+	encrypted1, err := EncryptGCM(data, password, salt, nil)
+	if err != nil {
+		t.Fatalf("First encryption failed: %v", err)
+	}
+
+	encrypted2, err := EncryptGCM(data, password, salt, nil)
+	if err != nil {
+		t.Fatalf("Second encryption failed: %v", err)
+	}
+
+	if !bytes.Equal(encrypted1[:NonceSize], nonce) {
+		t.Errorf("Expected nonce %q, got %q", nonce, encrypted1[:NonceSize])
+	}
+	if !bytes.Equal(encrypted1, encrypted2) {
+		t.Error("Same nonce, key and data should produce the same ciphertext")
+	}
+
+	decrypted, err := DecryptGCM(encrypted1, password, salt, nil)
+	if err != nil {
+		t.Fatalf("Decryption failed: %v", err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Error("Decryption produced wrong plaintext")
+	}
+}
+
+func TestDeterministicRandSource(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	password, err := GetEncKey()
+	if err != nil {
+		t.Fatalf("Failed to get encryption key: %v", err)
+	}
+
+	data := []byte("Reproducible from salt to ciphertext")
+
+	// Each run draws a fresh salt and nonce, as init followed by add does.
+	run := func() ([]byte, []byte) {
+		setRandSource(t, repeatReader{[]byte("deterministic")})
+
+		salt, err := GenerateSalt()
+		if err != nil {
+			t.Fatalf("Failed to generate salt: %v", err)
+		}
+		encrypted, err := EncryptGCM(data, password, salt, nil)
+		if err != nil {
+			t.Fatalf("Encryption failed: %v", err)
+		}
+		return salt, encrypted
+	}
+
+	salt1, encrypted1 := run()
+	salt2, encrypted2 := run()
+
+	if !bytes.Equal(salt1, salt2) {
+		t.Errorf("Expected the same salt from the same source, got %x and %x", salt1, salt2)
+	}
+	if !bytes.Equal(encrypted1, encrypted2) {
+		t.Error("Expected the same ciphertext from the same source")
+	}
+
+	decrypted, err := DecryptGCM(encrypted1, password, salt1, nil)
+	if err != nil {
+		t.Fatalf("Decryption failed: %v", err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Error("Decryption produced wrong plaintext")
+	}
+}
+
 func TestDecryptWithWrongPassword(t *testing.T) {
 	defer LogTestDuration(t, time.Now())
 
@@ -264,17 +382,56 @@ func TestDecryptWithWrongPassword(t *testing.T) {
 
 	data := []byte("Secret message")
 
-	encrypted, err := EncryptGCM(data, correctPassword, salt)
+	encrypted, err := EncryptGCM(data, correctPassword, salt, nil)
 	if err != nil {
 		t.Fatalf("Encryption failed: %v", err)
 	}
 
-	_, err = DecryptGCM(encrypted, wrongPassword, salt)
+	_, err = DecryptGCM(encrypted, wrongPassword, salt, nil)
 	if err == nil {
 		t.Error("Decryption with wrong password should fail authentication")
 	}
 }
 
+func TestDecryptWithAdditionalData(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	password, err := GetEncKey()
+	if err != nil {
+		t.Fatalf("Failed to get encryption key: %v", err)
+	}
+
+	salt, err := GenerateSalt()
+	if err != nil {
+		t.Fatalf("Failed to generate salt: %v", err)
+	}
+
+	data := []byte("Secret message")
+
+	encrypted, err := EncryptGCM(data, password, salt, []byte("production"))
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+
+	decrypted, err := DecryptGCM(encrypted, password, salt, []byte("production"))
+	if err != nil {
+		t.Fatalf("Decryption with matching additional data failed: %v", err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Error("Decrypted data doesn't match original")
+	}
+
+	if _, err := DecryptGCM(encrypted, password, salt, []byte("staging")); err == nil {
+		t.Error("Decryption with different additional data should fail authentication")
+	}
+	if _, err := DecryptGCM(encrypted, password, salt, nil); err == nil {
+		t.Error("Decryption without the additional data should fail authentication")
+	}
+}
+
 func TestDecryptWithWrongSalt(t *testing.T) {
 	defer LogTestDuration(t, time.Now())
 
@@ -298,12 +455,12 @@ func TestDecryptWithWrongSalt(t *testing.T) {
 
 	data := []byte("Secret message")
 
-	encrypted, err := EncryptGCM(data, password, salt1)
+	encrypted, err := EncryptGCM(data, password, salt1, nil)
 	if err != nil {
 		t.Fatalf("Encryption failed: %v", err)
 	}
 
-	_, err = DecryptGCM(encrypted, password, salt2)
+	_, err = DecryptGCM(encrypted, password, salt2, nil)
 	if err == nil {
 		t.Error("Decryption with wrong salt should fail authentication")
 	}
@@ -326,7 +483,7 @@ func TestDecryptTruncatedData(t *testing.T) {
 	}
 
 	shortData := []byte{0x01, 0x02, 0x03}
-	_, err = DecryptGCM(shortData, password, salt)
+	_, err = DecryptGCM(shortData, password, salt, nil)
 	if err == nil {
 		t.Error("Decryption of truncated data should fail")
 	}
@@ -350,7 +507,7 @@ func TestDecryptCorruptedData(t *testing.T) {
 
 	data := []byte("Secret message")
 
-	encrypted, err := EncryptGCM(data, password, salt)
+	encrypted, err := EncryptGCM(data, password, salt, nil)
 	if err != nil {
 		t.Fatalf("Encryption failed: %v", err)
 	}
@@ -359,7 +516,7 @@ func TestDecryptCorruptedData(t *testing.T) {
 		encrypted[NonceSize+1] ^= 0xFF
 	}
 
-	_, err = DecryptGCM(encrypted, password, salt)
+	_, err = DecryptGCM(encrypted, password, salt, nil)
 	if err == nil {
 		t.Error("Decryption of corrupted data should fail authentication")
 	}
@@ -419,12 +576,12 @@ func TestEncryptLargeData(t *testing.T) {
 	for _, size := range sizes {
 		t.Run(fmt.Sprintf("%d_bytes", size), func(t *testing.T) {
 			data := GenerateRandomBytes(size)
-			encrypted, err := EncryptGCM(data, password, salt)
+			encrypted, err := EncryptGCM(data, password, salt, nil)
 			if err != nil {
 				t.Fatalf("Encryption failed: %v", err)
 			}
 
-			decrypted, err := DecryptGCM(encrypted, password, salt)
+			decrypted, err := DecryptGCM(encrypted, password, salt, nil)
 			if err != nil {
 				t.Fatalf("Decryption failed: %v", err)
 			}
@@ -455,7 +612,7 @@ func BenchmarkEncryptGCM(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		EncryptGCM(data, password, salt)
+		EncryptGCM(data, password, salt, nil)
 	}
 }
 
@@ -464,11 +621,11 @@ func BenchmarkDecryptGCM(b *testing.B) {
 	password, _ := GetEncKey()
 	salt, _ := GenerateSalt()
 	data := GenerateRandomBytes(1024)
-	encrypted, _ := EncryptGCM(data, password, salt)
+	encrypted, _ := EncryptGCM(data, password, salt, nil)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		DecryptGCM(encrypted, password, salt)
+		DecryptGCM(encrypted, password, salt, nil)
 	}
 }
 
@@ -480,7 +637,7 @@ func BenchmarkEncryptDecryptGCM(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		encrypted, _ := EncryptGCM(data, password, salt)
-		DecryptGCM(encrypted, password, salt)
+		encrypted, _ := EncryptGCM(data, password, salt, nil)
+		DecryptGCM(encrypted, password, salt, nil)
 	}
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Defrag moves used slots down into the free slots before them, so the
+// files end up packed from index 0 in their existing order. Each move
+// copies the block, commits the metadata and only then clears the old
+// slot, so an interruption leaves every file readable. Aliases follow
+// their file.
+//
+// As with del, vacated slots are left in place while metadata backups are
+// kept so rollback can still find them.
+func Defrag(file F) (err error) {
+	defer func() { err = checkDevice(err) }()
+
+	meta, err := ReadMeta(file)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	keepBlocks := meta.Backups > 0 || KeepMetaBackups > 0
+
+	relocated := 0
+	next := 0
+	for i, v := range meta.Files {
+		if v.Name == "" {
+			continue
+		}
+		if i == next {
+			next++
+			continue
+		}
+
+		block, err := ReadBlock(file, i)
+		if err != nil {
+			return fmt.Errorf("failed to read slot %d: %w", i, err)
+		}
+		if err := WriteBlock(file, block, v.Name, next); err != nil {
+			return fmt.Errorf("failed to write slot %d: %w", next, err)
+		}
+		meta.Wear.BytesWritten += MAX_FILE_SIZE
+
+		meta.Files[next] = v
+		meta.Files[i] = File{}
+		for name, index := range meta.Aliases {
+			if index == i {
+				meta.Aliases[name] = next
+			}
+		}
+
+		if err := WriteMeta(file, meta); err != nil {
+			return fmt.Errorf("failed to update metadata: %w", err)
+		}
+
+		if !keepBlocks {
+			if err := clearSlot(file, meta, i); err != nil {
+				return err
+			}
+			meta.Wear.BytesWritten += MAX_FILE_SIZE
+		}
+
+		LogDebug("moved [%d] %s to slot %d", i, v.Name, next)
+		relocated++
+		next++
+	}
+
+	PrintSuccess(fmt.Sprintf("Defrag complete: %s relocated",
+		C(ColorBold+ColorWhite, fmt.Sprintf("%d files", relocated))))
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefrag(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	indexes := []int{2, 5, 9}
+	contents := [][]byte{
+		[]byte("first file"),
+		GenerateRandomBytes(5000),
+		[]byte("third file"),
+	}
+	for i, idx := range indexes {
+		if err := Add(file, CreateTempSourceFile(t, contents[i]), idx); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	if err := SetAlias(file, "third", 9); err != nil {
+		t.Fatalf("SetAlias failed: %v", err)
+	}
+
+	before := VerifyMetadataIntegrity(t, file)
+
+	output := captureOutput(func() {
+		if err := Defrag(file); err != nil {
+			t.Fatalf("Defrag failed: %v", err)
+		}
+	})
+	if !strings.Contains(output, "3 files") {
+		t.Errorf("Expected 3 files relocated, got:\n%s", output)
+	}
+
+	meta := VerifyMetadataIntegrity(t, file)
+	for i, idx := range indexes {
+		if meta.Files[i].Name != before.Files[idx].Name ||
+			meta.Files[i].Size != before.Files[idx].Size ||
+			meta.Files[i].Created != before.Files[idx].Created {
+			t.Errorf("Slot %d: expected the entry from slot %d, got %+v", i, idx, meta.Files[i])
+		}
+		if idx >= len(indexes) {
+			if meta.Files[idx].Name != "" {
+				t.Errorf("Expected slot %d to be free after defrag", idx)
+			}
+			block, _ := ReadBlock(file, idx)
+			if !bytes.Equal(block, make([]byte, MAX_FILE_SIZE)) {
+				t.Errorf("Expected vacated slot %d to be zeroed", idx)
+			}
+		}
+
+		outputPath := filepath.Join(t.TempDir(), "out")
+		if err := Get(file, i, outputPath); err != nil {
+			t.Fatalf("Get failed for slot %d: %v", i, err)
+		}
+		got, _ := os.ReadFile(outputPath)
+		if !bytes.Equal(got, contents[i]) {
+			t.Errorf("Content mismatch in slot %d", i)
+		}
+	}
+
+	if meta.Aliases["third"] != 2 {
+		t.Errorf("Expected alias to follow its file to slot 2, got %d", meta.Aliases["third"])
+	}
+
+	output = captureOutput(func() {
+		if err := Defrag(file); err != nil {
+			t.Fatalf("Second Defrag failed: %v", err)
+		}
+	})
+	if !strings.Contains(output, "0 files") {
+		t.Errorf("Expected a second run to move nothing, got:\n%s", output)
+	}
+}
@@ -2,52 +2,100 @@ package main
 
 import (
 	"fmt"
+	"strings"
 )
 
-func Del(file F, index int) error {
-	if index < 0 || index >= TOTAL_FILES {
-		return fmt.Errorf("index out of range: %d (valid range: 0-%d)", index, TOTAL_FILES-1)
-	}
+// Del deletes the file target names and returns the slots it freed.
+// target is a slot index, an @alias, or a file name; a name deletes every
+// file stored under it, including files added with NameHash. A name made
+// only of digits is taken as an index. Nothing is printed, callers report
+// the result.
+func Del(file F, target string) (freed []int, err error) {
+	defer func() { err = checkDevice(err) }()
 
 	meta, err := ReadMeta(file)
 	if err != nil {
-		return fmt.Errorf("failed to read metadata: %w", err)
+		return nil, fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	indices, err := resolveDelTarget(file, meta, target)
+	if err != nil {
+		return nil, err
+	}
+
+	// With metadata backups the block is left in place so rollback can
+	// bring the file back. It is overwritten when the slot is reused.
+	keepBlocks := meta.Backups > 0 || KeepMetaBackups > 0
+	for _, index := range indices {
+		meta.Files[index] = File{}
+		dropAliases(meta, index)
+
+		if !keepBlocks {
+			if err := clearSlot(file, meta, index); err != nil {
+				return nil, err
+			}
+			meta.Wear.BytesWritten += MAX_FILE_SIZE
+		}
+		meta.Wear.Deletes++
+		LogDebug("deleted slot %d", index)
+	}
+
+	if err := WriteMeta(file, meta); err != nil {
+		return nil, fmt.Errorf("failed to update metadata: %w", err)
+	}
+
+	return indices, nil
+}
+
+// resolveDelTarget returns the used slots target refers to, see Del.
+func resolveDelTarget(file F, meta *Meta, target string) ([]int, error) {
+	if target == "" {
+		return nil, fmt.Errorf("no file given to delete")
 	}
 
-	if meta.Files[index].Name == "" {
-		return fmt.Errorf("no file exists at index %d", index)
+	if strings.HasPrefix(target, "@") || strings.Trim(target, "0123456789") == "" {
+		index, err := ResolveIndex(file, target)
+		if err != nil {
+			return nil, err
+		}
+		if index < 0 || index >= TOTAL_FILES {
+			return nil, fmt.Errorf("index out of range: %d (valid range: 0-%d)", index, TOTAL_FILES-1)
+		}
+		if meta.Files[index].Name == "" {
+			return nil, fmt.Errorf("no file exists at index %d", index)
+		}
+		return []int{index}, nil
 	}
 
-	meta.Files[index].Size = 0
-	meta.Files[index].Name = ""
+	hashed := hashName(meta.Salt, target)
+	var indices []int
+	for i, v := range meta.Files {
+		if v.Name != "" && (v.Name == target || v.Name == hashed) {
+			indices = append(indices, i)
+		}
+	}
+	if len(indices) == 0 {
+		return nil, fmt.Errorf("no file named %q", target)
+	}
 
-	Printf("%s\n", C(ColorLightBlue, fmt.Sprintf("Deleting file at index %d...", index)))
+	return indices, nil
+}
 
+func zeroSlot(file F, index int) error {
 	seekPos := int64(META_FILE_SIZE) + (int64(index) * int64(MAX_FILE_SIZE))
-	_, err = file.Seek(seekPos, 0)
+	_, err := file.Seek(seekPos, 0)
 	if err != nil {
 		return fmt.Errorf("failed to seek to file position: %w", err)
 	}
 
 	buff := make([]byte, MAX_FILE_SIZE)
-	n, err := file.Write(buff)
-	if err != nil {
+	if err := writeFull(file, buff); err != nil {
 		return fmt.Errorf("failed to overwrite file slot: %w", err)
 	}
 
-	if n != MAX_FILE_SIZE {
-		return fmt.Errorf("short write: wrote %d bytes, expected %d", n, MAX_FILE_SIZE)
-	}
-
 	if err := file.Sync(); err != nil {
 		return fmt.Errorf("failed to sync file deletion: %w", err)
 	}
 
-	if err := WriteMeta(file, meta); err != nil {
-		return fmt.Errorf("failed to update metadata: %w", err)
-	}
-
-	PrintSuccess(fmt.Sprintf("Successfully deleted file at index %d", index))
-
 	return nil
 }
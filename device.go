@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// ErrDeviceRemoved is returned when the device stops responding during an
+// operation, typically because it was unplugged.
+var ErrDeviceRemoved = errors.New("device removed, volume may be in an inconsistent state, run doctor")
+
+// isDeviceGone reports whether err means the device itself went away, as
+// opposed to an ordinary failure of one request.
+func isDeviceGone(err error) bool {
+	return errors.Is(err, syscall.ENODEV) ||
+		errors.Is(err, syscall.ENXIO) ||
+		errors.Is(err, syscall.EIO)
+}
+
+// checkDevice tags device-gone errors with ErrDeviceRemoved and returns
+// every other error unchanged.
+func checkDevice(err error) error {
+	if err == nil || !isDeviceGone(err) || errors.Is(err, ErrDeviceRemoved) {
+		return err
+	}
+
+	return fmt.Errorf("%w: %w", ErrDeviceRemoved, err)
+}
+
+// discardRange passes a discard (TRIM) request to a block device. It is a
+// variable so tests can stand in for a real device.
+var discardRange = ioctlDiscard
+
+// blockDeviceSize asks the kernel for the size of a block device. It is a
+// variable so tests can stand in for a real device.
+var blockDeviceSize = ioctlDeviceSize
+
+// DeviceSize returns the capacity of file. Stat reports 0 for block device
+// nodes, so their size comes from the kernel instead; regular files, and
+// devices the kernel can't size, use the Stat size.
+func DeviceSize(file F) (int64, error) {
+	s, err := file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat device: %w", err)
+	}
+
+	if s.Mode()&os.ModeDevice != 0 {
+		if size, err := blockDeviceSize(file); err == nil && size > 0 {
+			return size, nil
+		}
+	}
+
+	return s.Size(), nil
+}
@@ -0,0 +1,49 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// Block device ioctls from linux/fs.h.
+const (
+	BLKGETSIZE64 = 0x80081272 // _IOR(0x12, 114, size_t)
+	BLKDISCARD   = 0x1277     // _IO(0x12, 119)
+)
+
+// ioctlDeviceSize returns the size in bytes of the block device behind
+// file.
+func ioctlDeviceSize(file F) (int64, error) {
+	f, ok := file.(interface{ Fd() uintptr })
+	if !ok {
+		return 0, fmt.Errorf("device has no file descriptor")
+	}
+
+	var size uint64
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), BLKGETSIZE64, uintptr(unsafe.Pointer(&size)))
+	if errno != 0 {
+		return 0, fmt.Errorf("BLKGETSIZE64 failed: %w", errno)
+	}
+
+	return int64(size), nil
+}
+
+// ioctlDiscard tells the block device behind file that length bytes at
+// offset are no longer in use.
+func ioctlDiscard(file F, offset, length int64) error {
+	f, ok := file.(interface{ Fd() uintptr })
+	if !ok {
+		return fmt.Errorf("device has no file descriptor")
+	}
+
+	r := [2]uint64{uint64(offset), uint64(length)}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), BLKDISCARD, uintptr(unsafe.Pointer(&r)))
+	if errno != 0 {
+		return fmt.Errorf("BLKDISCARD failed: %w", errno)
+	}
+
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+)
+
+// ioctlDeviceSize is only implemented on Linux; elsewhere the Stat size is
+// used.
+func ioctlDeviceSize(file F) (int64, error) {
+	return 0, fmt.Errorf("device size query not supported on this platform")
+}
+
+// ioctlDiscard is only implemented on Linux.
+func ioctlDiscard(file F, offset, length int64) error {
+	return fmt.Errorf("discard not supported on this platform")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// blockDeviceFile reports itself as a block device node, which Stat sizes
+// as 0 bytes.
+type blockDeviceFile struct {
+	*MockFile
+}
+
+func (f *blockDeviceFile) Stat() (os.FileInfo, error) {
+	return &mockFileInfo{name: f.Name(), mode: os.ModeDevice | 0o660}, nil
+}
+
+func TestDeviceSizeBlockDevice(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	const capacity = int64(META_FILE_SIZE) + int64(TOTAL_FILES)*int64(MAX_FILE_SIZE) + 4096
+
+	old := blockDeviceSize
+	blockDeviceSize = func(F) (int64, error) { return capacity, nil }
+	defer func() { blockDeviceSize = old }()
+
+	device := &blockDeviceFile{MockFile: NewMockFile(0)}
+	size, err := DeviceSize(device)
+	if err != nil {
+		t.Fatalf("DeviceSize failed: %v", err)
+	}
+	if size != capacity {
+		t.Errorf("Expected %d bytes from the kernel, got %d", capacity, size)
+	}
+
+	if c := checkGeometry(device, &Meta{}); c.Status != DOCTOR_PASS {
+		t.Errorf("Expected geometry to pass with the kernel size, got %s: %s", c.Status, c.Detail)
+	}
+
+	regular := NewMockFile(1234)
+	size, err = DeviceSize(regular)
+	if err != nil {
+		t.Fatalf("DeviceSize failed: %v", err)
+	}
+	if size != 1234 {
+		t.Errorf("Expected a regular file to use its Stat size, got %d", size)
+	}
+}
+
+func TestDeviceSizeFallback(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	// A real ioctl on something that isn't a block device fails, and the
+	// Stat size is used instead.
+	if _, err := ioctlDeviceSize(GetSharedTestFile(t)); err == nil {
+		t.Error("Expected the size query to fail on a regular file")
+	}
+
+	device := &blockDeviceFile{MockFile: NewMockFile(0)}
+	size, err := DeviceSize(device)
+	if err != nil {
+		t.Fatalf("DeviceSize failed: %v", err)
+	}
+	if size != 0 {
+		t.Errorf("Expected the Stat size when the kernel can't tell, got %d", size)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+const (
+	DOCTOR_PASS = "PASS"
+	DOCTOR_WARN = "WARN"
+	DOCTOR_FAIL = "FAIL"
+)
+
+// doctorCheck is a single line of the doctor report.
+type doctorCheck struct {
+	Status string
+	Name   string
+	Detail string
+	Advice string
+}
+
+func Doctor(file F) error {
+	checks := runDoctorChecks(file)
+
+	PrintHeader("DOCTOR")
+	PrintSeparator(70)
+
+	failed := 0
+	for _, c := range checks {
+		color := ColorGreen
+		switch c.Status {
+		case DOCTOR_WARN:
+			color = ColorYellow
+		case DOCTOR_FAIL:
+			color = ColorRed
+			failed++
+		}
+
+		Printf(" %s  %s %s\n",
+			C(ColorBold+color, c.Status),
+			C(ColorBold+ColorLightBlue, fmt.Sprintf("%-12s", c.Name)),
+			C(ColorWhite, c.Detail))
+		if c.Advice != "" {
+			Printf("        %s\n", C(ColorDim, "-> "+c.Advice))
+		}
+	}
+
+	PrintSeparator(70)
+
+	if failed > 0 {
+		return fmt.Errorf("%d health check(s) failed", failed)
+	}
+
+	return nil
+}
+
+// runDoctorChecks runs the read-only health checks in order. Checks that
+// depend on readable metadata are skipped once the metadata is unusable.
+func runDoctorChecks(file F) []doctorCheck {
+	var checks []doctorCheck
+
+	metaBlock := make([]byte, META_FILE_SIZE)
+	n, err := file.ReadAt(metaBlock, 0)
+	if n != META_FILE_SIZE {
+		return append(checks, doctorCheck{
+			Status: DOCTOR_FAIL,
+			Name:   "Header",
+			Detail: fmt.Sprintf("unable to read metadata block: read %d bytes (%v)", n, err),
+			Advice: "check that [device] is correct and initialized with init",
+		})
+	}
+
+	if string(metaBlock[0:MAGIC_SIZE]) != MAGIC_STRING {
+		return append(checks, doctorCheck{
+			Status: DOCTOR_FAIL,
+			Name:   "Header",
+			Detail: "magic number mismatch",
+			Advice: "the device is not initialized or the header was overwritten",
+		})
+	}
+	if int(metaBlock[MAGIC_SIZE]) != METADATA_VERSION {
+		return append(checks, doctorCheck{
+			Status: DOCTOR_FAIL,
+			Name:   "Header",
+			Detail: fmt.Sprintf("unsupported metadata version %d (expected %d)", metaBlock[MAGIC_SIZE], METADATA_VERSION),
+			Advice: "use a build of hdnfs that matches the volume version",
+		})
+	}
+	checks = append(checks, doctorCheck{Status: DOCTOR_PASS, Name: "Header", Detail: fmt.Sprintf("magic and version %d OK", METADATA_VERSION)})
+
+	if _, _, err := parseMetaBlock(metaBlock); err != nil {
+		return append(checks, doctorCheck{
+			Status: DOCTOR_FAIL,
+			Name:   "Checksum",
+			Detail: err.Error(),
+			Advice: "restore the volume from a synced copy",
+		})
+	}
+	checks = append(checks, doctorCheck{Status: DOCTOR_PASS, Name: "Checksum", Detail: "metadata checksum OK"})
+
+	meta, err := ReadMeta(file)
+	if err != nil {
+		return append(checks, doctorCheck{
+			Status: DOCTOR_FAIL,
+			Name:   "Metadata",
+			Detail: err.Error(),
+			Advice: "check the password; the metadata may be encrypted with a different one",
+		})
+	}
+	checks = append(checks, doctorCheck{Status: DOCTOR_PASS, Name: "Metadata", Detail: "metadata decrypts"})
+
+	checks = append(checks, checkEntries(meta))
+	checks = append(checks, checkGeometry(file, meta))
+	checks = append(checks, checkMetaFill(meta))
+
+	used := CountNonEmptyFiles(meta)
+	if used >= slotCount(meta) {
+		checks = append(checks, doctorCheck{
+			Status: DOCTOR_WARN,
+			Name:   "Free slots",
+			Detail: fmt.Sprintf("0 of %d slots free", slotCount(meta)),
+			Advice: "delete files you no longer need before adding more",
+		})
+	} else {
+		checks = append(checks, doctorCheck{Status: DOCTOR_PASS, Name: "Free slots", Detail: fmt.Sprintf("%d of %d slots free", slotCount(meta)-used, slotCount(meta))})
+	}
+
+	password, err := GetEncKey()
+	if err != nil {
+		return append(checks, doctorCheck{Status: DOCTOR_FAIL, Name: "Blocks", Detail: err.Error()})
+	}
+
+	sample := sampleSlots(meta, DoctorSample)
+	checked := CountNonEmptyFiles(sample)
+
+	var corrupt []string
+	for _, r := range verifySlots(file, sample, password, runtime.NumCPU(), nil) {
+		if r.Err != nil {
+			corrupt = append(corrupt, fmt.Sprintf("%d", r.Index))
+		}
+	}
+	if len(corrupt) > 0 {
+		checks = append(checks, doctorCheck{
+			Status: DOCTOR_FAIL,
+			Name:   "Blocks",
+			Detail: fmt.Sprintf("%d of %d checked files fail to decrypt: [%s]", len(corrupt), checked, strings.Join(corrupt, ", ")),
+			Advice: "run verify for details and restore the affected files from a synced copy",
+		})
+	} else if checked < used {
+		checks = append(checks, doctorCheck{Status: DOCTOR_PASS, Name: "Blocks", Detail: fmt.Sprintf("%d sampled of %d files decrypt", checked, used)})
+	} else {
+		checks = append(checks, doctorCheck{Status: DOCTOR_PASS, Name: "Blocks", Detail: fmt.Sprintf("all %d files decrypt", used)})
+	}
+
+	return checks
+}
+
+// checkEntries is a lightweight consistency check of the file table.
+func checkEntries(meta *Meta) doctorCheck {
+	var bad []string
+	for i, f := range meta.Files {
+		if f.Name == "" {
+			continue
+		}
+		if len(f.Name) > MAX_FILE_NAME_SIZE || f.Size < NonceSize+TagSize || f.Size >= MAX_FILE_SIZE {
+			bad = append(bad, fmt.Sprintf("%d", i))
+		}
+	}
+
+	if len(bad) > 0 {
+		return doctorCheck{
+			Status: DOCTOR_FAIL,
+			Name:   "Entries",
+			Detail: fmt.Sprintf("invalid name or size at [%s]", strings.Join(bad, ", ")),
+			Advice: "delete and re-add the affected files",
+		}
+	}
+
+	return doctorCheck{Status: DOCTOR_PASS, Name: "Entries", Detail: "file table is consistent"}
+}
+
+// checkGeometry confirms the device is large enough for the slots in use.
+// File-backed volumes grow on demand, so only the highest used slot must
+// fit; devices need room for the full layout and the metadata backup ring.
+func checkGeometry(file F, meta *Meta) doctorCheck {
+	s, err := file.Stat()
+	if err != nil {
+		return doctorCheck{Status: DOCTOR_WARN, Name: "Geometry", Detail: fmt.Sprintf("unable to stat device: %v", err)}
+	}
+	size, err := DeviceSize(file)
+	if err != nil {
+		return doctorCheck{Status: DOCTOR_WARN, Name: "Geometry", Detail: err.Error()}
+	}
+
+	required := metaBackupOffset(meta.Backups)
+	if s.Mode().IsRegular() {
+		required = int64(META_FILE_SIZE)
+		for i, f := range meta.Files {
+			if f.Name != "" {
+				required = int64(META_FILE_SIZE) + int64(i)*int64(MAX_FILE_SIZE) + int64(f.Size)
+			}
+		}
+	} else if size == 0 {
+		return doctorCheck{Status: DOCTOR_WARN, Name: "Geometry", Detail: "device size unknown"}
+	}
+
+	if size < required {
+		return doctorCheck{
+			Status: DOCTOR_FAIL,
+			Name:   "Geometry",
+			Detail: fmt.Sprintf("device is %d bytes, layout needs %d", size, required),
+			Advice: "the device was truncated or is too small; restore from a synced copy",
+		}
+	}
+
+	return doctorCheck{Status: DOCTOR_PASS, Name: "Geometry", Detail: fmt.Sprintf("%d bytes available, %d needed", size, required)}
+}
+
+// sampleSlots returns a copy of meta that keeps only n used slots, spread
+// evenly over the used ones. With n <= 0, or n at least the number of used
+// slots, every slot is kept.
+func sampleSlots(meta *Meta, n int) *Meta {
+	var used []int
+	for i, f := range meta.Files {
+		if f.Name != "" {
+			used = append(used, i)
+		}
+	}
+
+	if n <= 0 || n >= len(used) {
+		return meta
+	}
+
+	sample := *meta
+	sample.Files = [TOTAL_FILES]File{}
+	for k := 0; k < n; k++ {
+		i := used[k*len(used)/n]
+		sample.Files[i] = meta.Files[i]
+	}
+
+	return &sample
+}
+
+// checkMetaFill warns when the file table is close to the size of the
+// metadata block, after which adds are refused even with free slots.
+func checkMetaFill(meta *Meta) doctorCheck {
+	metaJSON, err := json.Marshal(meta)
+	if err != nil {
+		return doctorCheck{Status: DOCTOR_WARN, Name: "Meta fill", Detail: fmt.Sprintf("unable to measure metadata: %v", err)}
+	}
+
+	capacity := META_FILE_SIZE - HEADER_SIZE - CHECKSUM_SIZE - NonceSize - TagSize
+	percent := len(metaJSON) * 100 / capacity
+	detail := fmt.Sprintf("%d of %d bytes used (%d%%)", len(metaJSON), capacity, percent)
+
+	if percent >= 90 {
+		return doctorCheck{
+			Status: DOCTOR_WARN,
+			Name:   "Meta fill",
+			Detail: detail,
+			Advice: "shorter file names leave more room in the metadata block",
+		}
+	}
+
+	return doctorCheck{Status: DOCTOR_PASS, Name: "Meta fill", Detail: detail}
+}
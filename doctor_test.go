@@ -0,0 +1,141 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDoctorHealthyVolume(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+	FillSlots(t, file, 3)
+
+	checks := runDoctorChecks(file)
+	if len(checks) == 0 {
+		t.Fatal("Expected doctor checks")
+	}
+	for _, c := range checks {
+		if c.Status != DOCTOR_PASS {
+			t.Errorf("Expected PASS for %s, got %s: %s", c.Name, c.Status, c.Detail)
+		}
+	}
+
+	output := captureOutput(func() {
+		if err := Doctor(file); err != nil {
+			t.Errorf("Doctor failed on healthy volume: %v", err)
+		}
+	})
+	if strings.Contains(output, DOCTOR_FAIL) || strings.Contains(output, DOCTOR_WARN) {
+		t.Errorf("Healthy volume report should only contain PASS lines:\n%s", output)
+	}
+}
+
+func TestDoctorCorruptedBlock(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+	FillSlots(t, file, 3)
+
+	file.Seek(int64(META_FILE_SIZE+MAX_FILE_SIZE+NonceSize), 0)
+	file.Write([]byte("garbage"))
+
+	var err error
+	output := captureOutput(func() {
+		err = Doctor(file)
+	})
+	if err == nil {
+		t.Error("Expected Doctor to report a failure")
+	}
+
+	found := false
+	for _, line := range strings.Split(output, "\n") {
+		if strings.Contains(line, DOCTOR_FAIL) && strings.Contains(line, "Blocks") && strings.Contains(line, "[1]") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected a FAIL line for the corrupted block, got:\n%s", output)
+	}
+}
+
+func TestDoctorCorruptedChecksum(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	file.Seek(HEADER_SIZE+10, 0)
+	file.Write([]byte{0xFF, 0xFF})
+
+	checks := runDoctorChecks(file)
+	last := checks[len(checks)-1]
+	if last.Name != "Checksum" || last.Status != DOCTOR_FAIL {
+		t.Errorf("Expected checksum FAIL, got %s %s: %s", last.Status, last.Name, last.Detail)
+	}
+}
+
+func TestDoctorSampleAndMetaFill(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+	FillSlots(t, file, 6)
+
+	meta, err := ReadMeta(file)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+
+	sample := sampleSlots(meta, 3)
+	if CountNonEmptyFiles(sample) != 3 {
+		t.Errorf("Expected 3 sampled files, got %d", CountNonEmptyFiles(sample))
+	}
+	if sample.Files[0].Name == "" || sample.Files[2].Name == "" || sample.Files[4].Name == "" {
+		t.Error("Expected the sample to be spread over the used slots")
+	}
+	if CountNonEmptyFiles(meta) != 6 {
+		t.Error("sampleSlots must not modify the original metadata")
+	}
+
+	DoctorSample = 3
+	defer func() { DoctorSample = 0 }()
+
+	checks := runDoctorChecks(file)
+	for _, c := range checks {
+		if c.Name == "Blocks" && !strings.Contains(c.Detail, "3 sampled of 6") {
+			t.Errorf("Expected sampled block check, got %s: %s", c.Status, c.Detail)
+		}
+	}
+
+	if c := checkMetaFill(meta); c.Status != DOCTOR_PASS {
+		t.Errorf("Expected meta fill PASS for a sparse volume, got %s: %s", c.Status, c.Detail)
+	}
+
+	for i := range TOTAL_FILES {
+		meta.Files[i] = File{
+			Name:    strings.Repeat("x", MAX_FILE_NAME_SIZE),
+			Size:    1000,
+			Created: 1700000000,
+			Origin:  "/home/user/" + strings.Repeat("y", 60),
+		}
+	}
+	if c := checkMetaFill(meta); c.Status != DOCTOR_WARN {
+		t.Errorf("Expected meta fill WARN for a full file table, got %s: %s", c.Status, c.Detail)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// fileRecord is the JSON form of a used slot.
+type fileRecord struct {
+	Index   int    `json:"index"`
+	Name    string `json:"name"`
+	Size    int    `json:"size"`
+	Created int64  `json:"created"`
+	MIME    string `json:"mime,omitempty"`
+}
+
+// metaRecord is the JSON form of the whole metadata block.
+type metaRecord struct {
+	Version int          `json:"version"`
+	Wear    WearStats    `json:"wear"`
+	Files   []fileRecord `json:"files"`
+}
+
+func newFileRecord(index int, f File) fileRecord {
+	return fileRecord{
+		Index:   index,
+		Name:    f.Name,
+		Size:    f.Size,
+		Created: f.Created,
+		MIME:    f.MIME,
+	}
+}
+
+// DumpMeta writes the decrypted metadata to stdout as compact JSON, or
+// indented with --pretty. In JSON Lines mode each used slot is written as
+// its own object as soon as it is visited, instead of building the full
+// document first.
+func DumpMeta(file F) error {
+	meta, err := ReadMeta(file)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+
+	if JSONLines {
+		for i, v := range meta.Files {
+			if v.Name == "" {
+				continue
+			}
+			if err := enc.Encode(newFileRecord(i, v)); err != nil {
+				return fmt.Errorf("failed to encode metadata: %w", err)
+			}
+		}
+		return nil
+	}
+
+	rec := metaRecord{
+		Version: meta.Version,
+		Wear:    meta.Wear,
+		Files:   []fileRecord{},
+	}
+	for i, v := range meta.Files {
+		if v.Name != "" {
+			rec.Files = append(rec.Files, newFileRecord(i, v))
+		}
+	}
+
+	if PrettyJSON {
+		enc.SetIndent("", "  ")
+	}
+
+	if err := enc.Encode(rec); err != nil {
+		return fmt.Errorf("failed to encode metadata: %w", err)
+	}
+
+	return nil
+}
+
+// DumpHeader prints the plaintext metadata header. The salt is only shown
+// with --show-salt.
+func DumpHeader(file F) error {
+	h, err := ReadHeader(file)
+	if err != nil {
+		return fmt.Errorf("failed to read header: %w", err)
+	}
+
+	PrintHeader("METADATA HEADER")
+	PrintSeparator(60)
+	Printf(" %-15s %s\n", C(ColorBold+ColorLightBlue, "Magic:"), C(ColorWhite, h.Magic))
+	Printf(" %-15s %s\n", C(ColorBold+ColorLightBlue, "Version:"), C(ColorWhite, fmt.Sprintf("%d", h.Version)))
+	Printf(" %-15s %s\n", C(ColorBold+ColorLightBlue, "Length:"), C(ColorWhite, fmt.Sprintf("%d bytes", h.Length)))
+	if ShowSalt {
+		Printf(" %-15s %s\n", C(ColorBold+ColorLightBlue, "Salt:"), C(ColorWhite, hex.EncodeToString(h.Salt)))
+	}
+	PrintSeparator(60)
+
+	return nil
+}
+
+// blockInfo describes the raw layout of a stored slot.
+type blockInfo struct {
+	Index        int
+	Name         string
+	Size         int
+	Nonce        []byte
+	PaddingStart int
+	PaddingClean bool
+}
+
+// inspectBlock reads a slot without decrypting it. PaddingStart is the
+// offset after the last non-zero byte; PaddingClean reports whether
+// everything past the recorded Size is zero.
+func inspectBlock(file F, index int) (*blockInfo, error) {
+	if index < 0 || index >= TOTAL_FILES {
+		return nil, fmt.Errorf("index out of range: %d (valid range: 0-%d)", index, TOTAL_FILES-1)
+	}
+
+	meta, err := ReadMeta(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	block := make([]byte, MAX_FILE_SIZE)
+	seekPos := int64(META_FILE_SIZE) + (int64(index) * int64(MAX_FILE_SIZE))
+	n, err := file.ReadAt(block, seekPos)
+	if n != MAX_FILE_SIZE {
+		return nil, fmt.Errorf("failed to read block: %w", err)
+	}
+
+	df := meta.Files[index]
+	info := &blockInfo{
+		Index:        index,
+		Name:         df.Name,
+		Size:         df.Size,
+		Nonce:        block[:NonceSize],
+		PaddingStart: len(bytes.TrimRight(block, "\x00")),
+	}
+
+	if df.Size >= 0 && df.Size <= MAX_FILE_SIZE {
+		info.PaddingClean = len(bytes.TrimLeft(block[df.Size:], "\x00")) == 0
+	}
+
+	return info, nil
+}
+
+// DumpBlock prints the structure of a stored slot without decrypting it.
+func DumpBlock(file F, index int) error {
+	info, err := inspectBlock(file, index)
+	if err != nil {
+		return err
+	}
+
+	padding := C(ColorLightBlue, "zero")
+	if !info.PaddingClean {
+		padding = C(ColorRed, "non-zero bytes after recorded size")
+	}
+
+	PrintHeader("BLOCK")
+	PrintSeparator(60)
+	Printf(" %-15s %s\n", C(ColorBold+ColorLightBlue, "Index:"), C(ColorWhite, fmt.Sprintf("%d", info.Index)))
+	Printf(" %-15s %s\n", C(ColorBold+ColorLightBlue, "Name:"), C(ColorWhite, info.Name))
+	Printf(" %-15s %s\n", C(ColorBold+ColorLightBlue, "Nonce:"), C(ColorWhite, hex.EncodeToString(info.Nonce)))
+	Printf(" %-15s %s\n", C(ColorBold+ColorLightBlue, "Size:"), C(ColorWhite, fmt.Sprintf("%d bytes", info.Size)))
+	Printf(" %-15s %s\n", C(ColorBold+ColorLightBlue, "Padding start:"), C(ColorWhite, fmt.Sprintf("offset %d", info.PaddingStart)))
+	Printf(" %-15s %s\n", C(ColorBold+ColorLightBlue, "Padding:"), padding)
+	PrintSeparator(60)
+
+	return nil
+}
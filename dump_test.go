@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDumpMeta(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+	FillSlots(t, file, 3)
+
+	output := captureOutput(func() {
+		if err := DumpMeta(file); err != nil {
+			t.Errorf("DumpMeta failed: %v", err)
+		}
+	})
+
+	var rec metaRecord
+	if err := json.Unmarshal([]byte(output), &rec); err != nil {
+		t.Fatalf("Output is not valid JSON: %v\n%s", err, output)
+	}
+	if rec.Version != METADATA_VERSION {
+		t.Errorf("Expected version %d, got %d", METADATA_VERSION, rec.Version)
+	}
+	if len(rec.Files) != 3 {
+		t.Errorf("Expected 3 files, got %d", len(rec.Files))
+	}
+}
+
+func TestJSONLinesOutput(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	JSONLines = true
+	defer func() { JSONLines = false }()
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+	FillSlots(t, file, 4)
+
+	tests := []struct {
+		name string
+		run  func() error
+	}{
+		{"dump-meta", func() error { return DumpMeta(file) }},
+		{"verify", func() error { return Verify(file, 2) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := captureOutput(func() {
+				if err := tt.run(); err != nil {
+					t.Errorf("%s failed: %v", tt.name, err)
+				}
+			})
+
+			lines := strings.Split(strings.TrimSpace(output), "\n")
+			if len(lines) != 4 {
+				t.Fatalf("Expected 4 lines, got %d:\n%s", len(lines), output)
+			}
+
+			for _, line := range lines {
+				var obj map[string]interface{}
+				if err := json.Unmarshal([]byte(line), &obj); err != nil {
+					t.Errorf("Line is not valid JSON: %q: %v", line, err)
+				}
+				if _, ok := obj["index"]; !ok {
+					t.Errorf("Line has no index: %q", line)
+				}
+			}
+		})
+	}
+}
+
+func TestShowSalt(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	ShowSalt = true
+	defer func() { ShowSalt = false }()
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	header := make([]byte, HEADER_SIZE)
+	file.ReadAt(header, 0)
+	expected := hex.EncodeToString(header[8 : 8+SALT_SIZE])
+
+	output := captureOutput(func() {
+		if err := DumpHeader(file); err != nil {
+			t.Errorf("DumpHeader failed: %v", err)
+		}
+	})
+	if !strings.Contains(output, expected) {
+		t.Errorf("Expected salt %s in dump-header output:\n%s", expected, output)
+	}
+
+	output = captureOutput(func() {
+		if err := Stat(file); err != nil {
+			t.Errorf("Stat failed: %v", err)
+		}
+	})
+	if !strings.Contains(output, expected) {
+		t.Errorf("Expected salt %s in stat output:\n%s", expected, output)
+	}
+
+	ShowSalt = false
+	output = captureOutput(func() {
+		DumpHeader(file)
+	})
+	if strings.Contains(output, expected) {
+		t.Error("Salt should not be printed without --show-salt")
+	}
+}
+
+func TestDumpBlock(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	sourcePath := CreateTempSourceFileWithName(t, []byte("inspect me"), "inspect.txt")
+	if err := Add(file, sourcePath, 3); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	block, err := ReadBlock(file, 3)
+	if err != nil {
+		t.Fatalf("ReadBlock failed: %v", err)
+	}
+	meta, _ := ReadMeta(file)
+
+	info, err := inspectBlock(file, 3)
+	if err != nil {
+		t.Fatalf("inspectBlock failed: %v", err)
+	}
+	if hex.EncodeToString(info.Nonce) != hex.EncodeToString(block[:NonceSize]) {
+		t.Errorf("Nonce mismatch: got %x, expected %x", info.Nonce, block[:NonceSize])
+	}
+	if info.Size != meta.Files[3].Size {
+		t.Errorf("Expected size %d, got %d", meta.Files[3].Size, info.Size)
+	}
+	if info.PaddingStart > info.Size || !info.PaddingClean {
+		t.Errorf("Expected clean padding after %d, got start %d clean %v", info.Size, info.PaddingStart, info.PaddingClean)
+	}
+
+	output := captureOutput(func() {
+		if err := DumpBlock(file, 3); err != nil {
+			t.Errorf("DumpBlock failed: %v", err)
+		}
+	})
+	if !strings.Contains(output, hex.EncodeToString(block[:NonceSize])) {
+		t.Errorf("Expected nonce in output, got:\n%s", output)
+	}
+
+	file.WriteAt([]byte{0xAA}, int64(META_FILE_SIZE+3*MAX_FILE_SIZE+MAX_FILE_SIZE-1))
+	info, err = inspectBlock(file, 3)
+	if err != nil {
+		t.Fatalf("inspectBlock failed: %v", err)
+	}
+	if info.PaddingClean || info.PaddingStart != MAX_FILE_SIZE {
+		t.Errorf("Expected dirty padding to be reported, got start %d clean %v", info.PaddingStart, info.PaddingClean)
+	}
+}
+
+func TestDumpMetaPretty(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+	FillSlots(t, file, 2)
+
+	compact := captureOutput(func() {
+		if err := DumpMeta(file); err != nil {
+			t.Errorf("DumpMeta failed: %v", err)
+		}
+	})
+
+	PrettyJSON = true
+	defer func() { PrettyJSON = false }()
+
+	pretty := captureOutput(func() {
+		if err := DumpMeta(file); err != nil {
+			t.Errorf("DumpMeta failed: %v", err)
+		}
+	})
+
+	if strings.Contains(strings.TrimSpace(compact), "\n") {
+		t.Errorf("Expected compact output on one line, got:\n%s", compact)
+	}
+	if !strings.Contains(strings.TrimSpace(pretty), "\n") {
+		t.Errorf("Expected pretty output to span lines, got:\n%s", pretty)
+	}
+
+	var a, b metaRecord
+	if err := json.Unmarshal([]byte(compact), &a); err != nil {
+		t.Fatalf("Compact output is not valid JSON: %v", err)
+	}
+	if err := json.Unmarshal([]byte(pretty), &b); err != nil {
+		t.Fatalf("Pretty output is not valid JSON: %v", err)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("Compact and pretty output differ:\n%+v\n%+v", a, b)
+	}
+}
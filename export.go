@@ -0,0 +1,97 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// What export does with a file whose name was already written, see
+// OnCollision.
+const (
+	COLLISION_SKIP      = "skip"
+	COLLISION_RENAME    = "rename"
+	COLLISION_OVERWRITE = "overwrite"
+)
+
+// ExportTar decrypts every used slot and writes it to w as a tar stream,
+// one entry per file under its original name. Files sharing a name are
+// handled as OnCollision says. A slot that fails to decrypt
+// is reported on stderr and skipped so the rest of the volume still comes
+// out; the returned error then counts the files that were left out.
+//
+// Nothing else is written to stdout, so w can be os.Stdout.
+func ExportTar(file F, w io.Writer) error {
+	meta, err := ReadMeta(file)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	password, err := GetEncKey()
+	if err != nil {
+		return fmt.Errorf("failed to get encryption key: %w", err)
+	}
+
+	tw := tar.NewWriter(w)
+
+	failed := 0
+	written := make(map[string]bool)
+	for i, v := range meta.Files {
+		if v.Name == "" {
+			continue
+		}
+
+		content, err := decryptSlot(file, meta, password, i)
+		if err != nil {
+			failed++
+			LogWarn("skipping [%d] %s: %v", i, v.Name, err)
+			continue
+		}
+
+		name := realName(v, password, meta.Salt)
+		if written[name] {
+			switch OnCollision {
+			case COLLISION_SKIP:
+				LogWarn("skipping [%d] %s: name already exported", i, name)
+				continue
+			case COLLISION_RENAME:
+				name = indexedName(name, i)
+			}
+		}
+		written[name] = true
+
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     0o600,
+			Size:     int64(len(content)),
+			ModTime:  time.Unix(v.Created, 0),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("failed to write tar header for %s: %w", v.Name, err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			return fmt.Errorf("failed to write tar entry for %s: %w", v.Name, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish tar stream: %w", err)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d files could not be exported", failed)
+	}
+
+	return nil
+}
+
+// indexedName inserts the slot index before the extension of name, so
+// notes.txt in slot 7 becomes notes_7.txt.
+func indexedName(name string, index int) string {
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s_%d%s", strings.TrimSuffix(name, ext), index, ext)
+}
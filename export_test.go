@@ -0,0 +1,160 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestExportTar(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	files := map[string][]byte{
+		"notes.txt": []byte("some notes"),
+		"data.bin":  GenerateRandomBytes(4000),
+		"empty.txt": {},
+	}
+	for name, content := range files {
+		sourcePath := CreateTempSourceFileWithName(t, content, name)
+		if err := Add(file, sourcePath, OUT_OF_BOUNDS_INDEX); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := ExportTar(file, &buf); err != nil {
+		t.Fatalf("ExportTar failed: %v", err)
+	}
+
+	got := readTarEntries(t, &buf)
+	if len(got) != len(files) {
+		t.Fatalf("Expected %d entries, got %d", len(files), len(got))
+	}
+	for name, content := range files {
+		data, ok := got[name]
+		if !ok {
+			t.Errorf("Missing entry %s", name)
+			continue
+		}
+		if !bytes.Equal(data, content) {
+			t.Errorf("Content mismatch for %s", name)
+		}
+	}
+}
+
+func TestExportTarSkipsCorruptFiles(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	for i, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		sourcePath := CreateTempSourceFileWithName(t, []byte("content of "+name), name)
+		if err := Add(file, sourcePath, i); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	file.Seek(int64(META_FILE_SIZE+MAX_FILE_SIZE+20), 0)
+	file.Write([]byte{0xFF, 0xFF, 0xFF})
+
+	var buf bytes.Buffer
+	if err := ExportTar(file, &buf); err == nil {
+		t.Error("Expected ExportTar to report the corrupted file")
+	}
+
+	got := readTarEntries(t, &buf)
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(got))
+	}
+	if _, ok := got["b.txt"]; ok {
+		t.Error("Corrupted file should not be exported")
+	}
+	if string(got["c.txt"]) != "content of c.txt" {
+		t.Errorf("Expected export to continue past the corrupted file, got %q", got["c.txt"])
+	}
+}
+
+func TestExportTarCollisions(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	for i, content := range map[int]string{0: "first", 3: "second"} {
+		sourcePath := CreateTempSourceFileWithName(t, []byte(content), "notes.txt")
+		if err := Add(file, sourcePath, i); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	defer func() { OnCollision = COLLISION_RENAME }()
+
+	// readTarEntries keeps the last entry of a name, as extracting would.
+	tests := []struct {
+		policy string
+		want   map[string]string
+	}{
+		{COLLISION_SKIP, map[string]string{"notes.txt": "first"}},
+		{COLLISION_RENAME, map[string]string{"notes.txt": "first", "notes_3.txt": "second"}},
+		{COLLISION_OVERWRITE, map[string]string{"notes.txt": "second"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			OnCollision = tt.policy
+
+			var buf bytes.Buffer
+			if err := ExportTar(file, &buf); err != nil {
+				t.Fatalf("ExportTar failed: %v", err)
+			}
+
+			got := readTarEntries(t, &buf)
+			if len(got) != len(tt.want) {
+				t.Errorf("Expected %d files, got %d", len(tt.want), len(got))
+			}
+			for name, content := range tt.want {
+				if string(got[name]) != content {
+					t.Errorf("Expected %s to contain %q, got %q", name, content, got[name])
+				}
+			}
+		})
+	}
+}
+
+func readTarEntries(t *testing.T, r io.Reader) map[string][]byte {
+	t.Helper()
+
+	entries := make(map[string][]byte)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read tar: %v", err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("Failed to read tar entry %s: %v", hdr.Name, err)
+		}
+		entries[hdr.Name] = data
+	}
+	return entries
+}
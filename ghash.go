@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"fmt"
+)
+
+// lengthScanner finds where a GCM ciphertext ends in a block padded with
+// noise. Blocks don't record their length, and opening every candidate
+// length with crypto/cipher costs a pass over the whole block each time.
+// Here GHASH is carried forward from one length to the next, so each
+// candidate costs two field multiplications. A length whose tag matches
+// still has to be opened for real by the caller.
+type lengthScanner struct {
+	table [16]ghashElement // multiples of the hash key, indexed by reversed nibble
+	mask  [16]byte         // encrypted initial counter, XORed into the tag
+}
+
+// ghashElement is an element of GF(2^128); low holds the first eight bytes
+// in GCM's bit order.
+type ghashElement struct {
+	low, high uint64
+}
+
+// ghashReduction holds the reduction polynomial multiples for the four
+// bits shifted out of an element in mul.
+var ghashReduction = [16]uint16{
+	0x0000, 0x1c20, 0x3840, 0x2460, 0x7080, 0x6ca0, 0x48c0, 0x54e0,
+	0xe100, 0xfd20, 0xd940, 0xc560, 0x9180, 0x8da0, 0xa9c0, 0xb5e0,
+}
+
+// newLengthScanner prepares a scanner for ciphertexts sealed under key
+// with nonce.
+func newLengthScanner(key, nonce []byte) (*lengthScanner, error) {
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	s := &lengthScanner{}
+	var h [16]byte
+	c.Encrypt(h[:], h[:])
+	x := ghashElement{
+		low:  binary.BigEndian.Uint64(h[:8]),
+		high: binary.BigEndian.Uint64(h[8:]),
+	}
+	s.table[reverseNibble(1)] = x
+	for i := 2; i < 16; i += 2 {
+		s.table[reverseNibble(i)] = ghashDouble(s.table[reverseNibble(i/2)])
+		d := s.table[reverseNibble(i)]
+		s.table[reverseNibble(i+1)] = ghashElement{low: d.low ^ x.low, high: d.high ^ x.high}
+	}
+
+	var counter [16]byte
+	copy(counter[:], nonce)
+	counter[15] = 1
+	c.Encrypt(s.mask[:], counter[:])
+
+	return s, nil
+}
+
+// next returns the first total length, nonce and tag included, in
+// [from, to] at which block holds a ciphertext whose tag matches for aad,
+// or 0 when there is none.
+func (s *lengthScanner) next(block, aad []byte, from, to int) int {
+	var y ghashElement
+	for i := 0; i < len(aad); i += 16 {
+		s.update(&y, aad[i:min(i+16, len(aad))])
+	}
+
+	data := block[NonceSize:]
+	hashed := 0
+	var tag [16]byte
+	for size := max(from, NonceSize+TagSize); size <= min(to, len(block)); size++ {
+		n := size - NonceSize - TagSize
+		for hashed+16 <= n {
+			s.update(&y, data[hashed:hashed+16])
+			hashed += 16
+		}
+
+		z := y
+		if n > hashed {
+			s.update(&z, data[hashed:n])
+		}
+		z.low ^= uint64(len(aad)) * 8
+		z.high ^= uint64(n) * 8
+		s.mul(&z)
+
+		binary.BigEndian.PutUint64(tag[:8], z.low)
+		binary.BigEndian.PutUint64(tag[8:], z.high)
+		for i := range tag {
+			tag[i] ^= s.mask[i]
+		}
+		if bytes.Equal(tag[:], data[n:n+TagSize]) {
+			return size
+		}
+	}
+
+	return 0
+}
+
+// update hashes one block of up to 16 bytes, zero padded, into y.
+func (s *lengthScanner) update(y *ghashElement, b []byte) {
+	var full [16]byte
+	copy(full[:], b)
+	y.low ^= binary.BigEndian.Uint64(full[:8])
+	y.high ^= binary.BigEndian.Uint64(full[8:])
+	s.mul(y)
+}
+
+// mul sets y to y times the hash key, four bits at a time.
+func (s *lengthScanner) mul(y *ghashElement) {
+	var z ghashElement
+	for i := range 2 {
+		word := y.high
+		if i == 1 {
+			word = y.low
+		}
+
+		for j := 0; j < 64; j += 4 {
+			msw := z.high & 0xf
+			z.high >>= 4
+			z.high |= z.low << 60
+			z.low >>= 4
+			z.low ^= uint64(ghashReduction[msw]) << 48
+
+			t := &s.table[word&0xf]
+			z.low ^= t.low
+			z.high ^= t.high
+			word >>= 4
+		}
+	}
+
+	*y = z
+}
+
+// ghashDouble multiplies x by the field's generator.
+func ghashDouble(x ghashElement) ghashElement {
+	msbSet := x.high&1 == 1
+
+	var d ghashElement
+	d.high = x.high >> 1
+	d.high |= x.low << 63
+	d.low = x.low >> 1
+	if msbSet {
+		d.low ^= 0xe100000000000000
+	}
+
+	return d
+}
+
+// reverseNibble reverses the order of the low four bits of i.
+func reverseNibble(i int) int {
+	i = ((i << 2) & 0xc) | ((i >> 2) & 0x3)
+	i = ((i << 1) & 0xa) | ((i >> 1) & 0x5)
+	return i
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLengthScanner(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	key := GenerateRandomBytes(32)
+	for _, n := range []int{0, 1, 15, 16, 17, 100, 4095, MAX_FILE_SIZE - NonceSize - TagSize} {
+		for _, aad := range [][]byte{nil, []byte("additional data of 21")} {
+			ciphertext, err := encryptWithKey(GenerateRandomBytes(n), key, aad)
+			if err != nil {
+				t.Fatalf("encryptWithKey failed: %v", err)
+			}
+			block := GenerateRandomBytes(MAX_FILE_SIZE)
+			copy(block, ciphertext)
+
+			s, err := newLengthScanner(key, block[:NonceSize])
+			if err != nil {
+				t.Fatalf("newLengthScanner failed: %v", err)
+			}
+			if got := s.next(block, aad, 0, MAX_FILE_SIZE); got != len(ciphertext) {
+				t.Errorf("%d bytes, aad %q: found length %d, expected %d", n, aad, got, len(ciphertext))
+			}
+			if got := s.next(block, aad, len(ciphertext)+1, MAX_FILE_SIZE); got != 0 {
+				t.Errorf("%d bytes, aad %q: found a second length %d", n, aad, got)
+			}
+		}
+	}
+
+	block := GenerateRandomBytes(MAX_FILE_SIZE)
+	s, err := newLengthScanner(key, block[:NonceSize])
+	if err != nil {
+		t.Fatalf("newLengthScanner failed: %v", err)
+	}
+	if got := s.next(block, nil, 0, MAX_FILE_SIZE); got != 0 {
+		t.Errorf("Found length %d in pure noise", got)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// A hidden volume is a complete second volume stored in the free slots of
+// an outer volume initialized with SlotNoise, behind its own password. It
+// is laid out backwards from the last outer slot: unit u of the hidden
+// volume, MAX_FILE_SIZE bytes each with the metadata taking the first
+// hiddenMetaUnits, lives in outer slot TOTAL_FILES-1-u.
+//
+// Nothing in it stands out from the noise around it: the metadata is
+// always padded to the full block, slot padding is random, and the
+// plaintext header is masked with a pad derived from the hidden password.
+// The outer volume has no record of it, so outer files must stay out of
+// the last hiddenMetaUnits+hiddenFiles slots, and trim on the outer volume
+// destroys it.
+const (
+	hiddenMetaUnits = META_FILE_SIZE / MAX_FILE_SIZE
+	hiddenFiles     = 200
+	hiddenSize      = int64(hiddenMetaUnits+hiddenFiles) * MAX_FILE_SIZE
+)
+
+// hiddenPadSalt is the fixed salt for the header pad. The pad has to be
+// derived before the header, and with it the volume salt, can be read.
+const hiddenPadSalt = "hdnfs hidden volume header"
+
+// hiddenVolume presents the hidden volume inside an outer device as a
+// volume of its own, so every operation works on it unchanged.
+type hiddenVolume struct {
+	F
+	pad []byte // XOR mask for the metadata header
+	pos int64
+}
+
+// hiddenPads holds the pad of every hiddenVolume made, so Shutdown can
+// wipe them with the other secrets.
+var (
+	hiddenPadsMu sync.Mutex
+	hiddenPads   [][]byte
+)
+
+// newHiddenVolume opens the hidden volume of file for password. Nothing
+// is read, so it succeeds whether or not one exists.
+func newHiddenVolume(file F, password string) *hiddenVolume {
+	pad := argon2.IDKey([]byte(password), []byte(hiddenPadSalt), Argon2Time, Argon2Memory, Argon2Threads, HEADER_SIZE)
+
+	hiddenPadsMu.Lock()
+	hiddenPads = append(hiddenPads, pad)
+	hiddenPadsMu.Unlock()
+
+	return &hiddenVolume{F: file, pad: pad}
+}
+
+// volumeFor returns file as seen with password. For a hidden volume that
+// is a new wrapper with the header pad of password, since the pad follows
+// the password the metadata is sealed under; any other file is returned
+// as it is.
+func volumeFor(file F, password string) F {
+	if h, ok := file.(*hiddenVolume); ok {
+		return newHiddenVolume(h.F, password)
+	}
+	return file
+}
+
+// wipeHiddenPads zeroes the pads of all hidden volumes. They can't read
+// their header afterwards.
+func wipeHiddenPads() {
+	hiddenPadsMu.Lock()
+	defer hiddenPadsMu.Unlock()
+
+	for _, pad := range hiddenPads {
+		zeroBytes(pad)
+	}
+	hiddenPads = nil
+}
+
+// OpenVolume returns the volume the cached password opens: file itself,
+// or the hidden volume inside it when only that one decrypts. When
+// neither does, file is returned with the error from reading its
+// metadata.
+func OpenVolume(file F) (F, error) {
+	_, err := ReadMeta(file)
+	if err == nil {
+		return file, nil
+	}
+
+	password, perr := GetEncKey()
+	if perr != nil {
+		return file, err
+	}
+
+	hidden := newHiddenVolume(file, password)
+	if _, herr := ReadMeta(hidden); herr == nil {
+		LogDebug("opened hidden volume")
+		return hidden, nil
+	}
+
+	return file, err
+}
+
+// InitHidden creates a hidden volume in file under the cached password.
+// outerPass opens the outer volume, which must have been initialized with
+// SlotNoise and hold no files in the slots the hidden volume takes. The
+// hidden password must not open the outer volume.
+func InitHidden(file F, outerPass string) error {
+	password, err := GetEncKey()
+	if err != nil {
+		return fmt.Errorf("failed to get encryption key: %w", err)
+	}
+	if password == outerPass {
+		return errors.New("the hidden password must differ from the outer volume's")
+	}
+
+	// Outer files are padded with noise too, so only the outer metadata
+	// can tell which of the slots are free.
+	setCachedPassword(outerPass)
+	outer, err := ReadMeta(file)
+	setCachedPassword(password)
+	if err != nil {
+		return fmt.Errorf("outer volume: %w", err)
+	}
+	if !outer.Noise {
+		return fmt.Errorf("a hidden volume needs an outer volume initialized with --preserve-empty-slot-noise")
+	}
+	for slot := TOTAL_FILES - hiddenMetaUnits - hiddenFiles; slot < TOTAL_FILES; slot++ {
+		if outer.Files[slot].Name != "" {
+			return fmt.Errorf("outer slot %d is in use, a hidden volume needs the last %d slots free", slot, hiddenMetaUnits+hiddenFiles)
+		}
+	}
+
+	last := make([]byte, MAX_FILE_SIZE)
+	n, err := file.ReadAt(last, int64(META_FILE_SIZE)+int64(TOTAL_FILES-1)*MAX_FILE_SIZE)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to read slot %d: %w", TOTAL_FILES-1, err)
+	}
+	if n < MAX_FILE_SIZE {
+		return fmt.Errorf("volume is too small for a hidden volume")
+	}
+
+	salt, err := GenerateSalt()
+	if err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	meta := &Meta{
+		Version: METADATA_VERSION,
+		Salt:    salt,
+		Padded:  true,
+		Noise:   true,
+		Slots:   hiddenFiles,
+		Files:   [TOTAL_FILES]File{},
+	}
+
+	if err := WriteMeta(newHiddenVolume(file, password), meta); err != nil {
+		return fmt.Errorf("failed to write hidden metadata: %w", err)
+	}
+
+	return nil
+}
+
+// each calls fn for every piece of b at hidden offset off that falls in a
+// single unit, with the outer offset of that piece.
+func (h *hiddenVolume) each(b []byte, off int64, write bool, fn func([]byte, int64) (int, error)) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset: %d", off)
+	}
+
+	done := 0
+	for done < len(b) {
+		logical := off + int64(done)
+		if logical >= hiddenSize {
+			if write {
+				return done, fmt.Errorf("offset %d is beyond the hidden volume, which holds %d files", logical, hiddenFiles)
+			}
+			return done, io.EOF
+		}
+
+		unit := logical / MAX_FILE_SIZE
+		within := logical % MAX_FILE_SIZE
+		size := min(int64(len(b)-done), MAX_FILE_SIZE-within)
+		outer := int64(META_FILE_SIZE) + int64(TOTAL_FILES-1-unit)*MAX_FILE_SIZE + within
+
+		n, err := fn(b[done:done+int(size)], outer)
+		done += n
+		if err != nil {
+			return done, err
+		}
+	}
+
+	return done, nil
+}
+
+// mask XORs the part of b at hidden offset off that overlaps the metadata
+// header with the pad. It both hides and reveals the header.
+func (h *hiddenVolume) mask(b []byte, off int64) {
+	for i := range b {
+		p := off + int64(i)
+		if p >= HEADER_SIZE {
+			return
+		}
+		b[i] ^= h.pad[p]
+	}
+}
+
+func (h *hiddenVolume) ReadAt(b []byte, off int64) (int, error) {
+	n, err := h.each(b, off, false, h.F.ReadAt)
+	h.mask(b[:n], off)
+	return n, err
+}
+
+func (h *hiddenVolume) WriteAt(b []byte, off int64) (int, error) {
+	if off < HEADER_SIZE {
+		b = bytes.Clone(b)
+		h.mask(b, off)
+	}
+	return h.each(b, off, true, h.F.WriteAt)
+}
+
+func (h *hiddenVolume) Read(b []byte) (int, error) {
+	n, err := h.ReadAt(b, h.pos)
+	h.pos += int64(n)
+	return n, err
+}
+
+func (h *hiddenVolume) Write(b []byte) (int, error) {
+	n, err := h.WriteAt(b, h.pos)
+	h.pos += int64(n)
+	return n, err
+}
+
+func (h *hiddenVolume) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += h.pos
+	case io.SeekEnd:
+		offset += hiddenSize
+	default:
+		return 0, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position: %d", offset)
+	}
+
+	h.pos = offset
+	return offset, nil
+}
+
+// Truncate is refused, the hidden volume has a fixed size.
+func (h *hiddenVolume) Truncate(size int64) error {
+	return errors.New("a hidden volume can't be truncated")
+}
+
+// Close closes the outer device if it can be closed.
+func (h *hiddenVolume) Close() error {
+	if c, ok := h.F.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
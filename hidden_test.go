@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestHiddenVolume(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	outerPass := "outer-password-for-testing"
+	hiddenPass := "hidden-password-for-testing"
+	defer ClearPasswordCache()
+
+	file := NewMockFile(0)
+
+	SetPasswordForTesting(outerPass)
+	SlotNoise = true
+	err := InitMeta(file, "file")
+	SlotNoise = false
+	if err != nil {
+		t.Fatalf("InitMeta failed: %v", err)
+	}
+
+	SetPasswordForTesting(hiddenPass)
+	if err := InitHidden(file, outerPass); err != nil {
+		t.Fatalf("InitHidden failed: %v", err)
+	}
+
+	SetPasswordForTesting(outerPass)
+	if err := InitHidden(file, outerPass); err == nil {
+		t.Error("Expected the outer password to be refused for the hidden volume")
+	}
+
+	add := func(password string, content []byte, name string) F {
+		t.Helper()
+		SetPasswordForTesting(password)
+		v, err := OpenVolume(file)
+		if err != nil {
+			t.Fatalf("OpenVolume failed: %v", err)
+		}
+		sourcePath := CreateTempSourceFileWithName(t, content, name)
+		if err := Add(v, sourcePath, OUT_OF_BOUNDS_INDEX); err != nil {
+			t.Fatalf("Add %s failed: %v", name, err)
+		}
+		return v
+	}
+
+	outer := add(outerPass, []byte("decoy content"), "decoy.txt")
+	hidden := add(hiddenPass, []byte("the real secret"), "secret.txt")
+	add(outerPass, []byte("another decoy"), "decoy2.txt")
+
+	if _, ok := outer.(*hiddenVolume); ok {
+		t.Fatal("Expected the outer password to open the outer volume")
+	}
+	if _, ok := hidden.(*hiddenVolume); !ok {
+		t.Fatal("Expected the hidden password to open the hidden volume")
+	}
+
+	names := func(password string, v F) []string {
+		t.Helper()
+		SetPasswordForTesting(password)
+		meta, err := ReadMeta(v)
+		if err != nil {
+			t.Fatalf("ReadMeta failed: %v", err)
+		}
+		var names []string
+		for _, f := range meta.Files {
+			if f.Name != "" {
+				names = append(names, f.Name)
+			}
+		}
+		return names
+	}
+
+	if got := names(outerPass, outer); len(got) != 2 || got[0] != "decoy.txt" || got[1] != "decoy2.txt" {
+		t.Errorf("Outer volume should list only its own files, got %v", got)
+	}
+	if got := names(hiddenPass, hidden); len(got) != 1 || got[0] != "secret.txt" {
+		t.Errorf("Hidden volume should list only its own files, got %v", got)
+	}
+
+	SetPasswordForTesting(hiddenPass)
+	var out bytes.Buffer
+	if err := GetToWriter(hidden, 0, &out); err != nil {
+		t.Fatalf("Get from hidden volume failed: %v", err)
+	}
+	if out.String() != "the real secret" {
+		t.Errorf("Expected hidden content, got %q", out.String())
+	}
+
+	// Seen from the outer volume, the slots holding the hidden metadata
+	// and file are free and look like any other noise.
+	SetPasswordForTesting(outerPass)
+	meta, err := ReadMeta(file)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+	for _, slot := range []int{TOTAL_FILES - 1, TOTAL_FILES - hiddenMetaUnits - 1, 500} {
+		if meta.Files[slot].Name != "" {
+			t.Fatalf("Slot %d should be free in the outer volume", slot)
+		}
+		block, err := ReadBlock(file, slot)
+		if err != nil {
+			t.Fatalf("ReadBlock failed: %v", err)
+		}
+		if e := entropy(block); e < 7.9 {
+			t.Errorf("Slot %d: entropy %.2f bits per byte stands out from the noise", slot, e)
+		}
+		if bytes.Contains(block, []byte(MAGIC_STRING)) || bytes.Contains(block, make([]byte, 64)) {
+			t.Errorf("Slot %d holds recognizable data", slot)
+		}
+	}
+
+	SetPasswordForTesting("some-other-password-entirely")
+	if _, err := OpenVolume(file); err == nil {
+		t.Error("Expected a wrong password to open neither volume")
+	}
+}
+
+func TestInitHiddenRequiresNoise(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := NewMockFile(0)
+	if err := InitMeta(file, "file"); err != nil {
+		t.Fatalf("InitMeta failed: %v", err)
+	}
+
+	SetPasswordForTesting("hidden-password-for-testing")
+	if err := InitHidden(file, "test-password-for-testing"); err == nil {
+		t.Error("Expected a volume without noise to be refused")
+	}
+}
+
+func TestInitHiddenRefusesUsedOuterSlots(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	outerPass := "outer-password-for-testing"
+	defer ClearPasswordCache()
+
+	file := NewMockFile(0)
+	SetPasswordForTesting(outerPass)
+	SlotNoise = true
+	err := InitMeta(file, "file")
+	SlotNoise = false
+	if err != nil {
+		t.Fatalf("InitMeta failed: %v", err)
+	}
+
+	// Padded with noise, the outer file can't be told from free space
+	// without the outer metadata.
+	if err := Add(file, CreateTempSourceFile(t, []byte("outer file")), TOTAL_FILES-10); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	SetPasswordForTesting("hidden-password-for-testing")
+	if err := InitHidden(file, outerPass); err == nil {
+		t.Fatal("Expected an outer file in the hidden area to be refused")
+	}
+	if err := InitHidden(file, "not-the-outer-password"); err == nil {
+		t.Fatal("Expected a wrong outer password to be refused")
+	}
+
+	SetPasswordForTesting(outerPass)
+	var out bytes.Buffer
+	if err := GetToWriter(file, TOTAL_FILES-10, &out); err != nil || out.String() != "outer file" {
+		t.Errorf("Expected the outer file to be untouched, got %q, %v", out.String(), err)
+	}
+}
+
+func TestHiddenVolumeTrim(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	defer ClearPasswordCache()
+
+	file := NewMockFile(0)
+	SetPasswordForTesting("outer-password-for-testing")
+	SlotNoise = true
+	err := InitMeta(file, "file")
+	SlotNoise = false
+	if err != nil {
+		t.Fatalf("InitMeta failed: %v", err)
+	}
+
+	SetPasswordForTesting("hidden-password-for-testing")
+	if err := InitHidden(file, "outer-password-for-testing"); err != nil {
+		t.Fatalf("InitHidden failed: %v", err)
+	}
+	hidden, err := OpenVolume(file)
+	if err != nil {
+		t.Fatalf("OpenVolume failed: %v", err)
+	}
+
+	if err := Trim(hidden); err != nil {
+		t.Fatalf("Trim on hidden volume failed: %v", err)
+	}
+	if err := Add(hidden, CreateTempSourceFile(t, []byte("still there")), hiddenFiles); err == nil {
+		t.Error("Expected an add beyond the hidden volume to fail")
+	}
+	meta := VerifyMetadataIntegrity(t, hidden)
+	if slotCount(meta) != hiddenFiles {
+		t.Errorf("Expected the hidden volume to offer %d slots, got %d", hiddenFiles, slotCount(meta))
+	}
+}
+
+func TestChangePasswordHiddenVolume(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	outerPass := "outer-password-for-testing"
+	hiddenPass := "hidden-password-for-testing"
+	newPass := "new-hidden-password-for-testing"
+	defer ClearPasswordCache()
+
+	file := NewMockFile(0)
+
+	SetPasswordForTesting(outerPass)
+	SlotNoise = true
+	err := InitMeta(file, "file")
+	SlotNoise = false
+	if err != nil {
+		t.Fatalf("InitMeta failed: %v", err)
+	}
+
+	SetPasswordForTesting(hiddenPass)
+	if err := InitHidden(file, outerPass); err != nil {
+		t.Fatalf("InitHidden failed: %v", err)
+	}
+	hidden, err := OpenVolume(file)
+	if err != nil {
+		t.Fatalf("OpenVolume failed: %v", err)
+	}
+	sourcePath := CreateTempSourceFileWithName(t, []byte("the real secret"), "secret.txt")
+	if err := Add(hidden, sourcePath, 0); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	if err := ChangePassword(hidden, hiddenPass, newPass); err != nil {
+		t.Fatalf("ChangePassword failed: %v", err)
+	}
+
+	SetPasswordForTesting(hiddenPass)
+	if v, err := OpenVolume(file); err == nil {
+		if _, ok := v.(*hiddenVolume); ok {
+			t.Error("Expected the old password to no longer open the hidden volume")
+		}
+	}
+
+	SetPasswordForTesting(newPass)
+	reopened, err := OpenVolume(file)
+	if err != nil {
+		t.Fatalf("OpenVolume with the new password failed: %v", err)
+	}
+	if _, ok := reopened.(*hiddenVolume); !ok {
+		t.Fatal("Expected the new password to open the hidden volume")
+	}
+	var out bytes.Buffer
+	if err := GetToWriter(reopened, 0, &out); err != nil {
+		t.Fatalf("Get from hidden volume failed: %v", err)
+	}
+	if out.String() != "the real secret" {
+		t.Errorf("Expected hidden content, got %q", out.String())
+	}
+
+	SetPasswordForTesting(outerPass)
+	if _, err := ReadMeta(file); err != nil {
+		t.Errorf("Outer volume no longer opens: %v", err)
+	}
+}
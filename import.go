@@ -0,0 +1,188 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// ImportArchive adds every regular file in a tar, tar.gz or zip archive
+// without extracting anything to disk. Entries are streamed into AddReader
+// one at a time and stored under their base name. Directories, links and
+// entries too large for a slot are skipped.
+func ImportArchive(file F, archivePath string) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer f.Close()
+
+	if strings.HasSuffix(strings.ToLower(archivePath), ".zip") {
+		s, err := f.Stat()
+		if err != nil {
+			return fmt.Errorf("failed to stat archive: %w", err)
+		}
+		return importZip(file, f, s.Size())
+	}
+
+	r := bufio.NewReader(f)
+	magic, _ := r.Peek(2)
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("failed to open gzip stream: %w", err)
+		}
+		defer gz.Close()
+		return importTar(file, gz)
+	}
+
+	return importTar(file, r)
+}
+
+// ImportList adds every file named in listPath, one path per line. Blank
+// lines are skipped. A file that fails is reported and the rest are still
+// added; the returned error counts the failures.
+func ImportList(file F, listPath string) error {
+	list, err := os.Open(listPath)
+	if err != nil {
+		return fmt.Errorf("failed to open input list: %w", err)
+	}
+	defer list.Close()
+
+	added, failed := 0, 0
+	scanner := bufio.NewScanner(list)
+	for scanner.Scan() {
+		path := strings.TrimSpace(scanner.Text())
+		if path == "" {
+			continue
+		}
+
+		silent := Silent
+		Silent = true
+		err := Add(file, path, OUT_OF_BOUNDS_INDEX)
+		Silent = silent
+
+		if err != nil {
+			failed++
+			Printf("%s %s %s\n", C(ColorRed, "failed"), C(ColorWhite, path), C(ColorDim, err.Error()))
+			continue
+		}
+		added++
+		Printf("%s %s\n", C(ColorLightBlue, "added "), C(ColorWhite, path))
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read input list: %w", err)
+	}
+
+	PrintSuccess(fmt.Sprintf("Import complete: %s added, %s failed",
+		C(ColorBold+ColorWhite, fmt.Sprintf("%d files", added)),
+		C(ColorBold+ColorWhite, fmt.Sprintf("%d", failed))))
+
+	if failed > 0 {
+		return fmt.Errorf("%d files could not be added", failed)
+	}
+
+	return nil
+}
+
+func importTar(file F, r io.Reader) error {
+	tr := tar.NewReader(r)
+
+	imported, skipped := 0, 0
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		ok, err := importEntry(file, tr, hdr.Name, hdr.Size)
+		if err != nil {
+			return err
+		}
+		if ok {
+			imported++
+		} else {
+			skipped++
+		}
+	}
+
+	printImported(imported, skipped)
+	return nil
+}
+
+func importZip(file F, r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	imported, skipped := 0, 0
+	for _, zf := range zr.File {
+		if !zf.Mode().IsRegular() {
+			continue
+		}
+
+		rc, err := zf.Open()
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", zf.Name, err)
+		}
+		ok, err := importEntry(file, rc, zf.Name, int64(zf.UncompressedSize64))
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		if ok {
+			imported++
+		} else {
+			skipped++
+		}
+	}
+
+	printImported(imported, skipped)
+	return nil
+}
+
+// importEntry adds one archive entry and reports whether it was stored.
+// Entries that can never fit are skipped; any other error aborts.
+func importEntry(file F, r io.Reader, name string, size int64) (bool, error) {
+	name = path.Base(name)
+
+	reason := ""
+	switch {
+	case size > maxSourceSize():
+		reason = fmt.Sprintf("too large: %d bytes", size)
+	case len(name) > MAX_FILE_NAME_SIZE:
+		reason = "filename too long"
+	}
+	if reason != "" {
+		LogWarn("skipping %s: %s", name, reason)
+		return false, nil
+	}
+
+	if err := AddReader(file, r, name, OUT_OF_BOUNDS_INDEX); err != nil {
+		return false, fmt.Errorf("failed to import %s: %w", name, err)
+	}
+
+	return true, nil
+}
+
+func printImported(imported, skipped int) {
+	PrintSuccess(fmt.Sprintf("Import complete: %s imported, %s skipped",
+		C(ColorBold+ColorWhite, fmt.Sprintf("%d files", imported)),
+		C(ColorBold+ColorWhite, fmt.Sprintf("%d", skipped))))
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportArchiveTar(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	entries := map[string][]byte{
+		"docs/a.txt": []byte("first document"),
+		"docs/b.txt": []byte("second document"),
+		"c.bin":      GenerateRandomBytes(3000),
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "docs/", Typeflag: tar.TypeDir, Mode: 0o755})
+	for _, name := range []string{"docs/a.txt", "docs/b.txt", "c.bin"} {
+		tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(entries[name]))})
+		tw.Write(entries[name])
+	}
+	big := GenerateRandomBytes(MAX_FILE_SIZE)
+	tw.WriteHeader(&tar.Header{Name: "big.bin", Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(big))})
+	tw.Write(big)
+	tw.Close()
+
+	archivePath := filepath.Join(t.TempDir(), "docs.tar")
+	os.WriteFile(archivePath, buf.Bytes(), 0o644)
+
+	if err := ImportArchive(file, archivePath); err != nil {
+		t.Fatalf("ImportArchive failed: %v", err)
+	}
+
+	meta := VerifyMetadataIntegrity(t, file)
+	if CountUsedSlots(meta) != 3 {
+		t.Fatalf("Expected 3 imported files, got %d", CountUsedSlots(meta))
+	}
+
+	outDir := t.TempDir()
+	for i, name := range []string{"docs/a.txt", "docs/b.txt", "c.bin"} {
+		if meta.Files[i].Name != filepath.Base(name) {
+			t.Errorf("Slot %d: expected %s, got %q", i, filepath.Base(name), meta.Files[i].Name)
+			continue
+		}
+
+		outPath := filepath.Join(outDir, meta.Files[i].Name)
+		if err := Get(file, i, outPath); err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		data, _ := os.ReadFile(outPath)
+		if !bytes.Equal(data, entries[name]) {
+			t.Errorf("Content mismatch for %s", name)
+		}
+	}
+}
+
+func TestImportArchiveZip(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("notes/one.txt")
+	w.Write([]byte("one"))
+	w, _ = zw.Create("two.txt")
+	w.Write([]byte("two"))
+	zw.Close()
+
+	archivePath := filepath.Join(t.TempDir(), "notes.zip")
+	os.WriteFile(archivePath, buf.Bytes(), 0o644)
+
+	if err := ImportArchive(file, archivePath); err != nil {
+		t.Fatalf("ImportArchive failed: %v", err)
+	}
+
+	meta := VerifyMetadataIntegrity(t, file)
+	if meta.Files[0].Name != "one.txt" || meta.Files[1].Name != "two.txt" {
+		t.Errorf("Unexpected imported names: %q, %q", meta.Files[0].Name, meta.Files[1].Name)
+	}
+}
+
+func TestImportList(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	dir := t.TempDir()
+	contents := map[string][]byte{
+		"one.txt":   []byte("first"),
+		"two.txt":   []byte("second"),
+		"three.bin": GenerateRandomBytes(2000),
+	}
+	var paths []string
+	for _, name := range []string{"one.txt", "two.txt", "three.bin"} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, contents[name], 0o644)
+		paths = append(paths, path)
+	}
+
+	listPath := filepath.Join(dir, "paths.txt")
+	list := paths[0] + "\n\n" + paths[1] + "\n" + filepath.Join(dir, "missing.txt") + "\n" + paths[2] + "\n"
+	os.WriteFile(listPath, []byte(list), 0o644)
+
+	var err error
+	output := captureOutput(func() {
+		err = ImportList(file, listPath)
+	})
+	if err == nil {
+		t.Error("Expected ImportList to report the missing file")
+	}
+	if !strings.Contains(output, "missing.txt") || !strings.Contains(output, "failed") {
+		t.Errorf("Expected a failure line for missing.txt, got:\n%s", output)
+	}
+
+	meta := VerifyMetadataIntegrity(t, file)
+	if CountUsedSlots(meta) != 3 {
+		t.Fatalf("Expected 3 files, got %d", CountUsedSlots(meta))
+	}
+
+	outDir := t.TempDir()
+	for i, name := range []string{"one.txt", "two.txt", "three.bin"} {
+		if meta.Files[i].Name != name {
+			t.Errorf("Slot %d: expected %s, got %q", i, name, meta.Files[i].Name)
+			continue
+		}
+		outPath := filepath.Join(outDir, name)
+		if err := Get(file, i, outPath); err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		data, _ := os.ReadFile(outPath)
+		if !bytes.Equal(data, contents[name]) {
+			t.Errorf("Content mismatch for %s", name)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -84,7 +85,7 @@ func TestEndToEndWorkflow(t *testing.T) {
 	}
 
 	t.Log("Step 5: Delete file")
-	if err := Del(file, 1); err != nil {
+	if _, err := Del(file, "1"); err != nil {
 		t.Fatalf("Del failed: %v", err)
 	}
 
@@ -196,7 +197,7 @@ func TestRealWorldUsagePattern(t *testing.T) {
 	VerifyFileConsistency(t, file, 2, newNotesContent)
 
 	t.Log("Phase 5: Remove sensitive file")
-	Del(file, 3)
+	Del(file, "3")
 
 	meta, err := ReadMeta(file)
 	if err != nil {
@@ -408,7 +409,7 @@ func TestEdgeCases(t *testing.T) {
 		sourcePath := CreateTempSourceFile(t, content)
 		Add(file, sourcePath, 0)
 
-		Del(file, 0)
+		Del(file, "0")
 
 		meta, err := ReadMeta(file)
 		if err != nil {
@@ -501,7 +502,7 @@ func TestComplexScenario(t *testing.T) {
 	}
 
 	for _, idx := range docTypes["work"] {
-		Del(file, idx)
+		Del(file, strconv.Itoa(idx))
 	}
 
 	meta, err = ReadMeta(file)
@@ -545,7 +546,7 @@ func TestComplexScenario(t *testing.T) {
 		}
 	}
 
-	Overwrite(file, 0, uint64(META_FILE_SIZE+(10*MAX_FILE_SIZE)))
+	Overwrite(file, 0, uint64(META_FILE_SIZE+(10*MAX_FILE_SIZE)), 1)
 	InitMeta(file, "file")
 
 	Sync(backupFile, file)
@@ -583,7 +584,7 @@ func BenchmarkFullWorkflow(b *testing.B) {
 
 		List(file, "")
 
-		Del(file, 5)
+		Del(file, "5")
 
 		dstFile := CreateTempTestFile(&testing.T{}, META_FILE_SIZE+(TOTAL_FILES*MAX_FILE_SIZE))
 		Sync(file, dstFile)
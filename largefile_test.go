@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -202,7 +203,7 @@ func TestSmallFilesystemFragmentation(t *testing.T) {
 	}
 
 	for i := 0; i < numFiles; i += 2 {
-		Del(file, i)
+		Del(file, strconv.Itoa(i))
 	}
 
 	meta, err := ReadMeta(file)
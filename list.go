@@ -1,50 +1,272 @@
 package main
 
 import (
+	"bytes"
+	"encoding/hex"
 	"fmt"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 )
 
+// List prints the used slots whose name contains filter and passes
+// FilterRegex and TypeFilter, in slot order unless ListSort is set. With JSONEvents it writes them to stdout as
+// one JSON array of fileRecord instead of the table.
 func List(file F, filter string) error {
+	var re *regexp.Regexp
+	if FilterRegex != "" {
+		var err error
+		re, err = regexp.Compile(FilterRegex)
+		if err != nil {
+			return fmt.Errorf("invalid filter regex: %w", err)
+		}
+	}
+
 	meta, err := ReadMeta(file)
 	if err != nil {
 		return fmt.Errorf("failed to read metadata: %w", err)
 	}
 
+	// The count is the whole output, so it is printed even when Silent.
+	if UsedOnlyCount {
+		fmt.Println(CountUsedSlots(meta))
+		return nil
+	}
+
+	listed := func(v File) bool {
+		if v.Name == "" {
+			return false
+		}
+		if filter != "" && !strings.Contains(v.Name, filter) {
+			return false
+		}
+		if re != nil && !re.MatchString(v.Name) {
+			return false
+		}
+		return TypeFilter == "" || strings.HasPrefix(v.MIME, TypeFilter)
+	}
+
+	var indices []int
+	for i, v := range meta.Files {
+		if listed(v) {
+			indices = append(indices, i)
+		}
+	}
+	sortListed(meta, indices)
+
+	if JSONEvents {
+		records := []fileRecord{}
+		for _, i := range indices {
+			records = append(records, newFileRecord(i, meta.Files[i]))
+		}
+		return PrintJSON(records)
+	}
+
+	var password string
+	if VerifyInline {
+		password, err = GetEncKey()
+		if err != nil {
+			return fmt.Errorf("failed to get encryption key: %w", err)
+		}
+	}
+
+	extraHeader := ""
+	if LongList {
+		extraHeader = C(ColorBold+ColorLightBlue, fmt.Sprintf("%-26s", "TYPE")) + "  "
+	}
+	if Checksums {
+		extraHeader += C(ColorBold+ColorLightBlue, fmt.Sprintf("%-12s", "SHA256")) + "  "
+	}
+	if VerifyInline {
+		extraHeader += C(ColorBold+ColorLightBlue, fmt.Sprintf("%-8s", "STATUS")) + "  "
+	}
+
 	PrintHeader("FILE LIST")
 	PrintSeparator(100)
-	Printf(" %s  %s  %s  %s\n",
+	Printf(" %s  %s  %s  %s%s\n",
 		C(ColorBold+ColorLightBlue, "INDEX"),
 		C(ColorBold+ColorLightBlue, "SIZE      "),
 		C(ColorBold+ColorLightBlue, "CREATED            "),
+		extraHeader,
 		C(ColorBold+ColorLightBlue, "NAME"))
 	PrintSeparator(100)
 
-	count := 0
-	for i, v := range meta.Files {
-		if v.Name == "" {
-			continue
-		}
-		if filter != "" {
-			if !strings.Contains(v.Name, filter) {
-				continue
-			}
-		}
+	count, failed := 0, 0
+	for _, i := range indices {
+		v := meta.Files[i]
 		created := "N/A"
 		if v.Created > 0 {
 			created = time.Unix(v.Created, 0).Format("2006-01-02 15:04:05")
 		}
-		Printf(" %s  %s  %s  %s\n",
+		extra := ""
+		if LongList {
+			t := v.MIME
+			if t == "" {
+				t = "unknown"
+			}
+			extra = C(ColorDim, fmt.Sprintf("%-26s", t)) + "  "
+		}
+		if Checksums {
+			sum := "-"
+			if len(v.Checksum) > 0 {
+				sum = shortChecksum(v.Checksum)
+			}
+			extra += C(ColorDim, fmt.Sprintf("%-12s", sum)) + "  "
+		}
+		if VerifyInline {
+			status := inlineStatus(file, meta, password, i)
+			color := ColorGreen
+			if status != "OK" {
+				color = ColorRed
+				failed++
+			}
+			extra += C(color, fmt.Sprintf("%-8s", status)) + "  "
+		}
+		Printf(" %s  %s  %s  %s%s\n",
 			C(ColorBrightBlue, fmt.Sprintf("%-5d", i)),
 			C(ColorLightBlue, fmt.Sprintf("%-10s", fmt.Sprintf("%d bytes", v.Size))),
 			C(ColorCyan, fmt.Sprintf("%-19s", created)),
-			C(ColorWhite, v.Name))
+			extra,
+			highlightName(v.Name, filter))
 		count++
 	}
 
 	PrintSeparator(100)
 	Printf("\n%s %s\n", C(ColorBold+ColorLightBlue, "Total files:"), C(ColorWhite, fmt.Sprintf("%d", count)))
 
+	if failed > 0 {
+		return fmt.Errorf("%d files failed inline verification", failed)
+	}
+
 	return nil
 }
+
+// sortListed orders the slot indices list prints by ListSort: name, size
+// or created, descending with a - prefix. Files that compare equal keep
+// slot order either way.
+func sortListed(meta *Meta, indices []int) {
+	key, desc := strings.CutPrefix(ListSort, "-")
+
+	var less func(a, b File) bool
+	switch key {
+	case "name":
+		less = func(a, b File) bool { return a.Name < b.Name }
+		if NaturalSort {
+			less = func(a, b File) bool { return naturalLess(a.Name, b.Name) }
+		}
+	case "size":
+		less = func(a, b File) bool { return a.Size < b.Size }
+	case "created":
+		less = func(a, b File) bool { return a.Created < b.Created }
+	default:
+		return
+	}
+
+	sort.SliceStable(indices, func(a, b int) bool {
+		fa, fb := meta.Files[indices[a]], meta.Files[indices[b]]
+		if desc {
+			return less(fb, fa)
+		}
+		return less(fa, fb)
+	})
+}
+
+// naturalLess compares names the way people read them: runs of digits
+// are compared by their numeric value, so file2 sorts before file10, and
+// everything else byte by byte. Numbers that are equal apart from leading
+// zeros are ordered by the zeros, fewer first, so the order stays total.
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		da, db := digitPrefix(a), digitPrefix(b)
+		if da == 0 || db == 0 {
+			if a[0] != b[0] {
+				return a[0] < b[0]
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+
+		na := strings.TrimLeft(a[:da], "0")
+		nb := strings.TrimLeft(b[:db], "0")
+		if len(na) != len(nb) {
+			return len(na) < len(nb)
+		}
+		if na != nb {
+			return na < nb
+		}
+		if da != db {
+			return da < db
+		}
+		a, b = a[da:], b[db:]
+	}
+
+	return len(a) < len(b)
+}
+
+// digitPrefix returns the length of the run of ASCII digits s starts with.
+func digitPrefix(s string) int {
+	n := 0
+	for n < len(s) && '0' <= s[n] && s[n] <= '9' {
+		n++
+	}
+	return n
+}
+
+// highlightName colors name for list, marking each occurrence of filter
+// when Highlight is set.
+func highlightName(name, filter string) string {
+	if !Highlight || filter == "" {
+		return C(ColorWhite, name)
+	}
+
+	parts := strings.Split(name, filter)
+	for i, p := range parts {
+		parts[i] = C(ColorWhite, p)
+	}
+
+	return strings.Join(parts, C(ColorBold+ColorYellow, filter))
+}
+
+// CountUsedSlots returns the number of slots holding a file.
+func CountUsedSlots(meta *Meta) int {
+	count := 0
+	for _, f := range meta.Files {
+		if f.Name != "" {
+			count++
+		}
+	}
+	return count
+}
+
+// slotCount is how many slots of meta.Files can hold a file: Slots when
+// it is set, as on a hidden volume, otherwise TOTAL_FILES.
+func slotCount(meta *Meta) int {
+	if meta.Slots > 0 {
+		return meta.Slots
+	}
+	return TOTAL_FILES
+}
+
+// shortChecksum is the hex checksum truncated to fit the list column.
+func shortChecksum(sum []byte) string {
+	return hex.EncodeToString(sum)[:12]
+}
+
+// inlineStatus decrypts slot index for list --verify-inline. It returns
+// CORRUPT when the block fails to decrypt and MISMATCH when it no longer
+// matches the recorded checksum. Files without a checksum are trusted on
+// the strength of the GCM tag alone.
+func inlineStatus(file F, meta *Meta, password string, index int) string {
+	content, err := decryptSlot(file, meta, password, index)
+	if err != nil {
+		return "CORRUPT"
+	}
+
+	sum := meta.Files[index].Checksum
+	if len(sum) > 0 && !bytes.Equal(ComputeChecksum(content), sum) {
+		return "MISMATCH"
+	}
+
+	return "OK"
+}
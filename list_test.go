@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -225,8 +226,8 @@ func TestListAfterDelete(t *testing.T) {
 		Add(file, sourcePath, i)
 	}
 
-	Del(file, 1)
-	Del(file, 3)
+	Del(file, "1")
+	Del(file, "3")
 
 	output := captureOutput(func() {
 		List(file, "")
@@ -440,3 +441,400 @@ func BenchmarkListWithFilter(b *testing.B) {
 		List(file, "doc")
 	}
 }
+
+func TestContentTypeDetection(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+
+	InitMeta(file, "file")
+
+	png := append([]byte("\x89PNG\r\n\x1a\n"), GenerateRandomBytes(100)...)
+	Add(file, CreateTempSourceFileWithName(t, png, "picture.png"), 0)
+	Add(file, CreateTempSourceFileWithName(t, []byte("just some text\n"), "notes.txt"), 1)
+
+	meta, err := ReadMeta(file)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+	if meta.Files[0].MIME != "image/png" {
+		t.Errorf("Expected image/png, got %q", meta.Files[0].MIME)
+	}
+	if meta.Files[1].MIME != "text/plain; charset=utf-8" {
+		t.Errorf("Expected text/plain; charset=utf-8, got %q", meta.Files[1].MIME)
+	}
+
+	LongList = true
+	defer func() { LongList = false }()
+
+	output := captureOutput(func() {
+		List(file, "")
+	})
+	if !strings.Contains(output, "image/png") || !strings.Contains(output, "text/plain") {
+		t.Errorf("Expected content types in long listing, got:\n%s", output)
+	}
+
+	TypeFilter = "image/"
+	defer func() { TypeFilter = "" }()
+
+	output = captureOutput(func() {
+		List(file, "")
+	})
+	if !strings.Contains(output, "picture.png") || strings.Contains(output, "notes.txt") {
+		t.Errorf("Expected only picture.png with type filter, got:\n%s", output)
+	}
+}
+
+func TestListFilterRegex(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+
+	InitMeta(file, "file")
+
+	names := []string{"report_2023.txt", "report_2024.txt", "old_report_2024.txt", "notes.md"}
+	for i, name := range names {
+		Add(file, CreateTempSourceFileWithName(t, []byte(name), name), i)
+	}
+
+	FilterRegex = `^report_\d{4}\.txt$`
+	defer func() { FilterRegex = "" }()
+
+	output := captureOutput(func() {
+		if err := List(file, ""); err != nil {
+			t.Errorf("List failed: %v", err)
+		}
+	})
+
+	for _, name := range []string{"report_2023.txt", "report_2024.txt"} {
+		if !strings.Contains(output, name) {
+			t.Errorf("Expected %s in output", name)
+		}
+	}
+	for _, name := range []string{"old_report_2024.txt", "notes.md"} {
+		if strings.Contains(output, name) {
+			t.Errorf("Did not expect %s in output", name)
+		}
+	}
+	if !strings.Contains(output, "Total files:") || !strings.Contains(output, "2") {
+		t.Errorf("Expected a total of 2 files, got:\n%s", output)
+	}
+
+	FilterRegex = `report_(`
+	if err := List(file, ""); err == nil || !strings.Contains(err.Error(), "invalid filter regex") {
+		t.Errorf("Expected invalid filter regex error, got: %v", err)
+	}
+}
+
+func TestListChecksumVerifyInline(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	Checksums = true
+	defer func() { Checksums = false }()
+
+	file := GetSharedTestFile(t)
+
+	InitMeta(file, "file")
+
+	contents := []string{"content one", "content two", "content six"}
+	for i, content := range contents {
+		Add(file, CreateTempSourceFile(t, []byte(content)), i)
+	}
+
+	output := captureOutput(func() {
+		if err := List(file, ""); err != nil {
+			t.Errorf("List failed: %v", err)
+		}
+	})
+	if !strings.Contains(output, "SHA256") {
+		t.Errorf("Expected checksum column header, got:\n%s", output)
+	}
+	for _, content := range contents {
+		sum := shortChecksum(ComputeChecksum([]byte(content)))
+		if !strings.Contains(output, sum) {
+			t.Errorf("Expected checksum %s for %q in output", sum, content)
+		}
+	}
+
+	VerifyInline = true
+	defer func() { VerifyInline = false }()
+
+	output = captureOutput(func() {
+		if err := List(file, ""); err != nil {
+			t.Errorf("List failed on healthy volume: %v", err)
+		}
+	})
+	if strings.Count(output, "OK") != 3 {
+		t.Errorf("Expected 3 OK files, got:\n%s", output)
+	}
+
+	// Slot 1 gets a valid block holding different content, slot 2 gets
+	// garbage.
+	block, _ := ReadBlock(file, 0)
+	WriteBlock(file, block, "", 1)
+	file.Seek(int64(META_FILE_SIZE+(2*MAX_FILE_SIZE)+20), 0)
+	file.Write([]byte{0xFF, 0xFF, 0xFF})
+
+	output = captureOutput(func() {
+		err := List(file, "")
+		if err == nil || !strings.Contains(err.Error(), "2 files failed") {
+			t.Errorf("Expected 2 failed files, got: %v", err)
+		}
+	})
+	if !strings.Contains(output, "MISMATCH") {
+		t.Errorf("Expected MISMATCH in output, got:\n%s", output)
+	}
+	if !strings.Contains(output, "CORRUPT") {
+		t.Errorf("Expected CORRUPT in output, got:\n%s", output)
+	}
+}
+
+func TestListHighlight(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+
+	InitMeta(file, "file")
+	Add(file, CreateTempSourceFileWithName(t, []byte("q1"), "q1-report.pdf"), 0)
+
+	Highlight = true
+	defer func() { Highlight = false }()
+
+	output := captureOutput(func() {
+		List(file, "report")
+	})
+	if !strings.Contains(output, C(ColorBold+ColorYellow, "report")) {
+		t.Errorf("Expected the match to be highlighted, got:\n%q", output)
+	}
+	if !strings.Contains(output, C(ColorWhite, "q1-")) || !strings.Contains(output, C(ColorWhite, ".pdf")) {
+		t.Errorf("Expected the rest of the name in the normal color, got:\n%q", output)
+	}
+
+	NoColor = true
+	defer func() { NoColor = false }()
+
+	output = captureOutput(func() {
+		List(file, "report")
+	})
+	if strings.Contains(output, "\033[") {
+		t.Errorf("Expected no escape codes with --no-color, got:\n%q", output)
+	}
+	if !strings.Contains(output, "q1-report.pdf") {
+		t.Errorf("Expected the plain name with --no-color, got:\n%s", output)
+	}
+}
+
+func TestListUsedOnlyCount(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+
+	InitMeta(file, "file")
+	FillSlots(t, file, 3)
+	Add(file, CreateTempSourceFile(t, []byte("last")), 900)
+
+	UsedOnlyCount = true
+	defer func() { UsedOnlyCount = false }()
+
+	output := captureOutput(func() {
+		if err := List(file, ""); err != nil {
+			t.Errorf("List failed: %v", err)
+		}
+	})
+	if output != "4\n" {
+		t.Errorf("Expected only the count, got %q", output)
+	}
+
+	// The count is the whole point, so silent mode doesn't hide it.
+	Silent = true
+	defer func() { Silent = false }()
+
+	output = captureOutput(func() {
+		List(file, "")
+	})
+	if output != "4\n" {
+		t.Errorf("Expected the count in silent mode, got %q", output)
+	}
+}
+
+func TestListJSON(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	JSONEvents = true
+	defer func() { JSONEvents = false }()
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	for i, name := range []string{"alpha.txt", "beta.txt", "gamma.log"} {
+		sourcePath := CreateTempSourceFileWithName(t, []byte(strings.Repeat("x", 10*(i+1))), name)
+		if err := Add(file, sourcePath, i*3); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	meta := VerifyMetadataIntegrity(t, file)
+
+	var listErr error
+	output := captureOutput(func() {
+		listErr = List(file, ".txt")
+	})
+	if listErr != nil {
+		t.Fatalf("List failed: %v", listErr)
+	}
+	if strings.Contains(output, "FILE LIST") || strings.Contains(output, "\033[") {
+		t.Errorf("Expected plain JSON without table or colors, got:\n%s", output)
+	}
+
+	var records []struct {
+		Index   int    `json:"index"`
+		Name    string `json:"name"`
+		Size    int    `json:"size"`
+		Created int64  `json:"created"`
+	}
+	if err := json.Unmarshal([]byte(output), &records); err != nil {
+		t.Fatalf("Output is not a JSON array: %v\n%s", err, output)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected the 2 .txt files, got %d", len(records))
+	}
+	for _, r := range records {
+		f := meta.Files[r.Index]
+		if r.Name != f.Name || r.Size != f.Size || r.Created != f.Created {
+			t.Errorf("Record %+v doesn't match metadata %+v", r, f)
+		}
+	}
+	if records[0].Name != "alpha.txt" || records[1].Index != 3 {
+		t.Errorf("Unexpected records: %+v", records)
+	}
+}
+
+func TestListSortNatural(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	names := []string{"file10.txt", "file2.txt", "file1.txt", "file02.txt", "file100.txt", "file9b.txt", "file9a.txt"}
+	for i, name := range names {
+		sourcePath := CreateTempSourceFileWithName(t, []byte(name), name)
+		if err := Add(file, sourcePath, i); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	listedNames := func() []string {
+		JSONEvents = true
+		defer func() { JSONEvents = false }()
+
+		output := captureOutput(func() {
+			if err := List(file, ""); err != nil {
+				t.Errorf("List failed: %v", err)
+			}
+		})
+		var records []fileRecord
+		if err := json.Unmarshal([]byte(output), &records); err != nil {
+			t.Fatalf("List output is not JSON: %v\n%s", err, output)
+		}
+		var got []string
+		for _, r := range records {
+			got = append(got, r.Name)
+		}
+		return got
+	}
+
+	ListSort = "name"
+	defer func() { ListSort = "" }()
+
+	got := listedNames()
+	expected := []string{"file02.txt", "file1.txt", "file10.txt", "file100.txt", "file2.txt", "file9a.txt", "file9b.txt"}
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("Plain name order:\nexpected %v\ngot      %v", expected, got)
+	}
+
+	NaturalSort = true
+	defer func() { NaturalSort = false }()
+
+	got = listedNames()
+	expected = []string{"file1.txt", "file2.txt", "file02.txt", "file9a.txt", "file9b.txt", "file10.txt", "file100.txt"}
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("Natural name order:\nexpected %v\ngot      %v", expected, got)
+	}
+}
+
+func TestListSort(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	JSONEvents = true
+	defer func() { JSONEvents = false }()
+	defer func() { ListSort = "" }()
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	meta := VerifyMetadataIntegrity(t, file)
+	meta.Files[2] = File{Name: "b.txt", Size: 300, Created: 1000}
+	meta.Files[5] = File{Name: "a.txt", Size: 100, Created: 3000}
+	meta.Files[7] = File{Name: "c.txt", Size: 300, Created: 2000}
+	meta.Files[9] = File{Name: "a.txt", Size: 200, Created: 1000}
+	if err := WriteMeta(file, meta); err != nil {
+		t.Fatalf("WriteMeta failed: %v", err)
+	}
+
+	tests := []struct {
+		sort     string
+		expected []int
+	}{
+		{"", []int{2, 5, 7, 9}},
+		{"name", []int{5, 9, 2, 7}},
+		{"-name", []int{7, 2, 5, 9}},
+		{"size", []int{5, 9, 2, 7}},
+		{"-size", []int{2, 7, 9, 5}},
+		{"created", []int{2, 9, 7, 5}},
+		{"-created", []int{5, 7, 2, 9}},
+	}
+
+	for _, tt := range tests {
+		ListSort = tt.sort
+		output := captureOutput(func() {
+			if err := List(file, ""); err != nil {
+				t.Errorf("List failed: %v", err)
+			}
+		})
+
+		var records []fileRecord
+		if err := json.Unmarshal([]byte(output), &records); err != nil {
+			t.Fatalf("List output is not JSON: %v\n%s", err, output)
+		}
+		var got []int
+		for _, r := range records {
+			got = append(got, r.Index)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+			t.Errorf("--sort %q: expected indices %v, got %v", tt.sort, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Diagnostic levels, from least to most verbose. Results a command was
+// asked for still go to stdout through Print and friends; these are for
+// everything said along the way.
+const (
+	LevelError = iota
+	LevelWarn
+	LevelInfo
+	LevelDebug
+)
+
+var levelNames = []string{"error", "warn", "info", "debug"}
+
+// logOutput receives diagnostics, stderr unless a test swaps it.
+var logOutput io.Writer = os.Stderr
+
+// ParseLevel returns the level called name, as given to --verbosity.
+func ParseLevel(name string) (int, error) {
+	for level, v := range levelNames {
+		if v == name {
+			return level, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown verbosity %q (valid: error, warn, info, debug)", name)
+}
+
+// logf writes one diagnostic line if level is enabled. Silent keeps errors
+// and drops everything else.
+func logf(level int, color string, format string, a ...interface{}) {
+	if level > Verbosity || (Silent && level > LevelError) {
+		return
+	}
+
+	fmt.Fprintf(logOutput, "%s %s\n", C(color, levelNames[level]+":"), fmt.Sprintf(format, a...))
+}
+
+func LogError(format string, a ...interface{}) {
+	logf(LevelError, ColorRed, format, a...)
+}
+
+func LogWarn(format string, a ...interface{}) {
+	logf(LevelWarn, ColorYellow, format, a...)
+}
+
+func LogInfo(format string, a ...interface{}) {
+	logf(LevelInfo, ColorLightBlue, format, a...)
+}
+
+func LogDebug(format string, a ...interface{}) {
+	logf(LevelDebug, ColorDim, format, a...)
+}
+
+// now is the clock progress is timed by, swapped by tests.
+var now = time.Now
+
+// progressLimiter lets long running loops report progress at most once
+// per ProgressInterval instead of on every chunk. The zero value reports
+// on its first call.
+type progressLimiter struct {
+	last time.Time
+}
+
+// due reports whether a progress line should be logged now.
+func (p *progressLimiter) due() bool {
+	t := now()
+	if !p.last.IsZero() && t.Sub(p.last) < ProgressInterval {
+		return false
+	}
+	p.last = t
+	return true
+}
+
+// Fatalf logs an error and exits.
+func Fatalf(format string, a ...interface{}) {
+	LogError(format, a...)
+	Shutdown()
+	os.Exit(1)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogLevels(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	if logOutput != os.Stderr {
+		t.Fatal("Expected diagnostics to go to stderr by default")
+	}
+
+	var buf bytes.Buffer
+	logOutput = &buf
+	defer func() { logOutput = os.Stderr }()
+
+	stdout := captureOutput(func() {
+		LogDebug("debug detail")
+		LogInfo("info detail")
+		LogError("something broke")
+	})
+
+	if stdout != "" {
+		t.Errorf("Expected nothing on stdout, got:\n%s", stdout)
+	}
+	if strings.Contains(buf.String(), "debug detail") {
+		t.Error("Debug line should be suppressed at the default level")
+	}
+	if !strings.Contains(buf.String(), "info detail") {
+		t.Error("Expected info line at the default level")
+	}
+	if !strings.Contains(buf.String(), "something broke") {
+		t.Error("Expected error line on stderr")
+	}
+
+	buf.Reset()
+	Verbosity = LevelDebug
+	defer func() { Verbosity = LevelInfo }()
+	LogDebug("debug detail")
+	if !strings.Contains(buf.String(), "debug detail") {
+		t.Error("Expected debug line with --verbosity debug")
+	}
+
+	buf.Reset()
+	Silent = true
+	defer func() { Silent = false }()
+	LogWarn("a warning")
+	LogError("still an error")
+	if strings.Contains(buf.String(), "a warning") {
+		t.Error("Silent should suppress warnings")
+	}
+	if !strings.Contains(buf.String(), "still an error") {
+		t.Error("Silent should still show errors")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	for want, name := range levelNames {
+		got, err := ParseLevel(name)
+		if err != nil || got != want {
+			t.Errorf("ParseLevel(%q) = %d, %v; want %d", name, got, err, want)
+		}
+	}
+
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Error("Expected an unknown level to fail")
+	}
+}
+
+func TestProgressInterval(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	// Every reading of the fake clock moves it 300ms on.
+	clock := time.Unix(0, 0)
+	now = func() time.Time {
+		clock = clock.Add(300 * time.Millisecond)
+		return clock
+	}
+	defer func() { now = time.Now }()
+
+	var p progressLimiter
+	var emitted []time.Time
+	for range 20 {
+		if p.due() {
+			emitted = append(emitted, clock)
+		}
+	}
+	if len(emitted) != 5 {
+		t.Errorf("Expected 5 updates over 6s at a 1s interval, got %d", len(emitted))
+	}
+	for i := 1; i < len(emitted); i++ {
+		if gap := emitted[i].Sub(emitted[i-1]); gap < ProgressInterval {
+			t.Errorf("Updates %d and %d only %v apart", i-1, i, gap)
+		}
+	}
+
+	var buf bytes.Buffer
+	logOutput = &buf
+	defer func() { logOutput = os.Stderr }()
+
+	file := NewMockFile(10 * ERASE_CHUNK_SIZE)
+	captureOutput(func() {
+		if err := OverwriteDevice(file, 1); err != nil {
+			t.Fatalf("OverwriteDevice failed: %v", err)
+		}
+	})
+	if lines := strings.Count(buf.String(), "written:"); lines != 3 {
+		t.Errorf("Expected 3 progress lines for 10 chunks, got %d:\n%s", lines, buf.String())
+	}
+
+	buf.Reset()
+	ProgressInterval = 0
+	defer func() { ProgressInterval = time.Second }()
+	captureOutput(func() {
+		if err := OverwriteDevice(file, 1); err != nil {
+			t.Fatalf("OverwriteDevice failed: %v", err)
+		}
+	})
+	if lines := strings.Count(buf.String(), "written:"); lines != 10 {
+		t.Errorf("Expected a progress line per chunk with interval 0, got %d", lines)
+	}
+}
@@ -1,22 +1,165 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"log"
 	"os"
+	"runtime"
 	"strconv"
+	"strings"
+	"time"
 )
 
 var device string
 
 func main() {
-	for i, arg := range os.Args {
-		if arg == "--silent" || arg == "-silent" {
-			Silent = true
+	defer Shutdown()
 
-			os.Args = append(os.Args[:i], os.Args[i+1:]...)
-			break
+	Silent = parseFlag("silent")
+	SortByMatches = parseFlag("sort-by-matches")
+	ShredSource = parseFlag("shred-source")
+	JSONLines = parseFlag("jsonl")
+	PrettyJSON = parseFlag("pretty")
+	JSONEvents = parseFlag("json")
+	Echo = parseFlag("echo")
+	NoColor = parseFlag("no-color")
+	Highlight = parseFlag("highlight")
+	ShowSalt = parseFlag("show-salt")
+	StatWear = parseFlag("wear")
+	ConfirmSource = parseFlag("confirm-checksum")
+	NoMetaSync = parseFlag("no-metadata-sync")
+	PreserveOnError = parseFlag("preserve-on-error")
+	VerifyAfterAdd = parseFlag("verify-after-add")
+	OnlyIfChanged = parseFlag("only-if-changed")
+	VerifySource = parseFlag("verify-source")
+	KeepDstSalt = parseFlag("keep-dst-salt")
+	CheckNonces = parseFlag("check-nonces")
+	IfChanged = parseFlag("if-changed")
+	PadMetadata = parseFlag("pad-metadata")
+	SlotNoise = parseFlag("preserve-empty-slot-noise")
+	Hidden = parseFlag("hidden")
+	EraseRandom = parseFlag("random")
+	ConcurrencySafe = parseFlag("concurrency-safe")
+	LongList = parseFlag("long")
+	Force = parseFlag("force")
+	Compress = parseFlag("compress")
+	Discard = parseFlag("discard")
+	HonorSparse = parseFlag("honor-sparse")
+	OutputIndex = parseFlag("output-index")
+	Fuzzy = parseFlag("fuzzy")
+	SearchRegex = parseFlag("regex")
+	RecoverPartialAdd = parseFlag("recover-partial-add")
+	if v, ok := parseFlagValue("verbosity"); ok {
+		level, err := ParseLevel(v)
+		if err != nil {
+			printHelpMenu(err.Error())
+		}
+		Verbosity = level
+	}
+	UsedOnlyCount = parseFlag("used-only-count")
+	IgnoreChecksum = parseFlag("ignore-checksum")
+	NameHash = parseFlag("name-hash")
+	PromptConfirm = parseFlag("prompt-confirm")
+	Checksums = parseFlag("checksum")
+	VerifyInline = parseFlag("verify-inline")
+	if v, ok := parseFlagValue("filter-regex"); ok {
+		FilterRegex = v
+	}
+	if v, ok := parseFlagValue("sort"); ok {
+		switch strings.TrimPrefix(v, "-") {
+		case "name", "size", "created":
+			ListSort = v
+		default:
+			printHelpMenu(fmt.Sprintf("invalid --sort: %s (valid: name, size, created, - prefix for descending)", v))
+		}
+	}
+	NaturalSort = parseFlag("natural")
+	if v, ok := parseFlagValue("on-collision"); ok {
+		switch v {
+		case COLLISION_SKIP, COLLISION_RENAME, COLLISION_OVERWRITE:
+			OnCollision = v
+		default:
+			printHelpMenu(fmt.Sprintf("invalid --on-collision: %s (valid: skip, rename, overwrite)", v))
+		}
+	}
+	if v, ok := parseFlagValue("context"); ok {
+		Context = v
+	}
+	if v, ok := parseFlagValue("type"); ok {
+		TypeFilter = v
+	}
+	if v, ok := parseFlagValue("sha256"); ok {
+		sum, err := hex.DecodeString(v)
+		if err != nil || len(sum) != sha256.Size {
+			printHelpMenu(fmt.Sprintf("invalid --sha256: expected %d hex encoded bytes", sha256.Size))
+		}
+		ExpectedSHA256 = sum
+	}
+	if v, ok := parseFlagValue("salt"); ok {
+		salt, err := hex.DecodeString(v)
+		if err != nil || len(salt) != SALT_SIZE {
+			printHelpMenu(fmt.Sprintf("invalid --salt: expected %d hex encoded bytes", SALT_SIZE))
+		}
+		RecoverySalt = salt
+	}
+
+	threads := 1
+	explicitThreads := 0
+	parallelAdd := parseFlag("parallel-add")
+	inputList, _ := parseFlagValue("input-list")
+	if parseFlag("parallel-verify") {
+		threads = runtime.NumCPU()
+	}
+	if v, ok := parseFlagValue("sample"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			printHelpMenu(fmt.Sprintf("invalid --sample: %s", v))
+		}
+		DoctorSample = n
+	}
+	if v, ok := parseFlagValue("max-runtime"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			printHelpMenu(fmt.Sprintf("invalid --max-runtime: %s", v))
+		}
+		MaxRuntime = d
+	}
+	if v, ok := parseFlagValue("progress-interval"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			printHelpMenu(fmt.Sprintf("invalid --progress-interval: %s", v))
+		}
+		ProgressInterval = d
+	}
+	if v, ok := parseFlagValue("min-free"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > TOTAL_FILES {
+			printHelpMenu(fmt.Sprintf("invalid --min-free: %s", v))
+		}
+		MinFree = n
+	}
+	if v, ok := parseFlagValue("keep-metadata-backup"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			printHelpMenu(fmt.Sprintf("invalid --keep-metadata-backup: %s", v))
+		}
+		KeepMetaBackups = n
+	}
+	if v, ok := parseFlagValue("threads"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			printHelpMenu(fmt.Sprintf("invalid --threads: %s", v))
+		}
+		threads = n
+		explicitThreads = n
+	}
+	if v, ok := parseFlagValue("passes"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			printHelpMenu(fmt.Sprintf("invalid --passes: %s", v))
 		}
+		ErasePasses = n
 	}
 
 	if len(os.Args) < 2 {
@@ -39,41 +182,100 @@ func main() {
 		printHelpMenu("[cmd] missing")
 	}
 
-	file, err := os.OpenFile(device, os.O_RDWR, 0o777)
+	f, err := os.OpenFile(device, os.O_RDWR, 0o777)
 	if err != nil {
-		log.Fatalf("unable to open [device]: %v", err)
+		Fatalf("unable to open [device]: %v", err)
+	}
+	defer f.Close()
+
+	var file F = f
+	if MaxRuntime > 0 {
+		file = newWatchdog(f, MaxRuntime)
+	}
+
+	// A password that only opens the hidden volume switches every command
+	// over to it.
+	switch cmd {
+	case "init", "erase", "dump-header", "import-volume":
+	case "stat":
+		// Geometry needs no password, only the wear counters do.
+		if StatWear {
+			file, _ = OpenVolume(file)
+		}
+	default:
+		file, _ = OpenVolume(file)
+	}
+
+	if RecoverPartialAdd && cmd != "init" && cmd != "erase" {
+		if err := RecoverOrphans(file, os.Stdin); err != nil {
+			Fatalf("Recovery failed: %v", err)
+		}
 	}
-	defer file.Close()
 
 	switch cmd {
 	case "erase":
 		s, err := file.Stat()
 		if err != nil {
-			log.Fatalf("failed to stat device: %v", err)
+			Fatalf("failed to stat device: %v", err)
 		}
 
 		if s.Mode().IsRegular() {
+			// Truncating alone leaves the old blocks on the disk below.
+			if ErasePasses > 1 || EraseRandom {
+				if err := Overwrite(file, 0, uint64(s.Size()), ErasePasses); err != nil {
+					Fatalf("Erase failed: %v", err)
+				}
+			}
+			// A random file keeps its size, so it looks like a volume.
+			if EraseRandom {
+				PrintSuccess("File overwritten with random data")
+				break
+			}
 			if err := file.Truncate(0); err != nil {
-				log.Fatalf("Erase failed: %v", err)
+				Fatalf("Erase failed: %v", err)
 			}
 			PrintSuccess("File truncated successfully")
+		} else if size, _ := DeviceSize(file); explicitThreads > 1 && size > 0 {
+			if err := OverwriteParallel(file, 0, uint64(size), explicitThreads, ErasePasses); err != nil {
+				Fatalf("Erase failed: %v", err)
+			}
+			PrintSuccess(fmt.Sprintf("Device overwrite complete: %s",
+				C(ColorWhite, fmt.Sprintf("%d MB", size/1_000_000))))
 		} else {
-			if err := OverwriteDevice(file); err != nil {
-				log.Fatalf("Erase failed: %v", err)
+			if err := OverwriteDevice(file, ErasePasses); err != nil {
+				Fatalf("Erase failed: %v", err)
 			}
 		}
 	case "init":
+		PromptConfirm = true
+		if Hidden {
+			outerPass, err := passwordPrompt("Enter outer password: ")
+			if err != nil {
+				Fatalf("Initialization failed: %v", err)
+			}
+			if err := InitHidden(file, outerPass); err != nil {
+				Fatalf("Initialization failed: %v", err)
+			}
+			PrintSuccess(fmt.Sprintf("Hidden volume initialized (%d files)", hiddenFiles))
+			break
+		}
 		mode := "device"
 		if len(os.Args) > 3 {
 			mode = os.Args[3]
 		}
 		if err := InitMeta(file, mode); err != nil {
-			log.Fatalf("Initialization failed: %v", err)
+			Fatalf("Initialization failed: %v", err)
 		}
 		PrintSuccess("Filesystem initialized successfully")
 	case "add":
 		var index int
 		var path string
+		if inputList != "" {
+			if err := ImportList(file, inputList); err != nil {
+				Fatalf("Add failed: %v", err)
+			}
+			break
+		}
 		if len(os.Args) < 4 {
 			printHelpMenu("not enough parameters")
 		}
@@ -81,9 +283,27 @@ func main() {
 		if path == "" {
 			printHelpMenu("missing [path]")
 		}
+		// "-" reads the content from stdin and takes the name from
+		// os.Args[4], the index is then optional in os.Args[5].
+		if path == "-" {
+			if len(os.Args) < 5 {
+				printHelpMenu("add - needs a [name]")
+			}
+			index = OUT_OF_BOUNDS_INDEX
+			if len(os.Args) > 5 {
+				index, err = ResolveIndex(file, os.Args[5])
+				if err != nil {
+					printHelpMenu(fmt.Sprintf("invalid [index]: %s", err))
+				}
+			}
+			if err := AddReader(file, os.Stdin, os.Args[4], index); err != nil {
+				Fatalf("Add failed: %v", err)
+			}
+			break
+		}
 		// Index is optional (os.Args[4])
 		if len(os.Args) > 4 {
-			index, err = strconv.Atoi(os.Args[4])
+			index, err = ResolveIndex(file, os.Args[4])
 			if err != nil {
 				printHelpMenu(fmt.Sprintf("invalid [index]: %s", err))
 			}
@@ -91,28 +311,144 @@ func main() {
 			index = OUT_OF_BOUNDS_INDEX
 		}
 		if err := Add(file, path, index); err != nil {
-			log.Fatalf("Add failed: %v", err)
+			Fatalf("Add failed: %v", err)
+		}
+	case "add-dir":
+		if len(os.Args) < 4 {
+			printHelpMenu("not enough parameters")
+		}
+		if parallelAdd {
+			workers := explicitThreads
+			if workers == 0 {
+				workers = runtime.NumCPU()
+			}
+			if err := AddDirParallel(file, os.Args[3], workers); err != nil {
+				Fatalf("Add failed: %v", err)
+			}
+		} else if err := AddDir(file, os.Args[3]); err != nil {
+			Fatalf("Add failed: %v", err)
+		}
+	case "import":
+		if inputList != "" {
+			if err := ImportList(file, inputList); err != nil {
+				Fatalf("Import failed: %v", err)
+			}
+			break
+		}
+		if len(os.Args) < 4 {
+			printHelpMenu("not enough parameters")
+		}
+		if err := ImportArchive(file, os.Args[3]); err != nil {
+			Fatalf("Import failed: %v", err)
+		}
+	case "export":
+		if len(os.Args) < 4 {
+			printHelpMenu("not enough parameters")
+		}
+		out := os.Stdout
+		if os.Args[3] != "-" {
+			f, err := os.Create(os.Args[3])
+			if err != nil {
+				Fatalf("Export failed: %v", err)
+			}
+			defer f.Close()
+			out = f
+		}
+		if err := ExportTar(file, out); err != nil {
+			Fatalf("Export failed: %v", err)
+		}
+	case "export-volume":
+		if len(os.Args) < 4 {
+			printHelpMenu("not enough parameters")
+		}
+		out := os.Stdout
+		if os.Args[3] != "-" {
+			f, err := os.Create(os.Args[3])
+			if err != nil {
+				Fatalf("Export failed: %v", err)
+			}
+			defer f.Close()
+			out = f
+		}
+		if err := Export(file, out); err != nil {
+			Fatalf("Export failed: %v", err)
+		}
+	case "import-volume":
+		if len(os.Args) < 4 {
+			printHelpMenu("not enough parameters")
+		}
+		in, err := openArchivePath(os.Args[3])
+		if err != nil {
+			Fatalf("Import failed: %v", err)
+		}
+		defer in.Close()
+		if err := Import(file, in); err != nil {
+			Fatalf("Import failed: %v", err)
 		}
 	case "get":
 		var path string
 		if len(os.Args) < 5 {
 			printHelpMenu("not enough parameters")
 		}
-		index, err := strconv.Atoi(os.Args[3])
+		index, err := ResolveIndex(file, os.Args[3])
 		if err != nil {
 			printHelpMenu(fmt.Sprintf("invalid [index]: %s", err))
 		}
 		path = os.Args[4]
 		if err := Get(file, index, path); err != nil {
-			log.Fatalf("Get failed: %v", err)
+			Fatalf("Get failed: %v", err)
 		}
 	case "del":
-		index, err := strconv.Atoi(os.Args[3])
+		if len(os.Args) < 4 {
+			printHelpMenu("not enough parameters")
+		}
+		freed, err := Del(file, os.Args[3])
+		if err != nil {
+			Fatalf("Delete failed: %v", err)
+		}
+		for _, index := range freed {
+			PrintSuccess(fmt.Sprintf("Successfully deleted file at index %d", index))
+		}
+	case "rename":
+		if len(os.Args) < 5 {
+			printHelpMenu("not enough parameters")
+		}
+		index, err := ResolveIndex(file, os.Args[3])
 		if err != nil {
 			printHelpMenu(fmt.Sprintf("invalid [index]: %s", err))
 		}
-		if err := Del(file, index); err != nil {
-			log.Fatalf("Delete failed: %v", err)
+		if err := Rename(file, index, os.Args[4]); err != nil {
+			Fatalf("Rename failed: %v", err)
+		}
+	case "alias":
+		if len(os.Args) < 4 {
+			printHelpMenu("not enough parameters")
+		}
+		switch os.Args[3] {
+		case "set":
+			if len(os.Args) < 6 {
+				printHelpMenu("not enough parameters")
+			}
+			index, err := ResolveIndex(file, os.Args[5])
+			if err != nil {
+				printHelpMenu(fmt.Sprintf("invalid [index]: %s", err))
+			}
+			if err := SetAlias(file, os.Args[4], index); err != nil {
+				Fatalf("Alias failed: %v", err)
+			}
+		case "rm":
+			if len(os.Args) < 5 {
+				printHelpMenu("not enough parameters")
+			}
+			if err := RemoveAlias(file, os.Args[4]); err != nil {
+				Fatalf("Alias failed: %v", err)
+			}
+		case "list":
+			if err := ListAliases(file); err != nil {
+				Fatalf("Alias failed: %v", err)
+			}
+		default:
+			printHelpMenu(fmt.Sprintf("unknown alias command: %s", os.Args[3]))
 		}
 	case "list":
 		filter := ""
@@ -120,11 +456,11 @@ func main() {
 			filter = os.Args[3]
 		}
 		if err := List(file, filter); err != nil {
-			log.Fatalf("List failed: %v", err)
+			Fatalf("List failed: %v", err)
 		}
 	case "stat":
 		if err := Stat(file); err != nil {
-			log.Fatalf("Stat failed: %v", err)
+			Fatalf("Stat failed: %v", err)
 		}
 	case "sync":
 
@@ -137,15 +473,34 @@ func main() {
 			return
 		}
 
-		dst, err := os.OpenFile(os.Args[3], os.O_RDWR, 0o777)
+		target, err := os.OpenFile(os.Args[3], os.O_RDWR, 0o777)
 		if err != nil {
-			log.Fatalf("unable to open [target_device]: %v", err)
+			Fatalf("unable to open [target_device]: %v", err)
+		}
+		defer target.Close()
+
+		var dst F = target
+		if MaxRuntime > 0 {
+			dst = newWatchdog(target, MaxRuntime)
 		}
-		defer dst.Close()
 
 		if err := Sync(file, dst); err != nil {
-			log.Fatalf("Sync failed: %v", err)
+			Fatalf("Sync failed: %v", err)
+		}
+		if NoMetaSync {
+			if err := FlushMeta(dst); err != nil {
+				Fatalf("Sync failed: %v", err)
+			}
+		}
+	case "find":
+		if len(os.Args) < 4 {
+			printHelpMenu("not enough parameters")
 		}
+		index, err := FindByName(file, os.Args[3])
+		if err != nil {
+			Fatalf("Find failed: %v", err)
+		}
+		fmt.Println(index)
 	case "search-name":
 		if len(os.Args) < 4 {
 			printHelpMenu("not enough parameters")
@@ -155,7 +510,7 @@ func main() {
 			printHelpMenu("missing [phrase]")
 		}
 		if err := SearchName(file, phrase); err != nil {
-			log.Fatalf("Name search failed: %v", err)
+			Fatalf("Name search failed: %v", err)
 		}
 	case "search":
 		if len(os.Args) < 4 {
@@ -167,17 +522,122 @@ func main() {
 		}
 		index := OUT_OF_BOUNDS_INDEX
 		if len(os.Args) > 4 {
-			index, err = strconv.Atoi(os.Args[4])
+			index, err = ResolveIndex(file, os.Args[4])
 			if err != nil {
 				printHelpMenu(fmt.Sprintf("invalid [index]: %s", err))
 			}
 		}
 		if err := SearchContent(file, phrase, index); err != nil {
-			log.Fatalf("Content search failed: %v", err)
+			Fatalf("Content search failed: %v", err)
+		}
+	case "verify":
+		if err := Verify(file, threads); err != nil {
+			Fatalf("Verify failed: %v", err)
+		}
+	case "shell":
+		if err := Shell(file, os.Stdin, os.Stdout); err != nil {
+			Fatalf("Shell failed: %v", err)
+		}
+	case "batch":
+		if err := Batch(file, os.Stdin, os.Stdout); err != nil {
+			Fatalf("Batch failed: %v", err)
+		}
+	case "dump-meta":
+		if err := DumpMeta(file); err != nil {
+			Fatalf("Dump failed: %v", err)
+		}
+	case "dump-header":
+		if err := DumpHeader(file); err != nil {
+			Fatalf("Dump failed: %v", err)
+		}
+	case "dump-block":
+		if len(os.Args) < 4 {
+			printHelpMenu("not enough parameters")
+		}
+		index, err := ResolveIndex(file, os.Args[3])
+		if err != nil {
+			printHelpMenu(fmt.Sprintf("invalid [index]: %s", err))
+		}
+		if err := DumpBlock(file, index); err != nil {
+			Fatalf("Dump failed: %v", err)
+		}
+	case "benchmark":
+		count := 10
+		if len(os.Args) > 3 {
+			count, err = strconv.Atoi(os.Args[3])
+			if err != nil || count < 1 {
+				printHelpMenu(fmt.Sprintf("invalid [count]: %s", os.Args[3]))
+			}
+		}
+		if _, err := Benchmark(file, count); err != nil {
+			Fatalf("Benchmark failed: %v", err)
+		}
+	case "reindex":
+		if err := Reindex(file); err != nil {
+			Fatalf("Reindex failed: %v", err)
+		}
+	case "trim":
+		if err := Trim(file); err != nil {
+			Fatalf("Trim failed: %v", err)
+		}
+	case "defrag":
+		if err := Defrag(file); err != nil {
+			Fatalf("Defrag failed: %v", err)
+		}
+	case "rollback":
+		if err := Rollback(file); err != nil {
+			Fatalf("Rollback failed: %v", err)
+		}
+	case "doctor":
+		if err := Doctor(file); err != nil {
+			Fatalf("Doctor found problems: %v", err)
+		}
+	case "passwd":
+		oldPass, err := GetEncKey()
+		if err != nil {
+			Fatalf("Password change failed: %v", err)
+		}
+		newPass, err := PromptChangedPassword()
+		if err != nil {
+			Fatalf("Password change failed: %v", err)
+		}
+		if err := ChangePassword(file, oldPass, newPass); err != nil {
+			Fatalf("Password change failed: %v", err)
 		}
 	default:
 		printHelpMenu("unknown [cmd]")
 	}
+
+	if NoMetaSync {
+		if err := FlushMeta(file); err != nil {
+			Fatalf("Flush failed: %v", err)
+		}
+	}
+}
+
+// parseFlag reports whether --name (or -name) was passed and removes it
+// from os.Args so positional parameters keep their place.
+func parseFlag(name string) bool {
+	for i, arg := range os.Args {
+		if arg == "--"+name || arg == "-"+name {
+			os.Args = append(os.Args[:i], os.Args[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// parseFlagValue returns the value following --name (or -name) and
+// removes both from os.Args.
+func parseFlagValue(name string) (string, bool) {
+	for i, arg := range os.Args {
+		if (arg == "--"+name || arg == "-"+name) && i+1 < len(os.Args) {
+			value := os.Args[i+1]
+			os.Args = append(os.Args[:i], os.Args[i+2:]...)
+			return value, true
+		}
+	}
+	return "", false
 }
 
 func printHelpMenu(msg string) {
@@ -212,9 +672,184 @@ func printHelpMenu(msg string) {
 	// Flags
 	fmt.Printf("%s\n", C(ColorBold+ColorLightBlue, "FLAGS"))
 	PrintSeparator(60)
-	fmt.Printf(" %s  %s\n\n",
-		C(ColorWhite, "--silent"),
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--silent")),
 		C(ColorDim, "Suppress informational output"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--verbosity [lvl]")),
+		C(ColorDim, "Diagnostics on stderr: error, warn, info (default) or debug"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--sort-by-matches")),
+		C(ColorDim, "Order content search results by match count"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--fuzzy")),
+		C(ColorDim, "Rank search-name results by edit distance, closest first"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--regex")),
+		C(ColorDim, "Match search and search-name phrases as regular expressions"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--shred-source")),
+		C(ColorDim, "Shred the source file after a verified add"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--jsonl")),
+		C(ColorDim, "One JSON object per line for dump-meta and verify"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--pretty")),
+		C(ColorDim, "Indent the dump-meta JSON document"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--json")),
+		C(ColorDim, "JSON from list, stat and searches, one result per command in shell"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--no-color")),
+		C(ColorDim, "Print without color escape codes"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--highlight")),
+		C(ColorDim, "Mark where the list filter matched in each name"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--echo")),
+		C(ColorDim, "Print each shell and batch command before its output"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--show-salt")),
+		C(ColorDim, "Print the salt as hex in stat and dump-header"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--wear")),
+		C(ColorDim, "Make stat ask for the password and show wear statistics"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--confirm-checksum")),
+		C(ColorDim, "Fail add if the source changes while being read"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--salt [hex]")),
+		C(ColorDim, "Salt to use for reindex if the header is lost"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--sha256 [hex]")),
+		C(ColorDim, "Make get fail unless the file has this SHA256"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--no-metadata-sync")),
+		C(ColorDim, "Sync metadata once at the end instead of per write"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--preserve-on-error")),
+		C(ColorDim, "Restore the old file if an overwriting add fails"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--verify-after-add")),
+		C(ColorDim, "Read back and decrypt each added file, rolling back on mismatch"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--only-if-changed")),
+		C(ColorDim, "Skip sync when both volumes already match"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--verify-source")),
+		C(ColorDim, "Refuse to sync unless every source file decrypts"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--keep-dst-salt")),
+		C(ColorDim, "Re-encrypt on sync for the destination's own salt"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--check-nonces")),
+		C(ColorDim, "Make verify report nonces shared by several blocks"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--if-changed")),
+		C(ColorDim, "Skip add when the same path was added with the same content"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--pad-metadata")),
+		C(ColorDim, "Keep the metadata length constant (use with init)"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--preserve-empty-slot-noise")),
+		C(ColorDim, "Fill free slots with random data instead of zeros (use with init)"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--hidden")),
+		C(ColorDim, "Make init create a hidden volume inside a noise volume"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--long")),
+		C(ColorDim, "Show the detected content type in list"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--used-only-count")),
+		C(ColorDim, "Make list print only the number of used slots"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--type [prefix]")),
+		C(ColorDim, "List only files whose content type starts with prefix"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--filter-regex [re]")),
+		C(ColorDim, "List only files whose name matches the regular expression"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--sort [key]")),
+		C(ColorDim, "List by name, size or created instead of slot, -key descending"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--natural")),
+		C(ColorDim, "Sort names with numbers by value, file2 before file10"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--checksum")),
+		C(ColorDim, "Record SHA256 on add and show it in list"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--verify-inline")),
+		C(ColorDim, "Decrypt each file in list and flag corrupt ones"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--name-hash")),
+		C(ColorDim, "Store a hash instead of the name on add, see find"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--prompt-confirm")),
+		C(ColorDim, "Ask for the password twice (always on for init)"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--ignore-checksum")),
+		C(ColorDim, "Read metadata despite a checksum mismatch (read-only)"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--keep-metadata-backup [n]")),
+		C(ColorDim, "Keep n previous metadata versions for rollback"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--threads [n]")),
+		C(ColorDim, "Number of workers used by verify and device erase"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--passes [n]")),
+		C(ColorDim, "Overwrite n times on erase, ending with zeros (default 1)"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--random")),
+		C(ColorDim, "Make erase write random data instead of zeros"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--concurrency-safe")),
+		C(ColorDim, "Cache metadata, reloading it when another process writes"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--sample [n]")),
+		C(ColorDim, "Decrypt only n evenly spread files in doctor"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--input-list [file]")),
+		C(ColorDim, "Make add and import add every path listed in file"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--recover-partial-add")),
+		C(ColorDim, "Register or zero data left in free slots by an interrupted add"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--compress")),
+		C(ColorDim, "Gzip files before encrypting them on add"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--discard")),
+		C(ColorDim, "Make trim also discard the zeroed slots on SSDs"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--on-collision [p]")),
+		C(ColorDim, "Duplicate names in export: skip, rename (default), overwrite"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--context [s]")),
+		C(ColorDim, "Bind added files to s; get needs the same s"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--honor-sparse")),
+		C(ColorDim, "Make get leave zero runs as holes in the output file"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--output-index")),
+		C(ColorDim, "Make add print only the index the file was stored at"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--max-runtime [d]")),
+		C(ColorDim, "Abort with a timeout if a device read or write blocks longer than d (e.g. 30s)"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--progress-interval [d]")),
+		C(ColorDim, "Least time between erase progress lines (default 1s, 0 for every chunk)"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--min-free [n]")),
+		C(ColorDim, "Refuse adds that would leave fewer than n free slots"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--force")),
+		C(ColorDim, "Add into the slots reserved by --min-free"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--parallel-add")),
+		C(ColorDim, "Encrypt add-dir files concurrently, one metadata write"))
+	fmt.Printf(" %s  %s\n",
+		C(ColorWhite, fmt.Sprintf("%-20s", "--parallel-verify")),
+		C(ColorDim, "Verify with one worker per CPU"))
+	fmt.Println()
 
 	// Commands
 	fmt.Printf("%s\n", C(ColorBold+ColorLightBlue, "COMMANDS"))
@@ -238,6 +873,31 @@ func printHelpMenu(msg string) {
 		C(ColorWhite, "add"),
 		C(ColorBrightBlue, "[path]"),
 		C(ColorDim, "[index]"))
+	fmt.Printf("   %s %s %s %s %s %s\n\n",
+		C(ColorWhite, "./hdnfs"),
+		C(ColorBrightBlue, "[device]"),
+		C(ColorWhite, "add"),
+		C(ColorWhite, "-"),
+		C(ColorBrightBlue, "[name]"),
+		C(ColorDim, "[index]"))
+
+	// Add Dir
+	fmt.Printf(" %s\n", C(ColorBold+ColorWhite, "add-dir"))
+	fmt.Printf("   %s\n", C(ColorDim, "Add every regular file in a directory"))
+	fmt.Printf("   %s %s %s %s\n\n",
+		C(ColorWhite, "./hdnfs"),
+		C(ColorBrightBlue, "[device]"),
+		C(ColorWhite, "add-dir"),
+		C(ColorBrightBlue, "[dir]"))
+
+	// Import
+	fmt.Printf(" %s\n", C(ColorBold+ColorWhite, "import"))
+	fmt.Printf("   %s\n", C(ColorDim, "Add the files in a tar, tar.gz or zip archive without extracting"))
+	fmt.Printf("   %s %s %s %s\n\n",
+		C(ColorWhite, "./hdnfs"),
+		C(ColorBrightBlue, "[device]"),
+		C(ColorWhite, "import"),
+		C(ColorBrightBlue, "[archive]"))
 
 	// List
 	fmt.Printf(" %s\n", C(ColorBold+ColorWhite, "list"))
@@ -256,16 +916,71 @@ func printHelpMenu(msg string) {
 		C(ColorBrightBlue, "[device]"),
 		C(ColorWhite, "get"),
 		C(ColorBrightBlue, "[index]"),
-		C(ColorBrightBlue, "[output_path]"))
+		C(ColorBrightBlue, "[output_path|-]"))
+
+	// Export
+	fmt.Printf(" %s\n", C(ColorBold+ColorWhite, "export"))
+	fmt.Printf("   %s\n", C(ColorDim, "Decrypt every file into a tar archive, use - for stdout"))
+	fmt.Printf("   %s %s %s %s\n\n",
+		C(ColorWhite, "./hdnfs"),
+		C(ColorBrightBlue, "[device]"),
+		C(ColorWhite, "export"),
+		C(ColorBrightBlue, "[output.tar]"))
+
+	// Export Volume
+	fmt.Printf(" %s\n", C(ColorBold+ColorWhite, "export-volume"))
+	fmt.Printf("   %s\n", C(ColorDim, "Write the volume, still encrypted, to a compact archive, use - for stdout"))
+	fmt.Printf("   %s %s %s %s\n\n",
+		C(ColorWhite, "./hdnfs"),
+		C(ColorBrightBlue, "[device]"),
+		C(ColorWhite, "export-volume"),
+		C(ColorBrightBlue, "[output|-]"))
+
+	// Import Volume
+	fmt.Printf(" %s\n", C(ColorBold+ColorWhite, "import-volume"))
+	fmt.Printf("   %s\n", C(ColorDim, "Restore a volume archive onto an erased device, use - for stdin"))
+	fmt.Printf("   %s %s %s %s\n\n",
+		C(ColorWhite, "./hdnfs"),
+		C(ColorBrightBlue, "[device]"),
+		C(ColorWhite, "import-volume"),
+		C(ColorBrightBlue, "[archive|-]"))
+
+	// Alias
+	fmt.Printf(" %s\n", C(ColorBold+ColorWhite, "alias"))
+	fmt.Printf("   %s\n", C(ColorDim, "Name a slot; use @name wherever an index is expected"))
+	fmt.Printf("   %s %s %s %s\n\n",
+		C(ColorWhite, "./hdnfs"),
+		C(ColorBrightBlue, "[device]"),
+		C(ColorWhite, "alias"),
+		C(ColorBrightBlue, "set [name] [index] | rm [name] | list"))
 
 	// Delete
 	fmt.Printf(" %s\n", C(ColorBold+ColorWhite, "del"))
-	fmt.Printf("   %s\n", C(ColorDim, "Delete a file and zero its slot"))
+	fmt.Printf("   %s\n", C(ColorDim, "Delete a file by index, @alias or name; the slot is cleared unless metadata backups are kept"))
 	fmt.Printf("   %s %s %s %s\n\n",
 		C(ColorWhite, "./hdnfs"),
 		C(ColorBrightBlue, "[device]"),
 		C(ColorWhite, "del"),
-		C(ColorBrightBlue, "[index]"))
+		C(ColorBrightBlue, "[index|name]"))
+
+	// Rename
+	fmt.Printf(" %s\n", C(ColorBold+ColorWhite, "rename"))
+	fmt.Printf("   %s\n", C(ColorDim, "Change a file's name without rewriting its data"))
+	fmt.Printf("   %s %s %s %s %s\n\n",
+		C(ColorWhite, "./hdnfs"),
+		C(ColorBrightBlue, "[device]"),
+		C(ColorWhite, "rename"),
+		C(ColorBrightBlue, "[index]"),
+		C(ColorBrightBlue, "[newname]"))
+
+	// Find
+	fmt.Printf(" %s\n", C(ColorBold+ColorWhite, "find"))
+	fmt.Printf("   %s\n", C(ColorDim, "Print the index of the file with exactly this name"))
+	fmt.Printf("   %s %s %s %s\n\n",
+		C(ColorWhite, "./hdnfs"),
+		C(ColorBrightBlue, "[device]"),
+		C(ColorWhite, "find"),
+		C(ColorBrightBlue, "[name]"))
 
 	// Search Name
 	fmt.Printf(" %s\n", C(ColorBold+ColorWhite, "search-name"))
@@ -288,7 +1003,7 @@ func printHelpMenu(msg string) {
 
 	// Stat
 	fmt.Printf(" %s\n", C(ColorBold+ColorWhite, "stat"))
-	fmt.Printf("   %s\n", C(ColorDim, "Show device statistics"))
+	fmt.Printf("   %s\n", C(ColorDim, "Show device statistics; with --wear also the wear counters, which need the password"))
 	fmt.Printf("   %s %s %s\n\n",
 		C(ColorWhite, "./hdnfs"),
 		C(ColorBrightBlue, "[device]"),
@@ -303,6 +1018,113 @@ func printHelpMenu(msg string) {
 		C(ColorWhite, "sync"),
 		C(ColorBrightBlue, "[target_device]"))
 
+	// Verify
+	fmt.Printf(" %s\n", C(ColorBold+ColorWhite, "verify"))
+	fmt.Printf("   %s\n", C(ColorDim, "Check that every stored file decrypts"))
+	fmt.Printf("   %s %s %s\n\n",
+		C(ColorWhite, "./hdnfs"),
+		C(ColorBrightBlue, "[device]"),
+		C(ColorWhite, "verify"))
+
+	// Dump Meta
+	fmt.Printf(" %s\n", C(ColorBold+ColorWhite, "dump-meta"))
+	fmt.Printf("   %s\n", C(ColorDim, "Print the decrypted metadata as JSON"))
+	fmt.Printf("   %s %s %s\n\n",
+		C(ColorWhite, "./hdnfs"),
+		C(ColorBrightBlue, "[device]"),
+		C(ColorWhite, "dump-meta"))
+
+	// Shell
+	fmt.Printf(" %s\n", C(ColorBold+ColorWhite, "shell"))
+	fmt.Printf("   %s\n", C(ColorDim, "Run list, add, get, del, search and open from stdin with one password prompt"))
+	fmt.Printf("   %s %s %s\n\n",
+		C(ColorWhite, "./hdnfs"),
+		C(ColorBrightBlue, "[device]"),
+		C(ColorWhite, "shell"))
+
+	// Batch
+	fmt.Printf(" %s\n", C(ColorBold+ColorWhite, "batch"))
+	fmt.Printf("   %s\n", C(ColorDim, "Run a script of shell commands from stdin, stopping at the first error"))
+	fmt.Printf("   %s %s %s %s\n\n",
+		C(ColorWhite, "./hdnfs"),
+		C(ColorBrightBlue, "[device]"),
+		C(ColorWhite, "batch"),
+		C(ColorBrightBlue, "< script"))
+
+	// Dump Header
+	fmt.Printf(" %s\n", C(ColorBold+ColorWhite, "dump-header"))
+	fmt.Printf("   %s\n", C(ColorDim, "Print the plaintext metadata header (no password)"))
+	fmt.Printf("   %s %s %s\n\n",
+		C(ColorWhite, "./hdnfs"),
+		C(ColorBrightBlue, "[device]"),
+		C(ColorWhite, "dump-header"))
+
+	// Dump Block
+	fmt.Printf(" %s\n", C(ColorBold+ColorWhite, "dump-block"))
+	fmt.Printf("   %s\n", C(ColorDim, "Show a slot's nonce, recorded size and padding without decrypting"))
+	fmt.Printf("   %s %s %s %s\n\n",
+		C(ColorWhite, "./hdnfs"),
+		C(ColorBrightBlue, "[device]"),
+		C(ColorWhite, "dump-block"),
+		C(ColorBrightBlue, "[index]"))
+
+	// Benchmark
+	fmt.Printf(" %s\n", C(ColorBold+ColorWhite, "benchmark"))
+	fmt.Printf("   %s\n", C(ColorDim, "Time add, get and sync in free slots (default count: 10)"))
+	fmt.Printf("   %s %s %s %s\n\n",
+		C(ColorWhite, "./hdnfs"),
+		C(ColorBrightBlue, "[device]"),
+		C(ColorWhite, "benchmark"),
+		C(ColorDim, "[count]"))
+
+	// Trim
+	fmt.Printf(" %s\n", C(ColorBold+ColorWhite, "trim"))
+	fmt.Printf("   %s\n", C(ColorDim, "Zero free slots that still hold old data"))
+	fmt.Printf("   %s %s %s\n\n",
+		C(ColorWhite, "./hdnfs"),
+		C(ColorBrightBlue, "[device]"),
+		C(ColorWhite, "trim"))
+
+	// Defrag
+	fmt.Printf(" %s\n", C(ColorBold+ColorWhite, "defrag"))
+	fmt.Printf("   %s\n", C(ColorDim, "Move files down into free slots so they are packed from index 0"))
+	fmt.Printf("   %s %s %s\n\n",
+		C(ColorWhite, "./hdnfs"),
+		C(ColorBrightBlue, "[device]"),
+		C(ColorWhite, "defrag"))
+
+	// Reindex
+	fmt.Printf(" %s\n", C(ColorBold+ColorWhite, "reindex"))
+	fmt.Printf("   %s\n", C(ColorDim, "Rebuild metadata from the data slots after metadata loss"))
+	fmt.Printf("   %s %s %s\n\n",
+		C(ColorWhite, "./hdnfs"),
+		C(ColorBrightBlue, "[device]"),
+		C(ColorWhite, "reindex"))
+
+	// Rollback
+	fmt.Printf(" %s\n", C(ColorBold+ColorWhite, "rollback"))
+	fmt.Printf("   %s\n", C(ColorDim, "Restore the previous metadata version (needs --keep-metadata-backup)"))
+	fmt.Printf("   %s %s %s\n\n",
+		C(ColorWhite, "./hdnfs"),
+		C(ColorBrightBlue, "[device]"),
+		C(ColorWhite, "rollback"))
+
+	// Doctor
+	fmt.Printf(" %s\n", C(ColorBold+ColorWhite, "doctor"))
+	fmt.Printf("   %s\n", C(ColorDim, "Run health checks and suggest fixes"))
+	fmt.Printf("   %s %s %s\n\n",
+		C(ColorWhite, "./hdnfs"),
+		C(ColorBrightBlue, "[device]"),
+		C(ColorWhite, "doctor"))
+
+	// Passwd
+	fmt.Printf(" %s\n", C(ColorBold+ColorWhite, "passwd"))
+	fmt.Printf("   %s\n", C(ColorDim, "Re-encrypt everything under a new password"))
+	fmt.Printf("   %s %s %s\n\n",
+		C(ColorWhite, "./hdnfs"),
+		C(ColorBrightBlue, "[device]"),
+		C(ColorWhite, "passwd"))
+
 	// Erase
 	fmt.Printf(" %s\n", C(ColorBold+ColorWhite, "erase"))
 	fmt.Printf("   %s\n", C(ColorDim, "Erase all data (truncate file or overwrite device)"))
@@ -328,8 +1150,9 @@ func printHelpMenu(msg string) {
 
 	PrintSeparator(60)
 	fmt.Printf("\n%s %s\n\n",
-		C(ColorBold+ColorLightBlue, "Environment:"),
-		C(ColorWhite, "Set HDNFS variable with your encryption password"))
+		C(ColorBold+ColorLightBlue, "Password:"),
+		C(ColorWhite, "Prompted once per command and cached in memory"))
 
+	Shutdown()
 	os.Exit(1)
 }
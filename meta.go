@@ -6,9 +6,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
 )
 
 func WriteMeta(file F, m *Meta) error {
+	if IgnoreChecksum {
+		return errors.New("refusing to write metadata with --ignore-checksum, it is for read-only recovery")
+	}
+
 	password, err := GetEncKey()
 	if err != nil {
 		return fmt.Errorf("failed to get encryption key: %w", err)
@@ -22,14 +27,35 @@ func WriteMeta(file F, m *Meta) error {
 		m.Salt = salt
 	}
 
+	// Backups are written past the data slots, so a device needs room for
+	// the ring before they are turned on.
+	if KeepMetaBackups > m.Backups {
+		if err := checkBackupRoom(file, KeepMetaBackups); err != nil {
+			return err
+		}
+	}
+
 	m.Version = METADATA_VERSION
+	m.Wear.MetaWrites++
+	m.Wear.BytesWritten += META_FILE_SIZE
+
+	if PadMetadata {
+		m.Padded = true
+	}
+	if KeepMetaBackups > 0 {
+		m.Backups = KeepMetaBackups
+	}
 
 	metaJSON, err := json.Marshal(m)
 	if err != nil {
 		return fmt.Errorf("failed to marshal metadata: %w", err)
 	}
 
-	encrypted, err := EncryptGCM(metaJSON, password, m.Salt)
+	if m.Padded {
+		metaJSON = padMetaJSON(metaJSON)
+	}
+
+	encrypted, err := EncryptGCM(metaJSON, password, m.Salt, nil)
 	if err != nil {
 		return fmt.Errorf("failed to encrypt metadata: %w", err)
 	}
@@ -63,19 +89,35 @@ func WriteMeta(file F, m *Meta) error {
 		return fmt.Errorf("internal error: metadata block size mismatch: %d != %d", len(metaBlock), META_FILE_SIZE)
 	}
 
+	if m.Backups > 0 {
+		if err := backupMeta(file, int((m.Wear.MetaWrites-1)%uint64(m.Backups))); err != nil {
+			return err
+		}
+	}
+
 	if _, err := file.Seek(0, 0); err != nil {
 		return fmt.Errorf("failed to seek to metadata position: %w", err)
 	}
 
-	n, err := file.Write(metaBlock)
-	if err != nil {
+	forgetMeta(file)
+	if err := writeFull(file, metaBlock); err != nil {
 		return fmt.Errorf("failed to write metadata: %w", err)
 	}
+	LogDebug("wrote metadata version %d", m.Wear.MetaWrites)
 
-	if n != META_FILE_SIZE {
-		return fmt.Errorf("short write: wrote %d bytes, expected %d", n, META_FILE_SIZE)
+	if NoMetaSync {
+		return nil
+	}
+
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync metadata: %w", err)
 	}
 
+	return nil
+}
+
+// FlushMeta syncs metadata written while NoMetaSync was set.
+func FlushMeta(file F) error {
 	if err := file.Sync(); err != nil {
 		return fmt.Errorf("failed to sync metadata: %w", err)
 	}
@@ -83,7 +125,24 @@ func WriteMeta(file F, m *Meta) error {
 	return nil
 }
 
+// ReadMeta reads and decrypts the metadata of file. With ConcurrencySafe
+// the result is cached and only the header is read again, see
+// readMetaCached.
 func ReadMeta(file F) (*Meta, error) {
+	if ConcurrencySafe {
+		return readMetaCached(file)
+	}
+
+	metaBlock, err := readMetaBlock(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeMetaBlock(metaBlock)
+}
+
+// readMetaBlock reads the raw metadata block of file.
+func readMetaBlock(file F) ([]byte, error) {
 	metaBlock := make([]byte, META_FILE_SIZE)
 
 	if _, err := file.Seek(0, 0); err != nil {
@@ -99,14 +158,181 @@ func ReadMeta(file F) (*Meta, error) {
 		return nil, fmt.Errorf("short read: read %d bytes, expected %d", n, META_FILE_SIZE)
 	}
 
+	return metaBlock, nil
+}
+
+// metaStampSize is how much of the metadata block readMetaCached compares:
+// the header and the nonce the metadata was sealed under.
+const metaStampSize = HEADER_SIZE + NonceSize
+
+// cachedMeta is metadata decoded by readMetaCached with the header and
+// nonce it was read under.
+type cachedMeta struct {
+	stamp []byte
+	meta  *Meta
+}
+
+var (
+	metaCacheMu sync.Mutex
+	metaCache   = make(map[F]cachedMeta)
+)
+
+// readMetaCached returns the cached metadata of file as long as the header
+// and the nonce of the encrypted metadata on disk are unchanged. Every
+// WriteMeta encrypts under a fresh random nonce, so a write by another
+// process holding the same volume open forces a reload. The write count
+// itself, Wear.MetaWrites, stays inside the encrypted metadata and is
+// only compared for the debug log once the new metadata is decrypted.
+func readMetaCached(file F) (*Meta, error) {
+	stamp := make([]byte, metaStampSize)
+	var previous *Meta
+	if n, _ := file.ReadAt(stamp, 0); n == metaStampSize {
+		metaCacheMu.Lock()
+		c, ok := metaCache[file]
+		metaCacheMu.Unlock()
+
+		if ok && bytes.Equal(c.stamp, stamp) {
+			return cloneMeta(c.meta)
+		}
+		previous = c.meta
+	}
+
+	metaBlock, err := readMetaBlock(file)
+	if err != nil {
+		forgetMeta(file)
+		return nil, err
+	}
+	meta, err := decodeMetaBlock(metaBlock)
+	if err != nil {
+		forgetMeta(file)
+		return nil, err
+	}
+
+	if previous != nil {
+		LogDebug("metadata changed on disk from write %d to %d, reloaded", previous.Wear.MetaWrites, meta.Wear.MetaWrites)
+	}
+
+	cached, err := cloneMeta(meta)
+	if err != nil {
+		return nil, err
+	}
+	metaCacheMu.Lock()
+	metaCache[file] = cachedMeta{stamp: metaBlock[:metaStampSize], meta: cached}
+	metaCacheMu.Unlock()
+
+	return meta, nil
+}
+
+// forgetMeta drops the cached metadata of file, or of every volume when
+// file is nil.
+func forgetMeta(file F) {
+	metaCacheMu.Lock()
+	defer metaCacheMu.Unlock()
+
+	if file == nil {
+		clear(metaCache)
+		return
+	}
+	delete(metaCache, file)
+}
+
+// cloneMeta deep copies m, so callers can change what ReadMeta returns
+// without touching the cache.
+func cloneMeta(m *Meta) (*Meta, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy metadata: %w", err)
+	}
+
+	var c Meta
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("failed to copy metadata: %w", err)
+	}
+
+	return &c, nil
+}
+
+// decodeMetaBlock validates and decrypts a raw metadata block.
+func decodeMetaBlock(metaBlock []byte) (*Meta, error) {
+	salt, encrypted, err := parseMetaBlock(metaBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := GetEncKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get encryption key: %w", err)
+	}
+
+	metaJSON, err := DecryptGCM(encrypted, password, salt, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt metadata: %w", err)
+	}
+
+	var meta Meta
+	if err := json.Unmarshal(metaJSON, &meta); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
+	}
+
+	if meta.Version != METADATA_VERSION {
+		return nil, versionError(meta.Version)
+	}
+
+	return &meta, nil
+}
+
+// padMetaJSON pads metaJSON with trailing spaces, which the JSON decoder
+// ignores, to the largest plaintext the metadata block can hold. JSON that
+// is already too large is returned as is and rejected by WriteMeta.
+func padMetaJSON(metaJSON []byte) []byte {
+	target := META_FILE_SIZE - HEADER_SIZE - CHECKSUM_SIZE - NonceSize - TagSize
+	if len(metaJSON) >= target {
+		return metaJSON
+	}
+
+	return append(metaJSON, bytes.Repeat([]byte(" "), target-len(metaJSON))...)
+}
+
+// checkMetaFits estimates the size of the metadata block WriteMeta would
+// produce for m and errors if it exceeds META_FILE_SIZE. The estimate
+// leaves a little room for the counters WriteMeta updates.
+func checkMetaFits(m *Meta) error {
+	metaJSON, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	totalSize := HEADER_SIZE + NonceSize + len(metaJSON) + TagSize + CHECKSUM_SIZE + 64
+	if totalSize > META_FILE_SIZE {
+		return fmt.Errorf("metadata too large: %d bytes (max %d)", totalSize, META_FILE_SIZE)
+	}
+
+	return nil
+}
+
+// versionError explains a stored metadata version this binary can't read,
+// telling apart a volume written by a newer release from an older one.
+func versionError(version int) error {
+	if version > METADATA_VERSION {
+		return fmt.Errorf("unsupported metadata version: %d (this binary reads %d): the volume was written by a newer hdnfs, upgrade to read it", version, METADATA_VERSION)
+	}
+
+	return fmt.Errorf("unsupported metadata version: %d (this binary reads %d): the volume was written by an older hdnfs, export it with that release, then init and import", version, METADATA_VERSION)
+}
+
+// parseMetaBlock validates the header and checksum of a raw metadata block
+// and returns the salt and encrypted payload it describes. With
+// IgnoreChecksum a checksum mismatch is let through and the payload is
+// left to the GCM tag to authenticate.
+func parseMetaBlock(metaBlock []byte) ([]byte, []byte, error) {
 	magic := string(metaBlock[0:MAGIC_SIZE])
 	if magic != MAGIC_STRING {
-		return nil, errors.New("invalid filesystem: magic number mismatch (device not initialized or corrupted)")
+		return nil, nil, errors.New("invalid filesystem: magic number mismatch (device not initialized or corrupted)")
 	}
 
 	version := int(metaBlock[MAGIC_SIZE])
 	if version != METADATA_VERSION {
-		return nil, fmt.Errorf("unsupported metadata version: %d (expected %d)", version, METADATA_VERSION)
+		return nil, nil, versionError(version)
 	}
 
 	salt := metaBlock[8 : 8+SALT_SIZE]
@@ -116,7 +342,7 @@ func ReadMeta(file F) (*Meta, error) {
 	encryptedStart := HEADER_SIZE
 	encryptedEnd := encryptedStart + int(encryptedLen)
 	if encryptedEnd > META_FILE_SIZE-CHECKSUM_SIZE {
-		return nil, fmt.Errorf("encrypted data length exceeds metadata size: %d", encryptedLen)
+		return nil, nil, fmt.Errorf("encrypted data length exceeds metadata size: %d", encryptedLen)
 	}
 
 	encrypted := metaBlock[encryptedStart:encryptedEnd]
@@ -124,37 +350,49 @@ func ReadMeta(file F) (*Meta, error) {
 	checksumStart := encryptedEnd
 	checksumEnd := checksumStart + CHECKSUM_SIZE
 	if checksumEnd > META_FILE_SIZE {
-		return nil, errors.New("checksum position exceeds metadata size")
+		return nil, nil, errors.New("checksum position exceeds metadata size")
 	}
 	storedChecksum := metaBlock[checksumStart:checksumEnd]
 
 	checksumData := metaBlock[0:encryptedEnd]
 	computedChecksum := ComputeChecksum(checksumData)
 
-	if !bytes.Equal(storedChecksum, computedChecksum) {
-		return nil, errors.New("metadata corrupted: checksum mismatch")
+	if !bytes.Equal(storedChecksum, computedChecksum) && !IgnoreChecksum {
+		return nil, nil, errors.New("metadata corrupted: checksum mismatch")
 	}
 
-	password, err := GetEncKey()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get encryption key: %w", err)
-	}
+	return salt, encrypted, nil
+}
 
-	metaJSON, err := DecryptGCM(encrypted, password, salt)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt metadata: %w", err)
+// Header is the plaintext part of the metadata block.
+type Header struct {
+	Magic   string
+	Version int
+	Salt    []byte
+	Length  uint32
+}
+
+// ReadHeader reads the plaintext metadata header. It needs no password, so
+// it is safe to call for diagnostics on a volume that won't decrypt.
+func ReadHeader(file F) (*Header, error) {
+	header := make([]byte, HEADER_SIZE)
+	n, err := file.ReadAt(header, 0)
+	if n != HEADER_SIZE {
+		return nil, fmt.Errorf("failed to read header: read %d bytes, expected %d: %v", n, HEADER_SIZE, err)
 	}
 
-	var meta Meta
-	if err := json.Unmarshal(metaJSON, &meta); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
+	h := &Header{
+		Magic:   string(header[0:MAGIC_SIZE]),
+		Version: int(header[MAGIC_SIZE]),
+		Salt:    header[8 : 8+SALT_SIZE],
+		Length:  binary.BigEndian.Uint32(header[8+SALT_SIZE : HEADER_SIZE]),
 	}
 
-	if meta.Version != METADATA_VERSION {
-		return nil, fmt.Errorf("metadata version mismatch in JSON: %d (expected %d)", meta.Version, METADATA_VERSION)
+	if h.Magic != MAGIC_STRING {
+		return nil, errors.New("invalid filesystem: magic number mismatch (device not initialized or corrupted)")
 	}
 
-	return &meta, nil
+	return h, nil
 }
 
 func InitMeta(file F, mode string) error {
@@ -163,7 +401,7 @@ func InitMeta(file F, mode string) error {
 			return fmt.Errorf("failed to truncate file: %w", err)
 		}
 	} else {
-		if err := OverwriteDevice(file); err != nil {
+		if err := OverwriteDevice(file, 1); err != nil {
 			return fmt.Errorf("failed to overwrite device: %w", err)
 		}
 	}
@@ -176,9 +414,16 @@ func InitMeta(file F, mode string) error {
 	meta := &Meta{
 		Version: METADATA_VERSION,
 		Salt:    salt,
+		Noise:   SlotNoise,
 		Files:   [TOTAL_FILES]File{},
 	}
 
+	if meta.Noise {
+		if err := noiseSlots(file); err != nil {
+			return fmt.Errorf("failed to fill free space: %w", err)
+		}
+	}
+
 	if err := WriteMeta(file, meta); err != nil {
 		return fmt.Errorf("failed to write initial metadata: %w", err)
 	}
@@ -3,7 +3,11 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -68,6 +72,53 @@ func TestInitMeta(t *testing.T) {
 	}
 }
 
+// File mode never zeroes the slots: the file is truncated, only the
+// metadata block is written, and unwritten slots are holes that read as
+// zero.
+func TestInitMetaFileModeIsSparse(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := CreateTempTestFile(t, META_FILE_SIZE+(TOTAL_FILES*MAX_FILE_SIZE))
+	defer file.Close()
+
+	start := time.Now()
+	if err := InitMeta(file, "file"); err != nil {
+		t.Fatalf("InitMeta failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("File mode init took %v, expected it to skip zeroing", elapsed)
+	}
+
+	s, err := file.Stat()
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if s.Size() != META_FILE_SIZE {
+		t.Errorf("Expected only the metadata block to be written, file is %d bytes", s.Size())
+	}
+
+	VerifyMetadataIntegrity(t, file)
+
+	// Writing a high slot leaves the slots before it as holes.
+	block := bytes.Repeat([]byte{0xAB}, MAX_FILE_SIZE)
+	if err := WriteBlock(file, block, "", 10); err != nil {
+		t.Fatalf("WriteBlock failed: %v", err)
+	}
+	zero := make([]byte, MAX_FILE_SIZE)
+	for i := range 10 {
+		got, err := ReadBlock(file, i)
+		if err != nil {
+			t.Fatalf("ReadBlock %d failed: %v", i, err)
+		}
+		if !bytes.Equal(got, zero) {
+			t.Errorf("Slot %d should read as zero", i)
+		}
+	}
+}
+
 func TestWriteMetaAndReadMeta(t *testing.T) {
 	defer LogTestDuration(t, time.Now())
 
@@ -248,6 +299,41 @@ func TestReadMetaWithWrongMagicNumber(t *testing.T) {
 	}
 }
 
+func TestReadMetaVersionGuidance(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	tests := []struct {
+		name    string
+		version int
+		want    string
+	}{
+		{"newer", METADATA_VERSION + 1, "upgrade"},
+		{"older", METADATA_VERSION - 1, "older hdnfs"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := NewMockFile(META_FILE_SIZE)
+			if err := InitMeta(file, "device"); err != nil {
+				t.Fatalf("InitMeta failed: %v", err)
+			}
+
+			file.GetData()[MAGIC_SIZE] = byte(tt.version)
+
+			_, err := ReadMeta(file)
+			if err == nil {
+				t.Fatalf("ReadMeta should fail with metadata version %d", tt.version)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error to mention %q, got: %v", tt.want, err)
+			}
+		})
+	}
+}
+
 func TestReadMetaUninitialized(t *testing.T) {
 	defer LogTestDuration(t, time.Now())
 
@@ -532,3 +618,421 @@ func BenchmarkReadMeta(b *testing.B) {
 		ReadMeta(file)
 	}
 }
+
+func TestWearStats(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	meta, err := ReadMeta(file)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+	if meta.Wear.MetaWrites != 1 || meta.Wear.BytesWritten != META_FILE_SIZE {
+		t.Errorf("Unexpected wear after init: %+v", meta.Wear)
+	}
+
+	for i := 0; i < 3; i++ {
+		sourcePath := CreateTempSourceFile(t, []byte(fmt.Sprintf("wear test %d", i)))
+		if err := Add(file, sourcePath, i); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	if _, err := Del(file, "1"); err != nil {
+		t.Fatalf("Del failed: %v", err)
+	}
+
+	meta, err = ReadMeta(file)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+
+	if meta.Wear.Adds != 3 {
+		t.Errorf("Expected 3 adds, got %d", meta.Wear.Adds)
+	}
+	if meta.Wear.Deletes != 1 {
+		t.Errorf("Expected 1 delete, got %d", meta.Wear.Deletes)
+	}
+	if meta.Wear.MetaWrites != 5 {
+		t.Errorf("Expected 5 metadata writes, got %d", meta.Wear.MetaWrites)
+	}
+
+	expected := uint64(5*META_FILE_SIZE + 4*MAX_FILE_SIZE)
+	if meta.Wear.BytesWritten != expected {
+		t.Errorf("Expected %d bytes written, got %d", expected, meta.Wear.BytesWritten)
+	}
+
+	// Without --wear, stat shows geometry only and never asks for the
+	// password.
+	ClearPasswordCache()
+	prompt := passwordPrompt
+	passwordPrompt = func(string) (string, error) {
+		t.Error("Stat prompted for the password without --wear")
+		return "", io.EOF
+	}
+	output := captureOutput(func() {
+		if err := Stat(file); err != nil {
+			t.Errorf("Stat failed: %v", err)
+		}
+	})
+	passwordPrompt = prompt
+	SetupTestKey(t)
+	if strings.Contains(output, "WEAR STATS") || !strings.Contains(output, "DEVICE STATS") {
+		t.Errorf("Expected only device stats without --wear, got:\n%s", output)
+	}
+
+	StatWear = true
+	defer func() { StatWear = false }()
+	output = captureOutput(func() {
+		if err := Stat(file); err != nil {
+			t.Errorf("Stat failed: %v", err)
+		}
+	})
+	if !strings.Contains(output, "WEAR STATS") {
+		t.Errorf("Expected wear stats in Stat output, got:\n%s", output)
+	}
+
+	JSONEvents = true
+	defer func() { JSONEvents = false }()
+	output = captureOutput(func() {
+		if err := Stat(file); err != nil {
+			t.Errorf("Stat failed: %v", err)
+		}
+	})
+	var rec statRecord
+	if err := json.Unmarshal([]byte(output), &rec); err != nil {
+		t.Fatalf("Stat output is not JSON: %v\n%s", err, output)
+	}
+	if rec.Wear == nil || *rec.Wear != meta.Wear {
+		t.Errorf("Expected wear %+v in JSON, got %+v", meta.Wear, rec.Wear)
+	}
+	if rec.Size == 0 || rec.Mode == "" {
+		t.Errorf("Expected device fields in JSON, got %+v", rec)
+	}
+}
+
+// syncCountingFile counts Sync calls on the wrapped file.
+type syncCountingFile struct {
+	*os.File
+	syncs int
+}
+
+func (f *syncCountingFile) Sync() error {
+	f.syncs++
+	return f.File.Sync()
+}
+
+func TestNoMetaSyncFlush(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := &syncCountingFile{File: GetSharedTestFile(t)}
+	InitMeta(file, "file")
+
+	NoMetaSync = true
+	defer func() { NoMetaSync = false }()
+
+	meta, err := ReadMeta(file)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+
+	file.syncs = 0
+	for i := 0; i < 3; i++ {
+		meta.Files[i] = File{Name: fmt.Sprintf("batch_%d.txt", i), Size: 10}
+		if err := WriteMeta(file, meta); err != nil {
+			t.Fatalf("WriteMeta failed: %v", err)
+		}
+	}
+	if file.syncs != 0 {
+		t.Errorf("Expected no syncs during the batch, got %d", file.syncs)
+	}
+
+	if err := FlushMeta(file); err != nil {
+		t.Fatalf("FlushMeta failed: %v", err)
+	}
+	if file.syncs != 1 {
+		t.Errorf("Expected exactly one sync from FlushMeta, got %d", file.syncs)
+	}
+
+	reopened, err := os.Open(file.Name())
+	if err != nil {
+		t.Fatalf("Failed to reopen file: %v", err)
+	}
+	defer reopened.Close()
+
+	readMeta, err := ReadMeta(reopened)
+	if err != nil {
+		t.Fatalf("ReadMeta after flush failed: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		expected := fmt.Sprintf("batch_%d.txt", i)
+		if readMeta.Files[i].Name != expected {
+			t.Errorf("Index %d: expected %q after flush, got %q", i, expected, readMeta.Files[i].Name)
+		}
+	}
+}
+
+func benchmarkBulkAdd(b *testing.B, noMetaSync bool) {
+	SetupTestKey(&testing.T{})
+	file := CreateTempTestFile(&testing.T{}, META_FILE_SIZE+(TOTAL_FILES*MAX_FILE_SIZE))
+	defer file.Close()
+
+	InitMeta(file, "file")
+
+	content := GenerateRandomBytes(1024)
+	sourcePath := CreateTempSourceFile(&testing.T{}, content)
+
+	NoMetaSync = noMetaSync
+	defer func() { NoMetaSync = false }()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for index := 0; index < 10; index++ {
+			Add(file, sourcePath, index)
+		}
+		FlushMeta(file)
+	}
+}
+
+func BenchmarkBulkAddMetaSync(b *testing.B) {
+	benchmarkBulkAdd(b, false)
+}
+
+func BenchmarkBulkAddNoMetaSync(b *testing.B) {
+	benchmarkBulkAdd(b, true)
+}
+
+func TestCompactMetadataSize(t *testing.T) {
+	// legacyFile is the File encoding used before empty fields were omitted.
+	type legacyFile struct {
+		Name    string
+		Size    int
+		Created int64
+	}
+	type legacyMeta struct {
+		Version int
+		Salt    []byte
+		Wear    WearStats
+		Files   [TOTAL_FILES]legacyFile
+	}
+
+	salt := make([]byte, SALT_SIZE)
+	meta := &Meta{Version: METADATA_VERSION, Salt: salt}
+	legacy := &legacyMeta{Version: METADATA_VERSION, Salt: salt}
+	for _, i := range []int{0, 10, 500} {
+		meta.Files[i] = File{Name: fmt.Sprintf("file_%d.txt", i), Size: 100 + i, Created: 1700000000}
+		legacy.Files[i] = legacyFile{Name: meta.Files[i].Name, Size: meta.Files[i].Size, Created: meta.Files[i].Created}
+	}
+
+	compactJSON, err := json.Marshal(meta)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	legacyJSON, err := json.Marshal(legacy)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	t.Logf("sparse metadata: %d bytes compact, %d bytes legacy", len(compactJSON), len(legacyJSON))
+	if len(compactJSON)*5 > len(legacyJSON) {
+		t.Errorf("Expected compact metadata to be at least 5x smaller: %d vs %d bytes", len(compactJSON), len(legacyJSON))
+	}
+
+	// Metadata written in the old encoding must still decode.
+	var decoded Meta
+	if err := json.Unmarshal(legacyJSON, &decoded); err != nil {
+		t.Fatalf("Unmarshal of legacy metadata failed: %v", err)
+	}
+	for i := range TOTAL_FILES {
+		d, m := decoded.Files[i], meta.Files[i]
+		if d.Name != m.Name || d.Size != m.Size || d.Created != m.Created {
+			t.Errorf("Index %d: legacy decode got %+v, expected %+v", i, decoded.Files[i], meta.Files[i])
+		}
+	}
+}
+
+func TestPadMetadataConstantLength(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := NewMockFile(0)
+
+	PadMetadata = true
+	if err := InitMeta(file, "file"); err != nil {
+		t.Fatalf("InitMeta failed: %v", err)
+	}
+	PadMetadata = false
+
+	empty, err := ReadHeader(file)
+	if err != nil {
+		t.Fatalf("ReadHeader failed: %v", err)
+	}
+
+	// The flag is only needed once; the volume stays padded.
+	meta, err := ReadMeta(file)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+	if !meta.Padded {
+		t.Fatal("Expected metadata to be marked as padded")
+	}
+	for i := range TOTAL_FILES {
+		meta.Files[i] = File{Name: fmt.Sprintf("file_%04d.txt", i), Size: 1000 + i, Created: 1700000000}
+	}
+	if err := WriteMeta(file, meta); err != nil {
+		t.Fatalf("WriteMeta failed: %v", err)
+	}
+
+	full, err := ReadHeader(file)
+	if err != nil {
+		t.Fatalf("ReadHeader failed: %v", err)
+	}
+	if empty.Length != full.Length {
+		t.Errorf("Length field differs: %d for empty volume, %d for full volume", empty.Length, full.Length)
+	}
+
+	readMeta, err := ReadMeta(file)
+	if err != nil {
+		t.Fatalf("ReadMeta of padded metadata failed: %v", err)
+	}
+	if readMeta.Files[999].Name != "file_0999.txt" {
+		t.Errorf("Unexpected last entry: %+v", readMeta.Files[999])
+	}
+
+	unpadded := NewMockFile(0)
+	InitMeta(unpadded, "file")
+	h, _ := ReadHeader(unpadded)
+	if h.Length == empty.Length {
+		t.Error("Expected unpadded metadata to be shorter than padded metadata")
+	}
+}
+
+func TestReadMetaIgnoreChecksum(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := NewMockFile(META_FILE_SIZE)
+
+	salt, err := GenerateSalt()
+	if err != nil {
+		t.Fatalf("Failed to generate salt: %v", err)
+	}
+
+	meta := &Meta{
+		Version: METADATA_VERSION,
+		Salt:    salt,
+	}
+	meta.Files[0] = File{Name: "test.txt", Size: 100}
+
+	if err := WriteMeta(file, meta); err != nil {
+		t.Fatalf("WriteMeta failed: %v", err)
+	}
+
+	rawData := file.GetData()
+	encryptedLen := int(binary.BigEndian.Uint32(rawData[8+SALT_SIZE : HEADER_SIZE]))
+	checksumPos := HEADER_SIZE + encryptedLen
+
+	// The padding after the checksum isn't covered by it.
+	rawData[checksumPos+CHECKSUM_SIZE+10] ^= 0xFF
+	if _, err := ReadMeta(file); err != nil {
+		t.Fatalf("ReadMeta should ignore damage in the padding: %v", err)
+	}
+
+	rawData[checksumPos+3] ^= 0xFF
+	if _, err := ReadMeta(file); err == nil {
+		t.Fatal("ReadMeta should fail on a damaged checksum")
+	}
+
+	IgnoreChecksum = true
+	defer func() { IgnoreChecksum = false }()
+
+	recovered, err := ReadMeta(file)
+	if err != nil {
+		t.Fatalf("ReadMeta with IgnoreChecksum failed: %v", err)
+	}
+	if recovered.Files[0].Name != "test.txt" {
+		t.Errorf("Expected test.txt, got %q", recovered.Files[0].Name)
+	}
+
+	if err := WriteMeta(file, recovered); err == nil {
+		t.Error("WriteMeta should refuse to write with IgnoreChecksum")
+	}
+
+	// GCM still rejects a change to the encrypted metadata.
+	rawData[HEADER_SIZE+NonceSize+5] ^= 0xFF
+	if _, err := ReadMeta(file); err == nil {
+		t.Error("ReadMeta with IgnoreChecksum should fail on tampered ciphertext")
+	}
+}
+
+func TestReadMetaReloadsAfterExternalWrite(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	ConcurrencySafe = true
+	defer func() {
+		ConcurrencySafe = false
+		forgetMeta(nil)
+	}()
+
+	file := NewMockFile(0)
+	InitMeta(file, "file")
+
+	meta, err := ReadMeta(file)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+	meta.Files[3].Name = "changed-by-caller.txt"
+
+	// The cache serves reads while the header and nonce are unchanged,
+	// even though the ciphertext after them no longer decrypts.
+	data := file.GetData()
+	data[HEADER_SIZE+NonceSize+10] ^= 0xFF
+	cached, err := ReadMeta(file)
+	if err != nil {
+		t.Fatalf("Expected cached metadata while the header is unchanged: %v", err)
+	}
+	if cached.Files[3].Name != "" {
+		t.Error("Changes to returned metadata leaked into the cache")
+	}
+	data[HEADER_SIZE+NonceSize+10] ^= 0xFF
+
+	// Another process writes through its own handle on the same volume.
+	other := NewMockFileWithData(bytes.Clone(data))
+	otherMeta, err := ReadMeta(other)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+	otherMeta.Files[5] = File{Name: "external.txt", Size: 10}
+	if err := WriteMeta(other, otherMeta); err != nil {
+		t.Fatalf("WriteMeta failed: %v", err)
+	}
+	copy(data, other.GetData()[:META_FILE_SIZE])
+
+	// The write count stays in the encrypted metadata, the reserved
+	// header bytes are left zero.
+	if !bytes.Equal(data[MAGIC_SIZE+VERSION_SIZE:8], make([]byte, RESERVED_SIZE)) {
+		t.Errorf("Expected zero reserved header bytes, got %x", data[MAGIC_SIZE+VERSION_SIZE:8])
+	}
+
+	reloaded, err := ReadMeta(file)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+	if reloaded.Files[5].Name != "external.txt" {
+		t.Error("Stale metadata returned after an external write")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// fillNoise overwrites b with random bytes, which can't be told apart from
+// ciphertext.
+func fillNoise(b []byte) error {
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Errorf("failed to generate random data: %w", err)
+	}
+	return nil
+}
+
+// noiseSlots fills every data slot with random bytes, so a new volume has
+// no zeroed free space showing how much of it is in use.
+func noiseSlots(file F) error {
+	block := make([]byte, MAX_FILE_SIZE)
+	var progress progressLimiter
+	for i := range TOTAL_FILES {
+		if err := fillNoise(block); err != nil {
+			return err
+		}
+		seekPos := int64(META_FILE_SIZE) + (int64(i) * int64(MAX_FILE_SIZE))
+		if _, err := file.WriteAt(block, seekPos); err != nil {
+			return fmt.Errorf("failed to fill slot %d: %w", i, err)
+		}
+		if progress.due() {
+			LogInfo("filled %d/%d slots with noise", i+1, TOTAL_FILES)
+		}
+	}
+
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync: %w", err)
+	}
+
+	return nil
+}
+
+// clearSlot erases the block at index the way the volume expects: with
+// random bytes on a volume initialized with SlotNoise, with zeros
+// otherwise.
+func clearSlot(file F, meta *Meta, index int) error {
+	if !meta.Noise {
+		return zeroSlot(file, index)
+	}
+
+	block := make([]byte, MAX_FILE_SIZE)
+	if err := fillNoise(block); err != nil {
+		return err
+	}
+	if err := WriteBlock(file, block, "", index); err != nil {
+		return fmt.Errorf("failed to overwrite file slot: %w", err)
+	}
+
+	return nil
+}
+
+// padBlock fills the part of block after the first used bytes with noise
+// when the volume keeps its free space random. Zero padding would
+// otherwise mark the slot as used.
+func padBlock(noise bool, block []byte, used int) error {
+	if !noise {
+		return nil
+	}
+	return fillNoise(block[used:])
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+)
+
+// entropy returns the Shannon entropy of b in bits per byte.
+func entropy(b []byte) float64 {
+	var counts [256]int
+	for _, c := range b {
+		counts[c]++
+	}
+
+	var e float64
+	for _, n := range counts {
+		if n == 0 {
+			continue
+		}
+		p := float64(n) / float64(len(b))
+		e -= p * math.Log2(p)
+	}
+	return e
+}
+
+func TestSlotNoise(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	SlotNoise = true
+	defer func() { SlotNoise = false }()
+
+	file := NewMockFile(0)
+	if err := InitMeta(file, "file"); err != nil {
+		t.Fatalf("InitMeta failed: %v", err)
+	}
+
+	// The flag is only read by init, the volume remembers it.
+	SlotNoise = false
+
+	content := []byte("hidden among the noise")
+	sourcePath := CreateTempSourceFileWithName(t, content, "hidden.txt")
+	if err := Add(file, sourcePath, 3); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	meta, err := ReadMeta(file)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+	if !meta.Noise {
+		t.Fatal("Expected the volume to be marked as noise filled")
+	}
+
+	var out bytes.Buffer
+	if err := GetToWriter(file, 3, &out); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), content) {
+		t.Errorf("Expected %q, got %q", content, out.Bytes())
+	}
+
+	for _, i := range []int{0, 3, TOTAL_FILES - 1} {
+		block, err := ReadBlock(file, i)
+		if err != nil {
+			t.Fatalf("ReadBlock %d failed: %v", i, err)
+		}
+		if e := entropy(block); e < 7.9 {
+			t.Errorf("Slot %d: expected random data, entropy is %.2f bits per byte", i, e)
+		}
+		if bytes.HasSuffix(block, make([]byte, 32)) {
+			t.Errorf("Slot %d ends in zeros", i)
+		}
+	}
+
+	before, err := ReadBlock(file, 3)
+	if err != nil {
+		t.Fatalf("ReadBlock failed: %v", err)
+	}
+	if _, err := Del(file, "3"); err != nil {
+		t.Fatalf("Del failed: %v", err)
+	}
+	after, err := ReadBlock(file, 3)
+	if err != nil {
+		t.Fatalf("ReadBlock failed: %v", err)
+	}
+	if bytes.Equal(before, after) {
+		t.Error("Expected del to overwrite the slot")
+	}
+	if e := entropy(after); e < 7.9 {
+		t.Errorf("Expected del to randomize the slot, entropy is %.2f bits per byte", e)
+	}
+
+	VerifyMetadataIntegrity(t, file)
+}
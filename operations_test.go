@@ -2,9 +2,15 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -244,6 +250,168 @@ func TestGet(t *testing.T) {
 	}
 }
 
+func TestGetStdout(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+
+	InitMeta(file, "file")
+
+	originalContent := append([]byte("line one\nline two\n"), GenerateRandomBytes(2000)...)
+	sourcePath := CreateTempSourceFile(t, originalContent)
+	if err := Add(file, sourcePath, 5); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	var err error
+	output := captureOutput(func() {
+		err = Get(file, 5, "-")
+	})
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	if output != string(originalContent) {
+		t.Errorf("Expected exactly the decrypted bytes on stdout, got %d bytes", len(output))
+	}
+	if _, err := os.Stat("-"); err == nil {
+		os.Remove("-")
+		t.Error("Get should not create a file named -")
+	}
+}
+
+func TestGetChecksumMismatch(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	Checksums = true
+	defer func() { Checksums = false }()
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	for i, content := range [][]byte{[]byte("aaaa"), []byte("bbbb")} {
+		if err := Add(file, CreateTempSourceFile(t, content), i); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	// A block from another slot passes the GCM tag, so only the stored
+	// checksum catches it.
+	second, _ := ReadBlock(file, 1)
+	WriteBlock(file, second, "", 0)
+
+	outputPath := filepath.Join(t.TempDir(), "out")
+	err := Get(file, 0, outputPath)
+	if err == nil {
+		t.Fatal("Expected Get to fail on a block holding another file")
+	}
+	if !strings.Contains(err.Error(), "checksum mismatch: file corrupted") {
+		t.Errorf("Expected a checksum error, got: %v", err)
+	}
+
+	// Without a stored checksum the same block is returned as is.
+	meta, _ := ReadMeta(file)
+	meta.Files[0].Checksum = nil
+	WriteMeta(file, meta)
+
+	if err := Get(file, 0, outputPath); err != nil {
+		t.Fatalf("Get failed without a stored checksum: %v", err)
+	}
+}
+
+func TestGetToWriter(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	originalContent := GenerateRandomBytes(3000)
+	if err := Add(file, CreateTempSourceFile(t, originalContent), 2); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := GetToWriter(file, 2, &buf); err != nil {
+		t.Fatalf("GetToWriter failed: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), originalContent) {
+		t.Errorf("Content mismatch: got %d bytes, expected %d", buf.Len(), len(originalContent))
+	}
+
+	buf.Reset()
+	ExpectedSHA256 = make([]byte, sha256.Size)
+	defer func() { ExpectedSHA256 = nil }()
+	if err := GetToWriter(file, 2, &buf); err == nil {
+		t.Error("Expected GetToWriter to fail on a checksum mismatch")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Expected nothing written for a failed get, got %d bytes", buf.Len())
+	}
+}
+
+func TestGetUnpaddedBlock(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := NewMockFile(0)
+	if err := InitMeta(file, "file"); err != nil {
+		t.Fatalf("InitMeta failed: %v", err)
+	}
+
+	padded := []byte("written by add, padded to the full slot")
+	if err := Add(file, CreateTempSourceFile(t, padded), 0); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	password, err := GetEncKey()
+	if err != nil {
+		t.Fatalf("Failed to get encryption key: %v", err)
+	}
+	meta, err := ReadMeta(file)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+
+	// Only the ciphertext, with the volume ending right after it.
+	unpadded := []byte("ciphertext only, no padding after it")
+	encrypted, err := EncryptGCM(unpadded, password, meta.Salt, nil)
+	if err != nil {
+		t.Fatalf("EncryptGCM failed: %v", err)
+	}
+	if _, err := file.WriteAt(encrypted, int64(META_FILE_SIZE)+MAX_FILE_SIZE); err != nil {
+		t.Fatalf("WriteAt failed: %v", err)
+	}
+	if len(file.data) != META_FILE_SIZE+MAX_FILE_SIZE+len(encrypted) {
+		t.Fatalf("Expected the volume to end after the ciphertext, size is %d", len(file.data))
+	}
+	meta.Files[1] = File{Name: "unpadded.txt", Size: len(encrypted)}
+	if err := WriteMeta(file, meta); err != nil {
+		t.Fatalf("WriteMeta failed: %v", err)
+	}
+
+	// Get reads exactly Size bytes, so padding makes no difference.
+	for index, want := range map[int][]byte{0: padded, 1: unpadded} {
+		var buf bytes.Buffer
+		if err := GetToWriter(file, index, &buf); err != nil {
+			t.Fatalf("Get %d failed: %v", index, err)
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("Slot %d: expected %q, got %q", index, want, buf.Bytes())
+		}
+	}
+}
+
 func TestGetMultipleFiles(t *testing.T) {
 	defer LogTestDuration(t, time.Now())
 
@@ -288,6 +456,69 @@ func TestGetMultipleFiles(t *testing.T) {
 	}
 }
 
+func TestDelReturnsFreedIndices(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	Add(file, CreateTempSourceFileWithName(t, []byte("one"), "report.pdf"), 2)
+	Add(file, CreateTempSourceFileWithName(t, []byte("two"), "notes.txt"), 4)
+	Add(file, CreateTempSourceFileWithName(t, []byte("three"), "report.pdf"), 7)
+	NameHash = true
+	Add(file, CreateTempSourceFileWithName(t, []byte("four"), "hidden.txt"), 9)
+	NameHash = false
+
+	var freed []int
+	var err error
+	output := captureOutput(func() {
+		freed, err = Del(file, "4")
+	})
+	if err != nil {
+		t.Fatalf("Del by index failed: %v", err)
+	}
+	if fmt.Sprint(freed) != "[4]" {
+		t.Errorf("Expected [4] freed, got %v", freed)
+	}
+	if output != "" {
+		t.Errorf("Del should not print, got:\n%s", output)
+	}
+
+	freed, err = Del(file, "report.pdf")
+	if err != nil {
+		t.Fatalf("Del by name failed: %v", err)
+	}
+	if fmt.Sprint(freed) != "[2 7]" {
+		t.Errorf("Expected both report.pdf slots [2 7] freed, got %v", freed)
+	}
+
+	freed, err = Del(file, "hidden.txt")
+	if err != nil {
+		t.Fatalf("Del by hashed name failed: %v", err)
+	}
+	if fmt.Sprint(freed) != "[9]" {
+		t.Errorf("Expected [9] freed, got %v", freed)
+	}
+
+	meta := VerifyMetadataIntegrity(t, file)
+	if CountUsedSlots(meta) != 0 {
+		t.Errorf("Expected every file deleted, %d left", CountUsedSlots(meta))
+	}
+	if meta.Wear.Deletes != 4 {
+		t.Errorf("Expected 4 deletes counted, got %d", meta.Wear.Deletes)
+	}
+
+	if _, err := Del(file, "report.pdf"); err == nil {
+		t.Error("Expected an error deleting a name that no longer exists")
+	}
+	if _, err := Del(file, "4"); err == nil {
+		t.Error("Expected an error deleting an empty slot")
+	}
+}
+
 func TestDel(t *testing.T) {
 	defer LogTestDuration(t, time.Now())
 
@@ -310,7 +541,7 @@ func TestDel(t *testing.T) {
 		t.Fatal("File was not added")
 	}
 
-	Del(file, 3)
+	Del(file, "3")
 
 	meta, err = ReadMeta(file)
 	if err != nil {
@@ -358,7 +589,7 @@ func TestDelMultipleFiles(t *testing.T) {
 	}
 
 	for i := 0; i < 10; i += 2 {
-		Del(file, i)
+		Del(file, strconv.Itoa(i))
 	}
 
 	meta, err := ReadMeta(file)
@@ -390,7 +621,7 @@ func TestDelInvalidIndex(t *testing.T) {
 
 	InitMeta(file, "file")
 
-	Del(file, TOTAL_FILES+100)
+	Del(file, strconv.Itoa(TOTAL_FILES+100))
 
 	meta, err := ReadMeta(file)
 	if err != nil {
@@ -401,6 +632,148 @@ func TestDelInvalidIndex(t *testing.T) {
 	}
 }
 
+func TestAddOutputIndex(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+
+	InitMeta(file, "file")
+	FillSlots(t, file, 3)
+
+	OutputIndex = true
+	defer func() { OutputIndex = false }()
+
+	sourcePath := CreateTempSourceFileWithName(t, []byte("scripted"), "scripted.txt")
+
+	var err error
+	output := captureOutput(func() {
+		err = Add(file, sourcePath, OUT_OF_BOUNDS_INDEX)
+	})
+	if err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	meta := VerifyMetadataIntegrity(t, file)
+	if meta.Files[3].Name != "scripted.txt" {
+		t.Fatalf("Expected the file at index 3, got %q", meta.Files[3].Name)
+	}
+	if output != "3\n" {
+		t.Errorf("Expected only the index, got %q", output)
+	}
+}
+
+// File-backed volumes start at the metadata block and grow as slots are
+// written, so an add far past the end of the file extends it.
+func TestAddGrowsFileVolume(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+
+	InitMeta(file, "file")
+
+	s, err := file.Stat()
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if s.Size() != META_FILE_SIZE {
+		t.Fatalf("Expected a fresh file volume of %d bytes, got %d", META_FILE_SIZE, s.Size())
+	}
+
+	originalContent := []byte("stored in the last slot")
+	sourcePath := CreateTempSourceFile(t, originalContent)
+	if err := Add(file, sourcePath, TOTAL_FILES-1); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	s, err = file.Stat()
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	want := int64(META_FILE_SIZE) + int64(TOTAL_FILES)*int64(MAX_FILE_SIZE)
+	if s.Size() != want {
+		t.Errorf("Expected the volume to grow to %d bytes, got %d", want, s.Size())
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "output.txt")
+	if err := Get(file, TOTAL_FILES-1, outputPath); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	retrievedContent, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read retrieved file: %v", err)
+	}
+	if !bytes.Equal(retrievedContent, originalContent) {
+		t.Errorf("Content mismatch: %q", retrievedContent)
+	}
+}
+
+func TestRename(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+
+	InitMeta(file, "file")
+
+	originalContent := []byte("content that must survive a rename")
+	sourcePath := CreateTempSourceFileWithName(t, originalContent, "draft.txt")
+	if err := Add(file, sourcePath, 5); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	before, err := ReadBlock(file, 5)
+	if err != nil {
+		t.Fatalf("ReadBlock failed: %v", err)
+	}
+
+	if err := Rename(file, 5, "final.txt"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+
+	meta := VerifyMetadataIntegrity(t, file)
+	if meta.Files[5].Name != "final.txt" {
+		t.Errorf("Expected name final.txt, got %q", meta.Files[5].Name)
+	}
+
+	after, err := ReadBlock(file, 5)
+	if err != nil {
+		t.Fatalf("ReadBlock failed: %v", err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Error("Rename should not touch the data block")
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "output.txt")
+	if err := Get(file, 5, outputPath); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	retrievedContent, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read retrieved file: %v", err)
+	}
+	if !bytes.Equal(retrievedContent, originalContent) {
+		t.Errorf("Content changed after rename: %q", retrievedContent)
+	}
+
+	if err := Rename(file, 6, "nothing.txt"); err == nil {
+		t.Error("Expected renaming an empty slot to fail")
+	}
+	if err := Rename(file, TOTAL_FILES, "out.txt"); err == nil {
+		t.Error("Expected an out of range index to fail")
+	}
+	if err := Rename(file, 5, strings.Repeat("a", MAX_FILE_NAME_SIZE+1)); err == nil {
+		t.Error("Expected a too long name to fail")
+	}
+}
+
 func TestAddDeleteAddCycle(t *testing.T) {
 	defer LogTestDuration(t, time.Now())
 
@@ -421,7 +794,7 @@ func TestAddDeleteAddCycle(t *testing.T) {
 
 		VerifyFileConsistency(t, file, index, content)
 
-		Del(file, index)
+		Del(file, strconv.Itoa(index))
 
 		meta, err := ReadMeta(file)
 		if err != nil {
@@ -469,7 +842,7 @@ func TestAddWithEmptyFile(t *testing.T) {
 	}
 }
 
-func TestAddBinaryFile(t *testing.T) {
+func TestAddReader(t *testing.T) {
 	defer LogTestDuration(t, time.Now())
 
 	SetupTestKey(t)
@@ -479,59 +852,256 @@ func TestAddBinaryFile(t *testing.T) {
 
 	InitMeta(file, "file")
 
-	binaryContent := make([]byte, 256)
-	for i := 0; i < 256; i++ {
-		binaryContent[i] = byte(i)
+	content := []byte("piped in from stdin")
+	if err := AddReader(file, bytes.NewReader(content), "piped.txt", OUT_OF_BOUNDS_INDEX); err != nil {
+		t.Fatalf("AddReader failed: %v", err)
+	}
+	if err := AddReader(file, bytes.NewReader(nil), "empty.txt", 5); err != nil {
+		t.Fatalf("AddReader with empty input failed: %v", err)
 	}
 
-	sourcePath := CreateTempSourceFile(t, binaryContent)
-	Add(file, sourcePath, 0)
-
-	tmpDir := t.TempDir()
-	outputPath := filepath.Join(tmpDir, "binary_out.bin")
-	Get(file, 0, outputPath)
-
-	retrieved, err := os.ReadFile(outputPath)
-	if err != nil {
-		t.Fatalf("Failed to read binary file: %v", err)
+	meta := VerifyMetadataIntegrity(t, file)
+	if meta.Files[0].Name != "piped.txt" || meta.Files[5].Name != "empty.txt" {
+		t.Fatalf("Expected piped.txt at 0 and empty.txt at 5, got %q and %q", meta.Files[0].Name, meta.Files[5].Name)
 	}
 
-	if !bytes.Equal(retrieved, binaryContent) {
-		t.Error("Binary file content corrupted")
-		for i := 0; i < len(binaryContent) && i < len(retrieved); i++ {
-			if binaryContent[i] != retrieved[i] {
-				t.Errorf("First mismatch at byte %d: expected %02x, got %02x", i, binaryContent[i], retrieved[i])
-				break
-			}
+	password, _ := GetEncKey()
+	for index, want := range map[int][]byte{0: content, 5: {}} {
+		got, err := decryptSlot(file, meta, password, index)
+		if err != nil {
+			t.Fatalf("Failed to read slot %d: %v", index, err)
 		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("Slot %d: expected %q, got %q", index, want, got)
+		}
+	}
+
+	if err := AddReader(file, bytes.NewReader(content), strings.Repeat("a", MAX_FILE_NAME_SIZE+1), OUT_OF_BOUNDS_INDEX); err == nil {
+		t.Error("Expected a too long name to fail")
+	}
+	if err := AddReader(file, bytes.NewReader(GenerateRandomBytes(MAX_FILE_SIZE)), "big.bin", OUT_OF_BOUNDS_INDEX); err == nil {
+		t.Error("Expected input too large for a slot to fail")
 	}
 }
 
-func BenchmarkAdd(b *testing.B) {
-	SetupTestKey(&testing.T{})
-	file := CreateTempTestFile(&testing.T{}, META_FILE_SIZE+(TOTAL_FILES*MAX_FILE_SIZE))
-	defer file.Close()
+func TestAddCompressIncompressible(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
 
+	file := GetSharedTestFile(t)
 	InitMeta(file, "file")
 
-	content := GenerateRandomBytes(1024)
-	sourcePath := CreateTempSourceFile(&testing.T{}, content)
+	Compress = true
+	defer func() { Compress = false }()
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		index := i % TOTAL_FILES
-		Add(file, sourcePath, index)
+	originalContent := GenerateRandomBytes(10000)
+	if err := Add(file, CreateTempSourceFile(t, originalContent), 0); err != nil {
+		t.Fatalf("Add with --compress failed: %v", err)
+	}
+
+	meta := VerifyMetadataIntegrity(t, file)
+	entry := meta.Files[0]
+	if entry.Compressed {
+		t.Error("Expected random data to be stored uncompressed")
+	}
+	if entry.Size != len(originalContent)+NonceSize+TagSize {
+		t.Errorf("Expected the raw content to be stored, got ciphertext of %d bytes", entry.Size)
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "out")
+	if err := Get(file, 0, outputPath); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	retrievedContent, _ := os.ReadFile(outputPath)
+	if !bytes.Equal(retrievedContent, originalContent) {
+		t.Error("Content mismatch for uncompressed file")
+	}
+
+	// Get goes by the flag, not by what the content looks like.
+	meta.Files[0].Compressed = true
+	WriteMeta(file, meta)
+	if err := Get(file, 0, outputPath); err == nil || !strings.Contains(err.Error(), "decompress") {
+		t.Errorf("Expected Get to try decompressing a flagged file, got: %v", err)
 	}
 }
 
-func BenchmarkGet(b *testing.B) {
-	SetupTestKey(&testing.T{})
-	file := CreateTempTestFile(&testing.T{}, META_FILE_SIZE+(TOTAL_FILES*MAX_FILE_SIZE))
-	defer file.Close()
+func TestAddGetContext(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
 
+	file := GetSharedTestFile(t)
 	InitMeta(file, "file")
 
-	content := GenerateRandomBytes(1024)
+	if err := Add(file, CreateTempSourceFile(t, []byte("unbound")), 0); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	Context = "production"
+	defer func() { Context = "" }()
+
+	originalContent := []byte("DB_PASSWORD=hunter2")
+	if err := Add(file, CreateTempSourceFile(t, originalContent), 1); err != nil {
+		t.Fatalf("Add with --context failed: %v", err)
+	}
+
+	meta := VerifyMetadataIntegrity(t, file)
+	if meta.Files[0].Bound || !meta.Files[1].Bound {
+		t.Error("Expected only the file added with a context to be bound")
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "out")
+	if err := Get(file, 1, outputPath); err != nil {
+		t.Fatalf("Get with matching context failed: %v", err)
+	}
+	retrievedContent, _ := os.ReadFile(outputPath)
+	if !bytes.Equal(retrievedContent, originalContent) {
+		t.Errorf("Content mismatch: got %q, expected %q", retrievedContent, originalContent)
+	}
+
+	// Files added without a context don't care what is passed.
+	if err := Get(file, 0, outputPath); err != nil {
+		t.Errorf("Get of an unbound file failed with a context set: %v", err)
+	}
+
+	Context = "staging"
+	if err := Get(file, 1, outputPath); err == nil {
+		t.Error("Expected Get with the wrong context to fail")
+	}
+
+	Context = ""
+	err := Get(file, 1, outputPath)
+	if err == nil || !strings.Contains(err.Error(), "--context") {
+		t.Errorf("Expected Get without a context to ask for one, got: %v", err)
+	}
+}
+
+func TestAddCompress(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+
+	InitMeta(file, "file")
+
+	var log bytes.Buffer
+	for i := 0; log.Len() < 4*MAX_FILE_SIZE; i++ {
+		fmt.Fprintf(&log, "2024-01-01 12:00:%02d INFO request %d handled\n", i%60, i)
+	}
+	originalContent := log.Bytes()
+	sourcePath := CreateTempSourceFileWithName(t, originalContent, "big.log")
+
+	if err := Add(file, sourcePath, 0); err == nil {
+		t.Fatal("Expected the uncompressed file to be too large")
+	}
+
+	Compress = true
+	defer func() { Compress = false }()
+
+	if err := Add(file, sourcePath, 0); err != nil {
+		t.Fatalf("Add with --compress failed: %v", err)
+	}
+
+	meta := VerifyMetadataIntegrity(t, file)
+	entry := meta.Files[0]
+	if !entry.Compressed {
+		t.Error("Expected the entry to be marked compressed")
+	}
+	if entry.Size >= MAX_FILE_SIZE {
+		t.Errorf("Expected Size to be the on-disk ciphertext size, got %d", entry.Size)
+	}
+	block, _ := ReadBlock(file, 0)
+	if !bytes.Equal(block[entry.Size:], make([]byte, MAX_FILE_SIZE-entry.Size)) {
+		t.Error("Expected the ciphertext to end at Size")
+	}
+
+	Compress = false
+	outputPath := filepath.Join(t.TempDir(), "big.log")
+	if err := Get(file, 0, outputPath); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	retrievedContent, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read retrieved file: %v", err)
+	}
+	if !bytes.Equal(retrievedContent, originalContent) {
+		t.Errorf("Decompressed content mismatch: got %d bytes, expected %d", len(retrievedContent), len(originalContent))
+	}
+
+	Compress = true
+	if err := Add(file, CreateTempSourceFile(t, GenerateRandomBytes(2*MAX_FILE_SIZE)), 1); err == nil {
+		t.Error("Expected incompressible data too large for a slot to fail")
+	}
+}
+
+func TestAddBinaryFile(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+
+	InitMeta(file, "file")
+
+	binaryContent := make([]byte, 256)
+	for i := 0; i < 256; i++ {
+		binaryContent[i] = byte(i)
+	}
+
+	sourcePath := CreateTempSourceFile(t, binaryContent)
+	Add(file, sourcePath, 0)
+
+	tmpDir := t.TempDir()
+	outputPath := filepath.Join(tmpDir, "binary_out.bin")
+	Get(file, 0, outputPath)
+
+	retrieved, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read binary file: %v", err)
+	}
+
+	if !bytes.Equal(retrieved, binaryContent) {
+		t.Error("Binary file content corrupted")
+		for i := 0; i < len(binaryContent) && i < len(retrieved); i++ {
+			if binaryContent[i] != retrieved[i] {
+				t.Errorf("First mismatch at byte %d: expected %02x, got %02x", i, binaryContent[i], retrieved[i])
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkAdd(b *testing.B) {
+	SetupTestKey(&testing.T{})
+	file := CreateTempTestFile(&testing.T{}, META_FILE_SIZE+(TOTAL_FILES*MAX_FILE_SIZE))
+	defer file.Close()
+
+	InitMeta(file, "file")
+
+	content := GenerateRandomBytes(1024)
+	sourcePath := CreateTempSourceFile(&testing.T{}, content)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index := i % TOTAL_FILES
+		Add(file, sourcePath, index)
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	SetupTestKey(&testing.T{})
+	file := CreateTempTestFile(&testing.T{}, META_FILE_SIZE+(TOTAL_FILES*MAX_FILE_SIZE))
+	defer file.Close()
+
+	InitMeta(file, "file")
+
+	content := GenerateRandomBytes(1024)
 	sourcePath := CreateTempSourceFile(&testing.T{}, content)
 	Add(file, sourcePath, 0)
 
@@ -561,8 +1131,728 @@ func BenchmarkDel(b *testing.B) {
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		index := i % 100
-		Del(file, index)
+		Del(file, strconv.Itoa(index))
 
 		Add(file, sourcePath, index)
 	}
 }
+
+func TestReadLimitedRejectsOversizedStream(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+	defer r.Close()
+
+	go func() {
+		defer w.Close()
+		chunk := make([]byte, 4096)
+		for written := int64(0); written < MaxPlaintextSize()*4; written += int64(len(chunk)) {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+		}
+	}()
+
+	data, err := readLimited(r, MaxPlaintextSize())
+	if err == nil {
+		t.Fatal("Expected error for stream larger than the limit")
+	}
+	if data != nil {
+		t.Errorf("Expected no data on error, got %d bytes", len(data))
+	}
+	if !strings.Contains(err.Error(), "too large") {
+		t.Errorf("Expected 'too large' error, got: %v", err)
+	}
+
+	exact := bytes.Repeat([]byte("a"), int(MaxPlaintextSize()))
+	data, err = readLimited(bytes.NewReader(exact), MaxPlaintextSize())
+	if err != nil {
+		t.Fatalf("Unexpected error at exactly the limit: %v", err)
+	}
+	if len(data) != len(exact) {
+		t.Errorf("Expected %d bytes, got %d", len(exact), len(data))
+	}
+}
+
+func TestAddShredSource(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	ShredSource = true
+	defer func() { ShredSource = false }()
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	content := []byte("sensitive plaintext")
+	sourcePath := CreateTempSourceFileWithName(t, content, "secret.txt")
+
+	if err := Add(file, sourcePath, 0); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	if _, err := os.Stat(sourcePath); !os.IsNotExist(err) {
+		t.Errorf("Source should have been removed after shredding, stat err: %v", err)
+	}
+	VerifyFileConsistency(t, file, 0, content)
+
+	keptPath := CreateTempSourceFileWithName(t, content, "kept.txt")
+	if err := Add(file, keptPath, TOTAL_FILES); err == nil {
+		t.Fatal("Expected Add to fail for out of range index")
+	}
+
+	data, err := os.ReadFile(keptPath)
+	if err != nil {
+		t.Fatalf("Source should be preserved when Add fails: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("Source content changed even though Add failed")
+	}
+}
+
+func TestAddConfirmSource(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	sourcePath := CreateTempSourceFile(t, []byte("original content"))
+
+	before, err := os.Stat(sourcePath)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+
+	if err := checkSourceUnchanged(before, before, int(before.Size())); err != nil {
+		t.Errorf("Unchanged source reported as changed: %v", err)
+	}
+
+	f, err := os.OpenFile(sourcePath, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	f.Write([]byte(" plus a concurrent append"))
+	f.Close()
+
+	after, err := os.Stat(sourcePath)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+
+	err = checkSourceUnchanged(before, after, int(before.Size()))
+	if err == nil {
+		t.Fatal("Expected size change to be detected")
+	}
+	if !strings.Contains(err.Error(), "changed while reading") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	ConfirmSource = true
+	defer func() { ConfirmSource = false }()
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	if err := Add(file, sourcePath, 0); err != nil {
+		t.Fatalf("Add of a stable source failed: %v", err)
+	}
+}
+
+func TestAddRefusesWhenMetadataFull(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	meta, err := ReadMeta(file)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+
+	// '<' is escaped to \u003c in JSON, so these names fill the metadata
+	// block well before all slots are used.
+	longName := strings.Repeat("<", MAX_FILE_NAME_SIZE)
+	target := -1
+	for i := range TOTAL_FILES {
+		meta.Files[i] = File{Name: longName, Size: 100}
+		if checkMetaFits(meta) != nil {
+			meta.Files[i] = File{}
+			target = i
+			break
+		}
+	}
+	if target < 0 {
+		t.Fatal("Expected metadata to fill up before all slots were used")
+	}
+
+	if err := WriteMeta(file, meta); err != nil {
+		t.Fatalf("WriteMeta failed: %v", err)
+	}
+
+	sourcePath := CreateTempSourceFileWithName(t, []byte("does not fit"), longName)
+	err = Add(file, sourcePath, target)
+	if err == nil {
+		t.Fatal("Expected Add to fail when metadata is full")
+	}
+	if !strings.Contains(err.Error(), "metadata too large") {
+		t.Errorf("Expected 'metadata too large' error, got: %v", err)
+	}
+
+	// Reading past the end of the file leaves the buffer zeroed.
+	block := make([]byte, MAX_FILE_SIZE)
+	file.ReadAt(block, int64(META_FILE_SIZE+(target*MAX_FILE_SIZE)))
+	if !bytes.Equal(block, make([]byte, MAX_FILE_SIZE)) {
+		t.Error("Add wrote an orphan block even though metadata did not fit")
+	}
+
+	meta, err = ReadMeta(file)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+	if meta.Files[target].Name != "" {
+		t.Error("Slot should still be empty")
+	}
+}
+
+// failingBlockFile fails the first write that starts at failAt after
+// writing only half of the buffer.
+type failingBlockFile struct {
+	F
+	failAt int64
+	failed bool
+}
+
+func (f *failingBlockFile) Write(p []byte) (int, error) {
+	pos, err := f.F.Seek(0, 1)
+	if err != nil {
+		return 0, err
+	}
+
+	if !f.failed && pos == f.failAt {
+		f.failed = true
+		n, _ := f.F.Write(p[:len(p)/2])
+		return n, errors.New("injected write failure")
+	}
+
+	return f.F.Write(p)
+}
+
+func TestAddPreserveOnError(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	original := []byte("original content that must survive")
+	if err := Add(file, CreateTempSourceFileWithName(t, original, "keep.txt"), 2); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	PreserveOnError = true
+	defer func() { PreserveOnError = false }()
+
+	faulty := &failingBlockFile{F: file, failAt: int64(META_FILE_SIZE + 2*MAX_FILE_SIZE)}
+	replacement := CreateTempSourceFileWithName(t, []byte("replacement"), "new.txt")
+	if err := Add(faulty, replacement, 2); err == nil {
+		t.Fatal("Expected Add to fail on injected write error")
+	}
+	if !faulty.failed {
+		t.Fatal("Write failure was not injected")
+	}
+
+	meta := VerifyMetadataIntegrity(t, file)
+	if meta.Files[2].Name != "keep.txt" {
+		t.Errorf("Expected metadata to still reference keep.txt, got %q", meta.Files[2].Name)
+	}
+
+	outPath := filepath.Join(t.TempDir(), "out.txt")
+	if err := Get(file, 2, outPath); err != nil {
+		t.Fatalf("Get failed after failed overwrite: %v", err)
+	}
+	data, _ := os.ReadFile(outPath)
+	if !bytes.Equal(data, original) {
+		t.Errorf("Original content not preserved: got %q", data)
+	}
+}
+
+// corruptingReadFile flips a byte in every positioned read that starts at
+// corruptAt, as a device that silently mangles a block would.
+type corruptingReadFile struct {
+	F
+	corruptAt int64
+	corrupted bool
+}
+
+func (f *corruptingReadFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.F.ReadAt(p, off)
+	if off == f.corruptAt && n > 0 {
+		p[0] ^= 0xff
+		f.corrupted = true
+	}
+	return n, err
+}
+
+func TestVerifyAfterAdd(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	original := []byte("original content that must survive")
+	if err := Add(file, CreateTempSourceFileWithName(t, original, "keep.txt"), 2); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	VerifyAfterAdd = true
+	defer func() { VerifyAfterAdd = false }()
+
+	if err := Add(file, CreateTempSourceFileWithName(t, []byte("verified"), "ok.txt"), 3); err != nil {
+		t.Fatalf("Add with --verify-after-add failed on a healthy file: %v", err)
+	}
+
+	faulty := &corruptingReadFile{F: file, corruptAt: int64(META_FILE_SIZE + 5*MAX_FILE_SIZE)}
+	if err := Add(faulty, CreateTempSourceFileWithName(t, []byte("lost"), "new.txt"), 5); err == nil {
+		t.Fatal("Expected Add to fail when the block reads back corrupted")
+	}
+	if !faulty.corrupted {
+		t.Fatal("Read corruption was not injected")
+	}
+
+	meta := VerifyMetadataIntegrity(t, file)
+	if meta.Files[5].Name != "" {
+		t.Errorf("Expected slot 5 to stay free, got %q", meta.Files[5].Name)
+	}
+	block, err := ReadBlock(file, 5)
+	if err != nil {
+		t.Fatalf("ReadBlock failed: %v", err)
+	}
+	if !bytes.Equal(block, make([]byte, MAX_FILE_SIZE)) {
+		t.Error("Expected the rolled back slot to be cleared")
+	}
+
+	faulty = &corruptingReadFile{F: file, corruptAt: int64(META_FILE_SIZE + 2*MAX_FILE_SIZE)}
+	if err := Add(faulty, CreateTempSourceFileWithName(t, []byte("replacement"), "new.txt"), 2); err == nil {
+		t.Fatal("Expected overwriting Add to fail when the block reads back corrupted")
+	}
+
+	meta = VerifyMetadataIntegrity(t, file)
+	if meta.Files[2].Name != "keep.txt" {
+		t.Errorf("Expected metadata to still reference keep.txt, got %q", meta.Files[2].Name)
+	}
+
+	var buf bytes.Buffer
+	if err := GetToWriter(file, 2, &buf); err != nil {
+		t.Fatalf("Get failed after rolled back overwrite: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), original) {
+		t.Errorf("Original content not restored: got %q", buf.Bytes())
+	}
+}
+
+func TestGetExpectedSHA256(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	content := []byte("content with a known checksum")
+	if err := Add(file, CreateTempSourceFile(t, content), 0); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	defer func() { ExpectedSHA256 = nil }()
+
+	sum := sha256.Sum256(content)
+	ExpectedSHA256 = sum[:]
+	outPath := filepath.Join(t.TempDir(), "ok.txt")
+	if err := Get(file, 0, outPath); err != nil {
+		t.Fatalf("Get with correct hash failed: %v", err)
+	}
+
+	wrong := sha256.Sum256([]byte("something else"))
+	ExpectedSHA256 = wrong[:]
+	badPath := filepath.Join(t.TempDir(), "bad.txt")
+	err := Get(file, 0, badPath)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("Expected checksum mismatch, got: %v", err)
+	}
+	if _, err := os.Stat(badPath); !os.IsNotExist(err) {
+		t.Error("Output file should not be written on checksum mismatch")
+	}
+}
+
+// removedDeviceFile starts failing every write with ENODEV once
+// writesLeft writes have gone through, like an unplugged USB stick.
+type removedDeviceFile struct {
+	F
+	writesLeft int
+	attempts   int
+}
+
+func (f *removedDeviceFile) Write(p []byte) (int, error) {
+	if f.writesLeft <= 0 {
+		f.attempts++
+		return 0, &os.PathError{Op: "write", Path: f.Name(), Err: syscall.ENODEV}
+	}
+	f.writesLeft--
+	return f.F.Write(p)
+}
+
+func TestDeviceRemovedMidOperation(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	mock := NewMockFile(0)
+	InitMeta(mock, "file")
+
+	gone := &removedDeviceFile{F: mock}
+	err := Add(gone, CreateTempSourceFile(t, []byte("never written")), 0)
+	if !errors.Is(err, ErrDeviceRemoved) {
+		t.Fatalf("Expected ErrDeviceRemoved from Add, got: %v", err)
+	}
+	if !errors.Is(err, syscall.ENODEV) {
+		t.Errorf("Expected the underlying ENODEV to be kept, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "device removed") {
+		t.Errorf("Expected a device removed message, got: %v", err)
+	}
+
+	FillSlots(t, mock, 3)
+
+	dst := &removedDeviceFile{F: NewMockFile(0), writesLeft: 2}
+	err = Sync(mock, dst)
+	if !errors.Is(err, ErrDeviceRemoved) {
+		t.Fatalf("Expected ErrDeviceRemoved from Sync, got: %v", err)
+	}
+	if dst.attempts != 1 {
+		t.Errorf("Expected Sync to stop at the first failed write, got %d attempts", dst.attempts)
+	}
+
+	other := &removedDeviceFile{F: mock}
+	if err := Add(other, "/nonexistent/path", 0); errors.Is(err, ErrDeviceRemoved) {
+		t.Errorf("Ordinary errors should not be reported as device removal: %v", err)
+	}
+}
+
+func TestAddDirIfChanged(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	IfChanged = true
+	defer func() { IfChanged = false }()
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("alpha"), 0o644)
+	os.WriteFile(filepath.Join(dir, "b.txt"), []byte("bravo"), 0o644)
+	os.WriteFile(filepath.Join(dir, "c.txt"), []byte("charlie"), 0o644)
+	os.Mkdir(filepath.Join(dir, "sub"), 0o755)
+
+	if err := AddDir(file, dir); err != nil {
+		t.Fatalf("First AddDir failed: %v", err)
+	}
+
+	meta := VerifyMetadataIntegrity(t, file)
+	if CountUsedSlots(meta) != 3 || meta.Wear.Adds != 3 {
+		t.Fatalf("Expected 3 files after first run, got %d slots and %d adds", CountUsedSlots(meta), meta.Wear.Adds)
+	}
+
+	output := captureOutput(func() {
+		if err := AddDir(file, dir); err != nil {
+			t.Errorf("Second AddDir failed: %v", err)
+		}
+	})
+
+	meta = VerifyMetadataIntegrity(t, file)
+	if meta.Wear.Adds != 3 {
+		t.Errorf("Expected unchanged files to be skipped, got %d adds", meta.Wear.Adds)
+	}
+	if strings.Count(output, "unchanged") != 3 {
+		t.Errorf("Expected 3 unchanged reports, got:\n%s", output)
+	}
+
+	os.WriteFile(filepath.Join(dir, "b.txt"), []byte("bravo, edited"), 0o644)
+	if err := AddDir(file, dir); err != nil {
+		t.Fatalf("Third AddDir failed: %v", err)
+	}
+
+	meta = VerifyMetadataIntegrity(t, file)
+	if meta.Wear.Adds != 4 || CountUsedSlots(meta) != 3 {
+		t.Errorf("Expected only the edited file to be re-added in place, got %d adds and %d slots", meta.Wear.Adds, CountUsedSlots(meta))
+	}
+	if meta.Files[1].Name != "b.txt" {
+		t.Errorf("Expected b.txt to keep slot 1, got %q", meta.Files[1].Name)
+	}
+
+	outPath := filepath.Join(t.TempDir(), "b.txt")
+	if err := Get(file, 1, outPath); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	data, _ := os.ReadFile(outPath)
+	if string(data) != "bravo, edited" {
+		t.Errorf("Expected edited content, got %q", data)
+	}
+}
+
+// failingWriteAtFile fails the first WriteAt at failAt after writing
+// only half of the buffer.
+type failingWriteAtFile struct {
+	F
+	failAt int64
+	failed bool
+}
+
+func (f *failingWriteAtFile) WriteAt(p []byte, off int64) (int, error) {
+	if !f.failed && off == f.failAt {
+		f.failed = true
+		n, _ := f.F.WriteAt(p[:len(p)/2], off)
+		return n, errors.New("injected write failure")
+	}
+
+	return f.F.WriteAt(p, off)
+}
+
+func TestAddIfChangedFailedOverwrite(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	IfChanged = true
+	defer func() { IfChanged = false }()
+	Silent = true
+	defer func() { Silent = false }()
+
+	file := NewMockFile(0)
+	if err := InitMeta(file, "file"); err != nil {
+		t.Fatalf("InitMeta failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("alpha"), 0o644)
+	os.WriteFile(filepath.Join(dir, "b.txt"), []byte("bravo"), 0o644)
+	if err := AddDirParallel(file, dir, 2); err != nil {
+		t.Fatalf("AddDirParallel failed: %v", err)
+	}
+	meta := VerifyMetadataIntegrity(t, file)
+	if meta.Files[1].Name != "b.txt" {
+		t.Fatalf("Expected b.txt in slot 1, got %q", meta.Files[1].Name)
+	}
+
+	check := func(stage string) {
+		t.Helper()
+		meta := VerifyMetadataIntegrity(t, file)
+		if meta.Files[1].Name != "b.txt" {
+			t.Fatalf("%s: expected b.txt to stay in slot 1, got %q", stage, meta.Files[1].Name)
+		}
+		var out bytes.Buffer
+		if err := GetToWriter(file, 1, &out); err != nil {
+			t.Fatalf("%s: the old entry no longer reads: %v", stage, err)
+		}
+		if out.String() != "bravo" {
+			t.Errorf("%s: expected the old content, got %q", stage, out.String())
+		}
+	}
+
+	os.WriteFile(filepath.Join(dir, "b.txt"), []byte("bravo, edited"), 0o644)
+	slot1 := int64(META_FILE_SIZE + MAX_FILE_SIZE)
+
+	faulty := &failingWriteAtFile{F: file, failAt: slot1}
+	if err := AddDirParallel(faulty, dir, 2); err == nil {
+		t.Fatal("Expected the failed overwrite to be reported")
+	}
+	if !faulty.failed {
+		t.Fatal("The overwrite did not hit the injected failure")
+	}
+	check("AddDirParallel")
+
+	failing := &failingBlockFile{F: file, failAt: slot1}
+	if err := Add(failing, filepath.Join(dir, "b.txt"), OUT_OF_BOUNDS_INDEX); err == nil {
+		t.Fatal("Expected the failed overwrite to be reported")
+	}
+	if !failing.failed {
+		t.Fatal("The overwrite did not hit the injected failure")
+	}
+	check("Add")
+}
+
+func TestAddDirParallel(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	Silent = true
+	defer func() { Silent = false }()
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	// Slot 0 is in use, so the new files must go around it.
+	Add(file, CreateTempSourceFile(t, []byte("existing")), 0)
+
+	dir := t.TempDir()
+	contents := make(map[string][]byte)
+	for i := range 12 {
+		name := fmt.Sprintf("file_%02d.bin", i)
+		contents[name] = GenerateRandomBytes(100 + i*2000)
+		os.WriteFile(filepath.Join(dir, name), contents[name], 0o644)
+	}
+	os.Mkdir(filepath.Join(dir, "sub"), 0o755)
+
+	before := VerifyMetadataIntegrity(t, file).Wear.MetaWrites
+
+	if err := AddDirParallel(file, dir, 4); err != nil {
+		t.Fatalf("AddDirParallel failed: %v", err)
+	}
+
+	meta := VerifyMetadataIntegrity(t, file)
+	if CountUsedSlots(meta) != 13 || meta.Wear.Adds != 13 {
+		t.Fatalf("Expected 13 files, got %d slots and %d adds", CountUsedSlots(meta), meta.Wear.Adds)
+	}
+	if meta.Wear.MetaWrites != before+1 {
+		t.Errorf("Expected a single metadata write, got %d", meta.Wear.MetaWrites-before)
+	}
+	if meta.Files[0].Name == "" || contents[meta.Files[0].Name] != nil {
+		t.Error("Expected the existing file to keep slot 0")
+	}
+
+	outDir := t.TempDir()
+	for i := 1; i <= 12; i++ {
+		f := meta.Files[i]
+		expected, ok := contents[f.Name]
+		if !ok {
+			t.Errorf("Slot %d: unexpected file %q", i, f.Name)
+			continue
+		}
+
+		outPath := filepath.Join(outDir, f.Name)
+		if err := Get(file, i, outPath); err != nil {
+			t.Fatalf("Get failed for %s: %v", f.Name, err)
+		}
+		data, _ := os.ReadFile(outPath)
+		if !bytes.Equal(data, expected) {
+			t.Errorf("Content mismatch for %s", f.Name)
+		}
+		delete(contents, f.Name)
+	}
+	if len(contents) != 0 {
+		t.Errorf("Files not stored: %d", len(contents))
+	}
+}
+
+func TestAddMinFree(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	MinFree = 5
+	defer func() { MinFree = 0 }()
+
+	// The reserve only looks at the file table, so the slots are marked
+	// used there instead of being encrypted one by one.
+	meta, err := ReadMeta(file)
+	if err != nil {
+		t.Fatalf("Failed to read metadata: %v", err)
+	}
+	for i := range TOTAL_FILES - MinFree - 1 {
+		meta.Files[i] = File{Name: fmt.Sprintf("dummy_%d", i), Size: 100}
+	}
+	if err := WriteMeta(file, meta); err != nil {
+		t.Fatalf("Failed to write metadata: %v", err)
+	}
+
+	sourcePath := CreateTempSourceFile(t, []byte("last unreserved slot"))
+	if err := Add(file, sourcePath, OUT_OF_BOUNDS_INDEX); err != nil {
+		t.Fatalf("Add down to the reserve failed: %v", err)
+	}
+
+	sourcePath = CreateTempSourceFile(t, []byte("into the reserve"))
+	err = Add(file, sourcePath, OUT_OF_BOUNDS_INDEX)
+	if err == nil || !strings.Contains(err.Error(), "--min-free") {
+		t.Fatalf("Expected auto-placed add to hit the reserve, got %v", err)
+	}
+	if err := Add(file, sourcePath, TOTAL_FILES-1); err == nil {
+		t.Fatal("Expected explicit add into a free slot to hit the reserve")
+	}
+
+	if err := Add(file, sourcePath, 0); err != nil {
+		t.Errorf("Overwriting a used slot should not hit the reserve: %v", err)
+	}
+
+	Force = true
+	defer func() { Force = false }()
+	if err := Add(file, sourcePath, OUT_OF_BOUNDS_INDEX); err != nil {
+		t.Fatalf("Add with --force failed: %v", err)
+	}
+
+	meta, err = ReadMeta(file)
+	if err != nil {
+		t.Fatalf("Failed to read metadata: %v", err)
+	}
+	if free := TOTAL_FILES - CountUsedSlots(meta); free != MinFree-1 {
+		t.Errorf("Expected %d free slots, got %d", MinFree-1, free)
+	}
+}
+
+func benchmarkAddDir(b *testing.B, parallel bool) {
+	SetupTestKey(&testing.T{})
+	file := CreateTempTestFile(&testing.T{}, META_FILE_SIZE+(TOTAL_FILES*MAX_FILE_SIZE))
+	defer file.Close()
+
+	dir := b.TempDir()
+	for i := range 20 {
+		os.WriteFile(filepath.Join(dir, fmt.Sprintf("file_%02d.bin", i)), GenerateRandomBytes(20000), 0o644)
+	}
+
+	Silent = true
+	defer func() { Silent = false }()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		InitMeta(file, "file")
+		b.StartTimer()
+
+		if parallel {
+			AddDirParallel(file, dir, runtime.NumCPU())
+		} else {
+			AddDir(file, dir)
+		}
+	}
+}
+
+func BenchmarkAddDirSequential(b *testing.B) {
+	benchmarkAddDir(b, false)
+}
+
+func BenchmarkAddDirParallel(b *testing.B) {
+	benchmarkAddDir(b, true)
+}
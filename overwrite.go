@@ -1,13 +1,51 @@
 package main
 
 import (
+	"crypto/rand"
 	"fmt"
-	"log"
+	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
-func Overwrite(file F, start int64, end uint64) error {
+// Overwrite erases [start,end) in the given number of passes, seeking
+// back to start and syncing for each. The passes cycle through 0xFF,
+// random data and zeros, ending with zeros, so the range is left zeroed
+// unless EraseRandom is set. A single pass writes zeros only.
+func Overwrite(file F, start int64, end uint64, passes int) error {
+	for pass := range max(passes, 1) {
+		if err := overwritePass(file, start, end, pass, passes); err != nil {
+			return fmt.Errorf("failed on pass %d of %d: %w", pass+1, passes, err)
+		}
+	}
+	return nil
+}
+
+// passPattern fills chunk for pass out of passes, see Overwrite. With
+// EraseRandom every pass is random data. It is called for every chunk
+// written, so random chunks never repeat.
+func passPattern(chunk []byte, pass int, passes int) error {
+	if EraseRandom {
+		return fillNoise(chunk)
+	}
+
+	switch (max(passes, 1) - 1 - pass) % 3 {
+	case 0:
+		clear(chunk)
+	case 1:
+		if _, err := rand.Read(chunk); err != nil {
+			return fmt.Errorf("failed to generate random data: %w", err)
+		}
+	case 2:
+		for i := range chunk {
+			chunk[i] = 0xFF
+		}
+	}
+	return nil
+}
+
+func overwritePass(file F, start int64, end uint64, pass int, passes int) error {
 	chunk := make([]byte, ERASE_CHUNK_SIZE)
 
 	_, err := file.Seek(start, 0)
@@ -32,6 +70,9 @@ func Overwrite(file F, start int64, end uint64) error {
 			chunk = chunk[:missing]
 		}
 
+		if err := passPattern(chunk, pass, passes); err != nil {
+			return err
+		}
 		n, err := file.Write(chunk)
 		if err != nil {
 			return fmt.Errorf("failed to write chunk: %w", err)
@@ -45,22 +86,114 @@ func Overwrite(file F, start int64, end uint64) error {
 	}
 }
 
-func OverwriteDevice(file F) error {
+// OverwriteParallel erases [start,end) like Overwrite, but splits the
+// range into chunks written by workers at disjoint offsets with WriteAt,
+// and syncs once at the end of each pass. Some media handle concurrent
+// writes poorly, so callers only use it when asked for more than one
+// thread.
+func OverwriteParallel(file F, start int64, end uint64, workers int, passes int) error {
+	if workers <= 1 {
+		return Overwrite(file, start, end, passes)
+	}
+
+	for pass := range max(passes, 1) {
+		if err := overwriteParallelPass(file, start, end, workers, pass, passes); err != nil {
+			return fmt.Errorf("failed on pass %d of %d: %w", pass+1, passes, err)
+		}
+	}
+	return nil
+}
+
+func overwriteParallelPass(file F, start int64, end uint64, workers int, pass int, passes int) error {
+	offsets := make(chan int64)
+	errs := make(chan error, workers)
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			chunk := make([]byte, ERASE_CHUNK_SIZE)
+			for off := range offsets {
+				size := min(uint64(ERASE_CHUNK_SIZE), end-uint64(off))
+				if err := passPattern(chunk[:size], pass, passes); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := file.WriteAt(chunk[:size], off); err != nil {
+					errs <- fmt.Errorf("failed to write chunk at %d: %w", off, err)
+					return
+				}
+			}
+		}()
+	}
+
+	var err error
+send:
+	for off := start; uint64(off) < end; off += ERASE_CHUNK_SIZE {
+		select {
+		case offsets <- off:
+		case err = <-errs:
+			break send
+		}
+	}
+	close(offsets)
+	wg.Wait()
+
+	if err == nil {
+		select {
+		case err = <-errs:
+		default:
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync: %w", err)
+	}
+
+	return nil
+}
+
+// OverwriteDevice erases the whole device, or regular file, from the start
+// in the given number of passes, see Overwrite for the patterns.
+func OverwriteDevice(file F, passes int) error {
+	var total uint64
+	for pass := range max(passes, 1) {
+		n, err := overwriteDevicePass(file, pass, passes)
+		if err != nil {
+			return fmt.Errorf("failed on pass %d of %d: %w", pass+1, passes, err)
+		}
+		total = n
+	}
+
+	PrintSuccess(fmt.Sprintf("Device overwrite complete: %s",
+		C(ColorWhite, fmt.Sprintf("%d MB", total/1_000_000))))
+
+	return nil
+}
+
+// overwriteDevicePass writes one pass over the device and returns the
+// number of bytes written.
+func overwriteDevicePass(file F, pass int, passes int) (uint64, error) {
 	chunk := make([]byte, ERASE_CHUNK_SIZE)
 
 	stat, err := file.Stat()
 	if err != nil {
-		return fmt.Errorf("failed to stat: %w", err)
+		return 0, fmt.Errorf("failed to stat: %w", err)
 	}
 
 	_, err = file.Seek(0, 0)
 	if err != nil {
-		return fmt.Errorf("failed to seek to start: %w", err)
+		return 0, fmt.Errorf("failed to seek to start: %w", err)
 	}
 
 	var total uint64 = 0
 	var maxSize uint64 = 0
 	isRegularFile := stat.Mode().IsRegular()
+	var progress progressLimiter
 
 	if isRegularFile {
 		currentPos, _ := file.Seek(0, 1)
@@ -74,26 +207,26 @@ func OverwriteDevice(file F) error {
 		if isRegularFile && total+chunkSize > maxSize {
 			remaining := maxSize - total
 			if remaining == 0 {
-				PrintSuccess(fmt.Sprintf("Device overwrite complete: %s",
-					C(ColorWhite, fmt.Sprintf("%d MB", total/1_000_000))))
-				return nil
+				return total, nil
 			}
 			chunkSize = remaining
 		}
 
+		if err := passPattern(chunk[:chunkSize], pass, passes); err != nil {
+			return total, err
+		}
+
 		writeStart := time.Now()
 		n, err := file.Write(chunk[:chunkSize])
 		if err != nil {
 			if strings.Contains(err.Error(), "no space left on device") {
-				PrintSuccess(fmt.Sprintf("Device overwrite complete: %s",
-					C(ColorWhite, fmt.Sprintf("%d MB", total/1_000_000))))
-				return nil
+				return total, nil
 			}
-			return fmt.Errorf("failed to write chunk: %w", err)
+			return total, fmt.Errorf("failed to write chunk: %w", err)
 		}
 
 		if err := file.Sync(); err != nil {
-			return fmt.Errorf("failed to sync: %w", err)
+			return total, fmt.Errorf("failed to sync: %w", err)
 		}
 
 		total += uint64(n)
@@ -102,10 +235,52 @@ func OverwriteDevice(file F) error {
 			time.Sleep(3 * time.Second)
 		}
 
-		if !Silent {
-			log.Printf("%s %s\n",
-				C(ColorLightBlue, "Written:"),
-				C(ColorWhite, fmt.Sprintf("%d MB", total/1_000_000)))
+		if progress.due() {
+			LogInfo("written: %d MB", total/1_000_000)
+		}
+	}
+}
+
+// ShredFile overwrites a regular file with random data, syncs it and then
+// removes it.
+func ShredFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+
+	s, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	chunk := make([]byte, ERASE_CHUNK_SIZE)
+	for remaining := s.Size(); remaining > 0; {
+		size := min(remaining, int64(len(chunk)))
+		if _, err := rand.Read(chunk[:size]); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to generate random data: %w", err)
 		}
+		if _, err := f.Write(chunk[:size]); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to overwrite file: %w", err)
+		}
+		remaining -= size
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove file: %w", err)
 	}
+
+	return nil
 }
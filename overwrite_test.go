@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"testing"
 	"time"
 )
@@ -18,7 +19,7 @@ func TestOverwriteSmallRange(t *testing.T) {
 		file.data[i] = 0xFF
 	}
 
-	Overwrite(file, 0, ERASE_CHUNK_SIZE)
+	Overwrite(file, 0, ERASE_CHUNK_SIZE, 1)
 
 	for i := 0; i < ERASE_CHUNK_SIZE; i++ {
 		if file.data[i] != 0 {
@@ -51,7 +52,7 @@ func TestOverwriteFromOffset(t *testing.T) {
 	startOffset := int64(2 * ERASE_CHUNK_SIZE)
 	endOffset := uint64(4 * ERASE_CHUNK_SIZE)
 
-	Overwrite(file, startOffset, endOffset)
+	Overwrite(file, startOffset, endOffset, 1)
 
 	for i := 0; i < int(startOffset); i++ {
 		if file.data[i] != 0xAA {
@@ -89,7 +90,7 @@ func TestOverwritePartialChunk(t *testing.T) {
 		file.data[i] = 0xBB
 	}
 
-	Overwrite(file, 0, uint64(size))
+	Overwrite(file, 0, uint64(size), 1)
 
 	for i := 0; i < size; i++ {
 		if file.data[i] != 0 {
@@ -108,7 +109,7 @@ func TestOverwriteZeroLength(t *testing.T) {
 		file.data[i] = 0xCC
 	}
 
-	Overwrite(file, 0, 0)
+	Overwrite(file, 0, 0, 1)
 
 	for i := 0; i < len(file.data); i++ {
 		if file.data[i] != 0xCC {
@@ -132,7 +133,7 @@ func TestOverwriteMultipleChunks(t *testing.T) {
 		file.data[i] = 0xDD
 	}
 
-	Overwrite(file, 0, uint64(size))
+	Overwrite(file, 0, uint64(size), 1)
 
 	for i := 0; i < size; i++ {
 		if file.data[i] != 0 {
@@ -142,6 +143,113 @@ func TestOverwriteMultipleChunks(t *testing.T) {
 	}
 }
 
+// passRecorder notes the first byte written after each seek, which is the
+// start of an Overwrite pass.
+type passRecorder struct {
+	*MockFile
+	firsts  []byte
+	seeking bool
+}
+
+func (p *passRecorder) Seek(offset int64, whence int) (int64, error) {
+	p.seeking = true
+	return p.MockFile.Seek(offset, whence)
+}
+
+func (p *passRecorder) Write(b []byte) (int, error) {
+	if p.seeking && len(b) > 0 {
+		p.firsts = append(p.firsts, b[0])
+		p.seeking = false
+	}
+	return p.MockFile.Write(b)
+}
+
+func TestOverwriteMultiplePasses(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	if testing.Short() {
+		t.Skip("Skipping multi-pass overwrite test in short mode")
+	}
+
+	size := 3 * ERASE_CHUNK_SIZE
+	file := &passRecorder{MockFile: NewMockFile(size)}
+
+	for i := 0; i < len(file.data); i++ {
+		file.data[i] = 0xDD
+	}
+
+	start := int64(ERASE_CHUNK_SIZE / 2)
+	end := uint64(size - 1000)
+
+	if err := Overwrite(file, start, end, 3); err != nil {
+		t.Fatalf("Overwrite failed: %v", err)
+	}
+
+	if len(file.firsts) != 3 {
+		t.Fatalf("Expected 3 passes, got %d", len(file.firsts))
+	}
+	if file.firsts[0] != 0xFF {
+		t.Errorf("Expected the first pass to write 0xFF, got %#x", file.firsts[0])
+	}
+
+	for i := 0; i < size; i++ {
+		inRange := int64(i) >= start && uint64(i) < end
+		if inRange && file.data[i] != 0 {
+			t.Fatalf("Byte at position %d not zeroed: %d", i, file.data[i])
+		}
+		if !inRange && file.data[i] != 0xDD {
+			t.Fatalf("Byte at position %d should be unchanged: %d", i, file.data[i])
+		}
+	}
+}
+
+func TestOverwriteRandom(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	if testing.Short() {
+		t.Skip("Skipping random overwrite test in short mode")
+	}
+
+	EraseRandom = true
+	defer func() { EraseRandom = false }()
+
+	size := 3 * ERASE_CHUNK_SIZE
+	file := NewMockFile(size)
+
+	for i := 0; i < len(file.data); i++ {
+		file.data[i] = 0xDD
+	}
+
+	start := int64(1000)
+	end := uint64(size - 1000)
+
+	if err := Overwrite(file, start, end, 1); err != nil {
+		t.Fatalf("Overwrite failed: %v", err)
+	}
+
+	erased := file.data[start:end]
+	if e := entropy(erased); e < 7.99 {
+		t.Errorf("Expected random data, entropy is %.3f bits per byte", e)
+	}
+	if bytes.Contains(erased, make([]byte, 64)) {
+		t.Error("Erased region contains a long zero run")
+	}
+
+	// Each chunk is generated afresh, not one buffer written repeatedly.
+	first := file.data[start : start+4096]
+	second := file.data[start+ERASE_CHUNK_SIZE : start+ERASE_CHUNK_SIZE+4096]
+	if bytes.Equal(first, second) {
+		t.Error("Expected every chunk to hold different random data")
+	}
+
+	for i := 0; i < size; i++ {
+		inRange := int64(i) >= start && uint64(i) < end
+		if !inRange && file.data[i] != 0xDD {
+			t.Fatalf("Byte at position %d should be unchanged: %d", i, file.data[i])
+		}
+	}
+}
+
 func TestOverwriteSeekPosition(t *testing.T) {
 	defer LogTestDuration(t, time.Now())
 
@@ -150,7 +258,7 @@ func TestOverwriteSeekPosition(t *testing.T) {
 	file.Seek(1000, 0)
 
 	startPos := int64(ERASE_CHUNK_SIZE / 2)
-	Overwrite(file, startPos, uint64(ERASE_CHUNK_SIZE))
+	Overwrite(file, startPos, uint64(ERASE_CHUNK_SIZE), 1)
 
 	for i := int(startPos); i < ERASE_CHUNK_SIZE; i++ {
 		if file.data[i] != 0 {
@@ -178,7 +286,7 @@ func TestOverwriteFilesystemMetadata(t *testing.T) {
 	sourcePath := CreateTempSourceFile(t, content)
 	Add(file, sourcePath, 0)
 
-	Overwrite(file, 0, uint64(META_FILE_SIZE+(TOTAL_FILES*MAX_FILE_SIZE)))
+	Overwrite(file, 0, uint64(META_FILE_SIZE+(TOTAL_FILES*MAX_FILE_SIZE)), 1)
 
 	file.Seek(0, 0)
 	buf := make([]byte, META_FILE_SIZE)
@@ -223,7 +331,7 @@ func TestOverwriteAndReinitialize(t *testing.T) {
 	sourcePath := CreateTempSourceFile(t, content)
 	Add(file, sourcePath, 0)
 
-	Overwrite(file, 0, uint64(META_FILE_SIZE+(10*MAX_FILE_SIZE)))
+	Overwrite(file, 0, uint64(META_FILE_SIZE+(10*MAX_FILE_SIZE)), 1)
 
 	InitMeta(file, "file")
 
@@ -258,7 +366,7 @@ func TestOverwriteProgress(t *testing.T) {
 		file.data[i] = byte(i % 256)
 	}
 
-	Overwrite(file, 0, uint64(size))
+	Overwrite(file, 0, uint64(size), 1)
 
 	for i := 0; i < size; i++ {
 		if file.data[i] != 0 {
@@ -315,7 +423,7 @@ func TestOverwriteBoundaryConditions(t *testing.T) {
 				file.data[i] = 0xFF
 			}
 
-			Overwrite(file, tt.start, tt.end)
+			Overwrite(file, tt.start, tt.end, 1)
 
 			for i := int(tt.start); i < int(tt.end) && i < len(file.data); i++ {
 				if file.data[i] != 0 {
@@ -341,7 +449,7 @@ func BenchmarkOverwrite1MB(b *testing.B) {
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		file.Seek(0, 0)
-		Overwrite(file, 0, uint64(size))
+		Overwrite(file, 0, uint64(size), 1)
 	}
 }
 
@@ -352,6 +460,41 @@ func BenchmarkOverwrite10MB(b *testing.B) {
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		file.Seek(0, 0)
-		Overwrite(file, 0, uint64(size))
+		Overwrite(file, 0, uint64(size), 1)
+	}
+}
+
+func TestOverwriteParallel(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	if testing.Short() {
+		t.Skip("Skipping parallel overwrite test in short mode")
+	}
+
+	size := 10*ERASE_CHUNK_SIZE + 12345
+	file := NewMockFile(size)
+
+	for i := 0; i < len(file.data); i++ {
+		file.data[i] = 0xEE
+	}
+
+	start := int64(ERASE_CHUNK_SIZE / 2)
+	end := uint64(size - 100)
+
+	if err := OverwriteParallel(file, start, end, 4, 1); err != nil {
+		t.Fatalf("OverwriteParallel failed: %v", err)
+	}
+
+	if len(file.data) != size {
+		t.Fatalf("Expected file size %d, got %d", size, len(file.data))
+	}
+	for i := 0; i < size; i++ {
+		inRange := int64(i) >= start && uint64(i) < end
+		if inRange && file.data[i] != 0 {
+			t.Fatalf("Byte at position %d not zeroed: %d", i, file.data[i])
+		}
+		if !inRange && file.data[i] != 0xEE {
+			t.Fatalf("Byte at position %d should be unchanged: %d", i, file.data[i])
+		}
 	}
 }
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+)
+
+// ChangePassword re-encrypts every file, hashed name and the metadata
+// under newPass and a fresh salt. All files are decrypted with oldPass
+// before anything is written, so a wrong password, a missing --context or
+// a corrupt block leaves the volume untouched.
+//
+// The new salt is recorded in the metadata, still under oldPass, before
+// the first block is rewritten. If the blocks are interrupted, running
+// ChangePassword again with the same passwords picks up that salt, keeps
+// the blocks already under the new key and finishes the rest.
+//
+// On a hidden volume the final metadata is written through the header pad
+// of newPass, so the hidden volume opens with the new password afterwards.
+//
+// Metadata backups are still under the old password and are cleared, so
+// rollback history starts over. Blocks of deleted files kept for it stay
+// under the old key until trim.
+func ChangePassword(file F, oldPass, newPass string) (err error) {
+	defer func() { err = checkDevice(err) }()
+
+	if err := validatePassword(newPass); err != nil {
+		return err
+	}
+
+	setCachedPassword(oldPass)
+	meta, err := ReadMeta(file)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	oldKey, err := DeriveKey(oldPass, meta.Salt)
+	if err != nil {
+		return fmt.Errorf("failed to derive key: %w", err)
+	}
+	defer zeroBytes(oldKey)
+
+	resuming := meta.PendingSalt != nil
+	salt := meta.PendingSalt
+	if !resuming {
+		salt, err = GenerateSalt()
+		if err != nil {
+			return fmt.Errorf("failed to generate salt: %w", err)
+		}
+	}
+	newKey, err := DeriveKey(newPass, salt)
+	if err != nil {
+		return fmt.Errorf("failed to derive key: %w", err)
+	}
+	defer zeroBytes(newKey)
+
+	current := *meta
+	blocks := make([][]byte, TOTAL_FILES)
+	count := 0
+	for i, f := range meta.Files {
+		if f.Name == "" {
+			continue
+		}
+
+		block, err := ReadBlock(file, i)
+		if err != nil {
+			return fmt.Errorf("failed to read slot %d: %w", i, err)
+		}
+		meta.Files[i], err = rekeySlot(block, f, oldKey, newKey, salt, resuming)
+		if err != nil {
+			return fmt.Errorf("slot %d: %w", i, err)
+		}
+		blocks[i] = block
+		count++
+	}
+
+	if resuming {
+		LogInfo("resuming an interrupted password change")
+	} else {
+		// The journal is the metadata as it was, plus the new salt. It is
+		// sealed under oldPass, so a hidden volume writes it through the
+		// header pad of oldPass for a resumed run to find it.
+		journal := current
+		journal.PendingSalt = salt
+		if err := WriteMeta(volumeFor(file, oldPass), &journal); err != nil {
+			return fmt.Errorf("failed to record the new salt: %w", err)
+		}
+		meta.Wear = journal.Wear
+	}
+	LogInfo("new salt %x, pass it to reindex --salt if the metadata is lost before this finishes", salt)
+
+	for i, block := range blocks {
+		if block == nil {
+			continue
+		}
+		if err := WriteBlock(file, block, meta.Files[i].Name, i); err != nil {
+			return fmt.Errorf("failed to write slot %d: %w", i, err)
+		}
+		meta.Wear.BytesWritten += MAX_FILE_SIZE
+	}
+
+	meta.Salt = salt
+	meta.PendingSalt = nil
+	setCachedPassword(newPass)
+	if err := WriteMeta(volumeFor(file, newPass), meta); err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+
+	for n := range meta.Backups {
+		if _, err := file.WriteAt(make([]byte, META_FILE_SIZE), metaBackupOffset(n)); err != nil {
+			return fmt.Errorf("failed to clear metadata backup %d: %w", n, err)
+		}
+	}
+	if meta.Backups > 0 {
+		if err := file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync: %w", err)
+		}
+	}
+
+	PrintSuccess(fmt.Sprintf("Password changed, %s re-encrypted",
+		C(ColorBold+ColorWhite, fmt.Sprintf("%d files", count))))
+
+	return nil
+}
+
+// rekeySlot re-encrypts the ciphertext at the start of block in place from
+// oldKey to newKey, the key for salt. The padding after it is kept, so
+// noise volumes stay noise. A sealed name is re-encrypted too and its hash
+// recomputed, since hashes are salted. It returns the updated entry for f.
+// When resuming, a block that already opens under newKey is left as it is.
+func rekeySlot(block []byte, f File, oldKey, newKey, salt []byte, resuming bool) (File, error) {
+	aad, err := fileAAD(f)
+	if err != nil {
+		return f, err
+	}
+
+	content, err := decryptWithKey(block[:f.Size], oldKey, aad)
+	if err != nil && resuming {
+		if _, nerr := decryptWithKey(block[:f.Size], newKey, aad); nerr == nil {
+			return rekeyName(f, oldKey, newKey, salt)
+		}
+	}
+	if err != nil {
+		return f, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	encrypted, err := encryptWithKey(content, newKey, aad)
+	zeroBytes(content)
+	if err != nil {
+		return f, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	if len(encrypted) != f.Size {
+		return f, fmt.Errorf("internal error: ciphertext size %d, expected %d", len(encrypted), f.Size)
+	}
+	copy(block, encrypted)
+
+	return rekeyName(f, oldKey, newKey, salt)
+}
+
+// rekeyName moves a sealed name from oldKey to newKey and rehashes it.
+// Entries without one are returned unchanged.
+func rekeyName(f File, oldKey, newKey, salt []byte) (File, error) {
+	if len(f.SealedName) > 0 {
+		name, err := decryptWithKey(f.SealedName, oldKey, nil)
+		if err != nil {
+			return f, fmt.Errorf("failed to decrypt name: %w", err)
+		}
+		f.SealedName, err = encryptWithKey(name, newKey, nil)
+		if err != nil {
+			return f, fmt.Errorf("failed to encrypt name: %w", err)
+		}
+		f.Name = hashName(salt, string(name))
+	}
+
+	return f, nil
+}
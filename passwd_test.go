@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestChangePassword(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	oldPass, err := GetEncKey()
+	if err != nil {
+		t.Fatalf("Failed to get encryption key: %v", err)
+	}
+	newPass := "a-completely-new-password"
+
+	file := NewMockFile(META_FILE_SIZE)
+	if err := InitMeta(file, "file"); err != nil {
+		t.Fatalf("InitMeta failed: %v", err)
+	}
+
+	contents := map[int][]byte{
+		0: []byte("first file"),
+		4: GenerateRandomBytes(20000),
+	}
+	for i, content := range contents {
+		sourcePath := CreateTempSourceFile(t, content)
+		if err := Add(file, sourcePath, i); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	NameHash = true
+	sourcePath := CreateTempSourceFileWithName(t, []byte("hashed"), "secret-name.txt")
+	err = Add(file, sourcePath, 7)
+	NameHash = false
+	if err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	contents[7] = []byte("hashed")
+
+	before, err := ReadMeta(file)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+
+	if err := ChangePassword(file, oldPass, newPass); err != nil {
+		t.Fatalf("ChangePassword failed: %v", err)
+	}
+
+	SetPasswordForTesting(oldPass)
+	if _, err := ReadMeta(file); err == nil {
+		t.Error("Expected the old password to be rejected")
+	}
+
+	SetPasswordForTesting(newPass)
+	meta := VerifyMetadataIntegrity(t, file)
+	if bytes.Equal(meta.Salt, before.Salt) {
+		t.Error("Expected a fresh salt")
+	}
+
+	for i, content := range contents {
+		var out bytes.Buffer
+		if err := GetToWriter(file, i, &out); err != nil {
+			t.Fatalf("Get %d failed: %v", i, err)
+		}
+		if !bytes.Equal(out.Bytes(), content) {
+			t.Errorf("Slot %d: content differs after password change", i)
+		}
+	}
+
+	if name := realName(meta.Files[7], newPass, meta.Salt); name != "secret-name.txt" {
+		t.Errorf("Expected hashed name to survive, got %q", name)
+	}
+	if meta.Files[7].Name != hashName(meta.Salt, "secret-name.txt") {
+		t.Error("Expected the name hash to follow the new salt")
+	}
+}
+
+func TestChangePasswordWrongOldPassword(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := NewMockFile(META_FILE_SIZE)
+	if err := InitMeta(file, "file"); err != nil {
+		t.Fatalf("InitMeta failed: %v", err)
+	}
+	FillSlots(t, file, 2)
+
+	snapshot := bytes.Clone(file.data)
+
+	if err := ChangePassword(file, "not-the-right-password", "a-completely-new-password"); err == nil {
+		t.Fatal("Expected a wrong old password to fail")
+	}
+	if !bytes.Equal(file.data, snapshot) {
+		t.Error("Expected the volume to be left untouched")
+	}
+
+	if err := ChangePassword(file, "test-password-for-testing", "short"); err == nil {
+		t.Error("Expected a short new password to be rejected")
+	}
+}
+
+// interruptedFile stops accepting writes to the slot area once blocksLeft
+// blocks have gone through, like a process killed halfway through.
+type interruptedFile struct {
+	F
+	blocksLeft int
+}
+
+func (f *interruptedFile) Write(p []byte) (int, error) {
+	pos, err := f.F.Seek(0, 1)
+	if err != nil {
+		return 0, err
+	}
+	if pos >= META_FILE_SIZE {
+		if f.blocksLeft == 0 {
+			return 0, errors.New("interrupted")
+		}
+		f.blocksLeft--
+	}
+	return f.F.Write(p)
+}
+
+func TestChangePasswordResumesAfterInterruption(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	oldPass, err := GetEncKey()
+	if err != nil {
+		t.Fatalf("Failed to get encryption key: %v", err)
+	}
+	newPass := "a-completely-new-password"
+
+	file := NewMockFile(META_FILE_SIZE)
+	if err := InitMeta(file, "file"); err != nil {
+		t.Fatalf("InitMeta failed: %v", err)
+	}
+
+	contents := map[int][]byte{
+		1: []byte("first file"),
+		2: []byte("second file"),
+		6: GenerateRandomBytes(5000),
+	}
+	for i, content := range contents {
+		if err := Add(file, CreateTempSourceFile(t, content), i); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	NameHash = true
+	err = Add(file, CreateTempSourceFileWithName(t, []byte("hashed"), "secret-name.txt"), 3)
+	NameHash = false
+	if err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	contents[3] = []byte("hashed")
+
+	faulty := &interruptedFile{F: file, blocksLeft: 2}
+	if err := ChangePassword(faulty, oldPass, newPass); err == nil {
+		t.Fatal("Expected the interrupted password change to fail")
+	}
+	if faulty.blocksLeft != 0 {
+		t.Fatal("Interruption did not happen between block writes")
+	}
+
+	SetPasswordForTesting(oldPass)
+	meta := VerifyMetadataIntegrity(t, file)
+	if meta.PendingSalt == nil {
+		t.Fatal("Expected the new salt to be recorded before the blocks were rewritten")
+	}
+
+	if err := ChangePassword(file, oldPass, newPass); err != nil {
+		t.Fatalf("Resumed ChangePassword failed: %v", err)
+	}
+
+	SetPasswordForTesting(newPass)
+	meta = VerifyMetadataIntegrity(t, file)
+	if meta.PendingSalt != nil {
+		t.Error("Expected the pending salt to be cleared")
+	}
+
+	for i, content := range contents {
+		var out bytes.Buffer
+		if err := GetToWriter(file, i, &out); err != nil {
+			t.Fatalf("Get %d failed after resuming: %v", i, err)
+		}
+		if !bytes.Equal(out.Bytes(), content) {
+			t.Errorf("Slot %d: content differs after resuming", i)
+		}
+	}
+
+	if name := realName(meta.Files[3], newPass, meta.Salt); name != "secret-name.txt" {
+		t.Errorf("Expected hashed name to survive, got %q", name)
+	}
+}
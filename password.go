@@ -9,19 +9,40 @@ import (
 )
 
 var (
-	// Cache the password for the duration of the program execution
-	cachedPassword string
+	// Cache the password for the duration of the program execution. It is
+	// held as bytes so it can be wiped; the strings GetPassword hands out
+	// are copies that live until the garbage collector frees them.
+	cachedPassword []byte
 	passwordMu     sync.Mutex
 	passwordSet    bool
 )
 
-// PromptPassword prompts the user to enter a password from stdin without echoing.
+// maxConfirmAttempts is how many times PromptNewPassword asks again after
+// the confirmation didn't match.
+const maxConfirmAttempts = 3
+
+// passwordPrompt reads a single password entry after printing label. It is
+// a variable so tests can feed entries without a terminal.
+var passwordPrompt = readPassword
+
+// readPassword reads a password from stdin without echoing.
 // It uses the golang.org/x/term package for secure terminal input.
-func PromptPassword() (string, error) {
-	fmt.Fprint(os.Stderr, "Enter password: ")
+// When stdin is a pipe carrying data, as with add -, the password is read
+// from the controlling terminal instead.
+func readPassword(label string) (string, error) {
+	fmt.Fprint(os.Stderr, label)
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		if tty, err := os.Open("/dev/tty"); err == nil {
+			defer tty.Close()
+			fd = int(tty.Fd())
+		}
+	}
 
 	// Read password without echoing to terminal
-	passwordBytes, err := term.ReadPassword(int(os.Stdin.Fd()))
+	passwordBytes, err := term.ReadPassword(fd)
+	defer zeroBytes(passwordBytes)
 	fmt.Fprintln(os.Stderr) // Print newline after password input
 
 	if err != nil {
@@ -35,6 +56,45 @@ func PromptPassword() (string, error) {
 	return string(passwordBytes), nil
 }
 
+// PromptPassword prompts the user to enter a password from stdin without echoing.
+func PromptPassword() (string, error) {
+	return passwordPrompt("Enter password: ")
+}
+
+// PromptNewPassword asks for the password twice and prompts again while
+// the two entries differ, so a typo can't lock the user out of a volume.
+func PromptNewPassword() (string, error) {
+	return promptConfirmed("password")
+}
+
+// PromptChangedPassword is PromptNewPassword for the replacement password
+// asked for by passwd.
+func PromptChangedPassword() (string, error) {
+	return promptConfirmed("new password")
+}
+
+func promptConfirmed(what string) (string, error) {
+	for range maxConfirmAttempts {
+		password, err := passwordPrompt("Enter " + what + ": ")
+		if err != nil {
+			return "", err
+		}
+
+		confirm, err := passwordPrompt("Confirm " + what + ": ")
+		if err != nil {
+			return "", err
+		}
+
+		if password == confirm {
+			return password, nil
+		}
+
+		fmt.Fprintln(os.Stderr, C(ColorYellow, "Passwords do not match, try again"))
+	}
+
+	return "", fmt.Errorf("passwords did not match after %d attempts", maxConfirmAttempts)
+}
+
 // GetPassword returns the cached password or prompts for it if not set.
 // The password is cached in memory for the duration of the program execution
 // to avoid prompting multiple times for a single command.
@@ -43,25 +103,37 @@ func GetPassword() (string, error) {
 	defer passwordMu.Unlock()
 
 	if passwordSet {
-		return cachedPassword, nil
+		return string(cachedPassword), nil
 	}
 
-	password, err := PromptPassword()
+	prompt := PromptPassword
+	if PromptConfirm {
+		prompt = PromptNewPassword
+	}
+
+	password, err := prompt()
 	if err != nil {
 		return "", err
 	}
 
-	// Validate minimum length
-	if len(password) < 12 {
-		return "", fmt.Errorf("password must be at least 12 characters long")
+	if err := validatePassword(password); err != nil {
+		return "", err
 	}
 
-	cachedPassword = password
+	cachedPassword = []byte(password)
 	passwordSet = true
 
 	return password, nil
 }
 
+// validatePassword enforces the minimum password length.
+func validatePassword(password string) error {
+	if len(password) < 12 {
+		return fmt.Errorf("password must be at least 12 characters long")
+	}
+	return nil
+}
+
 // ClearPasswordCache clears the cached password from memory.
 // This is primarily useful for testing.
 func ClearPasswordCache() {
@@ -69,22 +141,36 @@ func ClearPasswordCache() {
 	defer passwordMu.Unlock()
 
 	// Zero out the password in memory
-	if cachedPassword != "" {
-		b := []byte(cachedPassword)
-		for i := range b {
-			b[i] = 0
-		}
-		cachedPassword = ""
-	}
+	zeroBytes(cachedPassword)
+	cachedPassword = nil
 	passwordSet = false
+	forgetMeta(nil)
+}
+
+// Shutdown drops the secrets hdnfs holds between operations: the cached
+// password, the decrypted metadata ConcurrencySafe keeps and the header
+// pads of hidden volumes. Derived keys are never cached and go out of
+// scope with the call that derived them. main defers it, and Fatalf calls
+// it because os.Exit skips deferred calls.
+func Shutdown() {
+	ClearPasswordCache()
+	wipeHiddenPads()
 }
 
 // SetPasswordForTesting sets a password without prompting.
 // This should only be used in tests.
 func SetPasswordForTesting(password string) {
+	setCachedPassword(password)
+}
+
+// setCachedPassword replaces the cached password, as when the shell goes
+// back to the password of the volume it still has open.
+func setCachedPassword(password string) {
 	passwordMu.Lock()
 	defer passwordMu.Unlock()
 
-	cachedPassword = password
+	zeroBytes(cachedPassword)
+	cachedPassword = []byte(password)
 	passwordSet = true
+	forgetMeta(nil)
 }
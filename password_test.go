@@ -1,7 +1,10 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
 	"testing"
+	"time"
 )
 
 func TestPasswordCaching(t *testing.T) {
@@ -116,3 +119,166 @@ func TestClearPasswordCache(t *testing.T) {
 	// We can't directly verify this without accessing internal state,
 	// but the function should have zeroed out the password bytes
 }
+
+func TestGetEncKeyUsesPasswordCache(t *testing.T) {
+	ClearPasswordCache()
+	defer ClearPasswordCache()
+
+	testPassword := "cached-password-123"
+	SetPasswordForTesting(testPassword)
+
+	for i := 0; i < 5; i++ {
+		password, err := GetEncKey()
+		if err != nil {
+			t.Fatalf("GetEncKey call %d failed: %v", i, err)
+		}
+		if password != testPassword {
+			t.Errorf("GetEncKey call %d returned %q, expected cached %q", i, password, testPassword)
+		}
+	}
+
+	// Replacing the cached value is picked up by the next call, which shows
+	// GetEncKey reads the cache rather than keeping its own copy.
+	SetPasswordForTesting("replaced-password-456")
+	password, err := GetEncKey()
+	if err != nil {
+		t.Fatalf("GetEncKey failed: %v", err)
+	}
+	if password != "replaced-password-456" {
+		t.Errorf("Expected GetEncKey to return the cached password, got %q", password)
+	}
+}
+
+// fakePrompt replaces the terminal prompt with a fixed list of entries and
+// returns a pointer to the number of prompts shown.
+func fakePrompt(t *testing.T, entries ...string) *int {
+	t.Helper()
+
+	calls := 0
+	previous := passwordPrompt
+	passwordPrompt = func(label string) (string, error) {
+		if calls >= len(entries) {
+			return "", fmt.Errorf("unexpected prompt %q", label)
+		}
+		calls++
+		return entries[calls-1], nil
+	}
+	t.Cleanup(func() { passwordPrompt = previous })
+
+	return &calls
+}
+
+func TestPromptNewPassword(t *testing.T) {
+	tests := []struct {
+		name        string
+		entries     []string
+		expected    string
+		prompts     int
+		expectError bool
+	}{
+		{
+			name:     "Matching entries",
+			entries:  []string{"correct-horse-1", "correct-horse-1"},
+			expected: "correct-horse-1",
+			prompts:  2,
+		},
+		{
+			name:     "Mismatch then match",
+			entries:  []string{"correct-horse-1", "correct-hrose-1", "correct-horse-2", "correct-horse-2"},
+			expected: "correct-horse-2",
+			prompts:  4,
+		},
+		{
+			name:        "Never matching",
+			entries:     []string{"aaaaaaaaaaaa", "bbbbbbbbbbbb", "aaaaaaaaaaaa", "bbbbbbbbbbbb", "aaaaaaaaaaaa", "bbbbbbbbbbbb"},
+			prompts:     6,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := fakePrompt(t, tt.entries...)
+
+			password, err := PromptNewPassword()
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected an error, got password %q", password)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("PromptNewPassword failed: %v", err)
+				}
+				if password != tt.expected {
+					t.Errorf("Expected password %q, got %q", tt.expected, password)
+				}
+			}
+			if *calls != tt.prompts {
+				t.Errorf("Expected %d prompts, got %d", tt.prompts, *calls)
+			}
+		})
+	}
+}
+
+func TestGetPasswordPromptConfirm(t *testing.T) {
+	ClearPasswordCache()
+	defer ClearPasswordCache()
+
+	PromptConfirm = true
+	defer func() { PromptConfirm = false }()
+
+	calls := fakePrompt(t, "first-typo-123", "first-typ0-123", "second-try-123", "second-try-123")
+
+	password, err := GetPassword()
+	if err != nil {
+		t.Fatalf("GetPassword failed: %v", err)
+	}
+	if password != "second-try-123" {
+		t.Errorf("Expected the confirmed password, got %q", password)
+	}
+	if *calls != 4 {
+		t.Errorf("Expected 4 prompts, got %d", *calls)
+	}
+
+	// The confirmed password is cached like any other.
+	if _, err := GetPassword(); err != nil || *calls != 4 {
+		t.Errorf("Expected cached password without prompting, got %d prompts: %v", *calls, err)
+	}
+}
+
+func TestShutdownClearsSecrets(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	ConcurrencySafe = true
+	defer func() { ConcurrencySafe = false }()
+
+	file := NewMockFile(0)
+	InitMeta(file, "file")
+	if _, err := ReadMeta(file); err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+	if len(metaCache) == 0 {
+		t.Fatal("Expected the metadata to be cached")
+	}
+
+	cached := cachedPassword
+	hidden := newHiddenVolume(file, "hidden-password-for-testing")
+
+	Shutdown()
+
+	if passwordSet || cachedPassword != nil {
+		t.Error("Password still cached after Shutdown")
+	}
+	if !bytes.Equal(cached, make([]byte, len(cached))) {
+		t.Error("Expected the cached password to be wiped, not just dropped")
+	}
+	if !bytes.Equal(hidden.pad, make([]byte, HEADER_SIZE)) {
+		t.Error("Expected the hidden volume pad to be wiped")
+	}
+	if len(metaCache) != 0 {
+		t.Errorf("Expected an empty metadata cache after Shutdown, got %d entries", len(metaCache))
+	}
+}
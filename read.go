@@ -1,74 +1,171 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"fmt"
+	"io"
 	"os"
 )
 
+// Get decrypts the file at index to path, or to stdout when path is "-".
 func Get(file F, index int, path string) error {
+	if path == "-" {
+		return GetToWriter(file, index, os.Stdout)
+	}
+
+	df, decrypted, err := readFile(file, index)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer f.Close()
+
+	if HonorSparse {
+		if err := writeSparse(f, decrypted); err != nil {
+			return fmt.Errorf("failed to write output file: %w", err)
+		}
+	} else {
+		n, err := f.Write(decrypted)
+		if err != nil {
+			return fmt.Errorf("failed to write output file: %w", err)
+		}
+
+		if n != len(decrypted) {
+			return fmt.Errorf("short write: wrote %d bytes, expected %d", n, len(decrypted))
+		}
+	}
+
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync output file: %w", err)
+	}
+
+	PrintSuccess(fmt.Sprintf("Extracted '%s' (%s) to '%s'",
+		C(ColorWhite, df.Name),
+		C(ColorWhite, fmt.Sprintf("%d bytes", len(decrypted))),
+		C(ColorWhite, path)))
+
+	return nil
+}
+
+// GetToWriter decrypts the file at index and writes it to w, so it can be
+// streamed without a plaintext copy on disk. Nothing is written unless the
+// whole file decrypted and passed its checks.
+func GetToWriter(file F, index int, w io.Writer) error {
+	df, decrypted, err := readFile(file, index)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(decrypted); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+
+	LogInfo("extracted '%s' (%d bytes)", df.Name, len(decrypted))
+
+	return nil
+}
+
+// readFile reads and decrypts the file at index, checking it against its
+// recorded checksum and ExpectedSHA256.
+func readFile(file F, index int) (File, []byte, error) {
 	if index < 0 || index >= TOTAL_FILES {
-		return fmt.Errorf("index out of range: %d (valid range: 0-%d)", index, TOTAL_FILES-1)
+		return File{}, nil, fmt.Errorf("index out of range: %d (valid range: 0-%d)", index, TOTAL_FILES-1)
 	}
 
 	meta, err := ReadMeta(file)
 	if err != nil {
-		return fmt.Errorf("failed to read metadata: %w", err)
+		return File{}, nil, fmt.Errorf("failed to read metadata: %w", err)
 	}
 
 	df := meta.Files[index]
 	if df.Name == "" {
-		return fmt.Errorf("no file exists at index %d", index)
+		return File{}, nil, fmt.Errorf("no file exists at index %d", index)
 	}
-
 	seekPos := int64(META_FILE_SIZE) + (int64(index) * int64(MAX_FILE_SIZE))
 	_, err = file.Seek(seekPos, 0)
 	if err != nil {
-		return fmt.Errorf("failed to seek to file position: %w", err)
+		return File{}, nil, fmt.Errorf("failed to seek to file position: %w", err)
 	}
 
 	buff := make([]byte, df.Size)
 	n, err := file.Read(buff)
 	if err != nil {
-		return fmt.Errorf("failed to read file: %w", err)
+		return File{}, nil, fmt.Errorf("failed to read file: %w", err)
 	}
 
 	if n != df.Size {
-		return fmt.Errorf("short read: read %d bytes, expected %d", n, df.Size)
+		return File{}, nil, fmt.Errorf("short read: read %d bytes, expected %d", n, df.Size)
 	}
 
 	password, err := GetEncKey()
 	if err != nil {
-		return fmt.Errorf("failed to get encryption key: %w", err)
+		return File{}, nil, fmt.Errorf("failed to get encryption key: %w", err)
 	}
 
-	decrypted, err := DecryptGCM(buff, password, meta.Salt)
+	aad, err := fileAAD(df)
 	if err != nil {
-		return fmt.Errorf("failed to decrypt file: %w", err)
+		return File{}, nil, err
 	}
 
-	f, err := os.Create(path)
+	decrypted, err := DecryptGCM(buff, password, meta.Salt, aad)
 	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
+		return File{}, nil, fmt.Errorf("failed to decrypt file: %w", err)
 	}
-	defer f.Close()
 
-	n, err = f.Write(decrypted)
+	decrypted, err = openPayload(df, decrypted)
 	if err != nil {
-		return fmt.Errorf("failed to write output file: %w", err)
+		return File{}, nil, err
 	}
 
-	if n != len(decrypted) {
-		return fmt.Errorf("short write: wrote %d bytes, expected %d", n, len(decrypted))
+	// Files added without --checksum have none to compare against.
+	if len(df.Checksum) > 0 && !bytes.Equal(ComputeChecksum(decrypted), df.Checksum) {
+		return File{}, nil, fmt.Errorf("checksum mismatch: file corrupted")
 	}
 
-	if err := f.Sync(); err != nil {
-		return fmt.Errorf("failed to sync output file: %w", err)
+	if ExpectedSHA256 != nil {
+		sum := sha256.Sum256(decrypted)
+		if !bytes.Equal(sum[:], ExpectedSHA256) {
+			return File{}, nil, fmt.Errorf("checksum mismatch: expected %x, got %x", ExpectedSHA256, sum)
+		}
 	}
 
-	PrintSuccess(fmt.Sprintf("Extracted '%s' (%s) to '%s'",
-		C(ColorWhite, df.Name),
-		C(ColorWhite, fmt.Sprintf("%d bytes", len(decrypted))),
-		C(ColorWhite, path)))
+	return df, decrypted, nil
+}
 
-	return nil
+// sparseChunk is the granularity writeSparse looks for zero runs at,
+// matching the usual filesystem block size.
+const sparseChunk = 4096
+
+// writeSparse writes data to f but seeks over chunks that are all zero, so
+// the filesystem can leave holes there. The final Truncate sets the size
+// when data ends in a hole.
+func writeSparse(f *os.File, data []byte) error {
+	for off := 0; off < len(data); off += sparseChunk {
+		chunk := data[off:min(off+sparseChunk, len(data))]
+		if isZero(chunk) {
+			if _, err := f.Seek(int64(len(chunk)), io.SeekCurrent); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := f.Write(chunk); err != nil {
+			return err
+		}
+	}
+
+	return f.Truncate(int64(len(data)))
+}
+
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
 }
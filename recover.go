@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// orphan is a free slot whose block still decrypts, typically left by an
+// add that was interrupted after writing the data but before the metadata.
+type orphan struct {
+	Index int
+	scavenged
+}
+
+// findOrphans returns the free slots holding data that decrypts with the
+// volume key, found the same way reindex finds files, see scavengeSlot.
+//
+// Deleted files are not zeroed while metadata backups are kept, so they
+// show up here as well.
+func findOrphans(file F, meta *Meta, password string) ([]orphan, error) {
+	key, err := DeriveKey(password, meta.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	defer zeroBytes(key)
+
+	var orphans []orphan
+	for i, f := range meta.Files {
+		if f.Name != "" {
+			continue
+		}
+
+		if found, ok := scavengeSlot(file, key, i, meta.Noise, 0); ok {
+			orphans = append(orphans, orphan{Index: i, scavenged: found})
+		}
+	}
+
+	return orphans, nil
+}
+
+// RecoverOrphans looks for orphaned slots and asks on in what to do with
+// each one: register it under a recovered name, zero it, or leave it.
+func RecoverOrphans(file F, in io.Reader) error {
+	meta, err := ReadMeta(file)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	password, err := GetEncKey()
+	if err != nil {
+		return fmt.Errorf("failed to get encryption key: %w", err)
+	}
+
+	orphans, err := findOrphans(file, meta, password)
+	if err != nil {
+		return err
+	}
+	if len(orphans) == 0 {
+		PrintSuccess("No orphaned slots found")
+		return nil
+	}
+
+	PrintHeader("ORPHANED SLOTS")
+	PrintSeparator(70)
+
+	scanner := bufio.NewScanner(in)
+	registered := 0
+	for _, o := range orphans {
+		name := fmt.Sprintf("recovered_%d", o.Index)
+		Printf(" %s %s\n",
+			C(ColorBrightBlue, fmt.Sprintf("[%d]", o.Index)),
+			C(ColorWhite, fmt.Sprintf("%d bytes of data not referenced by the metadata", len(o.Content))))
+		Printf("   %s ", C(ColorDim, fmt.Sprintf("(r)egister as %s, (z)ero, (s)kip?", name)))
+
+		answer := "s"
+		if scanner.Scan() {
+			answer = strings.ToLower(strings.TrimSpace(scanner.Text()))
+		}
+		Println("")
+
+		switch answer {
+		case "r", "register":
+			entry := File{Name: name, Compressed: looksCompressed(o.Content)}
+			content, err := openPayload(entry, o.Content)
+			if err != nil {
+				return fmt.Errorf("slot %d: %w", o.Index, err)
+			}
+			if err := completeEntry(&entry, content, o.Size, password, meta.Salt); err != nil {
+				return err
+			}
+			entry.Bound = o.Bound
+			meta.Files[o.Index] = entry
+			registered++
+		case "z", "zero":
+			if err := clearSlot(file, meta, o.Index); err != nil {
+				return fmt.Errorf("failed to zero slot %d: %w", o.Index, err)
+			}
+		}
+	}
+
+	PrintSeparator(70)
+
+	if registered == 0 {
+		return nil
+	}
+
+	if err := checkMetaFits(meta); err != nil {
+		return err
+	}
+	if err := WriteMeta(file, meta); err != nil {
+		return fmt.Errorf("failed to update metadata: %w", err)
+	}
+
+	PrintSuccess(fmt.Sprintf("Registered %d recovered files", registered))
+
+	return nil
+}
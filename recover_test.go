@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecoverOrphans(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	sourcePath := CreateTempSourceFileWithName(t, []byte("referenced"), "kept.txt")
+	if err := Add(file, sourcePath, 0); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	meta, err := ReadMeta(file)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+	password, err := GetEncKey()
+	if err != nil {
+		t.Fatalf("GetEncKey failed: %v", err)
+	}
+
+	// Write data blocks without metadata, as an add that crashed before
+	// WriteMeta would.
+	contents := map[int][]byte{3: []byte("interrupted add"), 7: GenerateRandomBytes(3000)}
+	for index, content := range contents {
+		encrypted, err := EncryptGCM(content, password, meta.Salt, nil)
+		if err != nil {
+			t.Fatalf("EncryptGCM failed: %v", err)
+		}
+		block := make([]byte, MAX_FILE_SIZE)
+		copy(block, encrypted)
+		if err := WriteBlock(file, block, "", index); err != nil {
+			t.Fatalf("WriteBlock failed: %v", err)
+		}
+	}
+
+	orphans, err := findOrphans(file, meta, password)
+	if err != nil {
+		t.Fatalf("findOrphans failed: %v", err)
+	}
+	if len(orphans) != 2 || orphans[0].Index != 3 || orphans[1].Index != 7 {
+		t.Fatalf("Expected orphans at 3 and 7, got %+v", orphans)
+	}
+
+	output := captureOutput(func() {
+		err = RecoverOrphans(file, strings.NewReader("r\nz\n"))
+	})
+	if err != nil {
+		t.Fatalf("RecoverOrphans failed: %v", err)
+	}
+	if strings.Count(output, "(r)egister") != 2 || strings.Count(output, "(z)ero") != 2 {
+		t.Errorf("Expected both options offered for each orphan, got:\n%s", output)
+	}
+
+	meta = VerifyMetadataIntegrity(t, file)
+	if meta.Files[3].Name != "recovered_3" {
+		t.Fatalf("Expected slot 3 to be registered, got %q", meta.Files[3].Name)
+	}
+	got, err := decryptSlot(file, meta, password, 3)
+	if err != nil {
+		t.Fatalf("Failed to read recovered file: %v", err)
+	}
+	if !bytes.Equal(got, contents[3]) {
+		t.Errorf("Recovered content mismatch: %q", got)
+	}
+
+	if meta.Files[7].Name != "" {
+		t.Errorf("Expected slot 7 to stay free, got %q", meta.Files[7].Name)
+	}
+	block, err := ReadBlock(file, 7)
+	if err != nil {
+		t.Fatalf("ReadBlock failed: %v", err)
+	}
+	if !bytes.Equal(block, make([]byte, MAX_FILE_SIZE)) {
+		t.Error("Expected slot 7 to be zeroed")
+	}
+
+	orphans, err = findOrphans(file, meta, password)
+	if err != nil {
+		t.Fatalf("findOrphans failed: %v", err)
+	}
+	if len(orphans) != 0 {
+		t.Errorf("Expected no orphans left, got %+v", orphans)
+	}
+}
+
+func TestRecoverOrphansNoise(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := noiseTestVolume(t, 5, 9)
+
+	meta, err := ReadMeta(file)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+	password, err := GetEncKey()
+	if err != nil {
+		t.Fatalf("GetEncKey failed: %v", err)
+	}
+
+	content := []byte("interrupted add on a noise volume")
+	encrypted, err := EncryptGCM(content, password, meta.Salt, nil)
+	if err != nil {
+		t.Fatalf("EncryptGCM failed: %v", err)
+	}
+	block := GenerateRandomBytes(MAX_FILE_SIZE)
+	copy(block, encrypted)
+	if err := WriteBlock(file, block, "", 3); err != nil {
+		t.Fatalf("WriteBlock failed: %v", err)
+	}
+
+	orphans, err := findOrphans(file, meta, password)
+	if err != nil {
+		t.Fatalf("findOrphans failed: %v", err)
+	}
+	if len(orphans) != 1 || orphans[0].Index != 3 {
+		t.Fatalf("Expected a single orphan at 3, got %+v", orphans)
+	}
+	if orphans[0].Size != len(encrypted) || !bytes.Equal(orphans[0].Content, content) {
+		t.Errorf("Expected the orphan's own length and content, got %d bytes: %q", orphans[0].Size, orphans[0].Content)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Reindex rebuilds the file table from the data slots. Every slot that
+// decrypts with the current password is listed again. When the old
+// metadata is still readable it is kept, settings and aliases included,
+// and only the file table is replaced: entries are kept for the slots
+// that still decrypt, with the size and binding found on disk. Other files
+// get a generated name and detected compression.
+//
+// The salt is taken from the header, or from RecoverySalt when the header
+// itself was destroyed.
+func Reindex(file F) error {
+	salt := RecoverySalt
+	if salt == nil {
+		h, err := ReadHeader(file)
+		if err != nil {
+			return fmt.Errorf("header unreadable, pass the salt with --salt: %w", err)
+		}
+		salt = append([]byte(nil), h.Salt...)
+	}
+
+	if len(salt) != SALT_SIZE {
+		return fmt.Errorf("invalid salt length: %d (expected %d)", len(salt), SALT_SIZE)
+	}
+
+	// Without readable metadata there is no telling whether the volume
+	// pads with noise, so every length is tried.
+	meta := &Meta{Version: METADATA_VERSION}
+	noise := true
+	if m, err := ReadMeta(file); err == nil {
+		meta, noise = m, m.Noise
+	}
+	old := meta.Files
+	meta.Files = [TOTAL_FILES]File{}
+	meta.Salt = salt
+
+	password, err := GetEncKey()
+	if err != nil {
+		return fmt.Errorf("failed to get encryption key: %w", err)
+	}
+
+	key, err := DeriveKey(password, salt)
+	if err != nil {
+		return fmt.Errorf("key derivation failed: %w", err)
+	}
+	defer zeroBytes(key)
+
+	PrintHeader("REINDEX")
+	PrintSeparator(70)
+
+	recovered := 0
+	for i := range TOTAL_FILES {
+		found, ok := scavengeSlot(file, key, i, noise, old[i].Size)
+		if !ok {
+			dropAliases(meta, i)
+			continue
+		}
+
+		entry := old[i]
+		if entry.Name == "" {
+			entry = File{
+				Name:       fmt.Sprintf("recovered_%03d.bin", i),
+				Compressed: looksCompressed(found.Content),
+			}
+		}
+		entry.Size = found.Size
+		entry.Bound = found.Bound
+		meta.Files[i] = entry
+		recovered++
+
+		Printf(" %-7s  %s  %s\n",
+			C(ColorBrightBlue, fmt.Sprintf("[%d]", i)),
+			C(ColorWhite, entry.Name),
+			C(ColorDim, fmt.Sprintf("%d bytes", found.Size)))
+	}
+
+	if err := WriteMeta(file, meta); err != nil {
+		return fmt.Errorf("failed to write rebuilt metadata: %w", err)
+	}
+
+	PrintSeparator(70)
+	PrintSuccess(fmt.Sprintf("Reindex complete: %s recovered",
+		C(ColorBold+ColorWhite, fmt.Sprintf("%d files", recovered))))
+
+	return nil
+}
+
+// scavenged is a block scavengeSlot managed to decrypt.
+type scavenged struct {
+	Size    int    // ciphertext length
+	Bound   bool   // sealed with the context as additional data
+	Content []byte // decrypted payload, still compressed if it was
+}
+
+// scavengeSlot tries to decrypt the block at index without its metadata
+// entry. size is the length recorded for it, or 0 when unknown, and is
+// tried first. Otherwise the length is searched for: on a zero padded
+// volume it starts at the last non-zero byte and grows in case the
+// ciphertext itself ended in zeros, and with noise, where the padding
+// can't be told from ciphertext, every length is tried with a
+// lengthScanner. Each length is tried without additional data and, when a
+// Context is set, with it, which tells whether the file was bound.
+func scavengeSlot(file F, key []byte, index int, noise bool, size int) (scavenged, bool) {
+	block := make([]byte, MAX_FILE_SIZE)
+	seekPos := int64(META_FILE_SIZE) + (int64(index) * int64(MAX_FILE_SIZE))
+	if n, _ := file.ReadAt(block, seekPos); n == 0 {
+		return scavenged{}, false
+	}
+
+	end := len(bytes.TrimRight(block, "\x00"))
+	if end == 0 {
+		return scavenged{}, false
+	}
+
+	aads := [][]byte{nil}
+	if Context != "" {
+		aads = append(aads, contextAAD())
+	}
+
+	if size >= NonceSize+TagSize && size <= MAX_FILE_SIZE {
+		for _, aad := range aads {
+			if content, err := decryptWithKey(block[:size], key, aad); err == nil {
+				return scavenged{Size: size, Bound: aad != nil, Content: content}, true
+			}
+		}
+	}
+
+	from, to := max(end, NonceSize+TagSize), min(end+TagSize, MAX_FILE_SIZE)
+	if noise {
+		from, to = NonceSize+TagSize, MAX_FILE_SIZE
+	}
+
+	scanner, err := newLengthScanner(key, block[:NonceSize])
+	if err != nil {
+		return scavenged{}, false
+	}
+	for _, aad := range aads {
+		for at := from; at <= to; at++ {
+			if at = scanner.next(block, aad, at, to); at == 0 {
+				break
+			}
+			if content, err := decryptWithKey(block[:at], key, aad); err == nil {
+				return scavenged{Size: at, Bound: aad != nil, Content: content}, true
+			}
+		}
+	}
+
+	return scavenged{}, false
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReindex(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	contents := map[int][]byte{
+		0: []byte("first file"),
+		3: GenerateRandomBytes(5000),
+		7: []byte("third file"),
+	}
+	for idx, content := range contents {
+		sourcePath := CreateTempSourceFile(t, content)
+		if err := Add(file, sourcePath, idx); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	h, err := ReadHeader(file)
+	if err != nil {
+		t.Fatalf("ReadHeader failed: %v", err)
+	}
+	salt := append([]byte(nil), h.Salt...)
+
+	file.Seek(0, 0)
+	file.Write(make([]byte, META_FILE_SIZE))
+
+	if _, err := ReadMeta(file); err == nil {
+		t.Fatal("Expected ReadMeta to fail after destroying metadata")
+	}
+
+	if err := Reindex(file); err == nil {
+		t.Error("Expected Reindex to fail without a salt when the header is gone")
+	}
+
+	RecoverySalt = salt
+	defer func() { RecoverySalt = nil }()
+
+	if err := Reindex(file); err != nil {
+		t.Fatalf("Reindex failed: %v", err)
+	}
+
+	meta := VerifyMetadataIntegrity(t, file)
+	if CountUsedSlots(meta) != len(contents) {
+		t.Errorf("Expected %d recovered files, got %d", len(contents), CountUsedSlots(meta))
+	}
+
+	outDir := t.TempDir()
+	for idx, content := range contents {
+		if meta.Files[idx].Name == "" {
+			t.Errorf("Slot %d was not recovered", idx)
+			continue
+		}
+
+		outPath := filepath.Join(outDir, meta.Files[idx].Name)
+		if err := Get(file, idx, outPath); err != nil {
+			t.Fatalf("Get failed for slot %d: %v", idx, err)
+		}
+		data, _ := os.ReadFile(outPath)
+		if !bytes.Equal(data, content) {
+			t.Errorf("Content mismatch for recovered slot %d", idx)
+		}
+	}
+}
+
+func TestReindexKeepsContextAndCompression(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	plain := []byte("added without a context")
+	bound := bytes.Repeat([]byte("bound and compressed "), 500)
+
+	if err := Add(file, CreateTempSourceFile(t, plain), 1); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	Context = "production"
+	Compress = true
+	defer func() {
+		Context = ""
+		Compress = false
+	}()
+	if err := Add(file, CreateTempSourceFile(t, bound), 2); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	Compress = false
+
+	file.Seek(HEADER_SIZE, 0)
+	file.Write(make([]byte, META_FILE_SIZE-HEADER_SIZE))
+
+	if err := Reindex(file); err != nil {
+		t.Fatalf("Reindex failed: %v", err)
+	}
+
+	meta := VerifyMetadataIntegrity(t, file)
+	if meta.Files[1].Bound || !meta.Files[2].Bound {
+		t.Errorf("Expected only slot 2 to be bound, got %v and %v", meta.Files[1].Bound, meta.Files[2].Bound)
+	}
+	if !meta.Files[2].Compressed {
+		t.Error("Expected slot 2 to be detected as compressed")
+	}
+
+	for idx, content := range map[int][]byte{1: plain, 2: bound} {
+		var out bytes.Buffer
+		if err := GetToWriter(file, idx, &out); err != nil {
+			t.Fatalf("Get failed for slot %d: %v", idx, err)
+		}
+		if !bytes.Equal(out.Bytes(), content) {
+			t.Errorf("Content mismatch for recovered slot %d", idx)
+		}
+	}
+}
+
+// noiseTestVolume returns a volume that pads with noise like one
+// initialized with SlotNoise, but with only the given free slots filled
+// with it so scans over the rest stay fast.
+func noiseTestVolume(t *testing.T, noiseSlots ...int) F {
+	t.Helper()
+
+	file := NewMockFile(0)
+	if err := InitMeta(file, "file"); err != nil {
+		t.Fatalf("InitMeta failed: %v", err)
+	}
+	meta, err := ReadMeta(file)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+	meta.Noise = true
+	if err := WriteMeta(file, meta); err != nil {
+		t.Fatalf("WriteMeta failed: %v", err)
+	}
+
+	for _, i := range noiseSlots {
+		if err := WriteBlock(file, GenerateRandomBytes(MAX_FILE_SIZE), "", i); err != nil {
+			t.Fatalf("WriteBlock failed: %v", err)
+		}
+	}
+
+	return file
+}
+
+func TestReindexNoise(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := noiseTestVolume(t, 5)
+
+	contents := map[int][]byte{
+		0: []byte("first file"),
+		3: GenerateRandomBytes(5000),
+		7: []byte("third file"),
+	}
+	for idx, content := range contents {
+		if err := Add(file, CreateTempSourceFile(t, content), idx); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	check := func(stage string) {
+		t.Helper()
+		meta := VerifyMetadataIntegrity(t, file)
+		if CountUsedSlots(meta) != len(contents) {
+			t.Fatalf("%s: expected %d recovered files, got %d", stage, len(contents), CountUsedSlots(meta))
+		}
+		for idx, content := range contents {
+			var out bytes.Buffer
+			if err := GetToWriter(file, idx, &out); err != nil {
+				t.Fatalf("%s: Get failed for slot %d: %v", stage, idx, err)
+			}
+			if !bytes.Equal(out.Bytes(), content) {
+				t.Errorf("%s: content mismatch for slot %d", stage, idx)
+			}
+		}
+	}
+
+	if err := Reindex(file); err != nil {
+		t.Fatalf("Reindex failed: %v", err)
+	}
+	check("readable metadata")
+
+	file.Seek(HEADER_SIZE, 0)
+	file.Write(make([]byte, META_FILE_SIZE-HEADER_SIZE))
+
+	if err := Reindex(file); err != nil {
+		t.Fatalf("Reindex failed: %v", err)
+	}
+	check("lost metadata")
+}
+
+func TestReindexKeepsMetadata(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := noiseTestVolume(t)
+
+	PadMetadata = true
+	Checksums = true
+	defer func() {
+		PadMetadata = false
+		Checksums = false
+	}()
+
+	for idx, content := range map[int][]byte{1: []byte("kept"), 2: []byte("lost")} {
+		if err := Add(file, CreateTempSourceFile(t, content), idx); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	NameHash = true
+	err := Add(file, CreateTempSourceFileWithName(t, []byte("hashed"), "secret.txt"), 3)
+	NameHash = false
+	if err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := SetAlias(file, "keep", 1); err != nil {
+		t.Fatalf("SetAlias failed: %v", err)
+	}
+	if err := SetAlias(file, "lost", 2); err != nil {
+		t.Fatalf("SetAlias failed: %v", err)
+	}
+
+	before := VerifyMetadataIntegrity(t, file)
+
+	// Slot 2 no longer decrypts.
+	if err := WriteBlock(file, GenerateRandomBytes(MAX_FILE_SIZE), "", 2); err != nil {
+		t.Fatalf("WriteBlock failed: %v", err)
+	}
+
+	if err := Reindex(file); err != nil {
+		t.Fatalf("Reindex failed: %v", err)
+	}
+
+	after := VerifyMetadataIntegrity(t, file)
+	if !after.Noise || !after.Padded {
+		t.Errorf("Expected the volume settings to be kept, got Noise %v Padded %v", after.Noise, after.Padded)
+	}
+	if after.Aliases["keep"] != 1 {
+		t.Errorf("Expected alias keep on slot 1, got %v", after.Aliases)
+	}
+	if _, ok := after.Aliases["lost"]; ok {
+		t.Error("Expected the alias of the lost file to be dropped")
+	}
+	if after.Files[2].Name != "" {
+		t.Errorf("Expected slot 2 to be free, got %q", after.Files[2].Name)
+	}
+	for _, idx := range []int{1, 3} {
+		b, a := before.Files[idx], after.Files[idx]
+		if a.Name != b.Name || a.Created != b.Created || a.MIME != b.MIME ||
+			!bytes.Equal(a.Checksum, b.Checksum) || !bytes.Equal(a.SealedName, b.SealedName) {
+			t.Errorf("Slot %d: entry changed from %+v to %+v", idx, b, a)
+		}
+	}
+	if after.Wear.MetaWrites <= before.Wear.MetaWrites {
+		t.Errorf("Expected the wear counters to carry on, got %d after %d", after.Wear.MetaWrites, before.Wear.MetaWrites)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Rename changes the name of the file at index. Only the metadata is
+// rewritten; the data block is left as it is.
+func Rename(file F, index int, newName string) (err error) {
+	defer func() { err = checkDevice(err) }()
+
+	if index < 0 || index >= TOTAL_FILES {
+		return fmt.Errorf("index out of range: %d (valid range: 0-%d)", index, TOTAL_FILES-1)
+	}
+	if newName == "" {
+		return fmt.Errorf("new name is empty")
+	}
+	if len(newName) > MAX_FILE_NAME_SIZE {
+		return fmt.Errorf("filename too long: %d characters (max %d)", len(newName), MAX_FILE_NAME_SIZE)
+	}
+
+	meta, err := ReadMeta(file)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	entry := &meta.Files[index]
+	if entry.Name == "" {
+		return fmt.Errorf("no file exists at index %d", index)
+	}
+
+	entry.Name = newName
+
+	// A hashed name stays hashed, and --name-hash hashes the new one.
+	if NameHash || entry.SealedName != nil {
+		password, err := GetEncKey()
+		if err != nil {
+			return fmt.Errorf("failed to get encryption key: %w", err)
+		}
+
+		sealed, err := EncryptGCM([]byte(newName), password, meta.Salt, nil)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt file name: %w", err)
+		}
+		entry.SealedName = sealed
+		entry.Name = hashName(meta.Salt, newName)
+	}
+
+	if err := checkMetaFits(meta); err != nil {
+		return err
+	}
+	if err := WriteMeta(file, meta); err != nil {
+		return fmt.Errorf("failed to update metadata: %w", err)
+	}
+
+	PrintSuccess(fmt.Sprintf("Renamed file at index %d to %s", index, newName))
+
+	return nil
+}
@@ -3,8 +3,13 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"regexp"
+	"sort"
 	"strings"
+	"unicode/utf8"
 )
 
 func SearchName(file F, phrase string) error {
@@ -12,45 +17,224 @@ func SearchName(file F, phrase string) error {
 		return fmt.Errorf("search phrase cannot be empty")
 	}
 
+	if Fuzzy && SearchRegex {
+		return fmt.Errorf("--fuzzy and --regex can't be combined")
+	}
+	m, err := newSearchMatcher(phrase)
+	if err != nil {
+		return err
+	}
+
 	meta, err := ReadMeta(file)
 	if err != nil {
 		return fmt.Errorf("failed to read metadata: %w", err)
 	}
 
+	var found []fuzzyMatch
+	if Fuzzy {
+		found = fuzzyMatches(meta, strings.ToLower(phrase))
+	} else {
+		for i, v := range meta.Files {
+			if v.Name != "" && m.match(v.Name) {
+				found = append(found, fuzzyMatch{Index: i})
+			}
+		}
+	}
+
+	if JSONEvents {
+		records := []fileRecord{}
+		for _, fm := range found {
+			records = append(records, newFileRecord(fm.Index, meta.Files[fm.Index]))
+		}
+		return PrintJSON(records)
+	}
+
 	PrintHeader("FILENAME SEARCH")
 	PrintSeparator(70)
 	Printf(" %s %s\n\n", C(ColorBold+ColorLightBlue, "Searching for:"), C(ColorWhite, fmt.Sprintf("\"%s\"", phrase)))
 
-	matchCount := 0
-	lowerPhrase := strings.ToLower(phrase)
-
-	for i := range TOTAL_FILES {
-		if meta.Files[i].Name == "" {
-			continue
-		}
-
-		lowerName := strings.ToLower(meta.Files[i].Name)
-		if strings.Contains(lowerName, lowerPhrase) {
-			Printf(" %-7s  %s\n",
-				C(ColorBrightBlue, fmt.Sprintf("[%d]", i)),
-				C(ColorWhite, meta.Files[i].Name))
-			matchCount++
+	for _, fm := range found {
+		distance := ""
+		if Fuzzy {
+			distance = " " + C(ColorDim, fmt.Sprintf("(distance %d)", fm.Distance))
 		}
+		Printf(" %-7s  %s%s\n",
+			C(ColorBrightBlue, fmt.Sprintf("[%d]", fm.Index)),
+			C(ColorWhite, meta.Files[fm.Index].Name),
+			distance)
 	}
 
 	PrintSeparator(70)
 	Printf("\n%s %s\n",
 		C(ColorBold+ColorLightBlue, "Total matches:"),
-		C(ColorWhite, fmt.Sprintf("%d", matchCount)))
+		C(ColorWhite, fmt.Sprintf("%d", len(found))))
 
 	return nil
 }
 
+// searchMatcher decides what search and search-name count as a match: a
+// case-insensitive substring by default, a regular expression with
+// SearchRegex.
+type searchMatcher struct {
+	lower string
+	re    *regexp.Regexp
+}
+
+// newSearchMatcher returns the matcher for phrase, failing if SearchRegex
+// is set and phrase doesn't compile.
+func newSearchMatcher(phrase string) (*searchMatcher, error) {
+	if !SearchRegex {
+		return &searchMatcher{lower: strings.ToLower(phrase)}, nil
+	}
+
+	re, err := regexp.Compile(phrase)
+	if err != nil {
+		return nil, fmt.Errorf("invalid search regex: %w", err)
+	}
+	return &searchMatcher{re: re}, nil
+}
+
+// match reports whether s, a name or a line, matches.
+func (m *searchMatcher) match(s string) bool {
+	if m.re != nil {
+		return m.re.MatchString(s)
+	}
+	return strings.Contains(strings.ToLower(s), m.lower)
+}
+
+// matchBinary returns one line per match in binary content, giving its
+// offset.
+func (m *searchMatcher) matchBinary(content []byte) []string {
+	if m.re == nil {
+		return searchBinary(content, m.lower)
+	}
+
+	var matches []string
+	for _, loc := range m.re.FindAllIndex(content, -1) {
+		matches = append(matches, fmt.Sprintf("binary match at offset %d", loc[0]))
+	}
+	return matches
+}
+
+// fuzzyMatch is a file name within edit distance of a search phrase. Plain
+// name matches use it too, with a zero Distance.
+type fuzzyMatch struct {
+	Index    int
+	Distance int
+}
+
+// fuzzyMatches returns the files whose name, or name without extension,
+// is at most a third of the phrase length plus one edits away from
+// lowerPhrase, closest first.
+func fuzzyMatches(meta *Meta, lowerPhrase string) []fuzzyMatch {
+	threshold := utf8.RuneCountInString(lowerPhrase)/3 + 1
+
+	var matches []fuzzyMatch
+	for i, v := range meta.Files {
+		if v.Name == "" {
+			continue
+		}
+
+		lowerName := strings.ToLower(v.Name)
+		distance := levenshtein(lowerPhrase, lowerName)
+		if dot := strings.LastIndex(lowerName, "."); dot > 0 {
+			distance = min(distance, levenshtein(lowerPhrase, lowerName[:dot]))
+		}
+
+		if distance <= threshold {
+			matches = append(matches, fuzzyMatch{Index: i, Distance: distance})
+		}
+	}
+
+	sort.SliceStable(matches, func(a, b int) bool {
+		return matches[a].Distance < matches[b].Distance
+	})
+
+	return matches
+}
+
+// levenshtein returns the number of single rune insertions, deletions and
+// substitutions needed to turn a into b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(rb)]
+}
+
+// FindByName returns the slot holding a file called exactly name. Files
+// added with NameHash are matched by hashing name with the volume salt.
+func FindByName(file F, name string) (int, error) {
+	if name == "" {
+		return 0, fmt.Errorf("file name cannot be empty")
+	}
+
+	meta, err := ReadMeta(file)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	hashed := hashName(meta.Salt, name)
+	for i, v := range meta.Files {
+		if v.Name != "" && (v.Name == name || v.Name == hashed) {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no file named %q", name)
+}
+
+// hashName is the name stored for a file added with NameHash. The #
+// prefix marks it as a hash in listings.
+func hashName(salt []byte, name string) string {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(name))
+	return "#" + hex.EncodeToString(h.Sum(nil))
+}
+
+// realName returns the original name of f, decrypting SealedName for a
+// file added with NameHash. The stored name is returned if that fails.
+func realName(f File, password string, salt []byte) string {
+	if len(f.SealedName) == 0 {
+		return f.Name
+	}
+
+	name, err := DecryptGCM(f.SealedName, password, salt, nil)
+	if err != nil {
+		return f.Name
+	}
+
+	return string(name)
+}
+
 func SearchContent(file F, phrase string, index int) error {
 	if phrase == "" {
 		return fmt.Errorf("search phrase cannot be empty")
 	}
 
+	m, err := newSearchMatcher(phrase)
+	if err != nil {
+		return err
+	}
+
 	meta, err := ReadMeta(file)
 	if err != nil {
 		return fmt.Errorf("failed to read metadata: %w", err)
@@ -61,7 +245,6 @@ func SearchContent(file F, phrase string, index int) error {
 		return fmt.Errorf("failed to get encryption key: %w", err)
 	}
 
-	lowerPhrase := strings.ToLower(phrase)
 	totalMatches := 0
 
 	if index != OUT_OF_BOUNDS_INDEX {
@@ -73,11 +256,19 @@ func SearchContent(file F, phrase string, index int) error {
 			return fmt.Errorf("no file exists at index %d", index)
 		}
 
-		matches, err := searchFileContent(file, meta, password, index, lowerPhrase)
+		matches, err := searchFileContent(file, meta, password, index, m)
 		if err != nil {
 			return fmt.Errorf("search failed at index %d: %w", index, err)
 		}
 
+		if JSONEvents {
+			results := []SearchResult{}
+			if len(matches) > 0 {
+				results = append(results, SearchResult{Index: index, Name: meta.Files[index].Name, Lines: matches})
+			}
+			return PrintJSON(results)
+		}
+
 		if len(matches) > 0 {
 			Printf("\n%s %s\n\n",
 				C(ColorBold+ColorBrightBlue, fmt.Sprintf("[%d]", index)),
@@ -89,31 +280,28 @@ func SearchContent(file F, phrase string, index int) error {
 			Printf("\n%s\n", C(ColorDim, fmt.Sprintf("No matches found in [%d] %s", index, meta.Files[index].Name)))
 		}
 	} else {
-		PrintHeader("CONTENT SEARCH")
-		PrintSeparator(70)
-		Printf(" %s %s\n\n", C(ColorBold+ColorLightBlue, "Searching for:"), C(ColorWhite, fmt.Sprintf("\"%s\"", phrase)))
+		results := searchSlots(file, meta, password, m)
 
-		for i := range TOTAL_FILES {
-			if meta.Files[i].Name == "" {
-				continue
+		if JSONEvents {
+			if results == nil {
+				results = []SearchResult{}
 			}
+			return PrintJSON(results)
+		}
 
-			matches, err := searchFileContent(file, meta, password, i, lowerPhrase)
-			if err != nil {
-				Printf("\n%s\n", C(ColorRed, fmt.Sprintf("Error searching [%d] %s: %v", i, meta.Files[i].Name, err)))
-				continue
-			}
+		PrintHeader("CONTENT SEARCH")
+		PrintSeparator(70)
+		Printf(" %s %s\n\n", C(ColorBold+ColorLightBlue, "Searching for:"), C(ColorWhite, fmt.Sprintf("\"%s\"", phrase)))
 
-			if len(matches) > 0 {
-				Printf(" %s %s\n\n",
-					C(ColorBold+ColorBrightBlue, fmt.Sprintf("[%d]", i)),
-					C(ColorWhite, meta.Files[i].Name))
-				for _, line := range matches {
-					Printf("    %s\n", C(ColorLightBlue, line))
-				}
-				Printf("\n")
-				totalMatches += len(matches)
+		for _, r := range results {
+			Printf(" %s %s\n\n",
+				C(ColorBold+ColorBrightBlue, fmt.Sprintf("[%d]", r.Index)),
+				C(ColorWhite, r.Name))
+			for _, line := range r.Lines {
+				Printf("    %s\n", C(ColorLightBlue, line))
 			}
+			Printf("\n")
+			totalMatches += len(r.Lines)
 		}
 
 		PrintSeparator(70)
@@ -125,7 +313,70 @@ func SearchContent(file F, phrase string, index int) error {
 	return nil
 }
 
-func searchFileContent(file F, meta *Meta, password string, index int, lowerPhrase string) ([]string, error) {
+// SearchResult holds the matching lines of one file, each prefixed with
+// its line number as in "42: the matching line".
+type SearchResult struct {
+	Index int
+	Name  string
+	Lines []string
+}
+
+// searchAll returns the matching lines of every used slot, without
+// printing anything but the warnings of searchSlots.
+func searchAll(file F, meta *Meta, phrase string) ([]SearchResult, error) {
+	m, err := newSearchMatcher(phrase)
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := GetEncKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get encryption key: %w", err)
+	}
+
+	return searchSlots(file, meta, password, m), nil
+}
+
+// searchSlots returns the matching lines of every used slot, ordered by
+// match count with SortByMatches. A file that fails to decrypt is skipped
+// with a warning, so one bad slot doesn't hide the matches in the rest.
+func searchSlots(file F, meta *Meta, password string, m *searchMatcher) []SearchResult {
+	var results []SearchResult
+	for i, f := range meta.Files {
+		if f.Name == "" {
+			continue
+		}
+
+		lines, err := searchFileContent(file, meta, password, i, m)
+		if err != nil {
+			LogWarn("failed to search [%d] %s: %v", i, f.Name, err)
+			continue
+		}
+
+		if len(lines) > 0 {
+			results = append(results, SearchResult{Index: i, Name: f.Name, Lines: lines})
+		}
+	}
+
+	if SortByMatches {
+		sortByMatchCount(results)
+	}
+
+	return results
+}
+
+// sortByMatchCount orders results by descending match count, keeping
+// slot order for files with the same number of hits.
+func sortByMatchCount(results []SearchResult) {
+	sort.SliceStable(results, func(a, b int) bool {
+		return len(results[a].Lines) > len(results[b].Lines)
+	})
+}
+
+// searchFileContent returns the lines of slot index that m matches, each
+// prefixed with its 1-based line number. Binary content is matched by
+// offset instead.
+func searchFileContent(file F, meta *Meta, password string, index int, m *searchMatcher) ([]string, error) {
 	df := meta.Files[index]
 
 	seekPos := int64(META_FILE_SIZE) + (int64(index) * int64(MAX_FILE_SIZE))
@@ -144,21 +395,36 @@ func searchFileContent(file F, meta *Meta, password string, index int, lowerPhra
 		return nil, fmt.Errorf("short read: read %d bytes, expected %d", n, df.Size)
 	}
 
-	decrypted, err := DecryptGCM(buff, password, meta.Salt)
+	aad, err := fileAAD(df)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted, err := DecryptGCM(buff, password, meta.Salt, aad)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decrypt: %w", err)
 	}
 
+	decrypted, err = openPayload(df, decrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	if isBinary(decrypted) {
+		return m.matchBinary(decrypted), nil
+	}
+
 	var matches []string
 	scanner := bufio.NewScanner(bytes.NewReader(decrypted))
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), max(len(decrypted)+1, bufio.MaxScanTokenSize))
+	scanner.Split(scanAnyLines)
 	lineNum := 1
 
 	for scanner.Scan() {
 		line := scanner.Text()
-		lowerLine := strings.ToLower(line)
 
-		if strings.Contains(lowerLine, lowerPhrase) {
-			matches = append(matches, fmt.Sprintf("%s", line))
+		if m.match(line) {
+			matches = append(matches, fmt.Sprintf("%d: %s", lineNum, line))
 		}
 		lineNum++
 	}
@@ -169,3 +435,68 @@ func searchFileContent(file F, meta *Meta, password string, index int, lowerPhra
 
 	return matches, nil
 }
+
+// isBinary reports whether content should be searched as raw bytes rather
+// than lines: anything with a NUL byte or that isn't valid UTF-8.
+func isBinary(content []byte) bool {
+	return bytes.IndexByte(content, 0) >= 0 || !utf8.Valid(content)
+}
+
+// searchBinary finds lowerPhrase in the raw bytes of content, ignoring
+// ASCII case, and returns one line per match giving its offset.
+func searchBinary(content []byte, lowerPhrase string) []string {
+	// bytes.ToLower would rewrite invalid UTF-8 and shift the offsets, so
+	// only ASCII letters are folded.
+	lower := make([]byte, len(content))
+	for i, b := range content {
+		if 'A' <= b && b <= 'Z' {
+			b += 'a' - 'A'
+		}
+		lower[i] = b
+	}
+	phrase := []byte(lowerPhrase)
+
+	var matches []string
+	for off := 0; ; {
+		i := bytes.Index(lower[off:], phrase)
+		if i < 0 {
+			break
+		}
+		matches = append(matches, fmt.Sprintf("binary match at offset %d", off+i))
+		off += i + len(phrase)
+	}
+
+	return matches
+}
+
+// scanAnyLines is a bufio.SplitFunc like bufio.ScanLines that also treats
+// a bare \r as a line break, so \n, \r\n and \r terminated content all
+// split the same way and no terminator is left on the returned line.
+func scanAnyLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+		if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		}
+		if atEOF {
+			return i + 1, data[:i], nil
+		}
+		// A trailing \r may be the first half of \r\n.
+		return 0, nil, nil
+	}
+
+	if atEOF {
+		return len(data), data, nil
+	}
+
+	return 0, nil, nil
+}
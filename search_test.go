@@ -2,10 +2,12 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestSearchName(t *testing.T) {
@@ -95,7 +97,7 @@ func TestSearchName(t *testing.T) {
 
 			for idx, filename := range tt.files {
 				content := []byte("test content for " + filename)
-				encrypted, err := EncryptGCM(content, password, meta.Salt)
+				encrypted, err := EncryptGCM(content, password, meta.Salt, nil)
 				if err != nil {
 					t.Fatalf("Failed to encrypt: %v", err)
 				}
@@ -277,7 +279,7 @@ func TestSearchContent(t *testing.T) {
 
 			for idx, filename := range tt.files {
 				content := []byte(tt.fileContents[idx])
-				encrypted, err := EncryptGCM(content, password, meta.Salt)
+				encrypted, err := EncryptGCM(content, password, meta.Salt, nil)
 				if err != nil {
 					t.Fatalf("Failed to encrypt: %v", err)
 				}
@@ -417,7 +419,7 @@ func TestSearchFileContent(t *testing.T) {
 			content:         "Line 1 has keyword\nLine 2 is normal\nLine 3 has keyword too",
 			searchPhrase:    "keyword",
 			expectedMatches: 2,
-			shouldContain:   []string{"Line 1", "Line 3"},
+			shouldContain:   []string{"1: Line 1", "3: Line 3"},
 		},
 		{
 			name:             "no matches",
@@ -451,7 +453,7 @@ func TestSearchFileContent(t *testing.T) {
 			password, _ := GetEncKey()
 			meta, _ := ReadMeta(file)
 
-			encrypted, err := EncryptGCM([]byte(tt.content), password, meta.Salt)
+			encrypted, err := EncryptGCM([]byte(tt.content), password, meta.Salt, nil)
 			if err != nil {
 				t.Fatalf("Failed to encrypt: %v", err)
 			}
@@ -468,7 +470,7 @@ func TestSearchFileContent(t *testing.T) {
 				Size: len(encrypted),
 			}
 
-			matches, err := searchFileContent(file, meta, password, 0, strings.ToLower(tt.searchPhrase))
+			matches, err := searchFileContent(file, meta, password, 0, mustMatcher(t, strings.ToLower(tt.searchPhrase)))
 			if err != nil {
 				t.Fatalf("searchFileContent failed: %v", err)
 			}
@@ -527,7 +529,7 @@ func TestSearchFileContentDecryptionError(t *testing.T) {
 
 	password, _ := GetEncKey()
 
-	_, err := searchFileContent(file, meta, password, 0, "test")
+	_, err := searchFileContent(file, meta, password, 0, mustMatcher(t, "test"))
 	if err == nil {
 		t.Error("Expected decryption error for corrupt data, got nil")
 	}
@@ -549,7 +551,7 @@ func TestSearchWithSpecialCharacters(t *testing.T) {
 	meta, _ := ReadMeta(file)
 
 	specialContent := "Special chars: @#$%^&*()_+-=[]{}|;:',.<>?/`~"
-	encrypted, _ := EncryptGCM([]byte(specialContent), password, meta.Salt)
+	encrypted, _ := EncryptGCM([]byte(specialContent), password, meta.Salt, nil)
 
 	seekPos := META_FILE_SIZE
 	file.Seek(int64(seekPos), 0)
@@ -595,7 +597,7 @@ func TestSearchNameWithUnicodeCharacters(t *testing.T) {
 
 	unicodeFilename := "文档_документ_📄.txt"
 	content := []byte("Unicode test content")
-	encrypted, _ := EncryptGCM(content, password, meta.Salt)
+	encrypted, _ := EncryptGCM(content, password, meta.Salt, nil)
 
 	seekPos := META_FILE_SIZE
 	file.Seek(int64(seekPos), 0)
@@ -631,3 +633,433 @@ func TestSearchNameWithUnicodeCharacters(t *testing.T) {
 		t.Error("Expected to find unicode characters in output")
 	}
 }
+
+func TestSearchContentSortByMatches(t *testing.T) {
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	if err := InitMeta(file, "file"); err != nil {
+		t.Fatalf("Failed to init metadata: %v", err)
+	}
+
+	password, _ := GetEncKey()
+	meta, _ := ReadMeta(file)
+
+	files := map[int]struct {
+		name    string
+		content string
+	}{
+		0: {"one.txt", "secret once\nnothing else"},
+		1: {"three.txt", "secret\nsecret again\nmore secret"},
+		2: {"two.txt", "secret here\nand secret there"},
+	}
+
+	for idx, f := range files {
+		encrypted, err := EncryptGCM([]byte(f.content), password, meta.Salt, nil)
+		if err != nil {
+			t.Fatalf("Failed to encrypt: %v", err)
+		}
+
+		file.Seek(int64(META_FILE_SIZE+(idx*MAX_FILE_SIZE)), 0)
+		padded := make([]byte, MAX_FILE_SIZE)
+		copy(padded, encrypted)
+		file.Write(padded)
+
+		meta.Files[idx] = File{Name: f.name, Size: len(encrypted)}
+	}
+	WriteMeta(file, meta)
+
+	SortByMatches = true
+	defer func() { SortByMatches = false }()
+
+	var err error
+	output := captureOutput(func() {
+		err = SearchContent(file, "secret", OUT_OF_BOUNDS_INDEX)
+	})
+	if err != nil {
+		t.Fatalf("SearchContent failed: %v", err)
+	}
+
+	three := strings.Index(output, "three.txt")
+	two := strings.Index(output, "two.txt")
+	one := strings.Index(output, "one.txt")
+	if three < 0 || two < 0 || one < 0 {
+		t.Fatalf("Expected all files in output, got:\n%s", output)
+	}
+	if !(three < two && two < one) {
+		t.Errorf("Expected order three.txt, two.txt, one.txt; got positions %d, %d, %d", three, two, one)
+	}
+}
+
+func TestSearchFileContentLineEndings(t *testing.T) {
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	if err := InitMeta(file, "file"); err != nil {
+		t.Fatalf("Failed to init metadata: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"LF", "alpha key\nbeta\ngamma key\n"},
+		{"CRLF", "alpha key\r\nbeta\r\ngamma key\r\n"},
+		{"bare CR", "alpha key\rbeta\rgamma key\r"},
+		{"mixed", "alpha key\r\nbeta\rgamma key\n"},
+		{"no trailing terminator", "alpha key\r\nbeta\r\ngamma key"},
+	}
+
+	for i, tt := range tests {
+		sourcePath := CreateTempSourceFile(t, []byte(tt.content))
+		if err := Add(file, sourcePath, i); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	password, _ := GetEncKey()
+	meta, err := ReadMeta(file)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, err := searchFileContent(file, meta, password, i, mustMatcher(t, "key"))
+			if err != nil {
+				t.Fatalf("searchFileContent failed: %v", err)
+			}
+
+			expected := []string{"1: alpha key", "3: gamma key"}
+			if len(matches) != len(expected) {
+				t.Fatalf("Expected %d matches, got %d: %q", len(expected), len(matches), matches)
+			}
+			for j := range expected {
+				if matches[j] != expected[j] {
+					t.Errorf("Match %d: expected %q, got %q", j, expected[j], matches[j])
+				}
+			}
+		})
+	}
+}
+
+func TestFindByNameHashed(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	Add(file, CreateTempSourceFileWithName(t, []byte("plain"), "plain.txt"), 0)
+
+	NameHash = true
+	Add(file, CreateTempSourceFileWithName(t, []byte("hidden"), "secret-plans.txt"), 1)
+	NameHash = false
+
+	meta := VerifyMetadataIntegrity(t, file)
+	stored := meta.Files[1]
+	if strings.Contains(stored.Name, "secret") || strings.Contains(string(stored.SealedName), "secret") {
+		t.Errorf("Stored metadata reveals the name: %q", stored.Name)
+	}
+	if stored.Name != hashName(meta.Salt, "secret-plans.txt") {
+		t.Errorf("Expected the salted hash as name, got %q", stored.Name)
+	}
+
+	for name, expected := range map[string]int{"plain.txt": 0, "secret-plans.txt": 1} {
+		index, err := FindByName(file, name)
+		if err != nil {
+			t.Errorf("FindByName(%q) failed: %v", name, err)
+			continue
+		}
+		if index != expected {
+			t.Errorf("FindByName(%q): expected %d, got %d", name, expected, index)
+		}
+	}
+
+	if _, err := FindByName(file, "secret-plans"); err == nil {
+		t.Error("Expected a partial name not to match a hashed name")
+	}
+
+	password, _ := GetEncKey()
+	if name := realName(stored, password, meta.Salt); name != "secret-plans.txt" {
+		t.Errorf("Expected the sealed name to decrypt, got %q", name)
+	}
+}
+
+func TestSearchFileContentLongLineAndBinary(t *testing.T) {
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	if err := InitMeta(file, "file"); err != nil {
+		t.Fatalf("Failed to init metadata: %v", err)
+	}
+
+	// A single line close to the slot size, with the phrase at the end.
+	longLine := strings.Repeat("x", int(MaxPlaintextSize())-20) + " NeedleHere"
+
+	// Binary content without newlines, with invalid UTF-8 before the
+	// phrase so a rune-based lowercase would shift the offset.
+	binary := GenerateRandomBytes(3000)
+	for i := range binary {
+		if binary[i] == '\n' || binary[i] == 'n' || binary[i] == 'N' {
+			binary[i] = 0
+		}
+	}
+	binary[10] = 0xFF
+	copy(binary[1000:], "NEEDLE")
+	copy(binary[2000:], "needle")
+
+	for i, content := range [][]byte{[]byte(longLine), binary} {
+		if err := Add(file, CreateTempSourceFile(t, content), i); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	password, _ := GetEncKey()
+	meta, err := ReadMeta(file)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+
+	matches, err := searchFileContent(file, meta, password, 0, mustMatcher(t, "needle"))
+	if err != nil {
+		t.Fatalf("searchFileContent failed on long line: %v", err)
+	}
+	if len(matches) != 1 || matches[0] != "1: "+longLine {
+		t.Errorf("Expected the whole long line as the only match, got %d matches", len(matches))
+	}
+
+	matches, err = searchFileContent(file, meta, password, 1, mustMatcher(t, "needle"))
+	if err != nil {
+		t.Fatalf("searchFileContent failed on binary content: %v", err)
+	}
+	expected := []string{"binary match at offset 1000", "binary match at offset 2000"}
+	if len(matches) != len(expected) {
+		t.Fatalf("Expected %d binary matches, got %q", len(expected), matches)
+	}
+	for i := range expected {
+		if matches[i] != expected[i] {
+			t.Errorf("Match %d: expected %q, got %q", i, expected[i], matches[i])
+		}
+	}
+}
+
+func TestSearchNameFuzzy(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	if err := InitMeta(file, "file"); err != nil {
+		t.Fatalf("Failed to init metadata: %v", err)
+	}
+
+	meta, _ := ReadMeta(file)
+	for i, name := range []string{"report_draft.txt", "notes.txt", "quarterly_report.pdf", "recipe.md"} {
+		meta.Files[i] = File{Name: name, Size: 100}
+	}
+	WriteMeta(file, meta)
+
+	Fuzzy = true
+	defer func() { Fuzzy = false }()
+
+	var err error
+	output := captureOutput(func() {
+		err = SearchName(file, "Quartrly_reprot")
+	})
+	if err != nil {
+		t.Fatalf("SearchName failed: %v", err)
+	}
+
+	lines := strings.Split(output, "\n")
+	first := ""
+	for _, line := range lines {
+		if strings.Contains(line, "distance") {
+			first = line
+			break
+		}
+	}
+	if !strings.Contains(first, "quarterly_report.pdf") {
+		t.Errorf("Expected quarterly_report.pdf as the top match, got:\n%s", output)
+	}
+	if strings.Contains(output, "notes.txt") || strings.Contains(output, "recipe.md") {
+		t.Errorf("Expected unrelated names to be left out, got:\n%s", output)
+	}
+
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"", "abc", 3},
+		{"kitten", "sitting", 3},
+		{"flaw", "lawn", 2},
+		{"same", "same", 0},
+		{"héllo", "hello", 1},
+	} {
+		if got := levenshtein(tc.a, tc.b); got != tc.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestSearchRegex(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	SearchRegex = true
+	defer func() { SearchRegex = false }()
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	contents := map[string]string{
+		"report_2024.pdf":  "user = admin\npassword = hunter2\npassword:\nPASSWORD = loud\n",
+		"report_draft.pdf": "password=\n",
+	}
+	for name, content := range contents {
+		if err := Add(file, CreateTempSourceFileWithName(t, []byte(content), name), OUT_OF_BOUNDS_INDEX); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	binary := append([]byte{0, 1, 2}, []byte("key=42\x00key=7")...)
+	if err := Add(file, CreateTempSourceFileWithName(t, binary, "blob.bin"), OUT_OF_BOUNDS_INDEX); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	password, _ := GetEncKey()
+	meta, err := ReadMeta(file)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+	index := func(name string) int {
+		for i, f := range meta.Files {
+			if f.Name == name {
+				return i
+			}
+		}
+		t.Fatalf("File %s not found", name)
+		return -1
+	}
+
+	matches, err := searchFileContent(file, meta, password, index("report_2024.pdf"), mustMatcher(t, `password\s*=\s*\S+`))
+	if err != nil {
+		t.Fatalf("searchFileContent failed: %v", err)
+	}
+	if len(matches) != 1 || matches[0] != "2: password = hunter2" {
+		t.Errorf("Expected only the case-sensitive regex match on line 2, got %q", matches)
+	}
+
+	matches, err = searchFileContent(file, meta, password, index("blob.bin"), mustMatcher(t, `key=\d+`))
+	if err != nil {
+		t.Fatalf("searchFileContent failed: %v", err)
+	}
+	expected := []string{"binary match at offset 3", "binary match at offset 10"}
+	if len(matches) != len(expected) || matches[0] != expected[0] || matches[1] != expected[1] {
+		t.Errorf("Expected binary matches %q, got %q", expected, matches)
+	}
+
+	var nameErr error
+	output := captureOutput(func() {
+		nameErr = SearchName(file, `^report_\d{4}\.pdf$`)
+	})
+	if nameErr != nil {
+		t.Fatalf("SearchName failed: %v", nameErr)
+	}
+	if !strings.Contains(output, "report_2024.pdf") || strings.Contains(output, "report_draft.pdf") {
+		t.Errorf("Expected only report_2024.pdf to match, got:\n%s", output)
+	}
+
+	if err := SearchContent(file, "password(", OUT_OF_BOUNDS_INDEX); err == nil || !strings.Contains(err.Error(), "invalid search regex") {
+		t.Errorf("Expected an invalid regex error from SearchContent, got %v", err)
+	}
+	if err := SearchName(file, "[a-"); err == nil || !strings.Contains(err.Error(), "invalid search regex") {
+		t.Errorf("Expected an invalid regex error from SearchName, got %v", err)
+	}
+
+	// The pattern is checked before the password is asked for.
+	ClearPasswordCache()
+	prompt := passwordPrompt
+	defer func() { passwordPrompt = prompt }()
+	passwordPrompt = func(string) (string, error) {
+		t.Error("Prompted for the password before the regex was checked")
+		return "", io.EOF
+	}
+	if err := SearchContent(file, "password(", OUT_OF_BOUNDS_INDEX); err == nil || !strings.Contains(err.Error(), "invalid search regex") {
+		t.Errorf("Expected an invalid regex error from SearchContent, got %v", err)
+	}
+}
+
+func TestSearchJSON(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	JSONEvents = true
+	defer func() { JSONEvents = false }()
+
+	file := GetSharedTestFile(t)
+	if err := InitMeta(file, "file"); err != nil {
+		t.Fatalf("InitMeta failed: %v", err)
+	}
+
+	if err := Add(file, CreateTempSourceFileWithName(t, []byte("one\nthe secret\n"), "notes.txt"), 0); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := Add(file, CreateTempSourceFileWithName(t, []byte("nothing here"), "secret-name.txt"), 4); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	output := captureOutput(func() {
+		if err := SearchName(file, "secret"); err != nil {
+			t.Errorf("SearchName failed: %v", err)
+		}
+	})
+	var names []fileRecord
+	if err := json.Unmarshal([]byte(output), &names); err != nil {
+		t.Fatalf("search-name output is not JSON: %v\n%s", err, output)
+	}
+	if len(names) != 1 || names[0].Index != 4 || names[0].Name != "secret-name.txt" {
+		t.Errorf("Unexpected search-name JSON: %+v", names)
+	}
+
+	output = captureOutput(func() {
+		if err := SearchContent(file, "secret", OUT_OF_BOUNDS_INDEX); err != nil {
+			t.Errorf("SearchContent failed: %v", err)
+		}
+	})
+	var results []SearchResult
+	if err := json.Unmarshal([]byte(output), &results); err != nil {
+		t.Fatalf("search output is not JSON: %v\n%s", err, output)
+	}
+	if len(results) != 1 || results[0].Index != 0 || len(results[0].Lines) != 1 || results[0].Lines[0] != "2: the secret" {
+		t.Errorf("Unexpected search JSON: %+v", results)
+	}
+
+	output = captureOutput(func() {
+		if err := SearchContent(file, "secret", 4); err != nil {
+			t.Errorf("SearchContent failed: %v", err)
+		}
+	})
+	if strings.TrimSpace(output) != "[]" {
+		t.Errorf("Expected an empty array for a file without matches, got %q", output)
+	}
+}
+
+func mustMatcher(t *testing.T, phrase string) *searchMatcher {
+	t.Helper()
+
+	m, err := newSearchMatcher(phrase)
+	if err != nil {
+		t.Fatalf("newSearchMatcher failed: %v", err)
+	}
+	return m
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// shellEvent is the JSON result of one shell command.
+type shellEvent struct {
+	Command string `json:"command"`
+	OK      bool   `json:"ok"`
+	Error   string `json:"error,omitempty"`
+	Result  any    `json:"result,omitempty"`
+}
+
+// Shell reads commands from in, one per line, and runs them against file
+// with a single password prompt for the whole session. Supported commands
+// are list [filter], add [path] [index], get [index] [path], del [index|name],
+// search [phrase], open [device] and exit. open switches the session to
+// another volume, asking for its password if the current one doesn't
+// unlock it.
+//
+// With JSONEvents set no prompt or colored output is written; instead
+// every command produces exactly one shellEvent object on out.
+func Shell(file F, in io.Reader, out io.Writer) error {
+	return runSession(file, in, out, false)
+}
+
+// Batch runs a script of shell commands from in without the prompt. Blank
+// lines and lines starting with # are ignored, and the first failing
+// command stops the script and is returned with its line number. With Echo
+// each command is printed before its output.
+func Batch(file F, in io.Reader, out io.Writer) error {
+	return runSession(file, in, out, true)
+}
+
+func runSession(file F, in io.Reader, out io.Writer, batch bool) error {
+	var enc *json.Encoder
+	if JSONEvents {
+		enc = json.NewEncoder(out)
+
+		silent := Silent
+		Silent = true
+		defer func() { Silent = silent }()
+	}
+
+	// file belongs to the caller; only volumes switched to with open are
+	// closed here, the last one when the session ends.
+	var opened F
+	defer func() { closeShellVolume(opened) }()
+
+	scanner := bufio.NewScanner(in)
+	line := 0
+	for {
+		if enc == nil && !batch {
+			Printf("%s ", C(ColorBold+ColorLightBlue, "hdnfs>"))
+		}
+
+		if !scanner.Scan() {
+			break
+		}
+		line++
+
+		args := strings.Fields(scanner.Text())
+		if len(args) == 0 {
+			continue
+		}
+		if batch && strings.HasPrefix(args[0], "#") {
+			continue
+		}
+		if Echo && enc == nil {
+			Printf("%s\n", C(ColorDim, "+ "+strings.Join(args, " ")))
+		}
+		if args[0] == "exit" || args[0] == "quit" {
+			break
+		}
+
+		var result any
+		var err error
+		if args[0] == "open" {
+			var next F
+			next, err = openShellVolume(args)
+			if err == nil {
+				closeShellVolume(opened)
+				file, opened = next, next
+				result = next.Name()
+				if enc == nil && !batch {
+					PrintSuccess(fmt.Sprintf("Opened %s", next.Name()))
+				}
+			}
+		} else {
+			result, err = runShellCommand(file, args)
+		}
+
+		if enc != nil {
+			ev := shellEvent{Command: args[0], OK: err == nil, Result: result}
+			if err != nil {
+				ev.Error = err.Error()
+			}
+			if err := enc.Encode(ev); err != nil {
+				return fmt.Errorf("failed to encode result: %w", err)
+			}
+		} else if err != nil && !batch {
+			Printf("%s\n", C(ColorRed, err.Error()))
+		}
+
+		if err != nil && batch {
+			return fmt.Errorf("line %d: %s: %w", line, args[0], err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read command: %w", err)
+	}
+
+	return nil
+}
+
+// openShellVolume opens the device named by open [device]. The cached
+// password is tried on its metadata first; if it doesn't decrypt, the
+// password is asked for again, and the old one restored if that fails too
+// so the session can carry on with the volume it has.
+func openShellVolume(args []string) (F, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("usage: open [device]")
+	}
+
+	next, err := os.OpenFile(args[1], os.O_RDWR, 0o777)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open [device]: %w", err)
+	}
+
+	if _, err := ReadHeader(next); err != nil {
+		next.Close()
+		return nil, err
+	}
+	if v, err := OpenVolume(next); err == nil {
+		return v, nil
+	}
+
+	current, err := GetPassword()
+	if err != nil {
+		next.Close()
+		return nil, err
+	}
+	ClearPasswordCache()
+
+	v, err := OpenVolume(next)
+	if err != nil {
+		next.Close()
+		setCachedPassword(current)
+		return nil, fmt.Errorf("failed to open %s: %w", args[1], err)
+	}
+
+	return v, nil
+}
+
+// closeShellVolume closes a volume opened by openShellVolume, if any.
+func closeShellVolume(file F) {
+	if c, ok := file.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// runShellCommand runs a single shell command. The returned result is only
+// used for JSON output; in text mode the commands print as they do on the
+// command line.
+func runShellCommand(file F, args []string) (any, error) {
+	switch args[0] {
+	case "list":
+		filter := ""
+		if len(args) > 1 {
+			filter = args[1]
+		}
+		if !JSONEvents {
+			return nil, List(file, filter)
+		}
+
+		meta, err := ReadMeta(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read metadata: %w", err)
+		}
+		records := []fileRecord{}
+		for i, v := range meta.Files {
+			if v.Name != "" && strings.Contains(v.Name, filter) {
+				records = append(records, newFileRecord(i, v))
+			}
+		}
+		return records, nil
+
+	case "add":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("usage: add [path] [index]")
+		}
+		index := OUT_OF_BOUNDS_INDEX
+		if len(args) > 2 {
+			var err error
+			index, err = ResolveIndex(file, args[2])
+			if err != nil {
+				return nil, fmt.Errorf("invalid [index]: %w", err)
+			}
+		}
+
+		index, err := addFile(file, args[1], index)
+		if err != nil {
+			return nil, err
+		}
+
+		meta, err := ReadMeta(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read metadata: %w", err)
+		}
+		return newFileRecord(index, meta.Files[index]), nil
+
+	case "get":
+		if len(args) < 3 {
+			return nil, fmt.Errorf("usage: get [index] [path]")
+		}
+		index, err := ResolveIndex(file, args[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid [index]: %w", err)
+		}
+		return nil, Get(file, index, args[2])
+
+	case "del":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("usage: del [index|name]")
+		}
+		freed, err := Del(file, args[1])
+		if err != nil {
+			return nil, err
+		}
+		for _, index := range freed {
+			PrintSuccess(fmt.Sprintf("Successfully deleted file at index %d", index))
+		}
+		return freed, nil
+
+	case "search":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("usage: search [phrase]")
+		}
+		phrase := strings.Join(args[1:], " ")
+		if !JSONEvents {
+			return nil, SearchContent(file, phrase, OUT_OF_BOUNDS_INDEX)
+		}
+
+		meta, err := ReadMeta(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read metadata: %w", err)
+		}
+		return searchAll(file, meta, phrase)
+
+	default:
+		return nil, fmt.Errorf("unknown command: %s", args[0])
+	}
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShellJSONEvents(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	JSONEvents = true
+	defer func() { JSONEvents = false }()
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	source := CreateTempSourceFileWithName(t, []byte("hello shell\nbye"), "shell.txt")
+	outPath := filepath.Join(t.TempDir(), "out.txt")
+
+	script := strings.Join([]string{
+		"add " + source,
+		"list",
+		"search hello",
+		"get 0 " + outPath,
+		"del 5",
+		"bogus",
+		"del 0",
+		"list",
+		"exit",
+		"list",
+	}, "\n")
+
+	var out bytes.Buffer
+	if err := Shell(file, strings.NewReader(script), &out); err != nil {
+		t.Fatalf("Shell failed: %v", err)
+	}
+
+	var events []map[string]any
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var ev map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			t.Fatalf("Line is not valid JSON: %v\n%s", err, scanner.Text())
+		}
+		events = append(events, ev)
+	}
+
+	expected := []struct {
+		command string
+		ok      bool
+	}{
+		{"add", true},
+		{"list", true},
+		{"search", true},
+		{"get", true},
+		{"del", false},
+		{"bogus", false},
+		{"del", true},
+		{"list", true},
+	}
+
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %d events, got %d:\n%s", len(expected), len(events), out.String())
+	}
+
+	for i, e := range expected {
+		if events[i]["command"] != e.command || events[i]["ok"] != e.ok {
+			t.Errorf("Event %d: expected %s ok=%v, got %v", i, e.command, e.ok, events[i])
+		}
+		if !e.ok && events[i]["error"] == nil {
+			t.Errorf("Event %d: expected an error message", i)
+		}
+	}
+
+	if list, ok := events[1]["result"].([]any); !ok || len(list) != 1 {
+		t.Errorf("Expected one file in first list, got %v", events[1]["result"])
+	}
+	if fmt.Sprint(events[2]["result"]) == "<nil>" {
+		t.Error("Expected search to return a match")
+	}
+	if list, ok := events[7]["result"].([]any); !ok || len(list) != 0 {
+		t.Errorf("Expected empty list after delete, got %v", events[7]["result"])
+	}
+}
+
+func TestBatchEcho(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	Echo = true
+	defer func() { Echo = false }()
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	a := CreateTempSourceFileWithName(t, []byte("alpha"), "a.txt")
+	commands := []string{
+		"add " + a,
+		"list",
+		"del 0",
+	}
+	script := "# not echoed\n" + strings.Join(commands, "\n")
+
+	var err error
+	output := captureOutput(func() {
+		err = Batch(file, strings.NewReader(script), io.Discard)
+	})
+	if err != nil {
+		t.Fatalf("Batch failed: %v", err)
+	}
+
+	if strings.Contains(output, "not echoed") {
+		t.Error("Comments should not be echoed")
+	}
+	last := -1
+	for _, cmd := range commands {
+		pos := strings.Index(output, "+ "+cmd)
+		if pos < 0 {
+			t.Fatalf("Expected %q to be echoed, got:\n%s", cmd, output)
+		}
+		if pos < last {
+			t.Errorf("Expected %q to be echoed after the command before it", cmd)
+		}
+		last = pos
+	}
+
+	// The echo comes before the command's own output.
+	if strings.Index(output, "+ add") > strings.Index(output, "FILE ADDED") {
+		t.Error("Expected the add to be echoed before its output")
+	}
+}
+
+func TestShellOpen(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	Silent = true
+	defer func() { Silent = false }()
+
+	const otherPassword = "other-password-for-third"
+
+	volumes := make([]string, 3)
+	for i := range volumes {
+		f := CreateTempTestFile(t, 0)
+		if i == 2 {
+			SetPasswordForTesting(otherPassword)
+		}
+		if err := InitMeta(f, "file"); err != nil {
+			t.Fatalf("InitMeta failed: %v", err)
+		}
+		volumes[i] = f.Name()
+		f.Close()
+	}
+	SetupTestKey(t)
+
+	first, err := os.OpenFile(volumes[0], os.O_RDWR, 0o777)
+	if err != nil {
+		t.Fatalf("Failed to open volume: %v", err)
+	}
+	defer first.Close()
+
+	script := strings.Join([]string{
+		"add " + CreateTempSourceFileWithName(t, []byte("alpha"), "a.txt"),
+		"open " + volumes[1],
+		"add " + CreateTempSourceFileWithName(t, []byte("bravo"), "b.txt"),
+		"open " + volumes[2],
+		"add " + CreateTempSourceFileWithName(t, []byte("charlie"), "c.txt"),
+	}, "\n")
+
+	prompts := fakePrompt(t, otherPassword)
+	if err := Shell(first, strings.NewReader(script), io.Discard); err != nil {
+		t.Fatalf("Shell failed: %v", err)
+	}
+	if *prompts != 1 {
+		t.Errorf("Expected one prompt for the volume with another password, got %d", *prompts)
+	}
+	if _, err := first.Stat(); err != nil {
+		t.Errorf("Shell closed the handle it was given: %v", err)
+	}
+
+	for i, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		SetupTestKey(t)
+		if i == 2 {
+			SetPasswordForTesting(otherPassword)
+		}
+		f, err := os.Open(volumes[i])
+		if err != nil {
+			t.Fatalf("Failed to open volume: %v", err)
+		}
+		meta := VerifyMetadataIntegrity(t, f)
+		f.Close()
+		if CountUsedSlots(meta) != 1 || meta.Files[0].Name != name {
+			t.Errorf("Volume %d: expected only %s, got %q", i, name, meta.Files[0].Name)
+		}
+	}
+
+	// A wrong password keeps the session on the volume it had.
+	SetupTestKey(t)
+	first, err = os.OpenFile(volumes[0], os.O_RDWR, 0o777)
+	if err != nil {
+		t.Fatalf("Failed to open volume: %v", err)
+	}
+	defer first.Close()
+
+	fakePrompt(t, "wrong-password-for-third")
+	script = strings.Join([]string{
+		"open " + volumes[2],
+		"add " + CreateTempSourceFileWithName(t, []byte("delta"), "d.txt"),
+	}, "\n")
+	if err := Shell(first, strings.NewReader(script), io.Discard); err != nil {
+		t.Fatalf("Shell failed: %v", err)
+	}
+
+	meta := VerifyMetadataIntegrity(t, first)
+	if meta.Files[1].Name != "d.txt" {
+		t.Errorf("Expected the add after a failed open to go to the first volume, got %q", meta.Files[1].Name)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	Silent = true
+	defer func() { Silent = false }()
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	a := CreateTempSourceFileWithName(t, []byte("alpha"), "a.txt")
+	b := CreateTempSourceFileWithName(t, []byte("bravo"), "b.txt")
+	c := CreateTempSourceFileWithName(t, []byte("charlie"), "c.txt")
+	outPath := filepath.Join(t.TempDir(), "out.txt")
+
+	script := strings.Join([]string{
+		"# build the volume",
+		"add " + a,
+		"add " + b,
+		"",
+		"add " + c + " 10",
+		"del 0",
+		"add " + c,
+		"get 10 " + outPath,
+	}, "\n")
+
+	if err := Batch(file, strings.NewReader(script), io.Discard); err != nil {
+		t.Fatalf("Batch failed: %v", err)
+	}
+
+	meta := VerifyMetadataIntegrity(t, file)
+	if CountUsedSlots(meta) != 3 {
+		t.Fatalf("Expected 3 files, got %d", CountUsedSlots(meta))
+	}
+	for i, name := range map[int]string{0: "c.txt", 1: "b.txt", 10: "c.txt"} {
+		if meta.Files[i].Name != name {
+			t.Errorf("Slot %d: expected %s, got %q", i, name, meta.Files[i].Name)
+		}
+	}
+	if data, _ := os.ReadFile(outPath); string(data) != "charlie" {
+		t.Errorf("Expected get to see the earlier add, got %q", data)
+	}
+
+	script = strings.Join([]string{
+		"del 1",
+		"del 500",
+		"del 10",
+	}, "\n")
+
+	err := Batch(file, strings.NewReader(script), io.Discard)
+	if err == nil {
+		t.Fatal("Expected Batch to fail on the second line")
+	}
+	if !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected the failing line number in the error, got: %v", err)
+	}
+
+	meta = VerifyMetadataIntegrity(t, file)
+	if meta.Files[1].Name != "" {
+		t.Error("Expected the command before the failure to run")
+	}
+	if meta.Files[10].Name == "" {
+		t.Error("Expected the commands after the failure to be skipped")
+	}
+}
+
+func TestShellJSONAddAndSearch(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	JSONEvents = true
+	defer func() { JSONEvents = false }()
+
+	file := NewMockFile(0)
+	if err := InitMeta(file, "file"); err != nil {
+		t.Fatalf("InitMeta failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.txt")
+	os.WriteFile(first, []byte("needle one"), 0o644)
+	if err := Add(file, CreateTempSourceFileWithName(t, []byte("placeholder"), "old.txt"), 3); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := SetAlias(file, "target", 3); err != nil {
+		t.Fatalf("SetAlias failed: %v", err)
+	}
+
+	run := func(script ...string) []shellEvent {
+		t.Helper()
+		var out bytes.Buffer
+		if err := Shell(file, strings.NewReader(strings.Join(script, "\n")), &out); err != nil {
+			t.Fatalf("Shell failed: %v", err)
+		}
+		var events []shellEvent
+		scanner := bufio.NewScanner(&out)
+		for scanner.Scan() {
+			var ev shellEvent
+			if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+				t.Fatalf("Line is not valid JSON: %v\n%s", err, scanner.Text())
+			}
+			events = append(events, ev)
+		}
+		return events
+	}
+	index := func(ev shellEvent) int {
+		t.Helper()
+		if !ev.OK {
+			t.Fatalf("%s failed: %s", ev.Command, ev.Error)
+		}
+		rec, ok := ev.Result.(map[string]any)
+		if !ok {
+			t.Fatalf("Unexpected %s result: %v", ev.Command, ev.Result)
+		}
+		return int(rec["index"].(float64))
+	}
+
+	events := run("add " + CreateTempSourceFileWithName(t, []byte("needle two"), "second.txt") + " @target")
+	if got := index(events[0]); got != 3 {
+		t.Errorf("Expected add to @target to write slot 3, got %d", got)
+	}
+
+	IfChanged = true
+	events = run("add " + first)
+	at := index(events[0])
+	os.WriteFile(first, []byte("needle one, edited"), 0o644)
+	events = run("add " + first)
+	IfChanged = false
+	if got := index(events[0]); got != at {
+		t.Errorf("Expected the changed file to be reported in its own slot %d, got %d", at, got)
+	}
+
+	// A file that no longer decrypts is skipped, as in SearchContent.
+	if err := WriteBlock(file, GenerateRandomBytes(MAX_FILE_SIZE), "", 3); err != nil {
+		t.Fatalf("WriteBlock failed: %v", err)
+	}
+	events = run("search needle")
+	if len(events) != 1 || !events[0].OK {
+		t.Fatalf("Expected search to succeed past the corrupt file, got %+v", events)
+	}
+	results, ok := events[0].Result.([]any)
+	if !ok || len(results) != 1 {
+		t.Fatalf("Expected one match, got %v", events[0].Result)
+	}
+	if got := int(results[0].(map[string]any)["Index"].(float64)); got != at {
+		t.Errorf("Expected the match in slot %d, got %d", at, got)
+	}
+}
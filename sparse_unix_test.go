@@ -0,0 +1,70 @@
+//go:build unix
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Allocated blocks are only reported through syscall.Stat_t, which
+// Windows doesn't have.
+func TestGetHonorSparse(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+
+	InitMeta(file, "file")
+
+	originalContent := make([]byte, 40000)
+	copy(originalContent, "image header")
+	copy(originalContent[20000:], "some data in the middle")
+	sourcePath := CreateTempSourceFile(t, originalContent)
+	if err := Add(file, sourcePath, 0); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	tmpDir := t.TempDir()
+	densePath := filepath.Join(tmpDir, "dense.bin")
+	if err := Get(file, 0, densePath); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	HonorSparse = true
+	defer func() { HonorSparse = false }()
+
+	sparsePath := filepath.Join(tmpDir, "sparse.bin")
+	if err := Get(file, 0, sparsePath); err != nil {
+		t.Fatalf("Get with --honor-sparse failed: %v", err)
+	}
+
+	retrieved, err := os.ReadFile(sparsePath)
+	if err != nil {
+		t.Fatalf("Failed to read retrieved file: %v", err)
+	}
+	if !bytes.Equal(retrieved, originalContent) {
+		t.Fatal("Sparse output is not byte-identical")
+	}
+
+	blocks := func(path string) int64 {
+		s, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+		return s.Sys().(*syscall.Stat_t).Blocks
+	}
+	dense, sparse := blocks(densePath), blocks(sparsePath)
+	if dense == 0 {
+		t.Skip("filesystem does not report allocated blocks")
+	}
+	if sparse >= dense {
+		t.Errorf("Expected the sparse output to use fewer blocks than %d, got %d", dense, sparse)
+	}
+}
@@ -1,23 +1,92 @@
 package main
 
 import (
+	"encoding/hex"
 	"fmt"
-	"os"
 )
 
-func Stat(file *os.File) error {
+// statRecord is the JSON form of stat. Wear is left out unless StatWear
+// is set and the metadata can be read, and Salt unless ShowSalt is set.
+type statRecord struct {
+	Name     string     `json:"name"`
+	Size     int64      `json:"size"`
+	Modified int64      `json:"modified"` // Unix timestamp
+	Mode     string     `json:"mode"`
+	Salt     string     `json:"salt,omitempty"`
+	Wear     *WearStats `json:"wear,omitempty"`
+}
+
+// Stat prints the device geometry, which is readable without the password.
+// With StatWear it also decrypts the metadata for the wear counters.
+func Stat(file F) error {
 	s, err := file.Stat()
 	if err != nil {
 		return fmt.Errorf("failed to stat device: %w", err)
 	}
 
+	size, err := DeviceSize(file)
+	if err != nil {
+		return err
+	}
+
+	if JSONEvents {
+		rec := statRecord{
+			Name:     s.Name(),
+			Size:     size,
+			Modified: s.ModTime().Unix(),
+			Mode:     s.Mode().String(),
+		}
+		if ShowSalt {
+			h, err := ReadHeader(file)
+			if err != nil {
+				return fmt.Errorf("failed to read header: %w", err)
+			}
+			rec.Salt = hex.EncodeToString(h.Salt)
+		}
+		if StatWear {
+			if meta, err := ReadMeta(file); err == nil {
+				rec.Wear = &meta.Wear
+			}
+		}
+		return PrintJSON(rec)
+	}
+
 	PrintHeader("DEVICE STATS")
 	PrintSeparator(60)
 	Printf(" %-15s %s\n", C(ColorBold+ColorLightBlue, "Name:"), C(ColorWhite, s.Name()))
-	Printf(" %-15s %s\n", C(ColorBold+ColorLightBlue, "Size:"), C(ColorWhite, fmt.Sprintf("%d bytes (%.2f MB)", s.Size(), float64(s.Size())/1024/1024)))
+	Printf(" %-15s %s\n", C(ColorBold+ColorLightBlue, "Size:"), C(ColorWhite, fmt.Sprintf("%d bytes (%.2f MB)", size, float64(size)/1024/1024)))
 	Printf(" %-15s %s\n", C(ColorBold+ColorLightBlue, "Modified:"), C(ColorWhite, s.ModTime().Format("2006-01-02 15:04:05")))
 	Printf(" %-15s %s\n", C(ColorBold+ColorLightBlue, "Mode:"), C(ColorWhite, s.Mode().String()))
 	PrintSeparator(60)
 
+	if ShowSalt {
+		h, err := ReadHeader(file)
+		if err != nil {
+			return fmt.Errorf("failed to read header: %w", err)
+		}
+		Printf(" %-15s %s\n", C(ColorBold+ColorLightBlue, "Salt:"), C(ColorWhite, hex.EncodeToString(h.Salt)))
+		Printf(" %s\n", C(ColorDim, "The salt alone cannot decrypt the volume; the password is still required."))
+		PrintSeparator(60)
+	}
+
+	if !StatWear {
+		return nil
+	}
+
+	meta, err := ReadMeta(file)
+	if err != nil {
+		Printf(" %s\n", C(ColorDim, fmt.Sprintf("Wear statistics unavailable: %v", err)))
+		return nil
+	}
+
+	Println("")
+	PrintHeader("WEAR STATS")
+	PrintSeparator(60)
+	Printf(" %-15s %s\n", C(ColorBold+ColorLightBlue, "Written:"), C(ColorWhite, fmt.Sprintf("%d bytes (%.2f MB)", meta.Wear.BytesWritten, float64(meta.Wear.BytesWritten)/1024/1024)))
+	Printf(" %-15s %s\n", C(ColorBold+ColorLightBlue, "Meta writes:"), C(ColorWhite, fmt.Sprintf("%d", meta.Wear.MetaWrites)))
+	Printf(" %-15s %s\n", C(ColorBold+ColorLightBlue, "Adds:"), C(ColorWhite, fmt.Sprintf("%d", meta.Wear.Adds)))
+	Printf(" %-15s %s\n", C(ColorBold+ColorLightBlue, "Deletes:"), C(ColorWhite, fmt.Sprintf("%d", meta.Wear.Deletes)))
+	PrintSeparator(60)
+
 	return nil
 }
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"runtime/debug"
 	"strings"
+	"time"
 )
 
 const (
@@ -15,6 +17,8 @@ const (
 	ERASE_CHUNK_SIZE    = 1_000_000
 	OUT_OF_BOUNDS_INDEX = 99999999
 
+	MAX_COMPRESSED_SOURCE = 100 * MAX_FILE_SIZE
+
 	MAGIC_SIZE    = 5
 	VERSION_SIZE  = 1
 	RESERVED_SIZE = 2
@@ -53,23 +57,283 @@ const (
 	ColorLightBlue = "\033[38;5;153m"
 )
 
-var Silent = false
+var (
+	Silent = false
+
+	// NoColor makes C return text without escape codes.
+	NoColor = false
+
+	// Highlight makes list mark where the filter matched in each name.
+	Highlight = false
+
+	// Verbosity is the most verbose diagnostic level printed to stderr.
+	Verbosity = LevelInfo
+
+	// Fuzzy makes search-name rank names by edit distance instead of
+	// matching substrings.
+	Fuzzy = false
+
+	// SearchRegex makes search and search-name treat the phrase as a
+	// regular expression instead of a case-insensitive substring.
+	SearchRegex = false
+
+	// SortByMatches orders content search results by descending match count.
+	SortByMatches = false
+
+	// ShredSource overwrites and removes the source file after a verified add.
+	ShredSource = false
+
+	// JSONLines makes dump-meta and verify emit one JSON object per line.
+	JSONLines = false
+
+	// JSONEvents makes the shell emit one JSON object per command instead
+	// of colored text, and list, stat, search and search-name print their
+	// result as JSON, see PrintJSON.
+	JSONEvents = false
+
+	// Echo makes the shell and batch print each command before running
+	// it, for an audit trail of a script. JSON output already names the
+	// command and is left alone.
+	Echo = false
+
+	// PrettyJSON indents the dump-meta document.
+	PrettyJSON = false
+
+	// ShowSalt prints the volume salt in stat and dump-header.
+	ShowSalt = false
+
+	// StatWear makes stat decrypt the metadata for the wear counters.
+	// Without it stat only reads device geometry and needs no password.
+	StatWear = false
+
+	// ConfirmSource makes Add reject a source file that changed while it
+	// was being read.
+	ConfirmSource = false
+
+	// NoMetaSync skips the fsync in WriteMeta so a batch of operations pays
+	// for it once, via FlushMeta, instead of on every metadata update.
+	NoMetaSync = false
+
+	// PreserveOnError makes Add validate the new block and restore the old
+	// one if overwriting a used slot fails.
+	PreserveOnError = false
+
+	// VerifyAfterAdd makes Add read each block back after writing it and
+	// decrypt it, rolling the slot back if it doesn't match the source.
+	VerifyAfterAdd = false
+
+	// OnlyIfChanged makes Sync return early when both volumes already hold
+	// the same files.
+	OnlyIfChanged = false
+
+	// CheckNonces makes verify report GCM nonces used by more than one
+	// block.
+	CheckNonces = false
+
+	// LongList adds the content type column to list.
+	LongList = false
+
+	// TypeFilter limits list to files whose content type starts with it.
+	TypeFilter = ""
+
+	// ExpectedSHA256 makes Get fail unless the decrypted file has this
+	// SHA256.
+	ExpectedSHA256 []byte
+
+	// IfChanged makes Add skip a source whose path and content match an
+	// existing entry, and overwrite that entry if the content changed.
+	IfChanged = false
+
+	// PadMetadata marks the volume so its metadata is always padded to the
+	// full block before encryption. The length field in the header then no
+	// longer reveals how many files are stored. The mark is kept in the
+	// encrypted metadata, so later writes stay padded without the flag.
+	PadMetadata = false
+
+	// SlotNoise makes init fill the data slots with random bytes instead
+	// of zeros. The volume remembers it, and from then on deleted slots
+	// and the padding after each file are randomized as well, so used and
+	// free slots look alike.
+	SlotNoise = false
+
+	// Hidden makes init create a hidden volume in the free space of an
+	// existing SlotNoise volume, see InitHidden.
+	Hidden = false
+
+	// UsedOnlyCount makes list print only the number of used slots.
+	UsedOnlyCount = false
+
+	// FilterRegex limits list to names matching this regular expression.
+	FilterRegex = ""
+
+	// ListSort orders list by "name", "size" or "created" instead of slot,
+	// descending with a "-" prefix. The slot index is still shown.
+	ListSort = ""
+
+	// NaturalSort makes the name order compare numbers by value, so file2
+	// comes before file10.
+	NaturalSort = false
+
+	// DoctorSample limits the doctor block check to this many files. Zero
+	// checks every file.
+	DoctorSample = 0
+
+	// Checksums makes Add record the SHA256 of each file and adds the
+	// checksum column to list.
+	Checksums = false
+
+	// VerifyInline makes list decrypt every listed file and flag the ones
+	// that are corrupt or don't match their recorded checksum.
+	VerifyInline = false
+
+	// KeepMetaBackups makes WriteMeta keep this many previous metadata
+	// blocks in a ring after the data slots, for rollback. Like
+	// PadMetadata it is remembered in the metadata once set.
+	KeepMetaBackups = 0
+
+	// ErasePasses is how many times erase overwrites the device, see
+	// Overwrite for the patterns used.
+	ErasePasses = 1
+
+	// EraseRandom makes erase write random data on every pass, so the
+	// erased device can't be told apart from one full of ciphertext.
+	EraseRandom = false
+
+	// ConcurrencySafe makes ReadMeta cache the decoded metadata and only
+	// read it again when the header or the metadata nonce has changed, so
+	// a long running shell stays fast yet sees writes made by another
+	// process.
+	ConcurrencySafe = false
+
+	// PromptConfirm makes the password prompt ask twice and compare the
+	// entries. init always does this.
+	PromptConfirm = false
+
+	// NameHash makes Add store a salted hash of the name instead of the
+	// name itself. The real name is kept encrypted in SealedName and the
+	// file can only be found by its exact name.
+	NameHash = false
+
+	// IgnoreChecksum makes ReadMeta accept a metadata block whose SHA256
+	// checksum doesn't match and rely on GCM authentication alone. Writes
+	// are refused while it is set.
+	IgnoreChecksum = false
+
+	// VerifySource makes Sync decrypt every source file before writing
+	// anything to the destination.
+	VerifySource = false
+
+	// KeepDstSalt makes Sync keep the salt of an initialized destination
+	// and re-encrypt every block for it, instead of copying the source
+	// salt and blocks as they are.
+	KeepDstSalt = false
+
+	// OutputIndex makes add print only the index the file was stored at.
+	OutputIndex = false
+
+	// HonorSparse makes get seek over zero runs instead of writing them,
+	// leaving holes in the output file.
+	HonorSparse = false
+
+	// Compress makes add gzip the content before encrypting it, so larger
+	// files that compress well fit in a slot. Content gzip doesn't shrink
+	// is stored uncompressed.
+	Compress = false
+
+	// Discard makes trim also send the zeroed slots to a block device as
+	// discard requests.
+	Discard = false
+
+	// OnCollision is what export does with a file whose name is already
+	// in the archive: skip it, rename it with its slot index, or write it
+	// again so it overwrites the earlier one on extraction.
+	OnCollision = COLLISION_RENAME
+
+	// Context is mixed into the GCM additional data of files added while it
+	// is set, binding them to it: get has to be given the same context.
+	Context = ""
+
+	// RecoverPartialAdd looks for data left in free slots by an interrupted
+	// add before running the command, see RecoverOrphans.
+	RecoverPartialAdd = false
+
+	// MaxRuntime bounds how long a single device call may block before the
+	// command is aborted with ErrTimeout; 0 waits forever.
+	MaxRuntime time.Duration
+
+	// ProgressInterval is the least time between progress lines from
+	// erase and init; 0 logs every chunk.
+	ProgressInterval = time.Second
+
+	// MinFree is the number of free slots adds must leave, as headroom for
+	// an emergency add with Force.
+	MinFree = 0
+
+	// Force lets an add use the slots reserved by MinFree.
+	Force = false
+
+	// RecoverySalt replaces the header salt for reindex when the header
+	// was destroyed.
+	RecoverySalt []byte
+)
 
 type Meta struct {
 	Version int
 	Salt    []byte
+	Padded  bool `json:",omitempty"` // Encrypt at a fixed size, see PadMetadata
+	Backups int  `json:",omitempty"` // Previous versions kept, see KeepMetaBackups
+	Noise   bool `json:",omitempty"` // Free space is random, see SlotNoise
+	Slots   int  `json:",omitempty"` // Usable slots if fewer than TOTAL_FILES
+	Wear    WearStats
 	Files   [TOTAL_FILES]File
+	Aliases map[string]int `json:",omitempty"` // Name to slot, used as @name
+
+	// PendingSalt is the new salt of a passwd that hasn't finished. It is
+	// written before any block is re-encrypted, so running passwd again
+	// can tell which blocks are already under the new key.
+	PendingSalt []byte `json:",omitempty"`
+}
+
+// WearStats counts writes made to the volume over its lifetime.
+type WearStats struct {
+	BytesWritten uint64
+	MetaWrites   uint64
+	Adds         uint64
+	Deletes      uint64
 }
 
+// File fields are omitted from the metadata JSON when empty, so each free
+// slot costs two bytes ("{}") instead of a full object. Field names are
+// unchanged, which keeps older metadata readable.
 type File struct {
-	Name    string
-	Size    int
-	Created int64 // Unix timestamp
+	Name    string `json:",omitempty"`
+	Size    int    `json:",omitempty"`
+	Created int64  `json:",omitempty"` // Unix timestamp
+	MIME    string `json:",omitempty"` // Detected from the first 512 bytes
+
+	// Compressed is set when the content was gzipped before encryption,
+	// see Compress. Size is still the size of the ciphertext on disk.
+	Compressed bool `json:",omitempty"`
+
+	// Bound is set when the file was added with a Context. The context
+	// itself is not stored.
+	Bound bool `json:",omitempty"`
+
+	// SealedName holds the encrypted real name when Name is only a hash,
+	// see NameHash.
+	SealedName []byte `json:",omitempty"`
+
+	// Origin is only recorded by add --if-changed, Checksum by
+	// --if-changed and --checksum.
+	Origin   string `json:",omitempty"` // Absolute source path
+	Checksum []byte `json:",omitempty"` // SHA256 of the plaintext
 }
 
 type F interface {
 	Write([]byte) (int, error)
 	Read([]byte) (int, error)
+	ReadAt([]byte, int64) (int, error)
+	WriteAt([]byte, int64) (int, error)
 	Seek(int64, int) (int64, error)
 	Name() string
 	Sync() error
@@ -78,14 +342,12 @@ type F interface {
 }
 
 func PrintError(msg string, err error) {
-	fmt.Println("----------------------------")
-	fmt.Println("MSG:", msg)
 	if err != nil {
-		fmt.Println("ERROR:", err)
+		LogError("%s: %v", msg, err)
+	} else {
+		LogError("%s", msg)
 	}
-	fmt.Println("----------------------------")
-	fmt.Println(string(debug.Stack()))
-	fmt.Println("----------------------------")
+	LogDebug("%s", debug.Stack())
 }
 
 func Print(a ...interface{}) {
@@ -107,6 +369,9 @@ func Printf(format string, a ...interface{}) {
 }
 
 func C(color string, text string) string {
+	if NoColor {
+		return text
+	}
 	return color + text + ColorReset
 }
 
@@ -133,3 +398,13 @@ func PrintLabel(label string, value interface{}) {
 		fmt.Printf("%s %v\n", C(ColorBold+ColorLightBlue, label+":"), value)
 	}
 }
+
+// PrintJSON writes v to stdout as a single line of JSON, for commands
+// asked for JSONEvents output. It bypasses Silent and colors, since the
+// JSON is the whole result.
+func PrintJSON(v any) error {
+	if err := json.NewEncoder(os.Stdout).Encode(v); err != nil {
+		return fmt.Errorf("failed to write JSON: %w", err)
+	}
+	return nil
+}
@@ -1,20 +1,91 @@
 package main
 
 import (
+	"crypto/sha256"
 	"fmt"
-	"os"
+	"io"
+	"runtime"
 )
 
-func Sync(src *os.File, dst *os.File) error {
+// BlockSum is the SHA256 of a full slot, padding included.
+type BlockSum struct {
+	Index int
+	Sum   [sha256.Size]byte
+}
+
+// Sync copies the source volume to dst. Blocks whose checksum already
+// matches the destination manifest are skipped, so repeating or resuming
+// an interrupted sync only transfers what changed.
+func Sync(src F, dst F) (err error) {
+	defer func() { err = checkDevice(err) }()
+
+	if VerifySource {
+		if err := verifySource(src); err != nil {
+			return err
+		}
+	}
+
+	if OnlyIfChanged {
+		same, err := volumesMatch(src, dst)
+		if err != nil {
+			return err
+		}
+		if same {
+			PrintSuccess("Already up to date")
+			return nil
+		}
+	}
+
+	if KeepDstSalt {
+		return syncKeepSalt(src, dst)
+	}
+
+	// A destination without readable metadata has nothing worth keeping,
+	// so every block is transferred.
+	manifest, err := Manifest(dst)
+	if err != nil {
+		manifest = nil
+	}
+
+	_, err = syncWithManifest(src, dst, manifest)
+	return err
+}
+
+// syncKeepSalt copies src to dst like Sync, but re-encrypts every block
+// and sealed name for the salt dst already has, so the destination keeps
+// its own key derivation. Every block is transferred, as re-encrypted
+// blocks never match the ones already there. The metadata is written
+// last, after which the slots only dst used are cleared: they are still
+// sealed under dst's key and recover would otherwise bring them back.
+func syncKeepSalt(src F, dst F) error {
 	srcMeta, err := ReadMeta(src)
 	if err != nil {
 		return fmt.Errorf("failed to read source metadata: %w", err)
 	}
+	dstMeta, err := ReadMeta(dst)
+	if err != nil {
+		return fmt.Errorf("--keep-dst-salt needs a destination initialized with the same password: %w", err)
+	}
 
-	if err := WriteMeta(dst, srcMeta); err != nil {
-		return fmt.Errorf("failed to write destination metadata: %w", err)
+	password, err := GetEncKey()
+	if err != nil {
+		return fmt.Errorf("failed to get encryption key: %w", err)
+	}
+	srcKey, err := DeriveKey(password, srcMeta.Salt)
+	if err != nil {
+		return fmt.Errorf("failed to derive key: %w", err)
 	}
+	defer zeroBytes(srcKey)
+	dstKey, err := DeriveKey(password, dstMeta.Salt)
+	if err != nil {
+		return fmt.Errorf("failed to derive key: %w", err)
+	}
+	defer zeroBytes(dstKey)
+
+	meta := *srcMeta
+	meta.Salt = dstMeta.Salt
 
+	total := CountNonEmptyFiles(srcMeta)
 	syncedCount := 0
 	for i, v := range srcMeta.Files {
 		if v.Name == "" {
@@ -25,8 +96,11 @@ func Sync(src *os.File, dst *os.File) error {
 		if err != nil {
 			return fmt.Errorf("failed to read block at index %d: %w", i, err)
 		}
-
-		if err := WriteBlock(dst, block, v.Name, i); err != nil {
+		meta.Files[i], err = rekeySlot(block, v, srcKey, dstKey, meta.Salt, false)
+		if err != nil {
+			return fmt.Errorf("failed to re-encrypt block at index %d: %w", i, err)
+		}
+		if err := WriteBlock(dst, block, meta.Files[i].Name, i); err != nil {
 			return fmt.Errorf("failed to write block at index %d: %w", i, err)
 		}
 
@@ -34,18 +108,186 @@ func Sync(src *os.File, dst *os.File) error {
 		Printf("%s %s/%s: %s\n",
 			C(ColorLightBlue, "Syncing"),
 			C(ColorBrightBlue, fmt.Sprintf("%d", syncedCount)),
-			C(ColorDim, fmt.Sprintf("%d", CountNonEmptyFiles(srcMeta))),
+			C(ColorDim, fmt.Sprintf("%d", total)),
 			C(ColorWhite, v.Name))
 	}
 
+	if err := WriteMeta(dst, &meta); err != nil {
+		return fmt.Errorf("failed to write destination metadata: %w", err)
+	}
+
+	for i, v := range dstMeta.Files {
+		if v.Name == "" || meta.Files[i].Name != "" {
+			continue
+		}
+		if err := clearSlot(dst, &meta, i); err != nil {
+			return fmt.Errorf("failed to clear destination slot %d: %w", i, err)
+		}
+		LogDebug("cleared destination slot %d", i)
+	}
+
 	Println("")
-	PrintSuccess(fmt.Sprintf("Sync complete: %s synchronized",
+	PrintSuccess(fmt.Sprintf("Sync complete: %s re-encrypted for the destination salt",
 		C(ColorBold+ColorWhite, fmt.Sprintf("%d files", syncedCount))))
 
 	return nil
 }
 
-func ReadBlock(file *os.File, index int) ([]byte, error) {
+// verifySource decrypts every used source slot, so a corrupted source is
+// refused before anything on the destination is overwritten.
+func verifySource(src F) error {
+	meta, err := ReadMeta(src)
+	if err != nil {
+		return fmt.Errorf("failed to read source metadata: %w", err)
+	}
+
+	password, err := GetEncKey()
+	if err != nil {
+		return fmt.Errorf("failed to get encryption key: %w", err)
+	}
+
+	results := verifySlots(src, meta, password, runtime.NumCPU(), nil)
+	if err := verifyError(results); err != nil {
+		for _, r := range results {
+			if r.Err != nil {
+				LogError("source [%d] %s: %v", r.Index, r.Name, r.Err)
+			}
+		}
+		return fmt.Errorf("source verification failed, destination left untouched: %w", err)
+	}
+
+	return nil
+}
+
+// Manifest returns the checksum of every used slot in file.
+func Manifest(file F) ([]BlockSum, error) {
+	meta, err := ReadMeta(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	return manifestFromMeta(file, meta)
+}
+
+func manifestFromMeta(file F, meta *Meta) ([]BlockSum, error) {
+	var sums []BlockSum
+	block := make([]byte, MAX_FILE_SIZE)
+	for i, v := range meta.Files {
+		if v.Name == "" {
+			continue
+		}
+
+		seekPos := int64(META_FILE_SIZE) + (int64(i) * int64(MAX_FILE_SIZE))
+		n, err := file.ReadAt(block, seekPos)
+		if n != MAX_FILE_SIZE {
+			return nil, fmt.Errorf("failed to read block at index %d: %w", i, err)
+		}
+
+		sums = append(sums, BlockSum{Index: i, Sum: sha256.Sum256(block)})
+	}
+
+	return sums, nil
+}
+
+// VolumeChecksum returns a single checksum over the file table and the
+// blocks it references. Wear counters and the metadata encryption nonce
+// are left out, so two volumes holding the same files compare equal.
+func VolumeChecksum(file F) ([sha256.Size]byte, error) {
+	meta, err := ReadMeta(file)
+	if err != nil {
+		return [sha256.Size]byte{}, fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	sums, err := manifestFromMeta(file, meta)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+
+	h := sha256.New()
+	for _, bs := range sums {
+		f := meta.Files[bs.Index]
+		fmt.Fprintf(h, "%d\x00%s\x00%d\x00%d\x00", bs.Index, f.Name, f.Size, f.Created)
+		h.Write(bs.Sum[:])
+	}
+
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// volumesMatch reports whether dst already holds the same files as src. A
+// destination that cannot be read never matches.
+func volumesMatch(src F, dst F) (bool, error) {
+	srcSum, err := VolumeChecksum(src)
+	if err != nil {
+		return false, fmt.Errorf("failed to checksum source: %w", err)
+	}
+
+	dstSum, err := VolumeChecksum(dst)
+	if err != nil {
+		return false, nil
+	}
+
+	return srcSum == dstSum, nil
+}
+
+// syncWithManifest writes the source metadata to dst and transfers every
+// used block whose checksum differs from the one in manifest. It returns
+// the number of blocks transferred.
+func syncWithManifest(src F, dst F, manifest []BlockSum) (int, error) {
+	srcMeta, err := ReadMeta(src)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read source metadata: %w", err)
+	}
+
+	dstSums := make(map[int][sha256.Size]byte, len(manifest))
+	for _, bs := range manifest {
+		dstSums[bs.Index] = bs.Sum
+	}
+
+	if err := WriteMeta(dst, srcMeta); err != nil {
+		return 0, fmt.Errorf("failed to write destination metadata: %w", err)
+	}
+
+	total := CountNonEmptyFiles(srcMeta)
+	syncedCount := 0
+	skippedCount := 0
+	for i, v := range srcMeta.Files {
+		if v.Name == "" {
+			continue
+		}
+
+		block, err := ReadBlock(src, i)
+		if err != nil {
+			return syncedCount, fmt.Errorf("failed to read block at index %d: %w", i, err)
+		}
+
+		if sum, ok := dstSums[i]; ok && sum == sha256.Sum256(block) {
+			skippedCount++
+			continue
+		}
+
+		if err := WriteBlock(dst, block, v.Name, i); err != nil {
+			return syncedCount, fmt.Errorf("failed to write block at index %d: %w", i, err)
+		}
+
+		syncedCount++
+		Printf("%s %s/%s: %s\n",
+			C(ColorLightBlue, "Syncing"),
+			C(ColorBrightBlue, fmt.Sprintf("%d", syncedCount+skippedCount)),
+			C(ColorDim, fmt.Sprintf("%d", total)),
+			C(ColorWhite, v.Name))
+	}
+
+	Println("")
+	PrintSuccess(fmt.Sprintf("Sync complete: %s synchronized, %s unchanged",
+		C(ColorBold+ColorWhite, fmt.Sprintf("%d files", syncedCount)),
+		C(ColorBold+ColorWhite, fmt.Sprintf("%d", skippedCount))))
+
+	return syncedCount, nil
+}
+
+func ReadBlock(file F, index int) ([]byte, error) {
 	if index < 0 || index >= TOTAL_FILES {
 		return nil, fmt.Errorf("index out of range: %d", index)
 	}
@@ -69,7 +311,7 @@ func ReadBlock(file *os.File, index int) ([]byte, error) {
 	return block, nil
 }
 
-func WriteBlock(file *os.File, block []byte, name string, index int) error {
+func WriteBlock(file F, block []byte, name string, index int) error {
 	if index < 0 || index >= TOTAL_FILES {
 		return fmt.Errorf("index out of range: %d", index)
 	}
@@ -84,15 +326,10 @@ func WriteBlock(file *os.File, block []byte, name string, index int) error {
 		return fmt.Errorf("failed to seek to block: %w", err)
 	}
 
-	n, err := file.Write(block)
-	if err != nil {
+	if err := writeFull(file, block); err != nil {
 		return fmt.Errorf("failed to write block: %w", err)
 	}
 
-	if n != len(block) {
-		return fmt.Errorf("short write: wrote %d bytes, expected %d", n, len(block))
-	}
-
 	if err := file.Sync(); err != nil {
 		return fmt.Errorf("failed to sync block: %w", err)
 	}
@@ -100,6 +337,25 @@ func WriteBlock(file *os.File, block []byte, name string, index int) error {
 	return nil
 }
 
+// writeFull writes all of b at the current position. Write may return
+// fewer bytes without an error on some backends, so it keeps writing the
+// rest until everything is out or a write makes no progress.
+func writeFull(file F, b []byte) error {
+	written := 0
+	for written < len(b) {
+		n, err := file.Write(b[written:])
+		written += n
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("%w: wrote %d bytes, expected %d", io.ErrShortWrite, written, len(b))
+		}
+	}
+
+	return nil
+}
+
 func CountNonEmptyFiles(meta *Meta) int {
 	count := 0
 	for _, f := range meta.Files {
@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 )
@@ -248,7 +249,7 @@ func TestSyncMultipleTimes(t *testing.T) {
 	Add(srcFile, sourcePath2, 1)
 	Sync(srcFile, dstFile)
 
-	Del(srcFile, 0)
+	Del(srcFile, "0")
 	Sync(srcFile, dstFile)
 
 	srcMeta, err := ReadMeta(srcFile)
@@ -281,6 +282,65 @@ func TestSyncMultipleTimes(t *testing.T) {
 	}
 }
 
+func TestSyncManifestTransfersOnlyChangedBlocks(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	srcFile := GetSharedTestFile(t)
+
+	dstFile := GetSharedTestFile(t)
+
+	InitMeta(srcFile, "file")
+	FillSlots(t, srcFile, 4)
+
+	if err := Sync(srcFile, dstFile); err != nil {
+		t.Fatalf("Initial sync failed: %v", err)
+	}
+
+	manifest, err := Manifest(dstFile)
+	if err != nil {
+		t.Fatalf("Manifest failed: %v", err)
+	}
+	if len(manifest) != 4 {
+		t.Fatalf("Expected 4 manifest entries, got %d", len(manifest))
+	}
+
+	sourcePath := CreateTempSourceFileWithName(t, []byte("changed content"), "changed.txt")
+	if err := Add(srcFile, sourcePath, 2); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	transferred, err := syncWithManifest(srcFile, dstFile, manifest)
+	if err != nil {
+		t.Fatalf("Incremental sync failed: %v", err)
+	}
+	if transferred != 1 {
+		t.Errorf("Expected 1 block transferred, got %d", transferred)
+	}
+
+	srcSums, _ := Manifest(srcFile)
+	dstSums, _ := Manifest(dstFile)
+	if len(srcSums) != len(dstSums) {
+		t.Fatalf("Manifest length mismatch: src %d, dst %d", len(srcSums), len(dstSums))
+	}
+	for i := range srcSums {
+		if srcSums[i] != dstSums[i] {
+			t.Errorf("Block %d differs after incremental sync", srcSums[i].Index)
+		}
+	}
+
+	manifest, _ = Manifest(dstFile)
+	transferred, err = syncWithManifest(srcFile, dstFile, manifest)
+	if err != nil {
+		t.Fatalf("Repeated sync failed: %v", err)
+	}
+	if transferred != 0 {
+		t.Errorf("Expected nothing transferred on repeated sync, got %d", transferred)
+	}
+}
+
 func TestReadBlock(t *testing.T) {
 	defer LogTestDuration(t, time.Now())
 
@@ -521,3 +581,299 @@ func BenchmarkWriteBlock(b *testing.B) {
 		WriteBlock(file, block, "test.txt", 0)
 	}
 }
+
+// writeCountingFile counts writes made to the wrapped file.
+type writeCountingFile struct {
+	F
+	writes int
+}
+
+func (f *writeCountingFile) Write(p []byte) (int, error) {
+	f.writes++
+	return f.F.Write(p)
+}
+
+// partialWriteFile writes at most max bytes per Write call, like a backend
+// that is allowed to return short writes without an error.
+type partialWriteFile struct {
+	F
+	max int
+}
+
+func (f *partialWriteFile) Write(p []byte) (int, error) {
+	if len(p) > f.max {
+		p = p[:f.max]
+	}
+	return f.F.Write(p)
+}
+
+func TestWritePartialWrites(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := &partialWriteFile{F: GetSharedTestFile(t), max: 777}
+	InitMeta(file, "file")
+
+	content := GenerateRandomBytes(20000)
+	sourcePath := CreateTempSourceFileWithName(t, content, "partial.bin")
+	if err := Add(file, sourcePath, 0); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	block := bytes.Repeat([]byte{0xAB}, MAX_FILE_SIZE)
+	if err := WriteBlock(file, block, "raw", 1); err != nil {
+		t.Fatalf("WriteBlock failed: %v", err)
+	}
+
+	meta, err := ReadMeta(file.F)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+	if meta.Files[0].Name != "partial.bin" {
+		t.Errorf("Expected metadata to be fully written, got name %q", meta.Files[0].Name)
+	}
+
+	password, err := GetEncKey()
+	if err != nil {
+		t.Fatalf("GetEncKey failed: %v", err)
+	}
+	got, err := decryptSlot(file.F, meta, password, 0)
+	if err != nil {
+		t.Fatalf("Failed to decrypt added file: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("Added file content mismatch")
+	}
+
+	raw, err := ReadBlock(file.F, 1)
+	if err != nil {
+		t.Fatalf("ReadBlock failed: %v", err)
+	}
+	if !bytes.Equal(raw, block) {
+		t.Error("Block was not fully written")
+	}
+
+	file.max = 0
+	if err := WriteBlock(file, block, "raw", 1); err == nil {
+		t.Error("Expected a write that makes no progress to fail")
+	}
+}
+
+func TestSyncVerifySource(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	VerifySource = true
+	defer func() { VerifySource = false }()
+
+	srcFile := GetSharedTestFile(t)
+	dst := &writeCountingFile{F: GetSharedTestFile(t)}
+
+	InitMeta(srcFile, "file")
+	FillSlots(t, srcFile, 3)
+
+	if err := Sync(srcFile, dst); err != nil {
+		t.Fatalf("Sync of a healthy source failed: %v", err)
+	}
+
+	srcFile.Seek(int64(META_FILE_SIZE+MAX_FILE_SIZE+20), 0)
+	srcFile.Write([]byte{0xFF, 0xFF, 0xFF})
+	FillSlots(t, srcFile, 1)
+
+	dst.writes = 0
+	err := Sync(srcFile, dst)
+	if err == nil || !strings.Contains(err.Error(), "source verification failed") {
+		t.Fatalf("Expected sync to refuse a corrupted source, got %v", err)
+	}
+	if dst.writes != 0 {
+		t.Errorf("Expected no destination writes, got %d", dst.writes)
+	}
+}
+
+func TestSyncOnlyIfChanged(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	OnlyIfChanged = true
+	defer func() { OnlyIfChanged = false }()
+
+	srcFile := GetSharedTestFile(t)
+
+	dst := &writeCountingFile{F: GetSharedTestFile(t)}
+
+	InitMeta(srcFile, "file")
+	FillSlots(t, srcFile, 3)
+
+	if err := Sync(srcFile, dst); err != nil {
+		t.Fatalf("First sync failed: %v", err)
+	}
+	if dst.writes == 0 {
+		t.Fatal("Expected the first sync to write to the destination")
+	}
+
+	dst.writes = 0
+	output := captureOutput(func() {
+		if err := Sync(srcFile, dst); err != nil {
+			t.Errorf("Second sync failed: %v", err)
+		}
+	})
+	if dst.writes != 0 {
+		t.Errorf("Expected no writes on second sync, got %d", dst.writes)
+	}
+	if !strings.Contains(output, "up to date") {
+		t.Errorf("Expected up to date message, got:\n%s", output)
+	}
+
+	sourcePath := CreateTempSourceFileWithName(t, []byte("changed"), "changed.txt")
+	if err := Add(srcFile, sourcePath, 1); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := Sync(srcFile, dst); err != nil {
+		t.Fatalf("Third sync failed: %v", err)
+	}
+	if dst.writes == 0 {
+		t.Error("Expected a changed source to be synced")
+	}
+}
+
+func TestSyncMockDevices(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	src := NewMockFile(META_FILE_SIZE)
+	dst := NewMockFile(0)
+
+	if err := InitMeta(src, "file"); err != nil {
+		t.Fatalf("InitMeta failed: %v", err)
+	}
+	FillSlots(t, src, 3)
+	sourcePath := CreateTempSourceFileWithName(t, GenerateRandomBytes(5000), "far.bin")
+	if err := Add(src, sourcePath, 40); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	if err := Sync(src, dst); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	srcMeta, err := ReadMeta(src)
+	if err != nil {
+		t.Fatalf("ReadMeta on source failed: %v", err)
+	}
+	dstMeta, err := ReadMeta(dst)
+	if err != nil {
+		t.Fatalf("ReadMeta on destination failed: %v", err)
+	}
+	if !bytes.Equal(srcMeta.Salt, dstMeta.Salt) {
+		t.Error("Salt was not transferred")
+	}
+
+	for i := range TOTAL_FILES {
+		if srcMeta.Files[i].Name != dstMeta.Files[i].Name || srcMeta.Files[i].Size != dstMeta.Files[i].Size {
+			t.Errorf("Index %d: metadata mismatch - src: %s (%d), dst: %s (%d)", i,
+				srcMeta.Files[i].Name, srcMeta.Files[i].Size, dstMeta.Files[i].Name, dstMeta.Files[i].Size)
+		}
+		if srcMeta.Files[i].Name == "" {
+			continue
+		}
+
+		srcBlock, err := ReadBlock(src, i)
+		if err != nil {
+			t.Fatalf("ReadBlock on source slot %d failed: %v", i, err)
+		}
+		dstBlock, err := ReadBlock(dst, i)
+		if err != nil {
+			t.Fatalf("ReadBlock on destination slot %d failed: %v", i, err)
+		}
+		if !bytes.Equal(srcBlock, dstBlock) {
+			t.Errorf("Slot %d: block differs after sync", i)
+		}
+	}
+
+	VerifyMetadataIntegrity(t, dst)
+}
+
+func TestSyncKeepDstSalt(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	KeepDstSalt = true
+	defer func() { KeepDstSalt = false }()
+
+	src := NewMockFile(META_FILE_SIZE)
+	dst := NewMockFile(META_FILE_SIZE)
+
+	if err := Sync(src, NewMockFile(0)); err == nil {
+		t.Error("Expected an uninitialized destination to be refused")
+	}
+
+	if err := InitMeta(src, "file"); err != nil {
+		t.Fatalf("InitMeta on source failed: %v", err)
+	}
+	if err := InitMeta(dst, "file"); err != nil {
+		t.Fatalf("InitMeta on destination failed: %v", err)
+	}
+
+	contents := map[int][]byte{
+		0:  []byte("kept under the destination salt"),
+		12: GenerateRandomBytes(8000),
+	}
+	for i, content := range contents {
+		sourcePath := CreateTempSourceFile(t, content)
+		if err := Add(src, sourcePath, i); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	// A file only the destination has must not survive as an orphan.
+	sourcePath := CreateTempSourceFile(t, []byte("only on the destination"))
+	if err := Add(dst, sourcePath, 5); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	before, err := ReadMeta(dst)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+
+	if err := Sync(src, dst); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	after := VerifyMetadataIntegrity(t, dst)
+	if !bytes.Equal(after.Salt, before.Salt) {
+		t.Errorf("Expected destination salt %x to be kept, got %x", before.Salt, after.Salt)
+	}
+
+	password, err := GetEncKey()
+	if err != nil {
+		t.Fatalf("GetEncKey failed: %v", err)
+	}
+	orphans, err := findOrphans(dst, after, password)
+	if err != nil {
+		t.Fatalf("findOrphans failed: %v", err)
+	}
+	if len(orphans) != 0 {
+		t.Errorf("Expected no orphans on the destination, got %d", len(orphans))
+	}
+
+	for i, content := range contents {
+		var out bytes.Buffer
+		if err := GetToWriter(dst, i, &out); err != nil {
+			t.Fatalf("Get %d from destination failed: %v", i, err)
+		}
+		if !bytes.Equal(out.Bytes(), content) {
+			t.Errorf("Slot %d: destination content differs", i)
+		}
+	}
+}
@@ -8,11 +8,13 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
 type MockFile struct {
+	mu       sync.Mutex // Guards data in WriteAt, which may run concurrently
 	data     []byte
 	position int64
 	closed   bool
@@ -51,6 +53,27 @@ func (m *MockFile) Write(p []byte) (n int, err error) {
 	return n, nil
 }
 
+func (m *MockFile) WriteAt(p []byte, off int64) (n int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return 0, os.ErrClosed
+	}
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset: %d", off)
+	}
+
+	needed := int(off) + len(p)
+	if needed > len(m.data) {
+		// Grow through append so filling a volume slot by slot doesn't
+		// copy the whole device on every write.
+		m.data = append(m.data, make([]byte, needed-len(m.data))...)
+	}
+
+	return copy(m.data[off:], p), nil
+}
+
 func (m *MockFile) Read(p []byte) (n int, err error) {
 	if m.closed {
 		return 0, os.ErrClosed
@@ -69,6 +92,25 @@ func (m *MockFile) Read(p []byte) (n int, err error) {
 	return n, nil
 }
 
+func (m *MockFile) ReadAt(p []byte, off int64) (n int, err error) {
+	if m.closed {
+		return 0, os.ErrClosed
+	}
+
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset: %d", off)
+	}
+	if off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+
+	n = copy(p, m.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
 func (m *MockFile) Seek(offset int64, whence int) (int64, error) {
 	if m.closed {
 		return 0, os.ErrClosed
@@ -305,7 +347,7 @@ func VerifyFileConsistency(t *testing.T, file F, index int, expectedContent []by
 	if err != nil {
 		t.Fatalf("Failed to get encryption key: %v", err)
 	}
-	decrypted, err := DecryptGCM(buff, password, meta.Salt)
+	decrypted, err := DecryptGCM(buff, password, meta.Salt, nil)
 	if err != nil {
 		t.Fatalf("Failed to decrypt: %v", err)
 	}
@@ -320,16 +362,6 @@ func VerifyFileConsistency(t *testing.T, file F, index int, expectedContent []by
 	}
 }
 
-func CountUsedSlots(meta *Meta) int {
-	count := 0
-	for _, f := range meta.Files {
-		if f.Name != "" {
-			count++
-		}
-	}
-	return count
-}
-
 func FindEmptySlot(meta *Meta) int {
 	for i, f := range meta.Files {
 		if f.Name == "" {
@@ -363,7 +395,7 @@ func FillSlots(t *testing.T, file F, count int) {
 	for i := 0; i < TOTAL_FILES && filled < count; i++ {
 		if meta.Files[i].Name == "" {
 			dummyData := []byte(fmt.Sprintf("dummy_%d", i))
-			encrypted, err := EncryptGCM(dummyData, password, meta.Salt)
+			encrypted, err := EncryptGCM(dummyData, password, meta.Salt, nil)
 			if err != nil {
 				t.Fatalf("Failed to encrypt: %v", err)
 			}
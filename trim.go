@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// discardAlign is the sector size discard ranges are rounded to, large
+// enough for 4K-sector drives.
+const discardAlign = 4096
+
+// Trim zeroes every free slot that still holds data, such as the blocks of
+// deleted files kept for rollback, so no old ciphertext is left on the
+// volume. Slots that are already zero are not rewritten. With Discard the
+// zeroed slots of a block device are also discarded, letting an SSD
+// reclaim them.
+//
+// On a volume initialized with SlotNoise old blocks can't be told apart
+// from noise, so every free slot is refilled with fresh random data
+// instead, and nothing is discarded.
+func Trim(file F) (err error) {
+	defer func() { err = checkDevice(err) }()
+
+	meta, err := ReadMeta(file)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	discard := false
+	if Discard && meta.Noise {
+		LogWarn("--discard would leave zeroed slots on a noise volume, skipping it")
+	} else if Discard {
+		s, err := file.Stat()
+		if err != nil {
+			return fmt.Errorf("failed to stat device: %w", err)
+		}
+		discard = s.Mode()&os.ModeDevice != 0
+		if !discard {
+			LogWarn("--discard only applies to block devices, zeroing only")
+		}
+	}
+
+	trimmed := 0
+	block := make([]byte, MAX_FILE_SIZE)
+	for i, v := range meta.Files {
+		if v.Name != "" {
+			continue
+		}
+
+		// File volumes grow as slots are used, so the end of the file
+		// counts as zero. A hidden volume ends after hiddenFiles slots.
+		seekPos := int64(META_FILE_SIZE) + (int64(i) * int64(MAX_FILE_SIZE))
+		n, err := file.ReadAt(block, seekPos)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to read slot %d: %w", i, err)
+		}
+		if n == 0 || !meta.Noise && isZero(block[:n]) {
+			continue
+		}
+
+		if err := clearSlot(file, meta, i); err != nil {
+			return fmt.Errorf("failed to clear slot %d: %w", i, err)
+		}
+		meta.Wear.BytesWritten += MAX_FILE_SIZE
+		trimmed++
+
+		if discard {
+			// Slots aren't sector aligned, so only the whole sectors inside
+			// the slot are discarded.
+			start := (seekPos + discardAlign - 1) / discardAlign * discardAlign
+			end := (seekPos + MAX_FILE_SIZE) / discardAlign * discardAlign
+			if err := discardRange(file, start, end-start); err != nil {
+				LogWarn("failed to discard slot %d: %v", i, err)
+				discard = false
+			}
+		}
+	}
+
+	if trimmed > 0 {
+		if err := WriteMeta(file, meta); err != nil {
+			return fmt.Errorf("failed to update metadata: %w", err)
+		}
+	}
+
+	action := "zeroed"
+	if meta.Noise {
+		action = "randomized"
+	}
+	PrintSuccess(fmt.Sprintf("Trim complete: %s %s",
+		C(ColorBold+ColorWhite, fmt.Sprintf("%d slots", trimmed)), action))
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrim(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+	FillSlots(t, file, 3)
+
+	// Leftover data in a slot the metadata no longer knows about.
+	meta, err := ReadMeta(file)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+	meta.Files[1] = File{}
+	if err := WriteMeta(file, meta); err != nil {
+		t.Fatalf("WriteMeta failed: %v", err)
+	}
+	if err := WriteBlock(file, GenerateRandomBytes(MAX_FILE_SIZE), "", 5); err != nil {
+		t.Fatalf("WriteBlock failed: %v", err)
+	}
+
+	output := captureOutput(func() {
+		if err := Trim(file); err != nil {
+			t.Fatalf("Trim failed: %v", err)
+		}
+	})
+	if !strings.Contains(output, "2 slots") {
+		t.Errorf("Expected 2 slots zeroed, got:\n%s", output)
+	}
+
+	for _, i := range []int{1, 5} {
+		block, _ := ReadBlock(file, i)
+		if !bytes.Equal(block, make([]byte, MAX_FILE_SIZE)) {
+			t.Errorf("Expected free slot %d to be zeroed", i)
+		}
+	}
+
+	meta = VerifyMetadataIntegrity(t, file)
+	password, _ := GetEncKey()
+	for _, i := range []int{0, 2} {
+		if _, err := decryptSlot(file, meta, password, i); err != nil {
+			t.Errorf("Expected used slot %d to be untouched: %v", i, err)
+		}
+	}
+
+	output = captureOutput(func() {
+		if err := Trim(file); err != nil {
+			t.Fatalf("Second Trim failed: %v", err)
+		}
+	})
+	if !strings.Contains(output, "0 slots") {
+		t.Errorf("Expected nothing left to zero, got:\n%s", output)
+	}
+}
+
+func TestTrimDiscard(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	Discard = true
+	defer func() { Discard = false }()
+
+	type span struct{ offset, length int64 }
+	var discarded []span
+	old := discardRange
+	discardRange = func(_ F, offset, length int64) error {
+		discarded = append(discarded, span{offset, length})
+		return nil
+	}
+	defer func() { discardRange = old }()
+
+	mock := NewMockFile(META_FILE_SIZE)
+	if err := InitMeta(mock, "file"); err != nil {
+		t.Fatalf("InitMeta failed: %v", err)
+	}
+	device := &blockDeviceFile{MockFile: mock}
+	if err := WriteBlock(device, GenerateRandomBytes(MAX_FILE_SIZE), "", 3); err != nil {
+		t.Fatalf("WriteBlock failed: %v", err)
+	}
+
+	captureOutput(func() {
+		if err := Trim(device); err != nil {
+			t.Fatalf("Trim failed: %v", err)
+		}
+	})
+
+	if len(discarded) != 1 {
+		t.Fatalf("Expected one discard, got %d", len(discarded))
+	}
+	slot := int64(META_FILE_SIZE + 3*MAX_FILE_SIZE)
+	d := discarded[0]
+	if d.offset%discardAlign != 0 || d.length%discardAlign != 0 {
+		t.Errorf("Expected a sector aligned range, got %+v", d)
+	}
+	if d.offset < slot || d.offset+d.length > slot+MAX_FILE_SIZE {
+		t.Errorf("Expected the discard to stay inside slot 3, got %+v", d)
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// verifyRecord is the JSON form of a VerifyResult.
+type verifyRecord struct {
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// VerifyResult is the outcome of verifying a single slot.
+type VerifyResult struct {
+	Index int
+	Name  string
+	Err   error
+}
+
+func Verify(file F, threads int) error {
+	meta, err := ReadMeta(file)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	password, err := GetEncKey()
+	if err != nil {
+		return fmt.Errorf("failed to get encryption key: %w", err)
+	}
+
+	var reused []nonceReuse
+	if CheckNonces {
+		reused, err = findReusedNonces(file, meta)
+		if err != nil {
+			return err
+		}
+	}
+
+	if JSONLines {
+		enc := json.NewEncoder(os.Stdout)
+		var encErr error
+		results := verifySlots(file, meta, password, threads, func(r VerifyResult) {
+			rec := verifyRecord{Index: r.Index, Name: r.Name, OK: r.Err == nil}
+			if r.Err != nil {
+				rec.Error = r.Err.Error()
+			}
+			if err := enc.Encode(rec); err != nil && encErr == nil {
+				encErr = err
+			}
+		})
+		if encErr != nil {
+			return fmt.Errorf("failed to encode result: %w", encErr)
+		}
+		for _, r := range reused {
+			if err := enc.Encode(nonceRecord{Nonce: hex.EncodeToString(r.Nonce), Indices: r.Indices}); err != nil {
+				return fmt.Errorf("failed to encode result: %w", err)
+			}
+		}
+		if err := verifyError(results); err != nil {
+			return err
+		}
+		return nonceError(reused)
+	}
+
+	results := verifySlots(file, meta, password, threads, nil)
+
+	PrintHeader("VERIFY")
+	PrintSeparator(70)
+
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+			Printf(" %-7s  %s  %s  %s\n",
+				C(ColorBrightBlue, fmt.Sprintf("[%d]", r.Index)),
+				C(ColorRed, "CORRUPT"),
+				C(ColorWhite, r.Name),
+				C(ColorDim, r.Err.Error()))
+			continue
+		}
+		Printf(" %-7s  %s  %s\n",
+			C(ColorBrightBlue, fmt.Sprintf("[%d]", r.Index)),
+			C(ColorLightBlue, "OK     "),
+			C(ColorWhite, r.Name))
+	}
+
+	PrintSeparator(70)
+	Printf("\n%s %s\n",
+		C(ColorBold+ColorLightBlue, "Verified:"),
+		C(ColorWhite, fmt.Sprintf("%d OK, %d corrupt", len(results)-failed, failed)))
+
+	if CheckNonces {
+		for _, r := range reused {
+			Printf("%s %s %s\n",
+				C(ColorRed, "NONCE REUSE"),
+				C(ColorWhite, hex.EncodeToString(r.Nonce)),
+				C(ColorDim, fmt.Sprintf("indices %v", r.Indices)))
+		}
+		Printf("%s %s\n",
+			C(ColorBold+ColorLightBlue, "Nonces:"),
+			C(ColorWhite, fmt.Sprintf("%d reused", len(reused))))
+	}
+
+	if err := verifyError(results); err != nil {
+		return err
+	}
+
+	return nonceError(reused)
+}
+
+// nonceRecord is the JSON form of a nonceReuse.
+type nonceRecord struct {
+	Nonce   string `json:"nonce"`
+	Indices []int  `json:"indices"`
+}
+
+// nonceReuse is a GCM nonce found at the start of more than one block.
+type nonceReuse struct {
+	Nonce   []byte
+	Indices []int
+}
+
+// findReusedNonces collects the nonce of every used block and returns the
+// ones that occur more than once. Every block is encrypted under the same
+// key, so any repeat breaks GCM's confidentiality and integrity guarantees.
+func findReusedNonces(file F, meta *Meta) ([]nonceReuse, error) {
+	seen := make(map[string][]int)
+	var order []string
+
+	nonce := make([]byte, NonceSize)
+	for i, v := range meta.Files {
+		if v.Name == "" {
+			continue
+		}
+
+		seekPos := int64(META_FILE_SIZE) + (int64(i) * int64(MAX_FILE_SIZE))
+		n, err := file.ReadAt(nonce, seekPos)
+		if n != NonceSize {
+			return nil, fmt.Errorf("failed to read nonce at index %d: %w", i, err)
+		}
+
+		key := string(nonce)
+		if _, ok := seen[key]; !ok {
+			order = append(order, key)
+		}
+		seen[key] = append(seen[key], i)
+	}
+
+	var reused []nonceReuse
+	for _, key := range order {
+		if len(seen[key]) > 1 {
+			reused = append(reused, nonceReuse{Nonce: []byte(key), Indices: seen[key]})
+		}
+	}
+
+	return reused, nil
+}
+
+func nonceError(reused []nonceReuse) error {
+	if len(reused) > 0 {
+		return fmt.Errorf("%d nonces are used by more than one block", len(reused))
+	}
+
+	return nil
+}
+
+func verifyError(results []VerifyResult) error {
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed verification", failed, len(results))
+	}
+
+	return nil
+}
+
+// verifySlots decrypts every used slot and returns the results in slot
+// order. With threads > 1 the slots are spread over a pool of workers;
+// each worker uses positioned reads so no shared file offset is involved.
+// If onResult is set it is called as each slot completes, one call at a
+// time, which lets callers stream results.
+func verifySlots(file F, meta *Meta, password string, threads int, onResult func(VerifyResult)) []VerifyResult {
+	var indices []int
+	for i, v := range meta.Files {
+		if v.Name != "" {
+			indices = append(indices, i)
+		}
+	}
+
+	results := make([]VerifyResult, len(indices))
+
+	if threads <= 1 {
+		for n, i := range indices {
+			results[n] = verifySlot(file, meta, password, i)
+			if onResult != nil {
+				onResult(results[n])
+			}
+		}
+		return results
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for range threads {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range jobs {
+				results[n] = verifySlot(file, meta, password, indices[n])
+				if onResult != nil {
+					mu.Lock()
+					onResult(results[n])
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for n := range indices {
+		jobs <- n
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// verifySlot decrypts a slot and, when the file has a stored checksum,
+// compares it with the plaintext. GCM already rejects damaged blocks; the
+// checksum catches a valid block that holds another file's data, such as a
+// slot reused after the metadata was rolled back.
+func verifySlot(file F, meta *Meta, password string, index int) VerifyResult {
+	r := VerifyResult{Index: index, Name: meta.Files[index].Name}
+
+	content, err := decryptSlot(file, meta, password, index)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+
+	sum := meta.Files[index].Checksum
+	if len(sum) > 0 && !bytes.Equal(ComputeChecksum(content), sum) {
+		r.Err = fmt.Errorf("checksum mismatch: content differs from what was added")
+	}
+
+	return r
+}
+
+// verifySlotContent reads a slot back and checks that it decrypts to the
+// expected plaintext.
+func verifySlotContent(file F, meta *Meta, password string, index int, expected []byte) error {
+	decrypted, err := decryptSlot(file, meta, password, index)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(decrypted, expected) {
+		return fmt.Errorf("content mismatch at index %d", index)
+	}
+
+	return nil
+}
+
+// decryptSlot reads a slot with a positioned read and decrypts it.
+func decryptSlot(file F, meta *Meta, password string, index int) ([]byte, error) {
+	df := meta.Files[index]
+
+	seekPos := int64(META_FILE_SIZE) + (int64(index) * int64(MAX_FILE_SIZE))
+	buff := make([]byte, df.Size)
+	n, err := file.ReadAt(buff, seekPos)
+	if n != df.Size {
+		if err != nil {
+			return nil, fmt.Errorf("failed to read: %w", err)
+		}
+		return nil, fmt.Errorf("short read: read %d bytes, expected %d", n, df.Size)
+	}
+
+	aad, err := fileAAD(df)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted, err := DecryptGCM(buff, password, meta.Salt, aad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+
+	return openPayload(df, decrypted)
+}
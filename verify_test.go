@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	for i, content := range [][]byte{[]byte("first"), []byte("second"), GenerateRandomBytes(2000)} {
+		sourcePath := CreateTempSourceFile(t, content)
+		if err := Add(file, sourcePath, i); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	output := captureOutput(func() {
+		if err := Verify(file, 1); err != nil {
+			t.Errorf("Verify failed on healthy volume: %v", err)
+		}
+	})
+	if !strings.Contains(output, "3 OK, 0 corrupt") {
+		t.Errorf("Expected all files OK, got:\n%s", output)
+	}
+
+	file.Seek(int64(META_FILE_SIZE+MAX_FILE_SIZE+20), 0)
+	file.Write([]byte{0xFF, 0xFF, 0xFF})
+
+	output = captureOutput(func() {
+		err := Verify(file, 1)
+		if err == nil {
+			t.Error("Expected Verify to fail on corrupted block")
+		}
+	})
+	if !strings.Contains(output, "CORRUPT") {
+		t.Errorf("Expected CORRUPT in output, got:\n%s", output)
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	Checksums = true
+	defer func() { Checksums = false }()
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+
+	for i, content := range [][]byte{[]byte("aaaa"), []byte("bbbb")} {
+		sourcePath := CreateTempSourceFile(t, content)
+		if err := Add(file, sourcePath, i); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	output := captureOutput(func() {
+		if err := Verify(file, 1); err != nil {
+			t.Errorf("Verify failed on healthy volume: %v", err)
+		}
+	})
+	if !strings.Contains(output, "2 OK, 0 corrupt") {
+		t.Errorf("Expected all files OK, got:\n%s", output)
+	}
+
+	// Swapped blocks still decrypt, only the checksums tell them apart.
+	first, _ := ReadBlock(file, 0)
+	second, _ := ReadBlock(file, 1)
+	WriteBlock(file, second, "", 0)
+	WriteBlock(file, first, "", 1)
+
+	var err error
+	output = captureOutput(func() {
+		err = Verify(file, 1)
+	})
+	if err == nil {
+		t.Error("Expected Verify to fail on swapped blocks")
+	}
+	if !strings.Contains(output, "0 OK, 2 corrupt") || !strings.Contains(output, "checksum mismatch") {
+		t.Errorf("Expected checksum mismatches in output, got:\n%s", output)
+	}
+}
+
+func TestVerifyParallel(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+	FillSlots(t, file, 6)
+
+	file.Seek(int64(META_FILE_SIZE+(4*MAX_FILE_SIZE)+5), 0)
+	file.Write([]byte{0x00, 0x01, 0x02})
+
+	meta, err := ReadMeta(file)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+	password, _ := GetEncKey()
+
+	serial := verifySlots(file, meta, password, 1, nil)
+	parallel := verifySlots(file, meta, password, 4, nil)
+
+	if len(serial) != 6 || len(parallel) != 6 {
+		t.Fatalf("Expected 6 results, got %d serial and %d parallel", len(serial), len(parallel))
+	}
+
+	for i := range serial {
+		if serial[i].Index != parallel[i].Index {
+			t.Errorf("Result %d: index mismatch %d vs %d", i, serial[i].Index, parallel[i].Index)
+		}
+		if (serial[i].Err == nil) != (parallel[i].Err == nil) {
+			t.Errorf("Result %d: serial err %v, parallel err %v", i, serial[i].Err, parallel[i].Err)
+		}
+		if (parallel[i].Err != nil) != (parallel[i].Index == 4) {
+			t.Errorf("Unexpected result for index %d: %v", parallel[i].Index, parallel[i].Err)
+		}
+	}
+}
+
+func benchmarkVerify(b *testing.B, threads int) {
+	SetupTestKey(&testing.T{})
+
+	file := CreateTempTestFile(&testing.T{}, META_FILE_SIZE+(TOTAL_FILES*MAX_FILE_SIZE))
+	defer file.Close()
+
+	InitMeta(file, "file")
+	FillAllSlots(&testing.T{}, file)
+
+	meta, _ := ReadMeta(file)
+	password, _ := GetEncKey()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		verifySlots(file, meta, password, threads, nil)
+	}
+}
+
+func BenchmarkVerifySerial(b *testing.B) {
+	benchmarkVerify(b, 1)
+}
+
+func BenchmarkVerifyParallel(b *testing.B) {
+	benchmarkVerify(b, 4)
+}
+
+func TestVerifyCheckNonces(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	CheckNonces = true
+	defer func() { CheckNonces = false }()
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+	FillSlots(t, file, 4)
+
+	captureOutput(func() {
+		if err := Verify(file, 1); err != nil {
+			t.Errorf("Verify failed on volume with unique nonces: %v", err)
+		}
+	})
+
+	// Copy block 1 over block 3, so both start with the same nonce.
+	block, err := ReadBlock(file, 1)
+	if err != nil {
+		t.Fatalf("ReadBlock failed: %v", err)
+	}
+	if err := WriteBlock(file, block, "", 3); err != nil {
+		t.Fatalf("WriteBlock failed: %v", err)
+	}
+
+	meta, _ := ReadMeta(file)
+	reused, err := findReusedNonces(file, meta)
+	if err != nil {
+		t.Fatalf("findReusedNonces failed: %v", err)
+	}
+	if len(reused) != 1 {
+		t.Fatalf("Expected 1 reused nonce, got %d", len(reused))
+	}
+	if len(reused[0].Indices) != 2 || reused[0].Indices[0] != 1 || reused[0].Indices[1] != 3 {
+		t.Errorf("Expected indices [1 3], got %v", reused[0].Indices)
+	}
+
+	output := captureOutput(func() {
+		if err := Verify(file, 1); err == nil {
+			t.Error("Expected Verify to fail on reused nonce")
+		}
+	})
+	if !strings.Contains(output, "NONCE REUSE") {
+		t.Errorf("Expected NONCE REUSE in output, got:\n%s", output)
+	}
+}
+
+func TestVerifyCheckNoncesFixedNonce(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	CheckNonces = true
+	defer func() { CheckNonces = false }()
+
+	file := GetSharedTestFile(t)
+	InitMeta(file, "file")
+	FillSlots(t, file, 2)
+
+	// Two different files encrypted under the same chosen nonce.
+	nonce := []byte("reused-nonce")
+	setRandSource(t, repeatReader{nonce})
+	for i, content := range []string{"first secret", "second secret"} {
+		sourcePath := CreateTempSourceFile(t, []byte(content))
+		if err := Add(file, sourcePath, 2+i); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	meta, _ := ReadMeta(file)
+	reused, err := findReusedNonces(file, meta)
+	if err != nil {
+		t.Fatalf("findReusedNonces failed: %v", err)
+	}
+	if len(reused) != 1 {
+		t.Fatalf("Expected 1 reused nonce, got %d", len(reused))
+	}
+	if !bytes.Equal(reused[0].Nonce, nonce) {
+		t.Errorf("Expected nonce %q, got %q", nonce, reused[0].Nonce)
+	}
+	if len(reused[0].Indices) != 2 || reused[0].Indices[0] != 2 || reused[0].Indices[1] != 3 {
+		t.Errorf("Expected indices [2 3], got %v", reused[0].Indices)
+	}
+
+	captureOutput(func() {
+		if err := Verify(file, 1); err == nil {
+			t.Error("Expected Verify to fail on reused nonce")
+		}
+	})
+}
+
+func TestVerifyJSONWriteError(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	file := NewMockFile(0)
+	InitMeta(file, "file")
+
+	sourcePath := CreateTempSourceFile(t, []byte("first"))
+	if err := Add(file, sourcePath, 0); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	JSONLines = true
+	defer func() { JSONLines = false }()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe failed: %v", err)
+	}
+	r.Close()
+	w.Close()
+
+	stdout := os.Stdout
+	os.Stdout = w
+	err = Verify(file, 1)
+	os.Stdout = stdout
+
+	if err == nil || !strings.Contains(err.Error(), "failed to encode result") {
+		t.Errorf("Expected encode error, got: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrTimeout is returned once a device call has run past MaxRuntime.
+var ErrTimeout = errors.New("device I/O exceeded --max-runtime, device may be hung")
+
+// watchdogFile runs every blocking call on the wrapped device in its own
+// goroutine and gives up when that call takes longer than timeout. A dying
+// USB device can block a read forever; the watchdog closes the device to
+// try to unblock it and returns ErrTimeout instead of waiting. Time spent
+// between calls, at a password prompt or an idle shell, doesn't count.
+type watchdogFile struct {
+	F
+	timeout time.Duration
+	aborted sync.Once
+	dead    chan struct{} // closed once a call has timed out
+}
+
+// newWatchdog wraps file so that any single call on it that takes longer
+// than d fails with ErrTimeout, and every call after it too.
+func newWatchdog(file F, d time.Duration) *watchdogFile {
+	return &watchdogFile{F: file, timeout: d, dead: make(chan struct{})}
+}
+
+// watch calls fn and waits for it for the timeout of w. A call that is
+// still blocked then is abandoned and the device closed.
+func watch[T any](w *watchdogFile, fn func() (T, error)) (T, error) {
+	type result struct {
+		v   T
+		err error
+	}
+
+	var zero T
+	select {
+	case <-w.dead:
+		return zero, ErrTimeout
+	default:
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		v, err := fn()
+		done <- result{v, err}
+	}()
+
+	timer := time.NewTimer(w.timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-done:
+		return r.v, r.err
+	case <-timer.C:
+		w.abort()
+		return zero, ErrTimeout
+	}
+}
+
+// abort closes the device the first time a call times out.
+func (w *watchdogFile) abort() {
+	w.aborted.Do(func() {
+		close(w.dead)
+		LogError("no response from %s within --max-runtime, closing it", w.F.Name())
+		w.Close()
+	})
+}
+
+func (w *watchdogFile) Read(b []byte) (int, error) {
+	return watch(w, func() (int, error) { return w.F.Read(b) })
+}
+
+func (w *watchdogFile) Write(b []byte) (int, error) {
+	return watch(w, func() (int, error) { return w.F.Write(b) })
+}
+
+func (w *watchdogFile) ReadAt(b []byte, off int64) (int, error) {
+	return watch(w, func() (int, error) { return w.F.ReadAt(b, off) })
+}
+
+func (w *watchdogFile) WriteAt(b []byte, off int64) (int, error) {
+	return watch(w, func() (int, error) { return w.F.WriteAt(b, off) })
+}
+
+func (w *watchdogFile) Seek(offset int64, whence int) (int64, error) {
+	return watch(w, func() (int64, error) { return w.F.Seek(offset, whence) })
+}
+
+func (w *watchdogFile) Sync() error {
+	_, err := watch(w, func() (struct{}, error) { return struct{}{}, w.F.Sync() })
+	return err
+}
+
+func (w *watchdogFile) Truncate(size int64) error {
+	_, err := watch(w, func() (struct{}, error) { return struct{}{}, w.F.Truncate(size) })
+	return err
+}
+
+func (w *watchdogFile) Stat() (os.FileInfo, error) {
+	return watch(w, w.F.Stat)
+}
+
+// Close closes the wrapped device if it can be closed.
+func (w *watchdogFile) Close() error {
+	if c, ok := w.F.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Fd passes the descriptor through for the block device ioctls.
+func (w *watchdogFile) Fd() uintptr {
+	if f, ok := w.F.(interface{ Fd() uintptr }); ok {
+		return f.Fd()
+	}
+	return ^uintptr(0)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// hungFile is a device whose reads block until it is closed, like a dying
+// USB stick.
+type hungFile struct {
+	*MockFile
+	closed chan struct{}
+}
+
+func (f *hungFile) Read(b []byte) (int, error) {
+	<-f.closed
+	return 0, errors.New("read on closed device")
+}
+
+func (f *hungFile) Close() error {
+	close(f.closed)
+	return nil
+}
+
+func TestWatchdogAbortsHungRead(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	device := &hungFile{MockFile: NewMockFile(META_FILE_SIZE), closed: make(chan struct{})}
+	w := newWatchdog(device, 100*time.Millisecond)
+
+	start := time.Now()
+	_, err := w.Read(make([]byte, 16))
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Expected ErrTimeout, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Watchdog took %s to fire", elapsed)
+	}
+
+	select {
+	case <-device.closed:
+	default:
+		t.Error("Expected the watchdog to close the hung device")
+	}
+
+	// Past the deadline nothing else is attempted.
+	if _, err := w.Seek(0, 0); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected calls after the deadline to fail, got: %v", err)
+	}
+}
+
+func TestWatchdogPassesThrough(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	SetupTestKey(t)
+	defer CleanupTestKey(t)
+
+	w := newWatchdog(NewMockFile(META_FILE_SIZE), time.Minute)
+	if err := InitMeta(w, "file"); err != nil {
+		t.Fatalf("InitMeta through the watchdog failed: %v", err)
+	}
+	if _, err := ReadMeta(w); err != nil {
+		t.Fatalf("ReadMeta through the watchdog failed: %v", err)
+	}
+}
+
+func TestWatchdogTimesEachCall(t *testing.T) {
+	defer LogTestDuration(t, time.Now())
+
+	w := newWatchdog(NewMockFile(META_FILE_SIZE), 100*time.Millisecond)
+
+	// Idle time between calls, such as a password prompt, doesn't count.
+	for range 3 {
+		time.Sleep(60 * time.Millisecond)
+		if _, err := w.ReadAt(make([]byte, 16), 0); err != nil {
+			t.Fatalf("ReadAt after idle time failed: %v", err)
+		}
+	}
+}